@@ -0,0 +1,207 @@
+package seekable
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// AccessHint is the expected access pattern of a range of the decompressed stream, see Reader's Advise.
+type AccessHint int
+
+const (
+	// AccessNormal drops the AccessSequential and AccessRandom hints of the range.
+	AccessNormal AccessHint = iota
+	// AccessSequential marks the range as read sequentially: reads in it prefetch twice
+	// as many frames as set by WithPrefetch.
+	AccessSequential
+	// AccessRandom marks the range as read randomly: reads in it do not prefetch frames.
+	AccessRandom
+	// AccessWillNeed decompresses the frames of the range into the frame cache in the background,
+	// as many of them as fit.  Frames are only decompressed if there is memory left under
+	// WithReaderMemoryLimit without evicting anything.  It does nothing without WithFrameCache.
+	AccessWillNeed
+	// AccessDontNeed drops the decompressed frames of the range retained by the reader:
+	// cached, prefetched and the last read frame.
+	AccessDontNeed
+)
+
+func (h AccessHint) String() string {
+	switch h {
+	case AccessNormal:
+		return "normal"
+	case AccessSequential:
+		return "sequential"
+	case AccessRandom:
+		return "random"
+	case AccessWillNeed:
+		return "will need"
+	case AccessDontNeed:
+		return "don't need"
+	default:
+		return fmt.Sprintf("AccessHint(%d)", int(h))
+	}
+}
+
+// hintRange is the AccessSequential or AccessRandom hint of [start, end).
+type hintRange struct {
+	start, end uint64
+	hint       AccessHint
+}
+
+// accessHints keeps the non-overlapping hinted ranges ordered by offset.
+type accessHints struct {
+	m      sync.Mutex
+	ranges []hintRange
+}
+
+// set hints [start, end) replacing the hints it overlaps.
+func (h *accessHints) set(start, end uint64, hint AccessHint) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	ranges := make([]hintRange, 0, len(h.ranges)+2)
+	for _, r := range h.ranges {
+		if r.end <= start || r.start >= end {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.start < start {
+			ranges = append(ranges, hintRange{start: r.start, end: start, hint: r.hint})
+		}
+		if r.end > end {
+			ranges = append(ranges, hintRange{start: end, end: r.end, hint: r.hint})
+		}
+	}
+	if hint != AccessNormal {
+		ranges = append(ranges, hintRange{start: start, end: end, hint: hint})
+	}
+	slices.SortFunc(ranges, func(a, b hintRange) int { return cmp.Compare(a.start, b.start) })
+	h.ranges = ranges
+}
+
+// get returns the hint of the offset, or AccessNormal if it has none.
+func (h *accessHints) get(off uint64) AccessHint {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	i, _ := slices.BinarySearchFunc(h.ranges, off, func(r hintRange, off uint64) int {
+		if r.end <= off {
+			return -1
+		}
+		if r.start > off {
+			return 1
+		}
+		return 0
+	})
+	if i < len(h.ranges) && h.ranges[i].start <= off && off < h.ranges[i].end {
+		return h.ranges[i].hint
+	}
+	return AccessNormal
+}
+
+func (r *readerImpl) Advise(offset, length int64, hint AccessHint) error {
+	if r.closed.Load() {
+		return fmt.Errorf("reader is closed")
+	}
+	if offset < 0 || length < 0 {
+		return fmt.Errorf("%w: invalid range: offset: %d, length: %d", ErrOffsetOutOfRange, offset, length)
+	}
+	idx := r.idx()
+	end := idx.endOffset
+	if length != 0 && length < idx.endOffset-offset {
+		end = offset + length
+	}
+	if offset >= end {
+		return nil
+	}
+
+	switch hint {
+	case AccessNormal, AccessSequential, AccessRandom:
+		r.hints.set(uint64(offset), uint64(end), hint)
+	case AccessWillNeed:
+		r.willNeed(uint64(offset), uint64(end))
+	case AccessDontNeed:
+		r.dontNeed(uint64(offset), uint64(end))
+	default:
+		return fmt.Errorf("unknown access hint: %d", int(hint))
+	}
+	return nil
+}
+
+// willNeed decompresses the frames overlapping [start, end) into the frame cache in the background.
+func (r *readerImpl) willNeed(start, end uint64) {
+	if r.cache == nil {
+		return
+	}
+
+	var frames []*env.FrameOffsetEntry
+	var size int64
+	r.idx().index.ascend(r.GetIndexByDecompOffset(start), func(e *env.FrameOffsetEntry) bool {
+		if e.DecompOffset >= end {
+			return false
+		}
+		if e.DecompSize == 0 || r.streamed(e) {
+			return true
+		}
+		if size += int64(e.DecompSize); size > r.cache.maxBytes {
+			return false
+		}
+		frames = append(frames, e)
+		return true
+	})
+
+	r.advised.Add(1)
+	go func() {
+		defer r.advised.Done()
+
+		for _, e := range frames {
+			if r.closed.Load() || r.ctx.Err() != nil {
+				return
+			}
+			if r.cache.contains(e.DecompOffset) {
+				continue
+			}
+			if !r.memory.tryAcquire(int64(e.CompSize) + int64(e.DecompSize)) {
+				return
+			}
+			// Errors are left to the reads of the frame.
+			data, err := r.decodeFrame(r.ctx, e, nil)
+			r.memory.release(int64(e.CompSize))
+			if err != nil {
+				r.memory.release(int64(e.DecompSize))
+				return
+			}
+			evicted, added := r.cache.add(e.DecompOffset, data)
+			if !added {
+				evicted += int64(e.DecompSize)
+			}
+			r.memory.release(evicted)
+		}
+	}()
+}
+
+// dontNeed drops the retained frames overlapping [start, end).
+func (r *readerImpl) dontNeed(start, end uint64) {
+	var freed int64
+	if r.cache != nil {
+		freed += r.cache.removeRange(start, end)
+	}
+	if off, data := r.cachedFrame.get(); data != nil && off < end && off+uint64(len(data)) > start {
+		freed += r.cachedFrame.reclaim()
+	}
+	if p := r.prefetcher; p != nil {
+		first, last := r.GetIndexByDecompOffset(start), r.GetIndexByDecompOffset(end-1)
+		p.m.Lock()
+		for id, f := range p.frames {
+			if id >= first.ID && id <= last.ID {
+				freed += p.drop(id, f)
+			}
+		}
+		p.m.Unlock()
+	}
+	r.memory.release(freed)
+}
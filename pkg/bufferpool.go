@@ -0,0 +1,39 @@
+package seekable
+
+import "sync"
+
+// BufferPool supplies the destination buffers for compressed frames, see WithBufferPool.
+// Implementations must be safe for concurrent use.
+type BufferPool interface {
+	// Get returns a buffer of any length, the contents are overwritten.
+	Get() []byte
+	// Put returns a buffer that is no longer used to the pool.
+	Put(buf []byte)
+}
+
+// noBufferPool allocates a new buffer for each frame, it is the default for custom environments.
+type noBufferPool struct{}
+
+func (noBufferPool) Get() []byte { return nil }
+
+func (noBufferPool) Put([]byte) {}
+
+// syncBufferPool is the default BufferPool backed by sync.Pool.
+type syncBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *syncBufferPool) Get() []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return nil
+}
+
+func (p *syncBufferPool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	buf = buf[:0]
+	p.pool.Put(&buf)
+}
@@ -0,0 +1,199 @@
+package seekable
+
+import "container/list"
+
+// CachePolicy decides which frames the frame caches evict, see WithCachePolicy.  Frames are
+// identified by their keys: decompressed offsets for WithFrameCache and compressed offsets for
+// WithCompressedFrameCache.  The cache calls the policy with its lock held, so implementations
+// do not have to be goroutine-safe, but must not call back into the reader.
+type CachePolicy interface {
+	// Added is called when the frame of the given size is put into the cache.
+	Added(key uint64, size int64)
+	// Accessed is called when the cached frame is read.
+	Accessed(key uint64)
+	// Removed is called when the frame leaves the cache, whether it was evicted or dropped.
+	Removed(key uint64)
+	// Victim returns the key of the cached frame to evict next, it is only called if the cache
+	// is not empty.  The frame is not removed until Removed is called.
+	Victim() uint64
+}
+
+// NewLRUPolicy returns the least recently used policy, the default one.  maxBytes is
+// unused, it is there so that the function can be passed to WithCachePolicy.
+func NewLRUPolicy(maxBytes int64) CachePolicy {
+	return &lruPolicy{queue: newPolicyQueue()}
+}
+
+// NewSegmentedLRUPolicy returns the segmented LRU policy for the cache of maxBytes: frames
+// start in the probationary segment and move to the protected one, which takes up to 80% of
+// the cache, when they are read again.  Frames read only once, e.g. by a scan, are evicted
+// from the probationary segment before the protected one is touched.
+func NewSegmentedLRUPolicy(maxBytes int64) CachePolicy {
+	return &segmentedLRUPolicy{
+		probation:    newPolicyQueue(),
+		protected:    newPolicyQueue(),
+		maxProtected: maxBytes / 5 * 4,
+	}
+}
+
+// NewTwoQueuePolicy returns the 2Q policy for the cache of maxBytes: new frames go into
+// a FIFO queue of up to 25% of the cache, and only the frames that are added again soon
+// after they were evicted from it, which are remembered for up to the size of the cache,
+// go into the main LRU queue.  It resists scans better than NewSegmentedLRUPolicy but needs
+// frames to be read twice, with some eviction in between, to keep them.
+func NewTwoQueuePolicy(maxBytes int64) CachePolicy {
+	return &twoQueuePolicy{
+		in:       newPolicyQueue(),
+		main:     newPolicyQueue(),
+		ghosts:   newPolicyQueue(),
+		maxIn:    maxBytes / 4,
+		maxGhost: maxBytes,
+	}
+}
+
+// policyQueue is the queue of keys with their sizes, most recently used first.
+type policyQueue struct {
+	l     *list.List // of *policyEntry
+	keys  map[uint64]*list.Element
+	bytes int64
+}
+
+type policyEntry struct {
+	key  uint64
+	size int64
+}
+
+func newPolicyQueue() *policyQueue {
+	return &policyQueue{l: list.New(), keys: make(map[uint64]*list.Element)}
+}
+
+func (q *policyQueue) push(key uint64, size int64) {
+	q.keys[key] = q.l.PushFront(&policyEntry{key: key, size: size})
+	q.bytes += size
+}
+
+func (q *policyQueue) contains(key uint64) bool {
+	_, ok := q.keys[key]
+	return ok
+}
+
+// touch moves the key to the front of the queue, it returns false if there is no such key.
+func (q *policyQueue) touch(key uint64) bool {
+	e, ok := q.keys[key]
+	if ok {
+		q.l.MoveToFront(e)
+	}
+	return ok
+}
+
+// remove removes the key and returns its size, it returns false if there is no such key.
+func (q *policyQueue) remove(key uint64) (int64, bool) {
+	e, ok := q.keys[key]
+	if !ok {
+		return 0, false
+	}
+	entry := q.l.Remove(e).(*policyEntry)
+	delete(q.keys, key)
+	q.bytes -= entry.size
+	return entry.size, true
+}
+
+// oldest returns the least recently used entry, the queue must not be empty.
+func (q *policyQueue) oldest() *policyEntry {
+	return q.l.Back().Value.(*policyEntry)
+}
+
+func (q *policyQueue) len() int {
+	return q.l.Len()
+}
+
+type lruPolicy struct {
+	queue *policyQueue
+}
+
+func (p *lruPolicy) Added(key uint64, size int64) { p.queue.push(key, size) }
+
+func (p *lruPolicy) Accessed(key uint64) { p.queue.touch(key) }
+
+func (p *lruPolicy) Removed(key uint64) { p.queue.remove(key) }
+
+func (p *lruPolicy) Victim() uint64 { return p.queue.oldest().key }
+
+type segmentedLRUPolicy struct {
+	probation, protected *policyQueue
+	maxProtected         int64
+}
+
+func (p *segmentedLRUPolicy) Added(key uint64, size int64) { p.probation.push(key, size) }
+
+func (p *segmentedLRUPolicy) Accessed(key uint64) {
+	if p.protected.touch(key) {
+		return
+	}
+	size, ok := p.probation.remove(key)
+	if !ok {
+		return
+	}
+	p.protected.push(key, size)
+	// The frames pushed out of the protected segment get another chance in the probationary one.
+	for p.protected.bytes > p.maxProtected && p.protected.len() > 1 {
+		oldest := p.protected.oldest()
+		p.protected.remove(oldest.key)
+		p.probation.push(oldest.key, oldest.size)
+	}
+}
+
+func (p *segmentedLRUPolicy) Removed(key uint64) {
+	if _, ok := p.probation.remove(key); !ok {
+		p.protected.remove(key)
+	}
+}
+
+func (p *segmentedLRUPolicy) Victim() uint64 {
+	if p.probation.len() > 0 {
+		return p.probation.oldest().key
+	}
+	return p.protected.oldest().key
+}
+
+type twoQueuePolicy struct {
+	// in is the FIFO queue of new frames, main is the LRU queue of the frames added again
+	// while they were in ghosts, the keys recently evicted from in.
+	in, main, ghosts *policyQueue
+	maxIn, maxGhost  int64
+}
+
+func (p *twoQueuePolicy) Added(key uint64, size int64) {
+	if _, ok := p.ghosts.remove(key); ok {
+		p.main.push(key, size)
+		return
+	}
+	p.in.push(key, size)
+}
+
+func (p *twoQueuePolicy) Accessed(key uint64) {
+	// Reads of the frames in the FIFO queue are likely to be correlated, e.g. of the same
+	// frame by a sequential read, so they do not count.
+	p.main.touch(key)
+}
+
+func (p *twoQueuePolicy) Removed(key uint64) {
+	if _, ok := p.in.remove(key); !ok {
+		p.main.remove(key)
+	}
+}
+
+func (p *twoQueuePolicy) Victim() uint64 {
+	if p.in.len() == 0 || (p.in.bytes <= p.maxIn && p.main.len() > 0) {
+		return p.main.oldest().key
+	}
+
+	oldest := p.in.oldest()
+	if !p.ghosts.contains(oldest.key) {
+		p.ghosts.push(oldest.key, oldest.size)
+	}
+	for p.ghosts.bytes > p.maxGhost && p.ghosts.len() > 0 {
+		p.ghosts.remove(p.ghosts.oldest().key)
+	}
+	return oldest.key
+}
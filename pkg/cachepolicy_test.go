@@ -0,0 +1,130 @@
+package seekable
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cachePolicies = map[string]func(maxBytes int64) CachePolicy{
+	"lru":           NewLRUPolicy,
+	"segmented-lru": NewSegmentedLRUPolicy,
+	"2q":            NewTwoQueuePolicy,
+}
+
+func TestCachePolicyScan(t *testing.T) {
+	t.Parallel()
+
+	for name, newPolicy := range cachePolicies {
+		c := newFrameCache(10)
+		c.policy = newPolicy(c.maxBytes)
+		// read caches the frame unless it is cached, as the reader does.
+		read := func(offset uint64) {
+			if c.get(offset) == nil {
+				c.add(offset, []byte{1})
+			}
+		}
+
+		// The hot frames are read repeatedly in between reads of other frames.
+		for i := uint64(1); i <= 3; i++ {
+			for offset := uint64(0); offset < 3; offset++ {
+				read(offset)
+				read(offset)
+			}
+			for offset := i * 10; offset < i*10+10; offset++ {
+				read(offset)
+			}
+		}
+		for offset := uint64(0); offset < 3; offset++ {
+			read(offset)
+		}
+
+		// The scan reads each frame once.
+		for offset := uint64(100); offset < 200; offset++ {
+			read(offset)
+		}
+		for offset := uint64(0); offset < 3; offset++ {
+			assert.Equal(t, name != "lru", c.contains(offset), "%s: frame %d", name, offset)
+		}
+		assert.LessOrEqual(t, c.getStats().Bytes, c.maxBytes, name)
+	}
+}
+
+func TestCachePolicyConsistency(t *testing.T) {
+	t.Parallel()
+
+	for name, newPolicy := range cachePolicies {
+		c := newFrameCache(100)
+		c.policy = newPolicy(c.maxBytes)
+		rnd := rand.New(rand.NewSource(1))
+
+		for i := 0; i < 10000; i++ {
+			offset := uint64(rnd.Intn(1000)) * 10
+			switch rnd.Intn(10) {
+			case 0:
+				c.removeRange(offset, offset+uint64(rnd.Intn(100)))
+			case 1:
+				c.evictVictim()
+			default:
+				if c.get(offset) == nil {
+					c.add(offset, make([]byte, 1+rnd.Intn(10)))
+				}
+			}
+
+			stats := c.getStats()
+			require.LessOrEqual(t, stats.Bytes, c.maxBytes, name)
+			require.Len(t, c.entries, stats.Frames, name)
+			if stats.Frames > 0 {
+				// The policy only evicts cached frames.
+				require.True(t, c.contains(c.policy.Victim()), name)
+			}
+		}
+		c.clear()
+		assert.Zero(t, c.getStats().Frames, name)
+	}
+}
+
+func TestReaderCachePolicy(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithCachePolicy(nil))
+	require.ErrorContains(t, err, "cache policy is nil")
+
+	for name, newPolicy := range cachePolicies {
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithFrameCache(int64(len(expected)/2)),
+			WithCompressedFrameCache(int64(b.Len()/2)), WithCachePolicy(newPolicy))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = r.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			all, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, expected, all, name)
+		}
+		assert.Positive(t, r.CacheStats().Evictions, name)
+		require.NoError(t, r.Close())
+	}
+}
@@ -0,0 +1,184 @@
+// Package chunker implements content-defined chunking (FastCDC) of an io.Reader.
+//
+// Chunk boundaries depend only on the content, so an insertion or a deletion in
+// the input only changes the chunks around the edit.  Used as a FrameSource for
+// Writer's WriteMany this makes seekable streams rsync and deduplication friendly.
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/gear"
+)
+
+const (
+	// DefaultAverageSize is the average chunk size used when Options.AverageSize is zero.
+	DefaultAverageSize = 1 << 20
+
+	minAverageSize = 64
+)
+
+// Options control the distribution of the chunk sizes.
+// Zero MinSize and MaxSize default to AverageSize/4 and AverageSize*4 respectively.
+type Options struct {
+	// MinSize is the minimum size of a chunk.  Only the last chunk can be smaller.
+	MinSize int
+	// AverageSize is the expected size of a chunk.  It is rounded to the nearest power of two.
+	AverageSize int
+	// MaxSize is the maximum size of a chunk.
+	MaxSize int
+}
+
+func (o *Options) normalize() error {
+	if o.AverageSize == 0 {
+		o.AverageSize = DefaultAverageSize
+	}
+	if o.MinSize == 0 {
+		o.MinSize = o.AverageSize / 4
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = o.AverageSize * 4
+	}
+
+	if o.AverageSize < minAverageSize {
+		return fmt.Errorf("average size is too small: %d < %d", o.AverageSize, minAverageSize)
+	}
+	if o.MinSize < 1 || o.MinSize > o.AverageSize || o.AverageSize > o.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min <= avg <= max: %d, %d, %d",
+			o.MinSize, o.AverageSize, o.MaxSize)
+	}
+	if int64(o.MaxSize) > math.MaxUint32 {
+		return fmt.Errorf("max size too big for seekable format: %d > %d", o.MaxSize, uint32(math.MaxUint32))
+	}
+	return nil
+}
+
+// Chunker splits the data read from the underlying io.Reader into content-defined chunks.
+type Chunker struct {
+	r    io.Reader
+	opts Options
+
+	// FastCDC normalized chunking: a stricter mask is used before the average size
+	// is reached and a looser one after that.
+	maskS, maskL uint64
+
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// New returns Chunker over r.
+func New(r io.Reader, opts Options) (*Chunker, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	b := bits.Len(uint(opts.AverageSize)) - 1
+	if opts.AverageSize-(1<<b) > (2<<b)-opts.AverageSize {
+		b++
+	}
+
+	return &Chunker{
+		r:     r,
+		opts:  opts,
+		maskS: topBitsMask(b + 1),
+		maskL: topBitsMask(b - 1),
+		buf:   make([]byte, 2*opts.MaxSize),
+	}, nil
+}
+
+// topBitsMask returns a mask with n most significant bits set.
+// Gear hash mixes the high bits of the fingerprint best.
+func topBitsMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk or io.EOF if there is no more data.
+// The returned slice is only valid until the next call to Next.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill makes sure that at least MaxSize bytes are buffered unless the reader is exhausted.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.opts.MaxSize {
+		return nil
+	}
+
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cut returns the length of the first chunk in data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+	normal := c.opts.AverageSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear.Table[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear.Table[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// FrameSource returns seekable.FrameSource that yields content-defined chunks of r.
+// Each returned frame is a separate copy, so it can be safely passed to WriteMany.
+func FrameSource(r io.Reader, opts Options) (seekable.FrameSource, error) {
+	c, err := New(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() ([]byte, error) {
+		chunk, err := c.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return append([]byte(nil), chunk...), nil
+	}, nil
+}
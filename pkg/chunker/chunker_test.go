@@ -0,0 +1,135 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+)
+
+func randomData(t *testing.T, seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.New(rand.NewSource(seed)).Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, opts Options) [][]byte {
+	c, err := New(bytes.NewReader(data), opts)
+	require.NoError(t, err)
+
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{MinSize: 1024, AverageSize: 4096, MaxSize: 16384}
+	data := randomData(t, 1, 1<<20)
+
+	chunks := chunkAll(t, data, opts)
+	assert.Greater(t, len(chunks), (1<<20)/opts.MaxSize)
+	assert.Less(t, len(chunks), (1<<20)/opts.MinSize)
+
+	var concat []byte
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), opts.MaxSize)
+		if i != len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), opts.MinSize)
+		}
+		concat = append(concat, chunk...)
+	}
+	assert.Equal(t, data, concat)
+
+	// Chunking is deterministic.
+	assert.Equal(t, chunks, chunkAll(t, data, opts))
+}
+
+func TestChunkerShift(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{MinSize: 1024, AverageSize: 4096, MaxSize: 16384}
+	data := randomData(t, 2, 1<<20)
+	shifted := append([]byte("prefix"), data...)
+
+	seen := map[string]struct{}{}
+	for _, chunk := range chunkAll(t, data, opts) {
+		seen[string(chunk)] = struct{}{}
+	}
+
+	var same int
+	chunks := chunkAll(t, shifted, opts)
+	for _, chunk := range chunks {
+		if _, ok := seen[string(chunk)]; ok {
+			same++
+		}
+	}
+	// Only the chunks around the edit are affected.
+	assert.GreaterOrEqual(t, same, len(chunks)-2)
+}
+
+func TestChunkerOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range []Options{
+		{AverageSize: 16},
+		{MinSize: 8192, AverageSize: 4096},
+		{AverageSize: 4096, MaxSize: 1024},
+	} {
+		_, err := New(bytes.NewReader(nil), opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+
+	c, err := New(bytes.NewReader(nil), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, Options{MinSize: DefaultAverageSize / 4, AverageSize: DefaultAverageSize, MaxSize: DefaultAverageSize * 4}, c.opts)
+
+	_, err = c.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestFrameSource(t *testing.T) {
+	t.Parallel()
+
+	data := randomData(t, 3, 256<<10)
+	frameSource, err := FrameSource(bytes.NewReader(data), Options{AverageSize: 8192})
+	require.NoError(t, err)
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	defer enc.Close()
+
+	var b bytes.Buffer
+	w, err := seekable.NewWriter(&b, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), frameSource))
+	require.NoError(t, w.Close())
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := seekable.NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, all)
+}
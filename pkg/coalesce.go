@@ -0,0 +1,119 @@
+package seekable
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// coalescedRead is a frame read waiting to be merged with the others of its batch.
+type coalescedRead struct {
+	index *env.FrameOffsetEntry
+	done  chan struct{}
+	p     []byte
+	err   error
+}
+
+// readCoalescer merges the frame reads that arrive within window of each other into reads
+// of at most maxBytes from the environment, see WithReadCoalescing.
+type readCoalescer struct {
+	window   time.Duration
+	maxBytes int64
+	// read reads the compressed data described by index from the environment.
+	read func(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error)
+
+	// m guards the batch being collected, it is flushed by the read that started it.
+	m     sync.Mutex
+	batch []*coalescedRead
+}
+
+// get reads the compressed frame described by index, possibly together with
+// the frames read concurrently.
+func (c *readCoalescer) get(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error) {
+	if index.CompSize == 0 || int64(index.CompSize) >= c.maxBytes {
+		return c.read(ctx, index)
+	}
+
+	rd := &coalescedRead{index: index, done: make(chan struct{})}
+	c.m.Lock()
+	leader := len(c.batch) == 0
+	c.batch = append(c.batch, rd)
+	c.m.Unlock()
+
+	if leader {
+		t := time.NewTimer(c.window)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		c.m.Lock()
+		batch := c.batch
+		c.batch = nil
+		c.m.Unlock()
+		c.flush(ctx, batch)
+	}
+
+	select {
+	case <-rd.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// The batch is read with the context of its leader, the frame is read again
+	// if only that one is done.
+	if rd.err != nil && ctx.Err() == nil &&
+		(errors.Is(rd.err, context.Canceled) || errors.Is(rd.err, context.DeadlineExceeded)) {
+		return c.read(ctx, index)
+	}
+	return rd.p, rd.err
+}
+
+// flush reads the frames of the batch, the frames that are at most maxBytes apart
+// in the compressed stream are read at once.
+func (c *readCoalescer) flush(ctx context.Context, batch []*coalescedRead) {
+	slices.SortFunc(batch, func(a, b *coalescedRead) int { return cmp.Compare(a.index.CompOffset, b.index.CompOffset) })
+	for len(batch) > 0 {
+		first := batch[0].index
+		end := first.CompOffset + uint64(first.CompSize)
+		n := 1
+		for ; n < len(batch); n++ {
+			index := batch[n].index
+			if index.CompOffset+uint64(index.CompSize)-first.CompOffset > uint64(c.maxBytes) {
+				break
+			}
+			end = max(end, index.CompOffset+uint64(index.CompSize))
+		}
+
+		run := batch[:n]
+		batch = batch[n:]
+		if n == 1 {
+			run[0].p, run[0].err = c.read(ctx, first)
+			close(run[0].done)
+			continue
+		}
+
+		span := &env.FrameOffsetEntry{ID: first.ID, CompOffset: first.CompOffset, CompSize: uint32(end - first.CompOffset)}
+		p, err := c.read(ctx, span)
+		if err == nil && len(p) != int(span.CompSize) {
+			// The frames are read on their own to report which of them does not match the index.
+			for _, rd := range run {
+				rd.p, rd.err = c.read(ctx, rd.index)
+				close(rd.done)
+			}
+			continue
+		}
+		for _, rd := range run {
+			if rd.err = err; err == nil {
+				from := rd.index.CompOffset - first.CompOffset
+				to := from + uint64(rd.index.CompSize)
+				rd.p = p[from:to:to]
+			}
+			close(rd.done)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package seekable
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CommitQueue writes frames that were compressed out of order, e.g. by a custom pool
+// of workers, to the stream strictly in sequence.  Writer's WriteMany commits the frames
+// compressed by its workers the same way.
+//
+// Frames are numbered from zero.  A frame is written as soon as all frames before it
+// have been committed, and its seek table entry is appended together with the write,
+// so the seek table always describes exactly the frames written so far.
+//
+// CommitQueue is safe for concurrent use.  The writer it wraps must not be used
+// until the queue is closed.
+type CommitQueue struct {
+	w *writerImpl
+	// write writes the next frame in sequence.
+	write func(queuedFrame) error
+
+	m       sync.Mutex
+	next    uint64
+	pending map[uint64]queuedFrame
+	closed  bool
+	err     error
+}
+
+type queuedFrame struct {
+	frame []byte
+	entry seekTableEntry
+	// src is the uncompressed data of the frames compressed by WriteMany.
+	src []byte
+}
+
+// NewCommitQueue returns a queue that commits frames to the passed writer.
+// Data buffered by the writer is flushed before the first queued frame.
+func NewCommitQueue(w Writer) (*CommitQueue, error) {
+	s, ok := w.(*writerImpl)
+	if !ok {
+		return nil, fmt.Errorf("unsupported writer: %T", w)
+	}
+	if err := s.flushPending(); err != nil {
+		return nil, err
+	}
+	return newCommitQueue(s, func(f queuedFrame) error {
+		return s.writeEncoded(f.frame, f.entry)
+	}), nil
+}
+
+// newCommitQueue returns a queue that passes the frames to write in sequence.
+func newCommitQueue(w *writerImpl, write func(queuedFrame) error) *CommitQueue {
+	return &CommitQueue{
+		w:       w,
+		write:   write,
+		pending: make(map[uint64]queuedFrame),
+	}
+}
+
+// Commit queues a compressed frame with the given sequence number.  Just like in
+// Writer.WriteCompressedFrame the frame must be a single complete zstd frame
+// and checksum is only used if frame checksums are enabled.
+//
+// The frame is owned by the queue until it is written.  Commit returns the first
+// error encountered by any of the writes: once a write fails, the queue is broken
+// and all further commits fail.
+func (q *CommitQueue) Commit(seq uint64, frame []byte, decompressedSize, checksum uint32) error {
+	entry, err := q.w.compressedFrameEntry(frame, decompressedSize, checksum)
+	if err != nil {
+		return err
+	}
+	return q.push(seq, queuedFrame{frame: frame, entry: entry})
+}
+
+// push queues the frame and writes all the frames that are next in sequence.
+func (q *CommitQueue) push(seq uint64, f queuedFrame) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+	if q.closed {
+		return fmt.Errorf("commit queue is closed")
+	}
+	if _, ok := q.pending[seq]; ok || seq < q.next {
+		return fmt.Errorf("frame %d is already committed", seq)
+	}
+	q.pending[seq] = f
+
+	for {
+		f, ok := q.pending[q.next]
+		if !ok {
+			return nil
+		}
+		delete(q.pending, q.next)
+
+		if err := q.write(f); err != nil {
+			q.err = fmt.Errorf("failed to write frame %d: %w", q.next, err)
+			return q.err
+		}
+		q.next++
+	}
+}
+
+// Close closes the queue.  It fails if some of the committed frames are still
+// waiting for their predecessors.  Close does not close the underlying writer.
+func (q *CommitQueue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.closed = true
+	if q.err != nil {
+		return q.err
+	}
+	if len(q.pending) > 0 {
+		return fmt.Errorf("%d frames are waiting for frame %d", len(q.pending), q.next)
+	}
+	return nil
+}
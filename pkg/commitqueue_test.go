@@ -0,0 +1,63 @@
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCommitQueue(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	const numFrames = 16
+	frames := make([][]byte, numFrames)
+	for i := range frames {
+		frames[i] = makeTestFrame(t, i)
+	}
+
+	var expected bytes.Buffer
+	w, err := NewWriter(&expected, enc, WithFrameChecksums(true))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+
+	var b bytes.Buffer
+	w, err = NewWriter(&b, enc, WithFrameChecksums(true))
+	require.NoError(t, err)
+	q, err := NewCommitQueue(w)
+	require.NoError(t, err)
+
+	// Compress and commit frames in reverse order.
+	var g errgroup.Group
+	for i := numFrames - 1; i >= 0; i-- {
+		i := i
+		g.Go(func() error {
+			return q.Commit(uint64(i), enc.EncodeAll(frames[i], nil),
+				uint32(len(frames[i])), frameChecksum(frames[i]))
+		})
+	}
+	require.NoError(t, g.Wait())
+	require.NoError(t, q.Close())
+	require.NoError(t, w.Close())
+	assert.Equal(t, expected.Bytes(), b.Bytes())
+
+	w, err = NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	q, err = NewCommitQueue(w)
+	require.NoError(t, err)
+	frame := enc.EncodeAll(frames[0], nil)
+	require.NoError(t, q.Commit(0, frame, uint32(len(frames[0])), 0))
+	assert.Error(t, q.Commit(0, frame, uint32(len(frames[0])), 0))
+	require.NoError(t, q.Commit(2, frame, uint32(len(frames[0])), 0))
+	assert.Equal(t, int64(1), w.(*writerImpl).frames.Load())
+	assert.Error(t, q.Close())
+	assert.Error(t, q.Commit(1, frame, uint32(len(frames[0])), 0))
+}
@@ -0,0 +1,323 @@
+package seekable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// ConvertToPlain writes the data frames of the seekable stream read by r to w as they are,
+// without decompressing them, dropping the seek table and all frames without data, e.g.
+// skippable and metadata frames.  The result is a standard multi-frame zstd stream that
+// can be decompressed by any zstd implementation, e.g. `zstd -d`.
+//
+// It returns the number of bytes written.  r must be created by NewReader or NewReaderAt.
+func ConvertToPlain(w io.Writer, r Reader) (int64, error) {
+	sr, ok := r.(*readerImpl)
+	if !ok || sr.env == nil {
+		return 0, fmt.Errorf("reader does not have the compressed stream")
+	}
+	if sr.closed.Load() {
+		return 0, fmt.Errorf("reader is closed")
+	}
+
+	first := sr.GetIndexByID(0)
+	if first == nil {
+		return 0, nil
+	}
+
+	var total int64
+	var err error
+	sr.idx().index.ascend(first, func(index *env.FrameOffsetEntry) bool {
+		if index.DecompSize == 0 {
+			return true
+		}
+
+		var src []byte
+		if src, err = sr.readFrame(sr.ctx, index); err != nil {
+			return false
+		}
+		if len(src) < 4 || binary.LittleEndian.Uint32(src) != zstdFrameMagic {
+			err = fmt.Errorf("%w: frame %d at: %d is not a zstd frame", ErrCorruptSeekTable, index.ID, index.CompOffset)
+			return false
+		}
+
+		var n int
+		n, err = w.Write(src)
+		total += int64(n)
+		if err == nil && n != len(src) {
+			err = io.ErrShortWrite
+		}
+		return err == nil
+	})
+	return total, err
+}
+
+// Concatenate copies the frames of the seekable streams read by parts into w one after
+// another without recompressing them, e.g. to stitch the shards written by separate producers
+// into one archive.  Closing w afterwards writes the seek table of all the frames, with their
+// offsets following each other.  It returns the number of compressed bytes copied.
+//
+// The seek tables, including the inline checkpoints, and extension frames, e.g. content digests,
+// of the parts are dropped, as they only describe their own parts; other skippable frames are
+// copied.  If w has frame checksums enabled and a part has none, its frames are decompressed to
+// compute them.  Each part must be created by NewReader or NewReaderAt.
+func Concatenate(w Writer, parts ...Reader) (int64, error) {
+	checksums := true
+	if sw, ok := w.(*writerImpl); ok {
+		checksums = sw.checksums
+	}
+
+	var total int64
+	for i, part := range parts {
+		sr, ok := part.(*readerImpl)
+		if !ok || sr.env == nil {
+			return total, fmt.Errorf("part %d does not have the compressed stream", i)
+		}
+		if sr.closed.Load() {
+			return total, fmt.Errorf("part %d reader is closed", i)
+		}
+		n, err := concatenatePart(w, sr, checksums)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to copy part %d: %w", i, err)
+		}
+	}
+	return total, nil
+}
+
+// concatenatePart copies the frames of sr into w, see Concatenate.
+func concatenatePart(w Writer, sr *readerImpl, checksums bool) (int64, error) {
+	first := sr.GetIndexByID(0)
+	if first == nil {
+		return 0, nil
+	}
+
+	idx := sr.idx()
+	var total int64
+	var data []byte
+	var err error
+	idx.index.ascend(first, func(index *env.FrameOffsetEntry) bool {
+		if index.CompSize == 0 {
+			return true
+		}
+
+		var src []byte
+		if src, err = sr.readFrame(sr.ctx, index); err != nil {
+			return false
+		}
+		if len(src) < 4 {
+			err = fmt.Errorf("%w: frame %d at: %d is too small: %d", ErrCorruptSeekTable, index.ID, index.CompOffset, len(src))
+			return false
+		}
+
+		if magic := binary.LittleEndian.Uint32(src); magic&0xFFFFFFF0 == skippableFrameMagic {
+			tag := magic - skippableFrameMagic
+			if index.DecompSize != 0 || len(src) < frameSizeFieldSize+skippableMagicNumberFieldSize {
+				err = fmt.Errorf("%w: skippable frame %d at: %d is malformed", ErrCorruptSeekTable, index.ID, index.CompOffset)
+				return false
+			}
+			if tag == seekableTag || tag == extensionTag || len(src) == frameSizeFieldSize+skippableMagicNumberFieldSize {
+				return true
+			}
+			if err = w.WriteSkippableFrame(tag, src[frameSizeFieldSize+skippableMagicNumberFieldSize:]); err == nil {
+				total += int64(len(src))
+			}
+			return err == nil
+		}
+		if index.DecompSize == 0 {
+			// Empty frames carry no data.
+			return true
+		}
+
+		checksum := index.Checksum
+		if checksums && !idx.checksums {
+			if data, err = sr.decodeData(sr.ctx, index, src, data[:0]); err != nil {
+				return false
+			}
+			checksum = frameChecksum(data)
+		}
+		if err = w.WriteCompressedFrame(src, index.DecompSize, checksum); err != nil {
+			err = fmt.Errorf("failed to write frame %d at: %d: %w", index.ID, index.CompOffset, err)
+			return false
+		}
+		total += int64(len(src))
+		return true
+	})
+	return total, err
+}
+
+// ConvertFromPlain copies the frames of the plain multi-frame zstd stream read from r into w
+// without recompressing them, e.g. to make archives produced by other tools seekable.
+// Closing w afterwards writes the seek table.  It returns the number of bytes read from r.
+//
+// The sizes of the frames are learned from their headers.  Frames are only decompressed with dec
+// if their header does not declare the content size, or if w has frame checksums enabled and
+// the frames have no content checksum, which is the same XXH64 digest as the one in the seek table.
+// dec can be nil if no frame needs to be decompressed.
+//
+// Skippable frames are copied except for the seek tables and extension frames of seekable streams.
+// Each frame becomes a separate frame of the seekable stream, so the converted stream is only
+// as randomly accessible as the input is split into frames, e.g. by `zstd -B` or `pzstd`.
+func ConvertFromPlain(w Writer, r io.Reader, dec ZSTDDecoder) (int64, error) {
+	checksums := true
+	if sw, ok := w.(*writerImpl); ok {
+		checksums = sw.checksums
+	}
+
+	br := bufio.NewReader(r)
+	var total int64
+	var frame, data []byte
+	for {
+		var err error
+		frame, err = readPlainFrame(br, frame[:0])
+		if errors.Is(err, io.EOF) && len(frame) == 0 {
+			return total, nil
+		}
+		if err != nil {
+			return total, fmt.Errorf("failed to read frame at: %d: %w", total, err)
+		}
+		offset := total
+		total += int64(len(frame))
+
+		magic := binary.LittleEndian.Uint32(frame)
+		if magic&0xFFFFFFF0 == skippableFrameMagic {
+			tag := magic - skippableFrameMagic
+			if tag == seekableTag || tag == extensionTag || len(frame) == frameSizeFieldSize+skippableMagicNumberFieldSize {
+				continue
+			}
+			if err := w.WriteSkippableFrame(tag, frame[frameSizeFieldSize+skippableMagicNumberFieldSize:]); err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		h, err := parseZSTDFrameHeader(frame)
+		if err != nil {
+			return total, fmt.Errorf("failed to parse frame header at: %d: %w", offset, err)
+		}
+
+		var size uint64
+		var checksum uint32
+		if h.HasContentSize && (!checksums || h.Checksum) {
+			size = h.ContentSize
+			if h.Checksum {
+				checksum = binary.LittleEndian.Uint32(frame[len(frame)-4:])
+			}
+		} else {
+			if dec == nil {
+				return total, fmt.Errorf("frame at: %d needs to be decompressed, but there is no decoder", offset)
+			}
+			if data, err = dec.DecodeAll(frame, data[:0]); err != nil {
+				return total, fmt.Errorf("failed to decompress frame at: %d: %w", offset, err)
+			}
+			size = uint64(len(data))
+			checksum = frameChecksum(data)
+		}
+		if size > math.MaxUint32 {
+			return total, fmt.Errorf("%w: frame at: %d: %d", ErrFrameTooLarge, offset, size)
+		}
+		if size == 0 {
+			// Empty frames carry no data.
+			continue
+		}
+
+		if err := w.WriteCompressedFrame(frame, uint32(size), checksum); err != nil {
+			return total, fmt.Errorf("failed to write frame at: %d: %w", offset, err)
+		}
+	}
+}
+
+// readPlainFrame appends the next zstd or skippable frame read from r to dst.
+// It returns io.EOF with empty dst if r has no more frames.
+func readPlainFrame(r *bufio.Reader, dst []byte) ([]byte, error) {
+	read := func(n int) error {
+		if int64(len(dst)+n) > maxChunkSize {
+			return fmt.Errorf("%w: frame is bigger than %d", ErrFrameTooLarge, maxChunkSize)
+		}
+		dst = slices.Grow(dst, n)
+		_, err := io.ReadFull(r, dst[len(dst):len(dst)+n])
+		dst = dst[:len(dst)+n]
+		return err
+	}
+	unexpected := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if _, err := r.Peek(1); err != nil {
+		return dst, err
+	}
+	if err := read(4); err != nil {
+		return nil, unexpected(err)
+	}
+
+	magic := binary.LittleEndian.Uint32(dst)
+	if magic&0xFFFFFFF0 == skippableFrameMagic {
+		if err := read(frameSizeFieldSize); err != nil {
+			return nil, unexpected(err)
+		}
+		if err := read(int(binary.LittleEndian.Uint32(dst[4:]))); err != nil {
+			return nil, unexpected(err)
+		}
+		return dst, nil
+	}
+	if magic != zstdFrameMagic {
+		return nil, fmt.Errorf("unknown frame magic: %#x", magic)
+	}
+
+	if err := read(1); err != nil {
+		return nil, unexpected(err)
+	}
+	fhd := dst[4]
+	headerSize, _, _, _ := zstdFrameHeaderLayout(fhd)
+	if err := read(headerSize - 5); err != nil {
+		return nil, unexpected(err)
+	}
+
+	/*
+		Block_Header
+
+		3 bytes, __little-endian__ format.
+
+		|`Last_Block`|`Block_Type`|`Block_Size`|
+		|------------|------------|------------|
+		| bit 0      | bits 1-2   | bits 3-23  |
+
+		`RLE_Block` stores a single byte repeated `Block_Size` times.
+	*/
+	for last := false; !last; {
+		if err := read(3); err != nil {
+			return nil, unexpected(err)
+		}
+		bh := dst[len(dst)-3:]
+		header := uint32(bh[0]) | uint32(bh[1])<<8 | uint32(bh[2])<<16
+		last = header&1 != 0
+		size := int(header >> 3)
+		switch (header >> 1) & 3 {
+		case 1:
+			size = 1
+		case 3:
+			return nil, fmt.Errorf("reserved block type at: %d", len(dst)-3)
+		}
+		if err := read(size); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+
+	if fhd&(1<<2) != 0 {
+		// Content_Checksum
+		if err := read(4); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,250 @@
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertToPlain(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithContentDigest(), WithSeekTableCheckpointEvery(1))
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 5; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+		require.NoError(t, w.WriteSkippableFrame(1, []byte("skippable")))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	var plain bytes.Buffer
+	n, err := ConvertToPlain(&plain, r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(plain.Len()), n)
+
+	// Only the data frames are left.
+	var size int64
+	for id := int64(0); id < r.NumFrames(); id++ {
+		frame, err := r.Frame(id)
+		require.NoError(t, err)
+		if frame.DecompressedSize != 0 {
+			size += frame.CompressedSize
+		}
+	}
+	assert.Equal(t, size, n)
+
+	zr, err := zstd.NewReader(&plain)
+	require.NoError(t, err)
+	defer zr.Close()
+	all, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+
+	// Writer errors stop the conversion.
+	pr, pw := io.Pipe()
+	require.NoError(t, pr.CloseWithError(io.ErrClosedPipe))
+	_, err = ConvertToPlain(pw, r)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+	// Decoder has no frames to copy.
+	d, err := NewDecoder(b.Bytes()[b.Len()-int(r.(*readerImpl).idx().seekTableFrameSize):], dec)
+	require.NoError(t, err)
+	_, err = ConvertToPlain(&plain, d.(Reader))
+	assert.Error(t, err)
+}
+
+func TestConvertFromPlain(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var frames [][]byte
+	for i := 0; i < 4; i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+	// Spans many blocks.
+	frames = append(frames, bytes.Repeat(makeTestFrame(t, 4), 2<<10))
+	expected := bytes.Join(frames, nil)
+
+	// plain returns the frames compressed separately, big streamed frames do not declare their size.
+	plain := func(stream bool, opts ...zstd.EOption) []byte {
+		var buf bytes.Buffer
+		e, err := zstd.NewWriter(&buf, opts...)
+		require.NoError(t, err)
+		var p []byte
+		for i, frame := range frames {
+			if stream {
+				buf.Reset()
+				e.Reset(&buf)
+				_, err = e.Write(frame)
+				require.NoError(t, err)
+				require.NoError(t, e.Close())
+				p = append(p, buf.Bytes()...)
+			} else {
+				p = e.EncodeAll(frame, p)
+			}
+			if i == 2 {
+				skippable, err := createSkippableFrame(1, []byte("skippable"))
+				require.NoError(t, err)
+				p = append(p, skippable...)
+			}
+		}
+		// Empty frames are dropped.
+		return e.EncodeAll(nil, p)
+	}
+
+	convert := func(t *testing.T, input []byte, w Writer, dec ZSTDDecoder) {
+		n, err := ConvertFromPlain(w, bytes.NewReader(input), dec)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(input)), n)
+		require.NoError(t, w.Close())
+	}
+	check := func(t *testing.T, stream []byte) {
+		r, err := NewReader(bytes.NewReader(stream), dec, WithRequireChecksums())
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		assert.Equal(t, int64(len(frames)+1), r.NumFrames())
+		all, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected, all)
+	}
+
+	t.Run("decompress", func(t *testing.T) {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc)
+		require.NoError(t, err)
+		convert(t, plain(true), w, dec)
+		check(t, b.Bytes())
+
+		// Either the size or the checksum is missing.
+		for _, input := range [][]byte{plain(true), plain(false, zstd.WithEncoderCRC(false))} {
+			w, err = NewWriter(&nullWriter{}, enc)
+			require.NoError(t, err)
+			_, err = ConvertFromPlain(w, bytes.NewReader(input), nil)
+			require.ErrorContains(t, err, "no decoder")
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		// The sizes and checksums are in the frame headers.
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc)
+		require.NoError(t, err)
+		convert(t, plain(false), w, nil)
+		check(t, b.Bytes())
+
+		// Seekable streams are converted to themselves.
+		var b2 bytes.Buffer
+		w, err = NewWriter(&b2, enc)
+		require.NoError(t, err)
+		convert(t, b.Bytes(), w, nil)
+		assert.Equal(t, b.Bytes(), b2.Bytes())
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		input := plain(true)
+		for _, tc := range []struct {
+			input []byte
+			err   string
+		}{
+			{input[:len(input)-1], io.ErrUnexpectedEOF.Error()},
+			{input[:3], io.ErrUnexpectedEOF.Error()},
+			{append(bytes.Clone(input), 1, 2, 3, 4), "unknown frame magic"},
+		} {
+			w, err := NewWriter(&nullWriter{}, enc)
+			require.NoError(t, err)
+			_, err = ConvertFromPlain(w, bytes.NewReader(tc.input), dec)
+			assert.ErrorContains(t, err, tc.err)
+		}
+	})
+}
+
+func TestConcatenate(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// The shards have frames of their own, skippable frames, the seek table checkpoints
+	// and content digests, some of them have no checksums.
+	var expected, last []byte
+	var parts []Reader
+	for i := 0; i < 3; i++ {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, WithFrameChecksums(i != 1), WithContentDigest(), WithSeekTableCheckpointEvery(2))
+		require.NoError(t, err)
+		for j := 0; j < 3; j++ {
+			frame := makeTestFrame(t, i*3+j)
+			expected = append(expected, frame...)
+			_, err = w.Write(frame)
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.WriteSkippableFrame(1, []byte("skippable")))
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		parts = append(parts, r)
+		last = b.Bytes()
+	}
+
+	for _, checksums := range []bool{true, false} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, WithFrameChecksums(checksums))
+		require.NoError(t, err)
+		n, err := Concatenate(w, parts...)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Equal(t, w.Stats().CompressedBytes, n)
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+		require.NoError(t, err)
+		// The data and the skippable frames of each part are left.
+		assert.Equal(t, int64(len(parts)*4), r.NumFrames())
+		all, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected, all)
+		if checksums {
+			report, err := r.VerifyAll(context.Background(), VerifyOptions{Decode: true})
+			require.NoError(t, err)
+			require.NoError(t, report.Err())
+			assert.Equal(t, r.NumFrames(), report.FramesChecked)
+		}
+		require.NoError(t, r.Close())
+	}
+
+	w, err := NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	d, err := NewDecoder(last[len(last)-int(parts[2].(*readerImpl).idx().seekTableFrameSize):], dec)
+	require.NoError(t, err)
+	_, err = Concatenate(w, parts[0], d.(Reader))
+	assert.ErrorContains(t, err, "part 1 does not have the compressed stream")
+}
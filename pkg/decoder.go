@@ -1,6 +1,8 @@
 package seekable
 
 import (
+	"sync"
+
 	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
 )
 
@@ -58,36 +60,42 @@ func (d *decoderEnv) ReadSkipFrame(skippableFrameOffset int64) ([]byte, error) {
 }
 
 func (r *readerImpl) Size() int64 {
-	return r.endOffset
+	return r.idx().endOffset
 }
 
 func (r *readerImpl) NumFrames() int64 {
-	return r.numFrames
+	return r.idx().numFrames
 }
 
-func (r *readerImpl) GetIndexByDecompOffset(off uint64) (found *env.FrameOffsetEntry) {
-	if off >= uint64(r.endOffset) {
+func (r *readerImpl) GetIndexByDecompOffset(off uint64) *env.FrameOffsetEntry {
+	if off >= uint64(r.idx().endOffset) {
 		return nil
 	}
-
-	r.index.DescendLessOrEqual(&env.FrameOffsetEntry{DecompOffset: off}, func(index *env.FrameOffsetEntry) bool {
-		found = index
-		return false
-	})
-	return
+	return r.idx().index.byOffset(off)
 }
 
-func (r *readerImpl) GetIndexByID(id int64) (found *env.FrameOffsetEntry) {
+func (r *readerImpl) GetIndexByID(id int64) *env.FrameOffsetEntry {
 	if id < 0 {
 		return nil
 	}
+	return r.idx().index.byID(id)
+}
+
+// decoderPool keeps decoders created by the factory, so that each of the concurrent reads
+// uses its own decoder instead of sharing a single one.
+type decoderPool struct {
+	pool sync.Pool
+}
+
+func newDecoderPool(factory func() ZSTDDecoder) *decoderPool {
+	return &decoderPool{pool: sync.Pool{New: func() any { return factory() }}}
+}
 
-	r.index.Descend(func(index *env.FrameOffsetEntry) bool {
-		if index.ID == id {
-			found = index
-			return false
-		}
-		return true
-	})
-	return
+// decoder returns the decoder to decompress a frame with and the function to call once it is done.
+func (r *readerImpl) decoder() (ZSTDDecoder, func()) {
+	if r.decoders == nil {
+		return r.dec, func() {}
+	}
+	dec := r.decoders.pool.Get().(ZSTDDecoder)
+	return dec, func() { r.decoders.pool.Put(dec) }
 }
@@ -1,10 +1,17 @@
 package seekable
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"sync"
+	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
 )
 
 // Encoder is a byte-oriented API that is useful where wrapping io.Writer is not desirable.
@@ -26,61 +33,321 @@ func NewEncoder(encoder ZSTDEncoder, opts ...wOption) (Encoder, error) {
 }
 
 func (s *writerImpl) encodeOne(src []byte) ([]byte, seekTableEntry, error) {
-	if int64(len(src)) > maxChunkSize {
+	if int64(len(src)) > s.maxFrameSize {
 		return nil, seekTableEntry{},
-			fmt.Errorf("chunk size too big for seekable format: %d > %d",
-				len(src), maxChunkSize)
+			fmt.Errorf("%w: chunk size too big for seekable format: %d > %d",
+				ErrFrameTooLarge, len(src), s.maxFrameSize)
 	}
 
 	if len(src) == 0 {
 		return nil, seekTableEntry{}, nil
 	}
 
-	dst := s.enc.EncodeAll(src, nil)
+	dst, err := s.encodeAll(src, s.buffers.Get()[:0])
+	if err != nil {
+		s.buffers.Put(dst)
+		return nil, seekTableEntry{}, err
+	}
+	if len(dst) == 0 {
+		return nil, seekTableEntry{}, fmt.Errorf("encoder produced no output for %d bytes", len(src))
+	}
 
 	if int64(len(dst)) > maxChunkSize {
+		s.buffers.Put(dst)
 		return nil, seekTableEntry{},
-			fmt.Errorf("result size too big for seekable format: %d > %d",
-				len(src), maxChunkSize)
+			fmt.Errorf("%w: result size too big for seekable format: %d > %d",
+				ErrFrameTooLarge, len(dst), maxChunkSize)
+	}
+
+	if s.dictID != 0 {
+		if err := s.checkDictionary(dst); err != nil {
+			s.buffers.Put(dst)
+			return nil, seekTableEntry{}, err
+		}
 	}
 
 	return dst, seekTableEntry{
 		CompressedSize:   uint32(len(dst)),
 		DecompressedSize: uint32(len(src)),
-		Checksum:         uint32((xxhash.Sum64(src) << 32) >> 32),
+		Checksum:         s.frameChecksum(src),
 	}, nil
 }
 
+// encodeAll compresses src appending it to dst with the encoder from the pool, if there is one.
+// During WriteWithLevel the pool of the requested level is used.
+func (s *writerImpl) encodeAll(src, dst []byte) ([]byte, error) {
+	pool := s.encoders
+	if s.levelEnc != nil {
+		pool = s.levelEnc
+	}
+	if pool == nil {
+		return s.encodeVerified(s.enc, &s.encoderVerified, src, dst)
+	}
+
+	enc := pool.get()
+	defer pool.put(enc)
+	return s.encodeVerified(enc.enc, &enc.verified, src, dst)
+}
+
+// encodeVerified compresses src with enc.  In the deterministic mode the first output
+// of each encoder is compared with the output of the second compression.
+func (s *writerImpl) encodeVerified(enc ZSTDEncoder, verified *atomic.Bool, src, dst []byte) ([]byte, error) {
+	dst = s.encodeWith(enc, src, dst)
+	if !s.deterministic || verified.Load() || len(dst) == 0 {
+		return dst, nil
+	}
+
+	again := s.encodeWith(enc, src, s.buffers.Get()[:0])
+	equal := bytes.Equal(dst, again)
+	s.buffers.Put(again)
+	if !equal {
+		return dst, ErrNondeterministicEncoder
+	}
+	verified.Store(true)
+	return dst, nil
+}
+
+// encodeWith compresses src with enc.  While WriteString is in progress src is backed
+// by the string and is passed to the encoder as such.
+func (s *writerImpl) encodeWith(enc ZSTDEncoder, src, dst []byte) []byte {
+	if se, ok := enc.(ZSTDStringEncoder); ok && s.stringSrc {
+		return se.EncodeAllString(unsafe.String(unsafe.SliceData(src), len(src)), dst)
+	}
+	return enc.EncodeAll(src, dst)
+}
+
+// encoderPool keeps encoders created by the factory, so that each of the concurrent
+// WriteMany workers uses its own encoder instead of sharing a single one.
+type encoderPool struct {
+	pool sync.Pool
+}
+
+// pooledEncoder is an encoder kept by encoderPool.  verified is set once it produced
+// the same output twice, see WithDeterministicOutput.
+type pooledEncoder struct {
+	enc      ZSTDEncoder
+	verified atomic.Bool
+}
+
+func newEncoderPool(factory func() ZSTDEncoder) *encoderPool {
+	return &encoderPool{pool: sync.Pool{New: func() any { return &pooledEncoder{enc: factory()} }}}
+}
+
+func (p *encoderPool) get() *pooledEncoder {
+	return p.pool.Get().(*pooledEncoder)
+}
+
+func (p *encoderPool) put(enc *pooledEncoder) {
+	p.pool.Put(enc)
+}
+
+// checkDictionary verifies that the compressed frame uses the dictionary set by WithDictionary.
+func (s *writerImpl) checkDictionary(dst []byte) error {
+	h, err := parseZSTDFrameHeader(dst)
+	if err != nil {
+		return fmt.Errorf("failed to parse frame header: %w", err)
+	}
+	if h.DictionaryID != s.dictID {
+		return fmt.Errorf("%w: frame dictionary ID: %d, expected: %d",
+			ErrDictionaryMismatch, h.DictionaryID, s.dictID)
+	}
+	return nil
+}
+
+// frameChecksum returns the checksum of the uncompressed data for the seek table entry,
+// or zero if checksums are disabled.
+func (s *writerImpl) frameChecksum(src []byte) uint32 {
+	if !s.checksums {
+		return 0
+	}
+	return frameChecksum(src)
+}
+
+// frameChecksum returns the least significant 32 bits of the XXH64 digest of the uncompressed data.
+func frameChecksum(src []byte) uint32 {
+	return uint32((xxhash.Sum64(src) << 32) >> 32)
+}
+
 func (s *writerImpl) Encode(src []byte) ([]byte, error) {
+	if skip, err := s.skipEmpty(src); skip || err != nil {
+		return nil, err
+	}
+
 	dst, entry, err := s.encodeOne(src)
 	if err != nil {
 		return nil, err
 	}
 
+	var prefix []byte
+	addDataless := func(frame []byte) error {
+		if err := s.checkFrameCount(); err != nil {
+			return err
+		}
+		s.commitFrame(seekTableEntry{
+			CompressedSize: uint32(len(frame)),
+			Checksum:       s.frameChecksum(nil),
+		})
+		prefix = append(prefix, frame...)
+		return nil
+	}
+
+	// Metadata frame, if any, is returned along with the first frame.
+	header, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		if err := addDataless(header); err != nil {
+			return nil, err
+		}
+		s.headerWritten = true
+	}
+	if pad := s.padding(len(dst)); pad != nil {
+		if err := addDataless(pad); err != nil {
+			return nil, err
+		}
+	}
+	if prefix != nil {
+		dst = append(prefix, dst...)
+	}
+
+	if err := s.checkFrameCount(); err != nil {
+		return nil, err
+	}
+
+	s.commitFrame(entry)
+	if err := s.contentWritten(src); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// checkFrameCount returns an error if one more frame does not fit into the seek table.
+// It must be called before the frame is written, so that the stream never goes out of spec.
+func (s *writerImpl) checkFrameCount() error {
+	return s.checkFrameCountAfter(0)
+}
+
+// checkFrameCountAfter is checkFrameCount for the frame written after n frames
+// that are not in the seek table yet.
+func (s *writerImpl) checkFrameCountAfter(n int) error {
+	if frames := len(s.frameEntries) + n; int64(frames) >= s.maxFrames {
+		return fmt.Errorf("%w: number of frames for seekable format: %d >= %d",
+			ErrTooManyFrames, frames, s.maxFrames)
+	}
+	return s.checkMemory(int64(n+1) * seekTableEntrySize)
+}
+
+// seekTableEntrySize is the memory taken by a single in-memory seek table entry.
+const seekTableEntrySize = int64(unsafe.Sizeof(seekTableEntry{}))
+
+// memoryUsage returns the memory held by the buffered data and the in-memory seek table.
+func (s *writerImpl) memoryUsage() int64 {
+	return int64(len(s.pending)) + int64(len(s.frameEntries))*seekTableEntrySize
+}
+
+// checkMemory returns an error if n more bytes do not fit into the memory limit.
+func (s *writerImpl) checkMemory(n int64) error {
+	if s.memoryLimit <= 0 {
+		return nil
+	}
+	if usage := s.memoryUsage(); usage+n > s.memoryLimit {
+		return fmt.Errorf("%w: %d + %d > %d", ErrMemoryLimit, usage, n, s.memoryLimit)
+	}
+	return nil
+}
+
+// commitFrame appends the frame to the in-memory seek table and reports the progress.
+func (s *writerImpl) commitFrame(entry seekTableEntry) {
 	s.logger.Debug("appending frame", zap.Object("frame", &entry))
+	offsets := env.FrameOffsetEntry{
+		ID:           int64(len(s.frameEntries)),
+		CompOffset:   uint64(s.compressedSize.Load()),
+		DecompOffset: uint64(s.uncompressedSize.Load()),
+		CompSize:     entry.CompressedSize,
+		DecompSize:   entry.DecompressedSize,
+		Checksum:     entry.Checksum,
+	}
+	s.logFrame(&offsets)
+
 	s.frameEntries = append(s.frameEntries, entry)
-	return dst, nil
+	frames := s.frames.Inc()
+	uncompressed := s.uncompressedSize.Add(int64(entry.DecompressedSize))
+	compressed := s.compressedSize.Add(int64(entry.CompressedSize))
+
+	if s.progress != nil {
+		s.progress(uncompressed, compressed, int(frames))
+	}
+	if s.onFrameWritten != nil {
+		s.onFrameWritten(offsets)
+	}
 }
 
-func (s *writerImpl) EndStream() ([]byte, error) {
-	if int64(len(s.frameEntries)) > maxNumberOfFrames {
-		return nil, fmt.Errorf("number of frames for seekable format: %d > %d",
-			len(s.frameEntries), maxNumberOfFrames)
+// frameLogRecord is the JSON description of a frame written by WithFrameLog.
+type frameLogRecord struct {
+	ID                 int64  `json:"id"`
+	CompressedOffset   int64  `json:"compressed_offset"`
+	DecompressedOffset int64  `json:"decompressed_offset"`
+	CompressedSize     uint32 `json:"compressed_size"`
+	DecompressedSize   uint32 `json:"decompressed_size"`
+	Checksum           uint32 `json:"checksum"`
+}
+
+// logFrame describes the frame that is about to be committed in the frame log.
+func (s *writerImpl) logFrame(o *env.FrameOffsetEntry) {
+	if s.frameLog == nil || s.frameLogErr != nil {
+		return
+	}
+	s.frameLogErr = s.frameLog.Encode(&frameLogRecord{
+		ID:                 o.ID,
+		CompressedOffset:   int64(o.CompOffset),
+		DecompressedOffset: int64(o.DecompOffset),
+		CompressedSize:     o.CompSize,
+		DecompressedSize:   o.DecompSize,
+		Checksum:           o.Checksum,
+	})
+	if s.frameLogErr != nil {
+		s.frameLogErr = fmt.Errorf("failed to write frame log: %w", s.frameLogErr)
 	}
+}
 
-	seekTable := make([]byte, len(s.frameEntries)*12+9)
-	for i, e := range s.frameEntries {
-		e.marshalBinaryInline(seekTable[i*12 : (i+1)*12])
+func (s *writerImpl) EndStream() ([]byte, error) {
+	if int64(len(s.frameEntries)) > s.maxFrames {
+		return nil, fmt.Errorf("%w: number of frames for seekable format: %d > %d",
+			ErrTooManyFrames, len(s.frameEntries), s.maxFrames)
 	}
 
 	footer := seekTableFooter{
 		NumberOfFrames: uint32(len(s.frameEntries)),
 		SeekTableDescriptor: seekTableDescriptor{
-			ChecksumFlag: true,
+			ChecksumFlag:   s.checksums,
+			CompressedFlag: s.compressSeekTable,
 		},
 		SeekableMagicNumber: seekableMagicNumber,
 	}
 
-	footer.marshalBinaryInline(seekTable[len(s.frameEntries)*12 : len(s.frameEntries)*12+9])
-	return createSkippableFrame(seekableTag, seekTable)
+	entrySize := int(footer.entrySize())
+	entries := make([]byte, len(s.frameEntries)*entrySize, len(s.frameEntries)*entrySize+seekTableFooterOffset)
+	for i, e := range s.frameEntries {
+		e.marshalBinaryInline(entries[i*entrySize : (i+1)*entrySize])
+	}
+
+	seekTable := entries
+	if s.compressSeekTable {
+		seekTable = nil
+		if len(entries) > 0 {
+			var err error
+			if seekTable, err = s.encodeAll(entries, nil); err != nil {
+				return nil, err
+			}
+		}
+		if int64(len(seekTable)) > maxChunkSize {
+			return nil, fmt.Errorf("%w: compressed seek table is too big: %d > %d",
+				ErrFrameTooLarge, len(seekTable), maxChunkSize)
+		}
+		seekTable = binary.LittleEndian.AppendUint32(seekTable, uint32(len(seekTable)))
+	}
+
+	footerBytes, _ := footer.MarshalBinary()
+	return createSkippableFrame(seekableTag, append(seekTable, footerBytes...))
 }
@@ -0,0 +1,181 @@
+// Package azureenv implements the seekable reader environment for Azure Blob Storage.
+//
+// It lives in a separate module, so that the Azure SDK is only required by its users.
+package azureenv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+const (
+	defaultParallelism = 8
+	defaultTailSize    = 64 << 10
+	defaultMaxAttempts = 3
+)
+
+// Option configures the environment returned by NewREnvironment.
+type Option func(*azureREnvironment) error
+
+// WithContext sets the context of every request, cancelling it aborts all reads.
+func WithContext(ctx context.Context) Option {
+	return func(e *azureREnvironment) error { e.ctx = ctx; return nil }
+}
+
+// WithParallelism limits the number of requests in flight.  Default is 8.
+func WithParallelism(n int) Option {
+	return func(e *azureREnvironment) error {
+		if n < 1 {
+			return fmt.Errorf("parallelism must be positive: %d", n)
+		}
+		e.parallelism = n
+		return nil
+	}
+}
+
+// WithTailSize sets the number of bytes fetched from the end of the blob when it is opened.
+// The seek table is served from them if it fits.  Default is 64KiB.
+func WithTailSize(n int) Option {
+	return func(e *azureREnvironment) error {
+		if n < 9 {
+			return fmt.Errorf("tail size must be at least the footer size: %d", n)
+		}
+		e.tailSize = n
+		return nil
+	}
+}
+
+// WithMaxAttempts sets the number of attempts to read a range, including the first one.
+// The client retries failed requests on its own, but not failures while reading the response
+// body, e.g. connection resets; those are retried by the environment.  Default is 3.
+func WithMaxAttempts(n int) Option {
+	return func(e *azureREnvironment) error {
+		if n < 1 {
+			return fmt.Errorf("max attempts must be positive: %d", n)
+		}
+		e.maxAttempts = n
+		return nil
+	}
+}
+
+// WithCustomerProvidedKey reads the blob encrypted with the customer-provided AES-256 key (CPK).
+func WithCustomerProvidedKey(key []byte) Option {
+	return func(e *azureREnvironment) error {
+		if len(key) != 32 {
+			return fmt.Errorf("customer-provided key must be 32 bytes: %d", len(key))
+		}
+		sum := sha256.Sum256(key)
+		e.cpk = &blob.CPKInfo{
+			EncryptionAlgorithm: to.Ptr(blob.EncryptionAlgorithmTypeAES256),
+			EncryptionKey:       to.Ptr(base64.StdEncoding.EncodeToString(key)),
+			EncryptionKeySHA256: to.Ptr(base64.StdEncoding.EncodeToString(sum[:])),
+		}
+		return nil
+	}
+}
+
+// azureREnvironment reads the stream with ranged blob downloads, see env.NewRangeREnvironment.
+type azureREnvironment struct {
+	client      *blob.Client
+	ctx         context.Context
+	parallelism int
+	tailSize    int
+	maxAttempts int
+	cpk         *blob.CPKInfo
+
+	// etag is set by ReadTail, which happens before all ReadRange calls.
+	etag *azcore.ETag
+}
+
+// NewREnvironment returns the goroutine-safe REnvironment that reads the stream from the blob:
+//
+//	e, err := azureenv.NewREnvironment(containerClient.NewBlobClient("data.zst"))
+//	...
+//	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e))
+//
+// Since Blob Storage does not support suffix ranges, opening the blob takes two requests:
+// one for its properties and one for its end.  All the reads are sent with If-Match, so that
+// they fail with env.ErrRemoteChanged once the blob is overwritten.  Concurrent reads of
+// the same frame are coalesced into a single request.  The environment implements env.Sizer.
+func NewREnvironment(client *blob.Client, opts ...Option) (env.REnvironment, error) {
+	if client == nil {
+		return nil, fmt.Errorf("blob client must not be nil")
+	}
+
+	e := &azureREnvironment{
+		client:      client,
+		ctx:         context.Background(),
+		parallelism: defaultParallelism,
+		tailSize:    defaultTailSize,
+		maxAttempts: defaultMaxAttempts,
+	}
+	for _, o := range opts {
+		if err := o(e); err != nil {
+			return nil, err
+		}
+	}
+	return env.NewRangeREnvironment(e, e.tailSize, e.parallelism)
+}
+
+func (e *azureREnvironment) ReadTail(n int64) ([]byte, int64, error) {
+	props, err := e.client.GetProperties(e.ctx, &blob.GetPropertiesOptions{CPKInfo: e.cpk})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get properties of %s: %w", e.client.URL(), e.wrap(err))
+	}
+	if props.ContentLength == nil {
+		return nil, 0, fmt.Errorf("unknown size of %s", e.client.URL())
+	}
+	size := *props.ContentLength
+
+	e.etag = props.ETag
+	n = min(size, n)
+	if n == 0 {
+		return []byte{}, size, nil
+	}
+	tail, err := e.ReadRange(size-n, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tail, size, nil
+}
+
+func (e *azureREnvironment) ReadRange(off, n int64) ([]byte, error) {
+	resp, err := e.client.DownloadStream(e.ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: off, Count: n},
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: e.etag},
+		},
+		CPKInfo: e.cpk,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch range at %d of %s: %w", off, e.client.URL(), e.wrap(err))
+	}
+
+	// The retry reader resumes interrupted reads with the same If-Match condition.
+	body := resp.NewRetryReader(e.ctx, &blob.RetryReaderOptions{MaxRetries: int32(e.maxAttempts - 1)})
+	defer body.Close()
+
+	p := make([]byte, n)
+	if _, err := io.ReadFull(body, p); err != nil {
+		return nil, fmt.Errorf("failed to read range at %d of %s: %w", off, e.client.URL(), e.wrap(err))
+	}
+	return p, nil
+}
+
+// wrap marks failed preconditions with env.ErrRemoteChanged.
+func (e *azureREnvironment) wrap(err error) error {
+	if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+		return fmt.Errorf("%w: %w", env.ErrRemoteChanged, err)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package azureenv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// fakeBlob serves blob properties and ranged downloads from memory.
+type fakeBlob struct {
+	m      sync.Mutex
+	data   []byte
+	etag   string
+	ranges []string
+	keys   []string
+}
+
+func (f *fakeBlob) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.ranges = append(f.ranges, req.Method+" "+req.Header.Get("X-Ms-Range"))
+	f.keys = append(f.keys, req.Header.Get("X-Ms-Encryption-Key-Sha256"))
+	if m := req.Header.Get("If-Match"); m != "" && m != f.etag {
+		w.Header().Set("X-Ms-Error-Code", "ConditionNotMet")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if rng := req.Header.Get("X-Ms-Range"); rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	w.Header().Set("ETag", f.etag)
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(f.data))
+}
+
+func TestREnvironment(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := seekable.NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = w.Write([]byte(fmt.Sprintf("frame %d;", i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	fake := &fakeBlob{data: b.Bytes(), etag: `"v1"`}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client, err := blob.NewClientWithNoCredential(srv.URL+"/container/blob", &blob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+
+	_, err = NewREnvironment(nil)
+	require.Error(t, err)
+	_, err = NewREnvironment(client, WithCustomerProvidedKey([]byte("short")))
+	require.Error(t, err)
+
+	e, err := NewREnvironment(client, WithCustomerProvidedKey(bytes.Repeat([]byte{1}, 32)), WithTailSize(1<<10))
+	require.NoError(t, err)
+	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e), seekable.WithReadConcurrency(4))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "frame 0;frame 1;frame 2;frame 3;frame 4;frame 5;frame 6;frame 7;frame 8;frame 9;", string(all))
+
+	fake.m.Lock()
+	// The seek table is served from the tail.
+	assert.Equal(t, []string{"HEAD ", fmt.Sprintf("GET bytes=0-%d", len(fake.data)-1)}, fake.ranges[:2])
+	assert.Len(t, fake.ranges, 2+10)
+	for _, k := range fake.keys {
+		assert.NotEmpty(t, k)
+	}
+
+	// The blob is overwritten.
+	fake.etag = `"v2"`
+	fake.m.Unlock()
+
+	_, err = r.ReadAt(make([]byte, 4), 0)
+	assert.ErrorIs(t, err, env.ErrRemoteChanged)
+}
@@ -1,9 +1,13 @@
 package env
 
+import "context"
+
 // WEnvironment can be used to inject a custom file writer that is different from normal WriteCloser.
 // This is useful when, for example there is a custom chunking code.
 type WEnvironment interface {
 	// WriteFrame is called each time frame is encoded and needs to be written upstream.
+	// Implementations may retain p, unless the writer is created with a BufferPool,
+	// which reuses the buffer once WriteFrame returns.
 	WriteFrame(p []byte) (n int, err error)
 	// WriteSeekTable is called on Close to flush the seek table.
 	WriteSeekTable(p []byte) (n int, err error)
@@ -20,3 +24,18 @@ type REnvironment interface {
 	// including the `Skippable_Magic_Number` and `Frame_Size`.
 	ReadSkipFrame(skippableFrameOffset int64) ([]byte, error)
 }
+
+// Sizer can be optionally implemented by the REnvironment that knows the total size
+// of the compressed stream.  It allows the reader to recover the seek table from the
+// latest checkpoint if the stream was truncated, e.g. because the writer crashed.
+type Sizer interface {
+	// Size returns the size of the compressed stream.
+	Size() (int64, error)
+}
+
+// ContextREnvironment can be optionally implemented by the REnvironment that does network I/O,
+// so that the reads stop once the context of the reader's call is done, see Reader's ReadAtContext.
+type ContextREnvironment interface {
+	// GetFrameByIndexContext is GetFrameByIndex that stops once ctx is done.
+	GetFrameByIndexContext(ctx context.Context, index FrameOffsetEntry) ([]byte, error)
+}
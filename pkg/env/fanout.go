@@ -0,0 +1,56 @@
+package env
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+// fanOutWEnvironment replicates all writes to every environment.
+type fanOutWEnvironment struct {
+	envs []WEnvironment
+}
+
+// NewFanOutWEnvironment returns the WEnvironment that writes every frame and the seek table
+// to all of the passed environments concurrently, e.g. to a local disk and a remote storage.
+// Frame boundaries are preserved for each of them.
+//
+// A write succeeds only if it succeeds for every environment.  Environments that succeeded
+// are not rolled back, so after a failure the copies may differ.
+func NewFanOutWEnvironment(envs ...WEnvironment) WEnvironment {
+	return &fanOutWEnvironment{envs: envs}
+}
+
+func (f *fanOutWEnvironment) WriteFrame(p []byte) (int, error) {
+	return f.write(p, WEnvironment.WriteFrame)
+}
+
+func (f *fanOutWEnvironment) WriteSeekTable(p []byte) (int, error) {
+	return f.write(p, WEnvironment.WriteSeekTable)
+}
+
+func (f *fanOutWEnvironment) write(p []byte, write func(WEnvironment, []byte) (int, error)) (int, error) {
+	errs := make([]error, len(f.envs))
+
+	var wg sync.WaitGroup
+	for i, e := range f.envs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := write(e, p)
+			if err == nil && n != len(p) {
+				err = fmt.Errorf("partial write: %d out of %d", n, len(p))
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("environment %d: %w", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := multierr.Combine(errs...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -33,6 +33,12 @@ func (o *FrameOffsetEntry) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return nil
 }
 
+// Less orders entries by their decompressed offset.  Frames that do not contain
+// any data (e.g. skippable frames) share the offset with the next frame, so ties are
+// broken by the frame ID.
 func Less(a, b *FrameOffsetEntry) bool {
-	return a.DecompOffset < b.DecompOffset
+	if a.DecompOffset != b.DecompOffset {
+		return a.DecompOffset < b.DecompOffset
+	}
+	return a.ID < b.ID
 }
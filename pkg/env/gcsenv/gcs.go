@@ -0,0 +1,143 @@
+// Package gcsenv implements the seekable reader environment for objects stored in Google Cloud Storage.
+//
+// It lives in a separate module, so that the Cloud Storage client is only required by its users.
+package gcsenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+const (
+	defaultParallelism = 8
+	defaultTailSize    = 64 << 10
+)
+
+// Option configures the environment returned by NewREnvironment.
+type Option func(*gcsREnvironment) error
+
+// WithContext sets the context of every request, cancelling it aborts all reads.
+func WithContext(ctx context.Context) Option {
+	return func(e *gcsREnvironment) error { e.ctx = ctx; return nil }
+}
+
+// WithParallelism limits the number of requests in flight.  Default is 8.
+func WithParallelism(n int) Option {
+	return func(e *gcsREnvironment) error {
+		if n < 1 {
+			return fmt.Errorf("parallelism must be positive: %d", n)
+		}
+		e.parallelism = n
+		return nil
+	}
+}
+
+// WithTailSize sets the number of bytes fetched from the end of the object by the first
+// request.  The seek table is served from them if it fits.  Default is 64KiB.
+func WithTailSize(n int) Option {
+	return func(e *gcsREnvironment) error {
+		if n < 9 {
+			return fmt.Errorf("tail size must be at least the footer size: %d", n)
+		}
+		e.tailSize = n
+		return nil
+	}
+}
+
+// WithEncryptionKey reads the object encrypted with the customer-supplied AES-256 key (CSEK).
+func WithEncryptionKey(key []byte) Option {
+	return func(e *gcsREnvironment) error {
+		if len(key) != 32 {
+			return fmt.Errorf("encryption key must be 32 bytes: %d", len(key))
+		}
+		e.obj = e.obj.Key(key)
+		return nil
+	}
+}
+
+// gcsREnvironment reads the stream with ranged object reads, see env.NewRangeREnvironment.
+type gcsREnvironment struct {
+	ctx         context.Context
+	parallelism int
+	tailSize    int
+
+	// The generation precondition of obj is set by ReadTail, which happens before all ReadRange calls.
+	obj *storage.ObjectHandle
+}
+
+// NewREnvironment returns the goroutine-safe REnvironment that reads the stream from the object:
+//
+//	e, err := gcsenv.NewREnvironment(client.Bucket("bucket").Object("data.zst"))
+//	...
+//	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e))
+//
+// The first request fetches the end of the object along with its size and generation.
+// All the following requests are sent with the generation precondition, so that the reads
+// fail with env.ErrRemoteChanged once the object is overwritten.  Concurrent reads of
+// the same frame are coalesced into a single request.  The environment implements env.Sizer.
+//
+// Retries are configured on the object handle with ObjectHandle.Retryer.  Interrupted
+// downloads are resumed by the client.
+func NewREnvironment(obj *storage.ObjectHandle, opts ...Option) (env.REnvironment, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("object handle must not be nil")
+	}
+
+	e := &gcsREnvironment{
+		obj:         obj,
+		ctx:         context.Background(),
+		parallelism: defaultParallelism,
+		tailSize:    defaultTailSize,
+	}
+	for _, o := range opts {
+		if err := o(e); err != nil {
+			return nil, err
+		}
+	}
+	return env.NewRangeREnvironment(e, e.tailSize, e.parallelism)
+}
+
+func (e *gcsREnvironment) ReadTail(n int64) ([]byte, int64, error) {
+	r, err := e.obj.NewRangeReader(e.ctx, -n, -1)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch tail of %s/%s: %w", e.obj.BucketName(), e.obj.ObjectName(), err)
+	}
+	defer r.Close()
+
+	size := r.Attrs.Size
+	tail := make([]byte, min(size, n))
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return nil, 0, fmt.Errorf("failed to read tail of %s/%s: %w", e.obj.BucketName(), e.obj.ObjectName(), err)
+	}
+
+	if r.Attrs.Generation > 0 {
+		e.obj = e.obj.If(storage.Conditions{GenerationMatch: r.Attrs.Generation})
+	}
+	return tail, size, nil
+}
+
+func (e *gcsREnvironment) ReadRange(off, n int64) ([]byte, error) {
+	r, err := e.obj.NewRangeReader(e.ctx, off, n)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			err = fmt.Errorf("%w: %w", env.ErrRemoteChanged, err)
+		}
+		return nil, fmt.Errorf("failed to fetch range at %d of %s/%s: %w", off, e.obj.BucketName(), e.obj.ObjectName(), err)
+	}
+	defer r.Close()
+
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, fmt.Errorf("failed to read range at %d of %s/%s: %w", off, e.obj.BucketName(), e.obj.ObjectName(), err)
+	}
+	return p, nil
+}
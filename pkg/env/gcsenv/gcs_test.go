@@ -0,0 +1,106 @@
+package gcsenv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// fakeGCS serves the XML API object reads from memory.
+type fakeGCS struct {
+	m          sync.Mutex
+	data       []byte
+	generation string
+	ranges     []string
+	keys       []string
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.ranges = append(f.ranges, req.Header.Get("Range"))
+	f.keys = append(f.keys, req.Header.Get("X-Goog-Encryption-Key-Sha256"))
+	if req.URL.Path != "/bucket/object" {
+		http.NotFound(w, req)
+		return
+	}
+	if gen := req.Header.Get("X-Goog-If-Generation-Match"); gen != "" && gen != f.generation {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("X-Goog-Generation", f.generation)
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(f.data))
+}
+
+func TestREnvironment(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := seekable.NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = w.Write([]byte(fmt.Sprintf("frame %d;", i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	fake := &fakeGCS{data: b.Bytes(), generation: "1"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+
+	client, err := storage.NewClient(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	obj := client.Bucket("bucket").Object("object")
+
+	_, err = NewREnvironment(nil)
+	require.Error(t, err)
+	_, err = NewREnvironment(obj, WithEncryptionKey([]byte("short")))
+	require.Error(t, err)
+
+	e, err := NewREnvironment(obj, WithEncryptionKey(bytes.Repeat([]byte{1}, 32)), WithParallelism(2))
+	require.NoError(t, err)
+	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e), seekable.WithReadConcurrency(4))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "frame 0;frame 1;frame 2;frame 3;frame 4;frame 5;frame 6;frame 7;frame 8;frame 9;", string(all))
+
+	fake.m.Lock()
+	// The seek table is served from the tail.
+	assert.Equal(t, "bytes=-65536", fake.ranges[0])
+	assert.Len(t, fake.ranges, 1+10)
+	for _, k := range fake.keys {
+		assert.NotEmpty(t, k)
+	}
+
+	// The object is overwritten.
+	fake.generation = "2"
+	fake.m.Unlock()
+
+	_, err = r.ReadAt(make([]byte, 4), 0)
+	assert.ErrorIs(t, err, env.ErrRemoteChanged)
+}
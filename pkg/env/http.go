@@ -0,0 +1,261 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrRemoteChanged is returned by the HTTP environment if the remote object was modified
+// after it was opened, i.e. its ETag or Last-Modified date no longer match.
+var ErrRemoteChanged = errors.New("remote object changed")
+
+const (
+	defaultHTTPParallelism = 8
+	defaultHTTPTailSize    = 64 << 10
+)
+
+// HTTPOption configures the environment returned by NewHTTPREnvironment.
+type HTTPOption func(*httpRangeReader) error
+
+// WithHTTPClient sets the client used for the requests.  By default the environment uses
+// its own client with a transport that keeps enough idle connections for the parallelism.
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(r *httpRangeReader) error { r.client = c; return nil }
+}
+
+// WithHTTPHeader adds headers to every request, e.g. Authorization.
+func WithHTTPHeader(h http.Header) HTTPOption {
+	return func(r *httpRangeReader) error { r.header = h.Clone(); return nil }
+}
+
+// WithHTTPContext sets the context of every request, cancelling it aborts all reads.
+func WithHTTPContext(ctx context.Context) HTTPOption {
+	return func(r *httpRangeReader) error { r.ctx = ctx; return nil }
+}
+
+// WithHTTPParallelism limits the number of requests in flight, which matters for readers with
+// prefetch or concurrent reads.  Default is 8.
+func WithHTTPParallelism(n int) HTTPOption {
+	return func(r *httpRangeReader) error {
+		if n < 1 {
+			return fmt.Errorf("parallelism must be positive: %d", n)
+		}
+		r.parallelism = n
+		return nil
+	}
+}
+
+// WithHTTPTailSize sets the number of bytes fetched from the end of the object by the first
+// request.  The seek table is served from them if it fits, so that opening the stream
+// takes a single round trip.  Default is 64KiB.
+func WithHTTPTailSize(n int) HTTPOption {
+	return func(r *httpRangeReader) error {
+		if n < 9 {
+			return fmt.Errorf("tail size must be at least the footer size: %d", n)
+		}
+		r.tailSize = n
+		return nil
+	}
+}
+
+// httpRangeReader reads the object with HTTP Range requests, see NewRangeREnvironment.
+type httpRangeReader struct {
+	url         string
+	client      *http.Client
+	header      http.Header
+	ctx         context.Context
+	parallelism int
+	tailSize    int
+
+	// validator is the If-Range value: the strong ETag or the Last-Modified date.
+	// It is set by ReadTail, which happens before all ReadRange calls, as is etag.
+	validator string
+	etag      string
+}
+
+var _ ContextRangeReader = (*httpRangeReader)(nil)
+
+// NewHTTPREnvironment returns the goroutine-safe REnvironment that reads the stream from
+// the HTTP(S) URL with Range requests, e.g. from a CDN:
+//
+//	e, err := env.NewHTTPREnvironment("https://example.com/data.zst")
+//	...
+//	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e))
+//
+// The first request fetches the end of the object along with its size and ETag.  All the
+// following requests are sent with If-Range, so that the reads fail with ErrRemoteChanged
+// instead of mixing up different versions of the object.  Concurrent reads of the same frame
+// are coalesced into a single request, see NewRangeREnvironment.  The server must support Range requests.
+func NewHTTPREnvironment(rawURL string, opts ...HTTPOption) (REnvironment, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+
+	r := &httpRangeReader{
+		url:         rawURL,
+		ctx:         context.Background(),
+		parallelism: defaultHTTPParallelism,
+		tailSize:    defaultHTTPTailSize,
+	}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.client == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConnsPerHost = r.parallelism
+		r.client = &http.Client{Transport: t}
+	}
+	return NewRangeREnvironment(r, r.tailSize, r.parallelism)
+}
+
+func (r *httpRangeReader) ReadTail(n int64) ([]byte, int64, error) {
+	req, err := r.newRequest(r.ctx, "bytes=-"+strconv.FormatInt(n, 10))
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch tail: %w", err)
+	}
+	defer closeBody(resp.Body)
+
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, _, size, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, err
+		}
+	case http.StatusOK:
+		// The whole object is smaller than the tail, or the server ignores ranges.
+		size = resp.ContentLength
+		if size < 0 || size > n {
+			return nil, 0, fmt.Errorf("server does not support range requests")
+		}
+	default:
+		return nil, 0, fmt.Errorf("failed to fetch tail: unexpected status: %s", resp.Status)
+	}
+
+	tail := make([]byte, min(size, n))
+	if _, err := io.ReadFull(resp.Body, tail); err != nil {
+		return nil, 0, fmt.Errorf("failed to read tail: %w", err)
+	}
+
+	r.etag = resp.Header.Get("ETag")
+	if r.etag != "" && !strings.HasPrefix(r.etag, "W/") {
+		r.validator = r.etag
+	} else {
+		r.validator = resp.Header.Get("Last-Modified")
+	}
+	return tail, size, nil
+}
+
+func (r *httpRangeReader) ReadRange(off, n int64) ([]byte, error) {
+	return r.ReadRangeContext(r.ctx, off, n)
+}
+
+// ReadRangeContext implements ContextRangeReader, the request is aborted once either ctx
+// or the context set by WithHTTPContext is done.
+func (r *httpRangeReader) ReadRangeContext(ctx context.Context, off, n int64) ([]byte, error) {
+	if ctx != r.ctx {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(r.ctx, cancel)()
+	}
+
+	req, err := r.newRequest(ctx, fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if err != nil {
+		return nil, err
+	}
+	if r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch range at %d: %w", off, err)
+	}
+	defer closeBody(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if r.validator != "" {
+			// The validator did not match, so the server sent the whole new object.
+			return nil, ErrRemoteChanged
+		}
+		return nil, fmt.Errorf("server does not support range requests")
+	default:
+		return nil, fmt.Errorf("failed to fetch range at %d: unexpected status: %s", off, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); r.etag != "" && etag != "" && etag != r.etag {
+		return nil, ErrRemoteChanged
+	}
+
+	start, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if start != off {
+		return nil, fmt.Errorf("server returned range at %d instead of %d", start, off)
+	}
+
+	p := make([]byte, n)
+	if _, err := io.ReadFull(resp.Body, p); err != nil {
+		return nil, fmt.Errorf("failed to read range at %d: %w", off, err)
+	}
+	return p, nil
+}
+
+func (r *httpRangeReader) newRequest(ctx context.Context, rng string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", rng)
+	return req, nil
+}
+
+// parseContentRange parses the "bytes first-last/size" value of the Content-Range header.
+func parseContentRange(s string) (first, last, size int64, err error) {
+	rng, total, ok := strings.Cut(strings.TrimPrefix(s, "bytes "), "/")
+	if !ok || !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	f, l, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	if first, err = strconv.ParseInt(f, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	if last, err = strconv.ParseInt(l, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	return first, last, size, nil
+}
+
+// closeBody drains the response body so that the connection can be reused.
+func closeBody(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	_ = body.Close()
+}
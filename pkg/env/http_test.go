@@ -0,0 +1,106 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPREnvironment(t *testing.T) {
+	t.Parallel()
+
+	var m sync.Mutex
+	data, etag := bytes.Repeat([]byte("0123456789"), 10), `"v1"`
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		content, tag := data, etag
+		ranges = append(ranges, req.Header.Get("Range"))
+		m.Unlock()
+		w.Header().Set("ETag", tag)
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	_, err := NewHTTPREnvironment("ftp://example.com/test")
+	require.ErrorContains(t, err, "unsupported url scheme")
+	_, err = NewHTTPREnvironment(srv.URL, WithHTTPParallelism(0))
+	require.Error(t, err)
+	_, err = NewHTTPREnvironment(srv.URL, WithHTTPTailSize(8))
+	require.Error(t, err)
+
+	// The whole object fits into the tail fetched by the first request.
+	e, err := NewHTTPREnvironment(srv.URL, WithHTTPParallelism(2))
+	require.NoError(t, err)
+	p, err := e.ReadFooter()
+	require.NoError(t, err)
+	assert.Equal(t, data, p)
+	size, err := e.(Sizer).Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	p, err = e.ReadSkipFrame(20)
+	require.NoError(t, err)
+	assert.Equal(t, data[80:], p)
+	m.Lock()
+	assert.Equal(t, []string{"bytes=-65536"}, ranges)
+	m.Unlock()
+
+	e, err = NewHTTPREnvironment(srv.URL, WithHTTPTailSize(10))
+	require.NoError(t, err)
+	p, err = e.ReadFooter()
+	require.NoError(t, err)
+	assert.Equal(t, data[90:], p)
+	p, err = e.GetFrameByIndex(FrameOffsetEntry{CompOffset: 5, CompSize: 20})
+	require.NoError(t, err)
+	assert.Equal(t, data[5:25], p)
+	p, err = e.ReadSkipFrame(20)
+	require.NoError(t, err)
+	assert.Equal(t, data[80:], p)
+
+	// The object is replaced.
+	m.Lock()
+	data, etag = bytes.Repeat([]byte("9876543210"), 10), `"v2"`
+	m.Unlock()
+
+	_, err = e.GetFrameByIndex(FrameOffsetEntry{CompOffset: 5, CompSize: 20})
+	assert.ErrorIs(t, err, ErrRemoteChanged)
+}
+
+func TestHTTPREnvironmentContext(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Range") != "bytes=-10" {
+			<-req.Context().Done()
+			return
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	// The deadline is propagated into the request.
+	e, err := NewHTTPREnvironment(srv.URL, WithHTTPTailSize(10))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = e.(ContextREnvironment).GetFrameByIndexContext(ctx, FrameOffsetEntry{CompOffset: 5, CompSize: 20})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Cancelling the context of the environment aborts the reads without a context.
+	ctx, cancel = context.WithCancel(context.Background())
+	e, err = NewHTTPREnvironment(srv.URL, WithHTTPTailSize(10), WithHTTPContext(ctx))
+	require.NoError(t, err)
+	_, err = e.ReadFooter()
+	require.NoError(t, err)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = e.GetFrameByIndex(FrameOffsetEntry{CompOffset: 5, CompSize: 20})
+	assert.ErrorIs(t, err, context.Canceled)
+}
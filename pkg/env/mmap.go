@@ -0,0 +1,103 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errMmapUnsupported is returned by mmap on platforms without memory mapping.
+var errMmapUnsupported = errors.New("mmap is not supported")
+
+// MmapREnvironment is the REnvironment that reads the stream from a memory-mapped local file,
+// so that reading a frame is a copy-free page cache access.  It is goroutine-safe.
+type MmapREnvironment struct {
+	// data is the mapping of the file, or nil if the file could not be mapped
+	// and is read with ra instead.
+	data []byte
+	ra   io.ReaderAt
+	size int64
+}
+
+var _ Sizer = (*MmapREnvironment)(nil)
+
+// NewMmapREnvironment maps f into memory for reading.  On platforms without mmap, or if the file
+// can not be mapped, e.g. because it does not fit into the address space, it falls back to
+// reading f with ReadAt, in which case f must stay open while the environment is used.
+// Otherwise f can be closed right away.
+//
+// Frames returned by the environment point into the mapping, so Close must only be called
+// once neither the reader nor the values returned by it, e.g. by FrameTag, are used anymore.
+func NewMmapREnvironment(f *os.File) (*MmapREnvironment, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := fi.Size()
+
+	e := &MmapREnvironment{ra: f, size: size}
+	if size > 0 && size == int64(int(size)) {
+		if data, err := mmap(f, int(size)); err == nil {
+			e.data = data
+			e.ra = nil
+		}
+	}
+	return e, nil
+}
+
+// Mapped reports whether the file is memory-mapped rather than read with ReadAt.
+func (e *MmapREnvironment) Mapped() bool {
+	return e.data != nil
+}
+
+func (e *MmapREnvironment) GetFrameByIndex(index FrameOffsetEntry) ([]byte, error) {
+	return e.slice(int64(index.CompOffset), int64(index.CompSize))
+}
+
+func (e *MmapREnvironment) ReadFooter() ([]byte, error) {
+	return e.ReadSkipFrame(min(e.size, 9))
+}
+
+func (e *MmapREnvironment) ReadSkipFrame(skippableFrameOffset int64) ([]byte, error) {
+	if skippableFrameOffset > e.size {
+		return nil, fmt.Errorf("skippable frame offset %d is beyond the file size %d", skippableFrameOffset, e.size)
+	}
+	return e.slice(e.size-skippableFrameOffset, skippableFrameOffset)
+}
+
+func (e *MmapREnvironment) Size() (int64, error) {
+	return e.size, nil
+}
+
+// Close unmaps the file.
+func (e *MmapREnvironment) Close() error {
+	if e.data == nil {
+		return nil
+	}
+	data := e.data
+	e.data = nil
+	if err := munmap(data); err != nil {
+		return fmt.Errorf("failed to unmap file: %w", err)
+	}
+	return nil
+}
+
+// slice returns n bytes of the file at off.
+func (e *MmapREnvironment) slice(off, n int64) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > e.size {
+		return nil, fmt.Errorf("range %d-%d is beyond the file size %d: %w", off, off+n, e.size, io.ErrUnexpectedEOF)
+	}
+	if e.data != nil {
+		return e.data[off : off+n : off+n], nil
+	}
+	if e.ra == nil {
+		return nil, fmt.Errorf("environment is closed")
+	}
+
+	p := make([]byte, n)
+	if m, err := e.ra.ReadAt(p, off); err != nil && !(errors.Is(err, io.EOF) && m == len(p)) {
+		return nil, err
+	}
+	return p, nil
+}
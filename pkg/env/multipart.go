@@ -0,0 +1,70 @@
+package env
+
+import "fmt"
+
+// PartUploader uploads consecutive parts of the stream, e.g. parts of an S3 multipart upload.
+type PartUploader interface {
+	// UploadPart uploads the part with the given number, starting from 1.
+	// p is only valid until UploadPart returns.
+	UploadPart(number int, p []byte) error
+	// Complete is called after the last part, which ends with the seek table, is uploaded.
+	Complete() error
+}
+
+// PartWEnvironment is the WEnvironment that is notified by the writer when a part of the stream
+// is complete, see seekable.WithPartSize.
+type PartWEnvironment interface {
+	WEnvironment
+
+	// CompletePart is called right after the frame that completes the current part.
+	// Parts always end at frame boundaries.
+	CompletePart() error
+}
+
+// multipartWEnvironment buffers frames of the current part and uploads it on CompletePart.
+type multipartWEnvironment struct {
+	u      PartUploader
+	buf    []byte
+	number int
+}
+
+// NewMultipartWEnvironment returns the PartWEnvironment that streams the compressed data to u
+// one part at a time, so that only the current part is kept in memory.  The last part contains
+// the seek table and may be smaller than the part size.
+//
+// The environment is not goroutine-safe and must not be shared between writers.
+func NewMultipartWEnvironment(u PartUploader) PartWEnvironment {
+	return &multipartWEnvironment{u: u}
+}
+
+func (e *multipartWEnvironment) WriteFrame(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	return len(p), nil
+}
+
+func (e *multipartWEnvironment) CompletePart() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+
+	if err := e.u.UploadPart(e.number+1, e.buf); err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", e.number+1, err)
+	}
+	e.number++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *multipartWEnvironment) WriteSeekTable(p []byte) (int, error) {
+	n := len(e.buf)
+	e.buf = append(e.buf, p...)
+	if err := e.CompletePart(); err != nil {
+		e.buf = e.buf[:n]
+		return 0, err
+	}
+
+	if err := e.u.Complete(); err != nil {
+		return len(p), fmt.Errorf("failed to complete upload: %w", err)
+	}
+	return len(p), nil
+}
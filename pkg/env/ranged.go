@@ -0,0 +1,206 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RangeReader reads a remote object by byte ranges, it is the backend of the environment
+// returned by NewRangeREnvironment, e.g. the client of an object storage.
+type RangeReader interface {
+	// ReadTail returns the last n bytes of the object, or all of it if it is smaller,
+	// and the object size.  It is called once before ReadRange, so that the backend can
+	// pin the version of the object it reads from then on.
+	ReadTail(n int64) (tail []byte, size int64, err error)
+	// ReadRange returns n bytes of the object starting at off.
+	ReadRange(off, n int64) ([]byte, error)
+}
+
+// ContextRangeReader can be optionally implemented by the RangeReader, so that its reads are
+// aborted once nobody waits for them, see NewRangeREnvironment.
+type ContextRangeReader interface {
+	// ReadRangeContext is ReadRange that stops once ctx is done.
+	ReadRangeContext(ctx context.Context, off, n int64) ([]byte, error)
+}
+
+// rangeREnvironment reads the stream with the ranged reads of RangeReader.
+type rangeREnvironment struct {
+	r        RangeReader
+	tailSize int64
+
+	sem chan struct{}
+
+	// m guards the state below; size and tail are set by the first successful ReadTail.
+	m     sync.Mutex
+	size  int64
+	tail  []byte
+	calls map[byteRange]*rangeCall
+}
+
+var (
+	_ Sizer               = (*rangeREnvironment)(nil)
+	_ ContextREnvironment = (*rangeREnvironment)(nil)
+)
+
+type byteRange struct {
+	off, n int64
+}
+
+// rangeCall is the read in flight that is shared by all concurrent reads of the same range.
+type rangeCall struct {
+	done chan struct{}
+	p    []byte
+	err  error
+
+	// waiters is the number of reads waiting for the call, guarded by rangeREnvironment.m.
+	// The call is cancelled once all of them are done.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewRangeREnvironment returns the goroutine-safe REnvironment that reads the stream from
+// the remote object with r.  The first read fetches the last tailSize bytes of the object,
+// the seek table is served from them if it fits.  Concurrent reads of the same frame, e.g.
+// by prefetch and ReadAt, are coalesced into a single ReadRange, and at most parallelism
+// calls of r are in flight at once.  The returned environment implements Sizer and
+// ContextREnvironment: a read stops waiting once its context is done, and if r implements
+// ContextRangeReader, the shared ReadRangeContext is cancelled once all of its reads are done.
+func NewRangeREnvironment(r RangeReader, tailSize, parallelism int) (REnvironment, error) {
+	if tailSize < 9 {
+		return nil, fmt.Errorf("tail size must be at least the footer size: %d", tailSize)
+	}
+	if parallelism < 1 {
+		return nil, fmt.Errorf("parallelism must be positive: %d", parallelism)
+	}
+	return &rangeREnvironment{
+		r:        r,
+		tailSize: int64(tailSize),
+		sem:      make(chan struct{}, parallelism),
+		size:     -1,
+		calls:    make(map[byteRange]*rangeCall),
+	}, nil
+}
+
+func (e *rangeREnvironment) GetFrameByIndex(index FrameOffsetEntry) ([]byte, error) {
+	return e.GetFrameByIndexContext(context.Background(), index)
+}
+
+func (e *rangeREnvironment) GetFrameByIndexContext(ctx context.Context, index FrameOffsetEntry) ([]byte, error) {
+	if index.CompSize == 0 {
+		return []byte{}, nil
+	}
+	if _, err := e.stat(); err != nil {
+		return nil, err
+	}
+	return e.get(ctx, int64(index.CompOffset), int64(index.CompSize))
+}
+
+func (e *rangeREnvironment) ReadFooter() ([]byte, error) {
+	if _, err := e.stat(); err != nil {
+		return nil, err
+	}
+	return e.tail, nil
+}
+
+func (e *rangeREnvironment) ReadSkipFrame(skippableFrameOffset int64) ([]byte, error) {
+	size, err := e.stat()
+	if err != nil {
+		return nil, err
+	}
+	if skippableFrameOffset > size {
+		return nil, fmt.Errorf("skippable frame offset %d is beyond the object size %d", skippableFrameOffset, size)
+	}
+	if skippableFrameOffset <= int64(len(e.tail)) {
+		buf := make([]byte, skippableFrameOffset)
+		copy(buf, e.tail[int64(len(e.tail))-skippableFrameOffset:])
+		return buf, nil
+	}
+	return e.get(context.Background(), size-skippableFrameOffset, skippableFrameOffset)
+}
+
+func (e *rangeREnvironment) Size() (int64, error) {
+	return e.stat()
+}
+
+// stat fetches the tail of the object on the first call and returns the object size.
+func (e *rangeREnvironment) stat() (int64, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if e.size >= 0 {
+		return e.size, nil
+	}
+
+	e.sem <- struct{}{}
+	tail, size, err := e.r.ReadTail(e.tailSize)
+	<-e.sem
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(tail)) != min(size, e.tailSize) {
+		return 0, fmt.Errorf("tail size mismatch: %d, expected: %d", len(tail), min(size, e.tailSize))
+	}
+
+	e.tail = tail
+	e.size = size
+	return size, nil
+}
+
+// get returns n bytes of the object starting at off, sharing the result with concurrent
+// calls for the same range.
+func (e *rangeREnvironment) get(ctx context.Context, off, n int64) ([]byte, error) {
+	rng := byteRange{off: off, n: n}
+
+	e.m.Lock()
+	c, ok := e.calls[rng]
+	if !ok {
+		// The call outlives the context of the read that started it, if others wait for it.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &rangeCall{done: make(chan struct{}), cancel: cancel}
+		e.calls[rng] = c
+		go e.call(callCtx, rng, c)
+	}
+	c.waiters++
+	e.m.Unlock()
+
+	select {
+	case <-c.done:
+		return c.p, c.err
+	case <-ctx.Done():
+	}
+
+	e.m.Lock()
+	if c.waiters--; c.waiters == 0 {
+		c.cancel()
+		if e.calls[rng] == c {
+			delete(e.calls, rng)
+		}
+	}
+	e.m.Unlock()
+	return nil, ctx.Err()
+}
+
+// call reads the range for the rangeCall c.
+func (e *rangeREnvironment) call(ctx context.Context, rng byteRange, c *rangeCall) {
+	defer c.cancel()
+
+	select {
+	case e.sem <- struct{}{}:
+		if cr, ok := e.r.(ContextRangeReader); ok {
+			c.p, c.err = cr.ReadRangeContext(ctx, rng.off, rng.n)
+		} else {
+			c.p, c.err = e.r.ReadRange(rng.off, rng.n)
+		}
+		<-e.sem
+	case <-ctx.Done():
+		c.err = ctx.Err()
+	}
+
+	e.m.Lock()
+	if e.calls[rng] == c {
+		delete(e.calls, rng)
+	}
+	e.m.Unlock()
+	close(c.done)
+}
@@ -0,0 +1,168 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRangeReader serves the object from memory, ReadRange waits until release is closed.
+type blockingRangeReader struct {
+	data    []byte
+	release chan struct{}
+
+	m      sync.Mutex
+	tails  int
+	ranges int
+}
+
+func (r *blockingRangeReader) ReadTail(n int64) ([]byte, int64, error) {
+	r.m.Lock()
+	r.tails++
+	r.m.Unlock()
+	return r.data[max(int64(len(r.data))-n, 0):], int64(len(r.data)), nil
+}
+
+func (r *blockingRangeReader) ReadRange(off, n int64) ([]byte, error) {
+	r.m.Lock()
+	r.ranges++
+	r.m.Unlock()
+	<-r.release
+	return r.data[off : off+n], nil
+}
+
+func TestRangeREnvironment(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRangeREnvironment(&blockingRangeReader{}, 8, 1)
+	require.ErrorContains(t, err, "tail size")
+	_, err = NewRangeREnvironment(&blockingRangeReader{}, 9, 0)
+	require.ErrorContains(t, err, "parallelism")
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	rr := &blockingRangeReader{data: data, release: make(chan struct{})}
+	e, err := NewRangeREnvironment(rr, 10, 8)
+	require.NoError(t, err)
+
+	size, err := e.(Sizer).Size()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+
+	// The end of the object is served from the tail.
+	p, err := e.ReadSkipFrame(5)
+	require.NoError(t, err)
+	assert.Equal(t, data[95:], p)
+	p, err = e.ReadFooter()
+	require.NoError(t, err)
+	assert.Equal(t, data[90:], p)
+
+	// Concurrent reads of the same range share a single ReadRange.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := e.GetFrameByIndex(FrameOffsetEntry{CompOffset: 5, CompSize: 20})
+			assert.NoError(t, err)
+			assert.Equal(t, data[5:25], p)
+		}()
+	}
+	for {
+		e := e.(*rangeREnvironment)
+		e.m.Lock()
+		n := len(e.calls)
+		e.m.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	close(rr.release)
+	wg.Wait()
+
+	p, err = e.ReadSkipFrame(20)
+	require.NoError(t, err)
+	assert.Equal(t, data[80:], p)
+
+	rr.m.Lock()
+	defer rr.m.Unlock()
+	assert.Equal(t, 1, rr.tails)
+	assert.LessOrEqual(t, rr.ranges, 1+10)
+	assert.GreaterOrEqual(t, rr.ranges, 2)
+}
+
+// contextRangeReader is blockingRangeReader whose reads stop once their context is done.
+type contextRangeReader struct {
+	blockingRangeReader
+	cancelled chan struct{}
+}
+
+func (r *contextRangeReader) ReadRangeContext(ctx context.Context, off, n int64) ([]byte, error) {
+	select {
+	case <-r.release:
+		return r.data[off : off+n], nil
+	case <-ctx.Done():
+		close(r.cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+func TestRangeREnvironmentContext(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	rr := &contextRangeReader{
+		blockingRangeReader: blockingRangeReader{data: data, release: make(chan struct{})},
+		cancelled:           make(chan struct{}),
+	}
+	e, err := NewRangeREnvironment(rr, 10, 1)
+	require.NoError(t, err)
+	ce := e.(ContextREnvironment)
+	index := FrameOffsetEntry{CompOffset: 5, CompSize: 20}
+
+	// The read shared with another one is not cancelled with the context of the first.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := ce.GetFrameByIndexContext(ctx, index)
+		errs <- err
+	}()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	go func() {
+		_, err := ce.GetFrameByIndexContext(ctx2, index)
+		errs <- err
+	}()
+	for {
+		e := e.(*rangeREnvironment)
+		e.m.Lock()
+		c := e.calls[byteRange{off: 5, n: 20}]
+		waiters := 0
+		if c != nil {
+			waiters = c.waiters
+		}
+		e.m.Unlock()
+		if waiters == 2 {
+			break
+		}
+	}
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-rr.cancelled:
+		t.Fatal("shared read is cancelled")
+	default:
+	}
+
+	// It is cancelled once nobody waits for it.
+	cancel2()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	<-rr.cancelled
+
+	close(rr.release)
+	p, err := e.GetFrameByIndex(index)
+	require.NoError(t, err)
+	assert.Equal(t, data[5:25], p)
+}
@@ -0,0 +1,262 @@
+// Package s3env implements the seekable reader environment for objects stored in Amazon S3.
+//
+// It lives in a separate module, so that the AWS SDK is only required by its users.
+package s3env
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // required by the SSE-C protocol
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+const (
+	defaultParallelism = 8
+	defaultTailSize    = 64 << 10
+	defaultMaxAttempts = 3
+)
+
+// GetObjectAPI is the subset of *s3.Client used by the environment.
+type GetObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// Option configures the environment returned by NewREnvironment.
+type Option func(*s3REnvironment) error
+
+// WithContext sets the context of every request, cancelling it aborts all reads.
+func WithContext(ctx context.Context) Option {
+	return func(e *s3REnvironment) error { e.ctx = ctx; return nil }
+}
+
+// WithParallelism limits the number of GetObject requests in flight.  Default is 8.
+func WithParallelism(n int) Option {
+	return func(e *s3REnvironment) error {
+		if n < 1 {
+			return fmt.Errorf("parallelism must be positive: %d", n)
+		}
+		e.parallelism = n
+		return nil
+	}
+}
+
+// WithTailSize sets the number of bytes fetched from the end of the object by the first
+// request.  The seek table is served from them if it fits.  Default is 64KiB.
+func WithTailSize(n int) Option {
+	return func(e *s3REnvironment) error {
+		if n < 9 {
+			return fmt.Errorf("tail size must be at least the footer size: %d", n)
+		}
+		e.tailSize = n
+		return nil
+	}
+}
+
+// WithMaxAttempts sets the number of attempts to read a range, including the first one.
+// The SDK retries failed requests on its own, but not failures while reading the response
+// body, e.g. connection resets; those are retried by the environment.  Default is 3.
+func WithMaxAttempts(n int) Option {
+	return func(e *s3REnvironment) error {
+		if n < 1 {
+			return fmt.Errorf("max attempts must be positive: %d", n)
+		}
+		e.maxAttempts = n
+		return nil
+	}
+}
+
+// WithVersionID reads the given version of the object instead of the latest one.
+func WithVersionID(id string) Option {
+	return func(e *s3REnvironment) error { e.versionID = aws.String(id); return nil }
+}
+
+// WithSSECustomerKey reads the object encrypted with the customer-provided AES-256 key (SSE-C).
+// Objects encrypted with SSE-S3 or SSE-KMS need no configuration.
+func WithSSECustomerKey(key []byte) Option {
+	return func(e *s3REnvironment) error {
+		if len(key) != 32 {
+			return fmt.Errorf("SSE-C key must be 32 bytes: %d", len(key))
+		}
+		sum := md5.Sum(key) //nolint:gosec // required by the SSE-C protocol
+		e.sseAlgorithm = aws.String("AES256")
+		e.sseKey = aws.String(base64.StdEncoding.EncodeToString(key))
+		e.sseKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		return nil
+	}
+}
+
+// s3REnvironment reads the stream with ranged GetObject requests, see env.NewRangeREnvironment.
+type s3REnvironment struct {
+	client      GetObjectAPI
+	bucket, key string
+	ctx         context.Context
+	parallelism int
+	tailSize    int
+	maxAttempts int
+
+	versionID                       *string
+	sseAlgorithm, sseKey, sseKeyMD5 *string
+
+	// etag is set by ReadTail, which happens before all ReadRange calls.
+	etag *string
+}
+
+// NewREnvironment returns the goroutine-safe REnvironment that reads the stream from the S3 object:
+//
+//	e, err := s3env.NewREnvironment(s3.NewFromConfig(cfg), "bucket", "data.zst")
+//	...
+//	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e))
+//
+// The first request fetches the end of the object along with its size and ETag.  All the
+// following requests are sent with If-Match, so that the reads fail with env.ErrRemoteChanged
+// once the object is overwritten.  Concurrent reads of the same frame, e.g. by prefetch and
+// ReadAt, are coalesced into a single request.  The environment implements env.Sizer.
+func NewREnvironment(client GetObjectAPI, bucket, key string, opts ...Option) (env.REnvironment, error) {
+	e := &s3REnvironment{
+		client:      client,
+		bucket:      bucket,
+		key:         key,
+		ctx:         context.Background(),
+		parallelism: defaultParallelism,
+		tailSize:    defaultTailSize,
+		maxAttempts: defaultMaxAttempts,
+	}
+	for _, o := range opts {
+		if err := o(e); err != nil {
+			return nil, err
+		}
+	}
+	return env.NewRangeREnvironment(e, e.tailSize, e.parallelism)
+}
+
+func (e *s3REnvironment) ReadTail(n int64) ([]byte, int64, error) {
+	var size int64
+	var tail []byte
+	err := e.retry(func() error {
+		out, err := e.getObject("bytes=-" + strconv.FormatInt(n, 10))
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+
+		if out.ContentRange != nil {
+			if _, size, err = parseContentRange(*out.ContentRange); err != nil {
+				return err
+			}
+		} else if out.ContentLength != nil {
+			// The whole object is smaller than the tail.
+			size = *out.ContentLength
+		} else {
+			return fmt.Errorf("unknown object size")
+		}
+
+		tail = make([]byte, min(size, n))
+		if _, err := io.ReadFull(out.Body, tail); err != nil {
+			return &bodyError{fmt.Errorf("failed to read tail: %w", err)}
+		}
+		e.etag = out.ETag
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch tail of s3://%s/%s: %w", e.bucket, e.key, err)
+	}
+	return tail, size, nil
+}
+
+func (e *s3REnvironment) ReadRange(off, n int64) ([]byte, error) {
+	var p []byte
+	err := e.retry(func() error {
+		out, err := e.getObject(fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+
+		if out.ContentRange != nil {
+			first, _, err := parseContentRange(*out.ContentRange)
+			if err != nil {
+				return err
+			}
+			if first != off {
+				return fmt.Errorf("server returned range at %d instead of %d", first, off)
+			}
+		}
+
+		p = make([]byte, n)
+		if _, err := io.ReadFull(out.Body, p); err != nil {
+			return &bodyError{fmt.Errorf("failed to read body: %w", err)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch range at %d of s3://%s/%s: %w", off, e.bucket, e.key, err)
+	}
+	return p, nil
+}
+
+func (e *s3REnvironment) getObject(rng string) (*s3.GetObjectOutput, error) {
+	out, err := e.client.GetObject(e.ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(e.bucket),
+		Key:                  aws.String(e.key),
+		Range:                aws.String(rng),
+		IfMatch:              e.etag,
+		VersionId:            e.versionID,
+		SSECustomerAlgorithm: e.sseAlgorithm,
+		SSECustomerKey:       e.sseKey,
+		SSECustomerKeyMD5:    e.sseKeyMD5,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return nil, fmt.Errorf("%w: %w", env.ErrRemoteChanged, err)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// bodyError is the failure to read the response body, which is not retried by the SDK.
+type bodyError struct {
+	err error
+}
+
+func (e *bodyError) Error() string { return e.err.Error() }
+func (e *bodyError) Unwrap() error { return e.err }
+
+// retry calls f until it succeeds, fails with an error other than bodyError,
+// or runs out of attempts.
+func (e *s3REnvironment) retry(f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		var bodyErr *bodyError
+		if err == nil || attempt >= e.maxAttempts || !errors.As(err, &bodyErr) || e.ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// parseContentRange parses the "bytes first-last/size" value of the Content-Range header.
+func parseContentRange(s string) (first, size int64, err error) {
+	rng, total, ok := strings.Cut(strings.TrimPrefix(s, "bytes "), "/")
+	f, _, ok2 := strings.Cut(rng, "-")
+	if !ok || !ok2 || !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	if first, err = strconv.ParseInt(f, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range: %q", s)
+	}
+	return first, size, nil
+}
@@ -0,0 +1,122 @@
+package s3env
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// fakeS3 serves ranged GetObject requests from memory.
+type fakeS3 struct {
+	m        sync.Mutex
+	data     []byte
+	etag     string
+	inputs   []*s3.GetObjectInput
+	failBody int
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.inputs = append(f.inputs, in)
+	if in.IfMatch != nil && *in.IfMatch != f.etag {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+
+	size := int64(len(f.data))
+	spec := strings.TrimPrefix(aws.ToString(in.Range), "bytes=")
+	var first, last int64
+	if strings.HasPrefix(spec, "-") {
+		n, _ := strconv.ParseInt(spec[1:], 10, 64)
+		first, last = max(size-n, 0), size-1
+	} else {
+		_, _ = fmt.Sscanf(spec, "%d-%d", &first, &last)
+	}
+
+	var body io.Reader = bytes.NewReader(f.data[first : last+1])
+	if f.failBody > 0 {
+		f.failBody--
+		body = io.MultiReader(bytes.NewReader(f.data[first:first+1]), failingReader{})
+	}
+	return &s3.GetObjectOutput{
+		Body:         io.NopCloser(body),
+		ContentRange: aws.String(fmt.Sprintf("bytes %d-%d/%d", first, last, size)),
+		ETag:         aws.String(f.etag),
+	}, nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestREnvironment(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := seekable.NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = w.Write([]byte(fmt.Sprintf("frame %d;", i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewREnvironment(&fakeS3{}, "bucket", "key", WithSSECustomerKey([]byte("short")))
+	require.Error(t, err)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	client := &fakeS3{data: b.Bytes(), etag: `"v1"`, failBody: 1}
+	e, err := NewREnvironment(client, "bucket", "key", WithSSECustomerKey(key), WithVersionID("1"))
+	require.NoError(t, err)
+
+	r, err := seekable.NewReader(nil, dec, seekable.WithREnvironment(e), seekable.WithReadConcurrency(4))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "frame 0;frame 1;frame 2;frame 3;frame 4;frame 5;frame 6;frame 7;frame 8;frame 9;", string(all))
+
+	client.m.Lock()
+	// The failed read of the tail is retried, and the seek table is served from the tail.
+	assert.Equal(t, "bytes=-65536", aws.ToString(client.inputs[0].Range))
+	assert.Equal(t, "bytes=-65536", aws.ToString(client.inputs[1].Range))
+	assert.Nil(t, client.inputs[0].IfMatch)
+	assert.Len(t, client.inputs, 2+10)
+	for _, in := range client.inputs {
+		assert.Equal(t, "bucket", aws.ToString(in.Bucket))
+		assert.Equal(t, "1", aws.ToString(in.VersionId))
+		assert.Equal(t, "AES256", aws.ToString(in.SSECustomerAlgorithm))
+		assert.NotEmpty(t, aws.ToString(in.SSECustomerKeyMD5))
+	}
+	assert.Equal(t, `"v1"`, aws.ToString(client.inputs[2].IfMatch))
+
+	// The object is overwritten.
+	client.etag = `"v2"`
+	client.m.Unlock()
+
+	_, err = r.ReadAt(make([]byte, 4), 0)
+	assert.ErrorIs(t, err, env.ErrRemoteChanged)
+}
@@ -0,0 +1,63 @@
+package seekable
+
+import (
+	"errors"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/format"
+)
+
+var (
+	// ErrCorruptSeekTable is returned when the seek table or its footer is malformed
+	// or does not match the frames of the stream.
+	ErrCorruptSeekTable = format.ErrCorruptSeekTable
+
+	// ErrChecksumMismatch is returned when the checksum of a decompressed frame
+	// does not match the one stored in the seek table.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrDigestMismatch is returned when the SHA-256 digest of the whole decompressed stream does not
+	// match the one stored by Writer's WithContentDigest, see WithVerifyContentDigest.
+	// It is always wrapped together with ErrChecksumMismatch.
+	ErrDigestMismatch = errors.New("content digest mismatch")
+
+	// ErrNoChecksums is returned by NewReader with WithRequireChecksums
+	// when the seek table does not have frame checksums.
+	ErrNoChecksums = errors.New("seek table has no checksums")
+
+	// ErrOffsetOutOfRange is returned when the requested offset is outside of the stream.
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+
+	// ErrPartialWrite is returned when the underlying writer or environment
+	// reports that only a part of the frame was written.
+	ErrPartialWrite = errors.New("partial write")
+
+	// ErrFrameTooLarge is returned when a frame does not fit into the seekable format.
+	ErrFrameTooLarge = errors.New("frame is too large")
+
+	// ErrTooManyFrames is returned when the number of frames in the stream
+	// would exceed the maximum supported by the seekable format.
+	ErrTooManyFrames = errors.New("too many frames")
+
+	// ErrDictionaryMismatch is returned when a frame produced by the encoder
+	// does not use the dictionary configured with WithDictionary.
+	ErrDictionaryMismatch = errors.New("dictionary mismatch")
+
+	// ErrDictionaryNotFound is returned by the reader when the stream is compressed with
+	// a dictionary that is not among the ones set by WithDictionaries.
+	ErrDictionaryNotFound = errors.New("dictionary not found")
+
+	// ErrEmptyFrame is returned for empty writes if WithAllowEmptyFrames is disabled.
+	ErrEmptyFrame = errors.New("frame is empty")
+
+	// ErrSectionNotFound is returned by Reader's OpenSection when the stream has no section
+	// with the requested name.
+	ErrSectionNotFound = errors.New("section not found")
+
+	// ErrMemoryLimit is returned when a write would make the writer exceed the memory limit
+	// set by WithWriterMemoryLimit, or a read does not fit into WithReaderMemoryLimit.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
+	// ErrNondeterministicEncoder is returned in the deterministic output mode
+	// when the encoder produces different output for the same input.
+	ErrNondeterministicEncoder = errors.New("encoder output is not deterministic")
+)
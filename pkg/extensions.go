@@ -0,0 +1,208 @@
+package seekable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	/*
+		Extension frames carry library metadata alongside the data frames.  They are regular
+		skippable frames, so they are ignored by any Zstandard decoder, and are accounted for
+		in the seek table as frames without any uncompressed data.
+
+		The structure of the extension frame is as follows:
+
+			|`Skippable_Magic_Number`|`Frame_Size`|`Extension_Magic_Number`|`Extension_Type`|`Payload`|
+			|------------------------|------------|------------------------|----------------|---------|
+			| 4 bytes                | 4 bytes    | 4 bytes                | 4 bytes        | n bytes |
+
+		Skippable_Magic_Number
+
+		Value: 0x184D2A5D.
+
+		Extension_Magic_Number
+
+		Value: 0x8F92EAB2, __little-endian__ format.  Since other applications are free
+		to use the same skippable magic number, this field is used to tell extension frames
+		apart from user data.
+
+		Extension_Type
+
+		4 Bytes, __little-endian__ format.  Identifies the layout of the `Payload`.
+
+		Padding frames written by WithFrameAlignment use the same `Skippable_Magic_Number`,
+		but their content is filled with zeroes, so they never have a valid `Extension_Magic_Number`.
+		The smallest padding frame is an empty skippable frame of 8 bytes.
+	*/
+	extensionTag = 0xD
+
+	extensionMagicNumber uint32 = 0x8F92EAB2
+
+	extensionHeaderSize = 8
+)
+
+// extensionType identifies the payload of an extension frame.
+type extensionType uint32
+
+const (
+	// extensionDictionary payload is the 4 bytes little-endian ID of the zstd dictionary
+	// that all data frames of the stream are compressed with.
+	extensionDictionary extensionType = 1
+
+	// extensionFrameTags payload is a sequence of user tags attached to frames:
+	//
+	//	|`Frame_Index`|`Tag_Size`|`Tag`   |
+	//	|-------------|----------|--------|
+	//	| 4 bytes     | 1 byte   | n bytes|
+	//
+	// `Frame_Index` is the index of the frame in the seek table, __little-endian__ format.
+	// Entries are sorted by `Frame_Index`.
+	extensionFrameTags extensionType = 2
+
+	// extensionContentDigest payload is the SHA-256 digest of the whole uncompressed stream.
+	extensionContentDigest extensionType = 3
+
+	// extensionSections payload is the directory of named sections written by WriteSection:
+	//
+	//	|`Decompressed_Offset`|`Decompressed_Size`|`Name_Size`|`Name`  |
+	//	|---------------------|-------------------|-----------|--------|
+	//	| 8 bytes             | 8 bytes           | 2 bytes   | n bytes|
+	//
+	// All fields are __little-endian__ format.  Entries are sorted by `Decompressed_Offset`.
+	extensionSections extensionType = 4
+
+	// maxFrameTagSize is the maximum size of a single frame tag.
+	maxFrameTagSize = math.MaxUint8
+
+	// maxSectionNameSize is the maximum size of a section name.
+	maxSectionNameSize = math.MaxUint16
+)
+
+// frameTag is a user tag attached to the frame with the given index.
+type frameTag struct {
+	ID  uint32
+	Tag []byte
+}
+
+func marshalFrameTags(tags []frameTag) []byte {
+	size := 0
+	for _, t := range tags {
+		size += 5 + len(t.Tag)
+	}
+
+	dst := make([]byte, 0, size)
+	for _, t := range tags {
+		dst = binary.LittleEndian.AppendUint32(dst, t.ID)
+		dst = append(dst, uint8(len(t.Tag)))
+		dst = append(dst, t.Tag...)
+	}
+	return dst
+}
+
+func parseFrameTags(p []byte) (map[int64][]byte, error) {
+	tags := make(map[int64][]byte)
+	for len(p) > 0 {
+		if len(p) < 5 {
+			return nil, fmt.Errorf("frame tag is truncated: %d", len(p))
+		}
+		id := int64(binary.LittleEndian.Uint32(p))
+		size := int(p[4])
+		p = p[5:]
+		if len(p) < size {
+			return nil, fmt.Errorf("frame tag %d is truncated: %d < %d", id, len(p), size)
+		}
+		tags[id] = p[:size:size]
+		p = p[size:]
+	}
+	return tags, nil
+}
+
+// section is a named range of the uncompressed stream written by WriteSection.
+type section struct {
+	Name   string
+	Offset uint64
+	Size   uint64
+}
+
+func marshalSections(sections []section) []byte {
+	size := 0
+	for _, s := range sections {
+		size += 18 + len(s.Name)
+	}
+
+	dst := make([]byte, 0, size)
+	for _, s := range sections {
+		dst = binary.LittleEndian.AppendUint64(dst, s.Offset)
+		dst = binary.LittleEndian.AppendUint64(dst, s.Size)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(len(s.Name)))
+		dst = append(dst, s.Name...)
+	}
+	return dst
+}
+
+func parseSections(p []byte) (map[string]section, error) {
+	sections := make(map[string]section)
+	for len(p) > 0 {
+		if len(p) < 18 {
+			return nil, fmt.Errorf("section is truncated: %d", len(p))
+		}
+		s := section{
+			Offset: binary.LittleEndian.Uint64(p),
+			Size:   binary.LittleEndian.Uint64(p[8:]),
+		}
+		size := int(binary.LittleEndian.Uint16(p[16:]))
+		p = p[18:]
+		if len(p) < size {
+			return nil, fmt.Errorf("section name is truncated: %d < %d", len(p), size)
+		}
+		s.Name = string(p[:size])
+		p = p[size:]
+		sections[s.Name] = s
+	}
+	return sections, nil
+}
+
+// createExtensionFrame returns an extension frame of type t with the passed payload.
+func createExtensionFrame(t extensionType, payload []byte) ([]byte, error) {
+	buf := make([]byte, extensionHeaderSize, extensionHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:], extensionMagicNumber)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(t))
+	return createSkippableFrame(extensionTag, append(buf, payload...))
+}
+
+// createPaddingFrame returns a padding frame of the given total size, which is at least 8 bytes.
+func createPaddingFrame(size int) []byte {
+	dst := make([]byte, size)
+	binary.LittleEndian.PutUint32(dst[0:], skippableFrameMagic+extensionTag)
+	binary.LittleEndian.PutUint32(dst[4:], uint32(size-skippableMagicNumberFieldSize-frameSizeFieldSize))
+	return dst
+}
+
+// parseExtensionFrame returns the type and the payload of an extension frame.
+func parseExtensionFrame(p []byte) (extensionType, []byte, error) {
+	minLen := skippableMagicNumberFieldSize + frameSizeFieldSize + extensionHeaderSize
+	if len(p) < minLen {
+		return 0, nil, fmt.Errorf("extension frame is too small: %d < %d", len(p), minLen)
+	}
+
+	magic := binary.LittleEndian.Uint32(p[0:])
+	if magic != skippableFrameMagic+extensionTag {
+		return 0, nil, fmt.Errorf("skippable frame magic mismatch %d vs %d",
+			magic, skippableFrameMagic+extensionTag)
+	}
+
+	size := binary.LittleEndian.Uint32(p[4:])
+	if int64(size) != int64(len(p))-skippableMagicNumberFieldSize-frameSizeFieldSize {
+		return 0, nil, fmt.Errorf("skippable frame size mismatch: expected: %d, actual: %d",
+			size, len(p)-skippableMagicNumberFieldSize-frameSizeFieldSize)
+	}
+
+	p = p[skippableMagicNumberFieldSize+frameSizeFieldSize:]
+	if magic := binary.LittleEndian.Uint32(p[0:]); magic != extensionMagicNumber {
+		return 0, nil, fmt.Errorf("extension magic mismatch %d vs %d", magic, extensionMagicNumber)
+	}
+
+	return extensionType(binary.LittleEndian.Uint32(p[4:])), p[extensionHeaderSize:], nil
+}
@@ -0,0 +1,100 @@
+package seekable
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// File presents the decompressed view of a seekable stream as a read-only file.
+// It implements fs.File and http.File, so the data can be served by http.ServeContent
+// with working Range requests straight from the compressed stream:
+//
+//	f, err := seekable.NewFile(r, "data.json", modTime)
+//	...
+//	http.ServeContent(w, req, f.Name(), modTime, f)
+type File struct {
+	r    Reader
+	info fileInfo
+}
+
+var (
+	_ fs.File     = (*File)(nil)
+	_ io.ReaderAt = (*File)(nil)
+)
+
+// NewFile returns the File with the given name and modification time reading from r.
+// Closing the File closes r.
+func NewFile(r Reader, name string, modTime time.Time) (*File, error) {
+	cur, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(cur, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &File{
+		r: r,
+		info: fileInfo{
+			name:    path.Base(name),
+			size:    size,
+			modTime: modTime,
+		},
+	}, nil
+}
+
+// Name returns the base name of the file.
+func (f *File) Name() string {
+	return f.info.name
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.ReadAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+// Stat returns the description of the file with its decompressed size.
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Readdir always fails, since File is not a directory.
+func (f *File) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: errors.New("not a directory")}
+}
+
+func (f *File) Close() error {
+	if err := f.r.Close(); err != nil {
+		return fmt.Errorf("failed to close reader: %w", err)
+	}
+	return nil
+}
+
+// fileInfo is the fs.FileInfo of File.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return nil }
@@ -0,0 +1,60 @@
+package seekable
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ http.File = (*File)(nil)
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	_, err = r.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, err := NewFile(r, "dir/test.txt", modTime)
+	require.NoError(t, err)
+
+	// The offset is preserved.
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("st"), buf)
+
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, "test.txt", fi.Name())
+	assert.Equal(t, int64(len(sourceString)), fi.Size())
+	assert.Equal(t, modTime, fi.ModTime())
+	assert.False(t, fi.IsDir())
+	_, err = f.Readdir(0)
+	assert.Error(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/test.txt", nil)
+	req.Header.Set("Range", "bytes=3-6")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, f.Name(), modTime, f)
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "ttes", rec.Body.String())
+	assert.Equal(t, "bytes 3-6/9", rec.Header().Get("Content-Range"))
+
+	require.NoError(t, f.Close())
+	_, err = f.Read(buf)
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package seekable
+
+import "sync"
+
+// FrameCacheStats are the statistics of a frame cache, see WithFrameCache and WithCompressedFrameCache.
+type FrameCacheStats struct {
+	// Hits is the number of reads served from the cache.
+	Hits int64
+	// Misses is the number of reads that had to decompress the frame, or read it for the compressed cache.
+	Misses int64
+	// Evictions is the number of frames evicted to stay within the size limit.
+	Evictions int64
+	// Frames is the number of frames currently in the cache.
+	Frames int
+	// Bytes is the total size of the frames currently in the cache: decompressed or compressed.
+	Bytes int64
+}
+
+// frameCache is the cache of frames bounded by their total size, the frames it evicts are
+// chosen by its policy, LRU by default.  Decompressed frames are keyed by their decompressed
+// offset, compressed frames by their compressed offset.
+type frameCache struct {
+	m sync.Mutex
+
+	maxBytes int64
+	policy   CachePolicy
+	entries  map[uint64][]byte
+	stats    FrameCacheStats
+}
+
+func newFrameCache(maxBytes int64) *frameCache {
+	return &frameCache{
+		maxBytes: maxBytes,
+		policy:   NewLRUPolicy(maxBytes),
+		entries:  make(map[uint64][]byte),
+	}
+}
+
+// get returns the frame at the given decompressed offset, or nil if it is not cached.
+func (c *frameCache) get(offset uint64) []byte {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	data, ok := c.entries[offset]
+	if !ok {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.policy.Accessed(offset)
+	return data
+}
+
+// contains returns whether the frame at the given decompressed offset is cached
+// without counting it as a hit or a miss.
+func (c *frameCache) contains(offset uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	_, ok := c.entries[offset]
+	return ok
+}
+
+// add puts the frame into the cache evicting the policy's victims if needed
+// and returns the total size of the evicted frames.  Frames bigger than the whole cache
+// and frames that are already cached are not added.
+func (c *frameCache) add(offset uint64, data []byte) (evicted int64, added bool) {
+	if int64(len(data)) > c.maxBytes {
+		return 0, false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, ok := c.entries[offset]; ok {
+		// Decompressed concurrently by another read.
+		return 0, false
+	}
+	for c.stats.Bytes+int64(len(data)) > c.maxBytes {
+		evicted += c.evict(c.victim())
+		c.stats.Evictions++
+	}
+	c.entries[offset] = data
+	c.policy.Added(offset, int64(len(data)))
+	c.stats.Frames++
+	c.stats.Bytes += int64(len(data))
+	return evicted, true
+}
+
+// evictVictim evicts the policy's victim and returns its size,
+// or zero if the cache is empty.
+func (c *frameCache) evictVictim() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if len(c.entries) == 0 {
+		return 0
+	}
+	c.stats.Evictions++
+	return c.evict(c.victim())
+}
+
+// victim returns the offset of the frame to evict, the cache must not be empty.
+func (c *frameCache) victim() uint64 {
+	offset := c.policy.Victim()
+	if _, ok := c.entries[offset]; ok {
+		return offset
+	}
+	// The policy is out of sync with the cache, any frame is evicted instead.
+	for offset := range c.entries {
+		return offset
+	}
+	panic("victim of an empty cache")
+}
+
+func (c *frameCache) evict(offset uint64) int64 {
+	data := c.entries[offset]
+	delete(c.entries, offset)
+	c.policy.Removed(offset)
+	c.stats.Frames--
+	c.stats.Bytes -= int64(len(data))
+	return int64(len(data))
+}
+
+// removeRange drops the cached frames overlapping [start, end) and returns their size.
+func (c *frameCache) removeRange(start, end uint64) int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var freed int64
+	for offset, data := range c.entries {
+		if offset < end && offset+uint64(len(data)) > start {
+			freed += c.evict(offset)
+		}
+	}
+	return freed
+}
+
+// clear drops all cached frames, keeping the hit statistics, and returns their size.
+func (c *frameCache) clear() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var freed int64
+	for offset := range c.entries {
+		freed += c.evict(offset)
+	}
+	return freed
+}
+
+func (c *frameCache) getStats() FrameCacheStats {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.stats
+}
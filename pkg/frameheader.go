@@ -0,0 +1,98 @@
+package seekable
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+zstdFrameHeader is the parsed header of a Zstandard frame.
+
+	|`Magic_Number`|`Frame_Header_Descriptor`|`Window_Descriptor`|`Dictionary_ID`|`Frame_Content_Size`|
+	|--------------|-------------------------|-------------------|---------------|--------------------|
+	| 4 bytes      | 1 byte                  | 0-1 byte          | 0-4 bytes     | 0-8 bytes          |
+
+https://github.com/facebook/zstd/blob/release/doc/zstd_compression_format.md#frame_header
+*/
+type zstdFrameHeader struct {
+	// DictionaryID is zero if the frame is compressed without a dictionary.
+	DictionaryID uint32
+	// ContentSize is the uncompressed size of the frame, if HasContentSize is set.
+	ContentSize    uint64
+	HasContentSize bool
+	// Checksum is set if the frame ends with a content checksum.
+	Checksum bool
+	// HeaderSize is the size of the frame header in bytes.
+	HeaderSize int
+}
+
+const (
+	zstdFrameMagic uint32 = 0xFD2FB528
+	zstdDictMagic  uint32 = 0xEC30A437
+)
+
+// zstdFrameHeaderLayout returns the size of the frame header with the given `Frame_Header_Descriptor`,
+// the position of the `Dictionary_ID` in it, and the sizes of `Dictionary_ID` and `Frame_Content_Size`.
+func zstdFrameHeaderLayout(fhd byte) (size, pos, dictIDSize, fcsSize int) {
+	fcsFlag := fhd >> 6
+	singleSegment := fhd&(1<<5) != 0
+
+	dictIDSize = [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize = [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+
+	pos = 5
+	if !singleSegment {
+		pos++
+	}
+	return pos + dictIDSize + fcsSize, pos, dictIDSize, fcsSize
+}
+
+// parseZSTDFrameHeader parses the header of the Zstandard frame at the beginning of p.
+func parseZSTDFrameHeader(p []byte) (zstdFrameHeader, error) {
+	var h zstdFrameHeader
+	if len(p) < 5 {
+		return h, fmt.Errorf("frame header is too small: %d", len(p))
+	}
+	if magic := binary.LittleEndian.Uint32(p); magic != zstdFrameMagic {
+		return h, fmt.Errorf("frame magic mismatch %d vs %d", magic, zstdFrameMagic)
+	}
+
+	fhd := p[4]
+	if fhd&(1<<3) != 0 {
+		return h, fmt.Errorf("reserved bit is set in frame header descriptor: %#x", fhd)
+	}
+	h.Checksum = fhd&(1<<2) != 0
+
+	var pos, dictIDSize, fcsSize int
+	h.HeaderSize, pos, dictIDSize, fcsSize = zstdFrameHeaderLayout(fhd)
+	if len(p) < h.HeaderSize {
+		return h, fmt.Errorf("frame header is truncated: %d < %d", len(p), h.HeaderSize)
+	}
+
+	switch dictIDSize {
+	case 1:
+		h.DictionaryID = uint32(p[pos])
+	case 2:
+		h.DictionaryID = uint32(binary.LittleEndian.Uint16(p[pos:]))
+	case 4:
+		h.DictionaryID = binary.LittleEndian.Uint32(p[pos:])
+	}
+	pos += dictIDSize
+
+	h.HasContentSize = fcsSize > 0
+	switch fcsSize {
+	case 1:
+		h.ContentSize = uint64(p[pos])
+	case 2:
+		h.ContentSize = uint64(binary.LittleEndian.Uint16(p[pos:])) + 256
+	case 4:
+		h.ContentSize = uint64(binary.LittleEndian.Uint32(p[pos:]))
+	case 8:
+		h.ContentSize = binary.LittleEndian.Uint64(p[pos:])
+	}
+
+	return h, nil
+}
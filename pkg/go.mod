@@ -7,6 +7,8 @@ require (
 	github.com/google/btree v1.1.3
 	github.com/klauspost/compress v1.17.10
 	github.com/stretchr/testify v1.9.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
 	go.uber.org/atomic v1.11.0
 	go.uber.org/multierr v1.11.0
 	go.uber.org/zap v1.27.0
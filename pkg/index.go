@@ -0,0 +1,251 @@
+package seekable
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/google/btree"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// frameIndex maps offsets in the decompressed stream and frame IDs to the frames.
+type frameIndex interface {
+	// Len returns the number of frames.
+	Len() int
+	// byOffset returns the frame containing the decompressed offset: the last frame starting
+	// at or before it.  Frames without data share the offset with the next frame.
+	byOffset(off uint64) *env.FrameOffsetEntry
+	// byID returns the frame with the given ID or nil if there is none.
+	byID(id int64) *env.FrameOffsetEntry
+	// ascend calls fn for the frame from and all frames after it in order until fn returns false.
+	ascend(from *env.FrameOffsetEntry, fn func(*env.FrameOffsetEntry) bool)
+}
+
+// btreeIndex is the frameIndex that keeps all parsed entries in a B-tree.
+type btreeIndex struct {
+	t *btree.BTreeG[*env.FrameOffsetEntry]
+}
+
+func (i *btreeIndex) Len() int {
+	return i.t.Len()
+}
+
+func (i *btreeIndex) byOffset(off uint64) (found *env.FrameOffsetEntry) {
+	pivot := &env.FrameOffsetEntry{ID: math.MaxInt64, DecompOffset: off}
+	i.t.DescendLessOrEqual(pivot, func(index *env.FrameOffsetEntry) bool {
+		found = index
+		return false
+	})
+	return
+}
+
+func (i *btreeIndex) byID(id int64) (found *env.FrameOffsetEntry) {
+	i.t.Descend(func(index *env.FrameOffsetEntry) bool {
+		if index.ID == id {
+			found = index
+			return false
+		}
+		return true
+	})
+	return
+}
+
+func (i *btreeIndex) ascend(from *env.FrameOffsetEntry, fn func(*env.FrameOffsetEntry) bool) {
+	i.t.AscendGreaterOrEqual(from, fn)
+}
+
+// bucketIndex is the frameIndex that keeps the offsets of all frames in parallel arrays,
+// which take half the memory of the parsed entries, together with an array of fixed-stride
+// buckets over the decompressed offsets, so that lookups by offset only search the few frames
+// of one bucket instead of all of them.  The entries are built on lookup.
+type bucketIndex struct {
+	// comp and decomp are the offsets of the frames followed by the end of the last frame,
+	// the sizes of the frame i are comp[i+1]-comp[i] and decomp[i+1]-decomp[i].
+	comp, decomp []uint64
+	checksums    []uint32
+	// stride is the average decompressed frame size, bucket b holds the frames from
+	// buckets[b], which contains the offset b*stride, to buckets[b+1].
+	stride  uint64
+	buckets []uint32
+}
+
+// newBucketIndex returns the bucket index of the frames with the given offsets, followed by
+// the end of the last frame, and checksums.
+func newBucketIndex(comp, decomp []uint64, checksums []uint32) *bucketIndex {
+	i := &bucketIndex{comp: comp, decomp: decomp, checksums: checksums}
+	n := len(checksums)
+	if n == 0 {
+		return i
+	}
+
+	size := decomp[n]
+	i.stride = max((size+uint64(n)-1)/uint64(n), 1)
+	i.buckets = make([]uint32, (size+i.stride-1)/i.stride)
+	id := 0
+	for b := range i.buckets {
+		off := uint64(b) * i.stride
+		for id+1 < n && decomp[id+1] <= off {
+			id++
+		}
+		i.buckets[b] = uint32(id)
+	}
+	return i
+}
+
+func (i *bucketIndex) Len() int {
+	return len(i.checksums)
+}
+
+func (i *bucketIndex) entry(id int) *env.FrameOffsetEntry {
+	return &env.FrameOffsetEntry{
+		ID:           int64(id),
+		CompOffset:   i.comp[id],
+		DecompOffset: i.decomp[id],
+		CompSize:     uint32(i.comp[id+1] - i.comp[id]),
+		DecompSize:   uint32(i.decomp[id+1] - i.decomp[id]),
+		Checksum:     i.checksums[id],
+	}
+}
+
+func (i *bucketIndex) byOffset(off uint64) *env.FrameOffsetEntry {
+	n := i.Len()
+	if n == 0 {
+		return nil
+	}
+	b := off / i.stride
+	if b >= uint64(len(i.buckets)) {
+		return i.entry(n - 1)
+	}
+
+	// The last frame of the bucket starting at or before off.
+	lo, hi := int(i.buckets[b]), n
+	if b+1 < uint64(len(i.buckets)) {
+		hi = int(i.buckets[b+1]) + 1
+	}
+	j := sort.Search(hi-lo, func(j int) bool { return i.decomp[lo+j] > off })
+	return i.entry(lo + j - 1)
+}
+
+func (i *bucketIndex) byID(id int64) *env.FrameOffsetEntry {
+	if id < 0 || id >= int64(i.Len()) {
+		return nil
+	}
+	return i.entry(int(id))
+}
+
+func (i *bucketIndex) ascend(from *env.FrameOffsetEntry, fn func(*env.FrameOffsetEntry) bool) {
+	for id := int(from.ID); id < i.Len(); id++ {
+		if !fn(i.entry(id)) {
+			return
+		}
+	}
+}
+
+// lazyIndexBlock is the number of entries between the offsets remembered by lazyIndex.
+const lazyIndexBlock = 1024
+
+// lazyIndex is the frameIndex that keeps the raw seek table entries and parses them on demand,
+// see WithLazySeekTable.  Offsets of every lazyIndexBlock-th frame are computed upfront,
+// so lookups scan at most one block of entries.
+type lazyIndex struct {
+	entries   []byte
+	entrySize int
+	// blocks are the offsets of the frames 0, lazyIndexBlock, 2*lazyIndexBlock...
+	blocks []lazyIndexOffsets
+}
+
+type lazyIndexOffsets struct {
+	comp, decomp uint64
+}
+
+// newLazyIndex returns the lazy index of the entries and the last frame.
+// Entries must be a multiple of entrySize.
+func newLazyIndex(entries []byte, entrySize int) (*lazyIndex, *env.FrameOffsetEntry) {
+	i := &lazyIndex{
+		entries:   entries,
+		entrySize: entrySize,
+		blocks:    make([]lazyIndexOffsets, 0, (len(entries)/entrySize+lazyIndexBlock-1)/lazyIndexBlock),
+	}
+
+	var offsets lazyIndexOffsets
+	for id := 0; id < i.Len(); id++ {
+		if id%lazyIndexBlock == 0 {
+			i.blocks = append(i.blocks, offsets)
+		}
+		p := i.raw(int64(id))
+		offsets.comp += uint64(binary.LittleEndian.Uint32(p[0:]))
+		offsets.decomp += uint64(binary.LittleEndian.Uint32(p[4:]))
+	}
+	if i.Len() == 0 {
+		return i, nil
+	}
+	return i, i.byID(int64(i.Len() - 1))
+}
+
+func (i *lazyIndex) Len() int {
+	return len(i.entries) / i.entrySize
+}
+
+func (i *lazyIndex) raw(id int64) []byte {
+	return i.entries[id*int64(i.entrySize) : (id+1)*int64(i.entrySize)]
+}
+
+// entry returns the frame with the given ID given the offsets of its start.
+func (i *lazyIndex) entry(id int64, offsets lazyIndexOffsets) *env.FrameOffsetEntry {
+	var entry seekTableEntry
+	_ = entry.UnmarshalBinary(i.raw(id))
+	return &env.FrameOffsetEntry{
+		ID:           id,
+		CompOffset:   offsets.comp,
+		DecompOffset: offsets.decomp,
+		CompSize:     entry.CompressedSize,
+		DecompSize:   entry.DecompressedSize,
+		Checksum:     entry.Checksum,
+	}
+}
+
+func (i *lazyIndex) byOffset(off uint64) *env.FrameOffsetEntry {
+	// The last block starting at or before off.
+	b := sort.Search(len(i.blocks), func(b int) bool { return i.blocks[b].decomp > off }) - 1
+	if b < 0 {
+		return nil
+	}
+
+	var found *env.FrameOffsetEntry
+	i.ascend(i.entry(int64(b)*lazyIndexBlock, i.blocks[b]), func(e *env.FrameOffsetEntry) bool {
+		if e.DecompOffset > off {
+			return false
+		}
+		found = e
+		return true
+	})
+	return found
+}
+
+func (i *lazyIndex) byID(id int64) *env.FrameOffsetEntry {
+	if id < 0 || id >= int64(i.Len()) {
+		return nil
+	}
+
+	first := id / lazyIndexBlock * lazyIndexBlock
+	var found *env.FrameOffsetEntry
+	i.ascend(i.entry(first, i.blocks[first/lazyIndexBlock]), func(e *env.FrameOffsetEntry) bool {
+		found = e
+		return e.ID < id
+	})
+	return found
+}
+
+func (i *lazyIndex) ascend(from *env.FrameOffsetEntry, fn func(*env.FrameOffsetEntry) bool) {
+	offsets := lazyIndexOffsets{comp: from.CompOffset, decomp: from.DecompOffset}
+	for id := from.ID; id < int64(i.Len()); id++ {
+		e := i.entry(id, offsets)
+		if !fn(e) {
+			return
+		}
+		offsets.comp += uint64(e.CompSize)
+		offsets.decomp += uint64(e.DecompSize)
+	}
+}
@@ -0,0 +1,125 @@
+package seekable
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+func TestLazySeekTable(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// Spans several blocks of the lazy index and has frames without data.
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 3*lazyIndexBlock; i++ {
+		frame := bytes.Repeat([]byte{byte(i)}, 1+i%7)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+		if i%500 == 0 {
+			require.NoError(t, w.WriteSkippableFrame(0, []byte("skip")))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	eager, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	lazy, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithLazySeekTable())
+	require.NoError(t, err)
+	e, l := eager.(*readerImpl), lazy.(*readerImpl)
+	assert.IsType(t, &lazyIndex{}, l.idx().index)
+	assert.Equal(t, e.idx().index.Len(), l.idx().index.Len())
+	assert.Equal(t, e.idx().numFrames, l.idx().numFrames)
+	assert.Equal(t, e.idx().endOffset, l.idx().endOffset)
+
+	for id := int64(-1); id <= e.idx().numFrames; id++ {
+		assert.Equal(t, e.GetIndexByID(id), l.GetIndexByID(id), id)
+	}
+	for off := uint64(0); off <= uint64(e.idx().endOffset); off++ {
+		assert.Equal(t, e.GetIndexByDecompOffset(off), l.GetIndexByDecompOffset(off), off)
+	}
+
+	all, err := io.ReadAll(lazy)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+	require.NoError(t, eager.Close())
+	require.NoError(t, lazy.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithMaxSeekTableSize(0))
+	require.ErrorContains(t, err, "must be positive")
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithMaxSeekTableSize(100))
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+func TestBucketIndex(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// Frame sizes vary a lot, so that buckets have different numbers of frames.
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		size := 1 + i%5
+		if i%50 == 0 {
+			size = 1000
+		}
+		_, err = w.Write(bytes.Repeat([]byte{byte(i)}, size))
+		require.NoError(t, err)
+		if i%30 == 0 {
+			require.NoError(t, w.WriteSkippableFrame(0, []byte("skip")))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	bucket, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	tree, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithBucketIndex(false))
+	require.NoError(t, err)
+	bi, ti := bucket.(*readerImpl).idx().index, tree.(*readerImpl).idx().index
+	assert.IsType(t, &bucketIndex{}, bi)
+	assert.IsType(t, &btreeIndex{}, ti)
+	assert.Equal(t, ti.Len(), bi.Len())
+	assert.Equal(t, btreeEntrySize*int64(ti.Len()), tree.(*readerImpl).idx().indexMemory)
+	assert.Equal(t, bucketEntrySize*int64(bi.Len()), bucket.(*readerImpl).idx().indexMemory)
+	assert.LessOrEqual(t, len(bi.(*bucketIndex).buckets), bi.Len())
+
+	for id := int64(-1); id <= int64(ti.Len()); id++ {
+		assert.Equal(t, ti.byID(id), bi.byID(id), id)
+	}
+	end := uint64(bucket.(*readerImpl).idx().endOffset)
+	for off := uint64(0); off <= end+10; off++ {
+		assert.Equal(t, ti.byOffset(off), bi.byOffset(off), off)
+	}
+	var ids []int64
+	bi.ascend(bi.byID(5), func(e *env.FrameOffsetEntry) bool {
+		ids = append(ids, e.ID)
+		return e.ID < 8
+	})
+	assert.Equal(t, []int64{5, 6, 7, 8}, ids)
+
+	require.NoError(t, bucket.Close())
+	require.NoError(t, tree.Close())
+
+	assert.Nil(t, newBucketIndex([]uint64{0}, []uint64{0}, nil).byOffset(0))
+}
@@ -0,0 +1,137 @@
+// Package format contains the encoding of the seek table shared by the seekable and seektable
+// packages, see
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md.
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SkippableFrameMagic is the first of the 16 `Skippable_Magic_Number` values,
+	// the seek table frame uses SkippableFrameMagic+SeekableTag.
+	SkippableFrameMagic uint32 = 0x184D2A50
+	SeekableTag                = 0xE
+
+	// SeekableMagicNumber ends the `Seek_Table_Footer`.
+	SeekableMagicNumber uint32 = 0x8F92EAB1
+
+	// HeaderSize is the size of `Skippable_Magic_Number` and `Frame_Size`.
+	HeaderSize = 8
+	// FooterSize is the size of `Seek_Table_Footer`.
+	FooterSize = 9
+
+	// ChecksumFlag and CompressedFlag are the bits of `Seek_Table_Descriptor`.
+	ChecksumFlag   = 1 << 7
+	CompressedFlag = 1 << 6
+	// UnknownBits is the mask of `Reserved_Bits` and `Unused_Bits`.
+	UnknownBits = 0x3F
+)
+
+// ErrCorruptSeekTable is returned when the seek table or its footer is malformed.
+var ErrCorruptSeekTable = errors.New("corrupt seek table")
+
+// Footer is the `Seek_Table_Footer`.
+type Footer struct {
+	NumberOfFrames uint32
+	// Checksum and Compressed are the flags of `Seek_Table_Descriptor`.
+	Checksum   bool
+	Compressed bool
+	// UnknownBits are the `Reserved_Bits` and `Unused_Bits` that are set, in place.
+	UnknownBits uint8
+}
+
+// ParseFooter parses the footer.  If strict is set, it refuses descriptors with `Reserved_Bits`
+// or `Unused_Bits` set, otherwise they are returned in UnknownBits.
+func ParseFooter(p []byte, strict bool) (Footer, error) {
+	if len(p) != FooterSize {
+		return Footer{}, fmt.Errorf("%w: footer length mismatch %d vs %d", ErrCorruptSeekTable, len(p), FooterSize)
+	}
+	if strict {
+		// Check that reserved and unused bits are set to 0.
+		if reservedBits := (p[4] << 2) >> 4; reservedBits != 0 {
+			return Footer{}, fmt.Errorf("%w: footer reserved bits %d != 0", ErrCorruptSeekTable, reservedBits)
+		}
+		if unusedBits := p[4] & 0x3; unusedBits != 0 {
+			return Footer{}, fmt.Errorf("%w: footer unused bits %d != 0", ErrCorruptSeekTable, unusedBits)
+		}
+	}
+	f := Footer{
+		NumberOfFrames: binary.LittleEndian.Uint32(p[0:]),
+		Checksum:       p[4]&ChecksumFlag != 0,
+		Compressed:     p[4]&CompressedFlag != 0,
+		UnknownBits:    p[4] & UnknownBits,
+	}
+	if magic := binary.LittleEndian.Uint32(p[5:]); magic != SeekableMagicNumber {
+		return f, fmt.Errorf("%w: footer magic mismatch %d vs %d", ErrCorruptSeekTable, magic, SeekableMagicNumber)
+	}
+	return f, nil
+}
+
+// PutFooter writes the footer into the first FooterSize bytes of dst.  UnknownBits are not written.
+func PutFooter(dst []byte, f Footer) {
+	binary.LittleEndian.PutUint32(dst[0:], f.NumberOfFrames)
+	dst[4] = 0
+	if f.Checksum {
+		dst[4] |= ChecksumFlag
+	}
+	if f.Compressed {
+		dst[4] |= CompressedFlag
+	}
+	binary.LittleEndian.PutUint32(dst[5:], SeekableMagicNumber)
+}
+
+// CheckHeader checks the `Skippable_Magic_Number` and `Frame_Size` of the seek table
+// skippable frame p, which must be at least HeaderSize bytes.
+func CheckHeader(p []byte) error {
+	if magic := binary.LittleEndian.Uint32(p[0:]); magic != SkippableFrameMagic+SeekableTag {
+		return fmt.Errorf("%w: skippable frame magic mismatch %d vs %d",
+			ErrCorruptSeekTable, magic, SkippableFrameMagic+SeekableTag)
+	}
+	if size := int64(binary.LittleEndian.Uint32(p[4:])); size != int64(len(p))-HeaderSize {
+		return fmt.Errorf("%w: skippable frame size mismatch: expected: %d, actual: %d",
+			ErrCorruptSeekTable, len(p)-HeaderSize, size)
+	}
+	return nil
+}
+
+// Entry is an element of `Seek_Table_Entries`.
+type Entry struct {
+	CompressedSize   uint32
+	DecompressedSize uint32
+	Checksum         uint32
+}
+
+// EntrySize returns the size of a single `Seek_Table_Entries` element.
+func EntrySize(checksum bool) int64 {
+	if checksum {
+		return 12
+	}
+	return 8
+}
+
+// ParseEntry parses the entry.  The checksum is only read if p has room for it.
+func ParseEntry(p []byte) (Entry, error) {
+	if len(p) < 8 {
+		return Entry{}, fmt.Errorf("%w: entry length mismatch %d vs %d", ErrCorruptSeekTable, len(p), 8)
+	}
+	e := Entry{
+		CompressedSize:   binary.LittleEndian.Uint32(p[0:]),
+		DecompressedSize: binary.LittleEndian.Uint32(p[4:]),
+	}
+	if len(p) >= 12 {
+		e.Checksum = binary.LittleEndian.Uint32(p[8:])
+	}
+	return e, nil
+}
+
+// PutEntry writes the entry into dst.  The checksum is only written if dst has room for it.
+func PutEntry(dst []byte, e Entry) {
+	binary.LittleEndian.PutUint32(dst[0:], e.CompressedSize)
+	binary.LittleEndian.PutUint32(dst[4:], e.DecompressedSize)
+	if len(dst) >= 12 {
+		binary.LittleEndian.PutUint32(dst[8:], e.Checksum)
+	}
+}
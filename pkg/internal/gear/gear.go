@@ -0,0 +1,22 @@
+// Package gear contains the table of the Gear rolling hash shared by the content-defined
+// chunking implementations.
+package gear
+
+// Table is the table of random values used by the Gear rolling hash:
+//
+//	fp = (fp << 1) + Table[b]
+//
+// It is generated with a fixed seed and MUST NOT change: chunk boundaries,
+// and therefore deduplication across streams, depend on it.
+var Table = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x5A5D_5EEC_AB1E_F00D)
+	for i := range t {
+		x += 0x9E3779B97F4A7C15
+		z := x
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}()
@@ -0,0 +1,180 @@
+package seekable
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"unsafe"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+const (
+	// btreeEntrySize is the memory taken by a frame in btreeIndex: the entry and the pointer to it.
+	btreeEntrySize = int64(unsafe.Sizeof(env.FrameOffsetEntry{})) + int64(unsafe.Sizeof(uintptr(0)))
+	// bucketEntrySize is the memory taken by a frame in bucketIndex: the offsets, the checksum
+	// and, on average, a bucket.
+	bucketEntrySize = 2*int64(unsafe.Sizeof(uint64(0))) + 2*int64(unsafe.Sizeof(uint32(0)))
+)
+
+// estimateIndexMemory returns the memory taken by the index of the seek table described by footer.
+func (r *readerImpl) estimateIndexMemory(footer *seekTableFooter) int64 {
+	frames := int64(footer.NumberOfFrames)
+	if r.lazySeekTable {
+		blocks := (frames + lazyIndexBlock - 1) / lazyIndexBlock
+		return footer.entrySize()*frames + blocks*int64(unsafe.Sizeof(lazyIndexOffsets{}))
+	}
+	if r.bucketIndex {
+		return bucketEntrySize * frames
+	}
+	return btreeEntrySize * frames
+}
+
+// readerMemory is the memory budget for decompressed frames, see WithReaderMemoryLimit.
+//
+// A frame reserves its compressed and decompressed size while it is decompressed.
+// Once decompressed, the compressed size is released and the decompressed size stays reserved
+// while the frame is retained by the reader: in the frame cache, as the last read frame or
+// as a prefetched frame.  Retained frames are reclaimed to make room for new ones.
+type readerMemory struct {
+	m    sync.Mutex
+	cond *sync.Cond
+
+	limit int64
+	used  int64
+
+	// prefetched and retained drop one of the prefetched or otherwise retained frames
+	// and return its reserved size, or zero if there is nothing to drop.  Prefetched frames
+	// are reclaimed first.  They are called with m held.
+	prefetched []reclaimer
+	retained   []reclaimer
+}
+
+// reclaimer is a reclaim function registered by owner, e.g. a reader sharing the budget with its clones.
+type reclaimer struct {
+	owner   any
+	reclaim func() int64
+}
+
+func newReaderMemory(limit int64) *readerMemory {
+	m := &readerMemory{limit: limit}
+	m.cond = sync.NewCond(&m.m)
+	return m
+}
+
+// acquire reserves n bytes reclaiming retained frames if needed and waiting for
+// other frames being decompressed.  It fails if n does not fit into the budget at all.
+func (m *readerMemory) acquire(n int64) error {
+	if m == nil {
+		return nil
+	}
+	if n > m.limit {
+		return fmt.Errorf("%w: frame needs %d bytes, available: %d", ErrMemoryLimit, n, m.limit)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for m.used+n > m.limit {
+		if freed := m.reclaim(); freed > 0 {
+			m.used -= freed
+			continue
+		}
+		// Everything left is reserved by frames being decompressed.
+		m.cond.Wait()
+	}
+	m.used += n
+	return nil
+}
+
+// tryAcquire reserves n bytes if they are available without reclaiming retained frames.
+func (m *readerMemory) tryAcquire(n int64) bool {
+	if m == nil {
+		return true
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.used+n > m.limit {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+// setLimit changes the limit, e.g. after the index grew.
+func (m *readerMemory) setLimit(limit int64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.limit = limit
+	m.cond.Broadcast()
+}
+
+func (m *readerMemory) release(n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.used -= n
+	m.cond.Broadcast()
+}
+
+// notify wakes up the waiters after a frame became reclaimable.
+func (m *readerMemory) notify() {
+	if m == nil {
+		return
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.cond.Broadcast()
+}
+
+// addReclaimers registers the reclaim functions of owner for prefetched and other retained frames.
+// Either may be nil.
+func (m *readerMemory) addReclaimers(owner any, prefetched, retained func() int64) {
+	if m == nil {
+		return
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if prefetched != nil {
+		m.prefetched = append(m.prefetched, reclaimer{owner: owner, reclaim: prefetched})
+	}
+	if retained != nil {
+		m.retained = append(m.retained, reclaimer{owner: owner, reclaim: retained})
+	}
+}
+
+// removeReclaimers unregisters the reclaim functions of owner.
+func (m *readerMemory) removeReclaimers(owner any) {
+	if m == nil {
+		return
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	remove := func(r reclaimer) bool { return r.owner == owner }
+	m.prefetched = slices.DeleteFunc(m.prefetched, remove)
+	m.retained = slices.DeleteFunc(m.retained, remove)
+}
+
+func (m *readerMemory) reclaim() int64 {
+	for _, l := range [][]reclaimer{m.prefetched, m.retained} {
+		for _, r := range l {
+			if freed := r.reclaim(); freed > 0 {
+				return freed
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package seekable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// MultiReader presents several seekable streams as one logical decompressed stream,
+// e.g. the shards of a backup, see NewMultiReader.
+type MultiReader struct {
+	parts []Reader
+	// starts are the decompressed offsets of the parts in the logical stream followed by its size.
+	starts []int64
+	offset int64
+}
+
+// NewMultiReader returns the reader of the concatenation of the decompressed data of parts.
+// Offsets of Seek, ReadAt and ReadAtContext are translated across parts, and reads spanning
+// several parts are split between them.  The parts are owned by the returned reader and must
+// not be used directly afterwards, Close closes all of them.
+func NewMultiReader(parts ...Reader) (*MultiReader, error) {
+	m := &MultiReader{parts: parts, starts: make([]int64, 0, len(parts)+1)}
+
+	var size int64
+	for i, p := range parts {
+		n, err := p.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get size of part %d: %w", i, err)
+		}
+		if _, err := p.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek part %d: %w", i, err)
+		}
+		m.starts = append(m.starts, size)
+		size += n
+	}
+	m.starts = append(m.starts, size)
+	return m, nil
+}
+
+// Size returns the decompressed size of all parts.
+func (m *MultiReader) Size() int64 {
+	return m.starts[len(m.parts)]
+}
+
+// part returns the index of the part containing the offset, skipping empty parts.
+// The offset must be within the logical stream.
+func (m *MultiReader) part(off int64) int {
+	return sort.Search(len(m.parts), func(i int) bool { return m.starts[i+1] > off })
+}
+
+// Seek implements io.Seeker interface.  This method is NOT goroutine-safe.
+func (m *MultiReader) Seek(offset int64, whence int) (int64, error) {
+	newOffset := m.offset
+	switch whence {
+	case io.SeekCurrent:
+		newOffset += offset
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = m.Size() + offset
+	default:
+		return 0, fmt.Errorf("unknown whence: %d", whence)
+	}
+
+	if newOffset < 0 {
+		return 0, fmt.Errorf("%w: offset before the start of the file: %d (%d + %d)",
+			ErrOffsetOutOfRange, newOffset, m.offset, offset)
+	}
+
+	m.offset = newOffset
+	return m.offset, nil
+}
+
+// Read implements io.Reader interface, reads do not span parts.  This method is NOT goroutine-safe.
+func (m *MultiReader) Read(p []byte) (int, error) {
+	if m.offset >= m.Size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	i := m.part(m.offset)
+	if _, err := m.parts[i].Seek(m.offset-m.starts[i], io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := m.parts[i].Read(p[:min(int64(len(p)), m.starts[i+1]-m.offset)])
+	m.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt interface.  This method is goroutine-safe if ReadAt of all parts is.
+func (m *MultiReader) ReadAt(p []byte, off int64) (int, error) {
+	return m.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is ReadAt that is canceled with ctx, see Reader's ReadAtContext.
+func (m *MultiReader) ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: offset before the start of the file: %d", ErrOffsetOutOfRange, off)
+	}
+
+	for n < len(p) {
+		cur := off + int64(n)
+		if cur >= m.Size() {
+			return n, io.EOF
+		}
+		i := m.part(cur)
+		dst := p[n:min(int64(len(p)), int64(n)+m.starts[i+1]-cur)]
+		var k int
+		k, err = m.parts[i].ReadAtContext(ctx, dst, cur-m.starts[i])
+		n += k
+		if err != nil && !(errors.Is(err, io.EOF) && k == len(dst)) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo interface to write all data from the current offset to w.
+// This method is NOT goroutine-safe.
+func (m *MultiReader) WriteTo(w io.Writer) (n int64, err error) {
+	for m.offset < m.Size() {
+		i := m.part(m.offset)
+		if _, err := m.parts[i].Seek(m.offset-m.starts[i], io.SeekStart); err != nil {
+			return n, err
+		}
+		k, err := m.parts[i].WriteTo(w)
+		n += k
+		m.offset += k
+		if err != nil {
+			return n, err
+		}
+		if m.offset < m.starts[i+1] {
+			return n, fmt.Errorf("%w: part %d ended at: %d, expected: %d",
+				io.ErrUnexpectedEOF, i, m.offset-m.starts[i], m.starts[i+1]-m.starts[i])
+		}
+	}
+	return n, nil
+}
+
+// Close closes all parts.
+func (m *MultiReader) Close() error {
+	errs := make([]error, 0, len(m.parts))
+	for _, p := range m.parts {
+		errs = append(errs, p.Close())
+	}
+	return errors.Join(errs...)
+}
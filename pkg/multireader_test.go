@@ -0,0 +1,79 @@
+package seekable
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReader(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// The second part is empty.
+	var expected []byte
+	var parts []Reader
+	for i, sizes := range [][]int{{100, 50}, {}, {30, 70, 10}} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc)
+		require.NoError(t, err)
+		for j, size := range sizes {
+			data := bytes.Repeat([]byte{byte(i*10 + j)}, size)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			expected = append(expected, data...)
+		}
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+		require.NoError(t, err)
+		parts = append(parts, r)
+	}
+
+	m, err := NewMultiReader(parts...)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, m.Close()) }()
+	assert.Equal(t, int64(len(expected)), m.Size())
+
+	all, err := io.ReadAll(m)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+
+	// Reads spanning parts.
+	for _, off := range []int64{0, 99, 140, 149, 150, 250} {
+		buf := make([]byte, 20)
+		n, err := m.ReadAt(buf, off)
+		end := min(off+20, int64(len(expected)))
+		if end-off < 20 {
+			require.ErrorIs(t, err, io.EOF)
+		} else {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, expected[off:end], buf[:n], "offset %d", off)
+	}
+	_, err = m.ReadAt(make([]byte, 1), -1)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	off, err := m.Seek(-120, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(140), off)
+	var out bytes.Buffer
+	n, err := io.Copy(&out, m)
+	require.NoError(t, err)
+	assert.Equal(t, int64(120), n)
+	assert.Equal(t, expected[140:], out.Bytes())
+
+	_, err = m.Seek(-1, io.SeekStart)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+	_, err = m.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,195 @@
+package seekable
+
+import (
+	"context"
+	"sync"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// prefetchedFrame is a frame that is being decompressed in the background.
+type prefetchedFrame struct {
+	done chan struct{}
+	data []byte
+	err  error
+
+	// size is the decompressed size reserved in the reader memory by the frame.
+	size int64
+	// finished and dropped are guarded by prefetcher.m.  A finished frame keeps its size
+	// reserved until it is taken or dropped.
+	finished, dropped bool
+}
+
+// prefetcher decompresses frames following the one being read in the background,
+// see WithPrefetch.  At most n frames are kept at a time.
+type prefetcher struct {
+	m      sync.Mutex
+	n      int
+	frames map[int64]*prefetchedFrame
+	wg     sync.WaitGroup
+}
+
+func newPrefetcher(n int) *prefetcher {
+	return &prefetcher{n: n, frames: make(map[int64]*prefetchedFrame, n)}
+}
+
+// take returns the prefetched frame with the given ID, waiting for it to be decompressed,
+// or nil if the frame was not prefetched.  The reserved size of the frame passes to the caller.
+func (p *prefetcher) take(id int64) *prefetchedFrame {
+	p.m.Lock()
+	f, ok := p.frames[id]
+	delete(p.frames, id)
+	p.m.Unlock()
+	if !ok {
+		return nil
+	}
+
+	<-f.done
+	return f
+}
+
+// drop forgets the frame and returns its reserved size if it is finished, otherwise
+// the size is released once the frame is.  p.m must be held.
+func (p *prefetcher) drop(id int64, f *prefetchedFrame) int64 {
+	delete(p.frames, id)
+	if !f.finished {
+		f.dropped = true
+		return 0
+	}
+	if f.err != nil {
+		return 0
+	}
+	return f.size
+}
+
+// reclaim drops the finished frame farthest ahead and returns its reserved size,
+// or zero if there is none.
+func (p *prefetcher) reclaim() int64 {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var last *prefetchedFrame
+	lastID := int64(-1)
+	for id, f := range p.frames {
+		if f.finished && f.err == nil && id > lastID {
+			last, lastID = f, id
+		}
+	}
+	if last == nil {
+		return 0
+	}
+	return p.drop(lastID, last)
+}
+
+// wait waits for background decompression to finish, drops all prefetched frames
+// and returns their reserved size.
+func (p *prefetcher) wait() int64 {
+	p.wg.Wait()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var freed int64
+	for id, f := range p.frames {
+		freed += p.drop(id, f)
+	}
+	return freed
+}
+
+// frameData returns the decompressed frame appending it to dst, or the prefetched one if there is one.
+// If prefetching is enabled, it also starts prefetching the frames after index.
+//
+// On success the decompressed size of the frame stays reserved in the reader memory,
+// see decodeReserved.
+func (r *readerImpl) frameData(ctx context.Context, index *env.FrameOffsetEntry, dst []byte) ([]byte, error) {
+	if r.prefetcher == nil {
+		return r.decodeReserved(ctx, index, dst)
+	}
+
+	f := r.prefetcher.take(index.ID)
+	r.prefetchAfter(index)
+	if f == nil {
+		return r.decodeReserved(ctx, index, dst)
+	}
+	return f.data, f.err
+}
+
+// prefetchAfter starts decompressing the data frames after index in the background and drops
+// prefetched frames that are not among them, e.g. after a seek.  Under WithReaderMemoryLimit
+// frames are only prefetched if there is memory left without reclaiming retained frames.
+//
+// Reads in the ranges hinted with AccessRandom do not prefetch, and the ones hinted with
+// AccessSequential prefetch twice as many frames, see Reader's Advise.
+func (r *readerImpl) prefetchAfter(index *env.FrameOffsetEntry) {
+	p := r.prefetcher
+	n := p.n
+	switch r.hints.get(index.DecompOffset) {
+	case AccessRandom:
+		return
+	case AccessSequential:
+		n *= 2
+	}
+
+	var next []*env.FrameOffsetEntry
+	ids := make(map[int64]struct{}, n)
+	r.idx().index.ascend(index, func(e *env.FrameOffsetEntry) bool {
+		// Streamed frames are never decompressed as a whole.
+		if e.ID != index.ID && e.DecompSize != 0 && !r.streamed(e) {
+			next = append(next, e)
+			ids[e.ID] = struct{}{}
+		}
+		return len(next) < n
+	})
+
+	p.m.Lock()
+	var freed int64
+	for id, f := range p.frames {
+		if _, ok := ids[id]; !ok {
+			// The frame may still be decompressed, but the result is dropped.
+			freed += p.drop(id, f)
+		}
+	}
+	start := next[:0]
+	for _, e := range next {
+		if _, ok := p.frames[e.ID]; !ok {
+			start = append(start, e)
+		}
+	}
+	p.m.Unlock()
+	r.memory.release(freed)
+
+	for _, e := range start {
+		if !r.memory.tryAcquire(int64(e.CompSize) + int64(e.DecompSize)) {
+			break
+		}
+
+		p.m.Lock()
+		if _, ok := p.frames[e.ID]; ok {
+			// Started concurrently by another read.
+			p.m.Unlock()
+			r.memory.release(int64(e.CompSize) + int64(e.DecompSize))
+			continue
+		}
+		f := &prefetchedFrame{done: make(chan struct{}), size: int64(e.DecompSize)}
+		p.frames[e.ID] = f
+		p.wg.Add(1)
+		p.m.Unlock()
+
+		go func() {
+			defer p.wg.Done()
+			// Prefetched frames outlive the read, so they are decompressed with the reader's context.
+			data, err := r.decodeFrame(r.ctx, e, nil)
+
+			released := int64(e.CompSize)
+			p.m.Lock()
+			f.data, f.err = data, err
+			f.finished = true
+			if err != nil || f.dropped {
+				released += f.size
+			}
+			p.m.Unlock()
+			close(f.done)
+			r.memory.release(released)
+		}()
+	}
+}
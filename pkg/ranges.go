@@ -0,0 +1,194 @@
+package seekable
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// Range is a range of the decompressed stream, see Reader's ReadRanges.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// maxRangeReadSize caps the compressed size of adjacent frames that ReadRanges reads
+// from the environment at once.  Bigger frames are read on their own.
+const maxRangeReadSize = 8 << 20
+
+// rangeFrame is a frame overlapping some of the ranges passed to ReadRanges.
+type rangeFrame struct {
+	index *env.FrameOffsetEntry
+	// ranges are the indexes of the ranges the frame overlaps.
+	ranges []int
+}
+
+func (r *readerImpl) ReadRanges(ranges []Range) ([][]byte, error) {
+	if r.closed.Load() {
+		return nil, fmt.Errorf("reader is closed")
+	}
+
+	out := make([][]byte, len(ranges))
+	frames := make(map[int64]*rangeFrame)
+	idx := r.idx()
+	for i, rng := range ranges {
+		if rng.Offset < 0 || rng.Length < 0 || rng.Offset+rng.Length > idx.endOffset {
+			return nil, fmt.Errorf("%w: invalid range: offset: %d, length: %d, size: %d",
+				ErrOffsetOutOfRange, rng.Offset, rng.Length, idx.endOffset)
+		}
+		out[i] = make([]byte, rng.Length)
+		if rng.Length == 0 {
+			continue
+		}
+
+		end := uint64(rng.Offset + rng.Length)
+		idx.index.ascend(r.GetIndexByDecompOffset(uint64(rng.Offset)), func(index *env.FrameOffsetEntry) bool {
+			if index.DecompOffset >= end {
+				return false
+			}
+			if index.DecompSize == 0 {
+				return true
+			}
+			f, ok := frames[index.ID]
+			if !ok {
+				f = &rangeFrame{index: index}
+				frames[index.ID] = f
+			}
+			f.ranges = append(f.ranges, i)
+			return true
+		})
+	}
+
+	g, ctx := errgroup.WithContext(r.ctx)
+	g.SetLimit(max(r.readConcurrency, 1))
+	for _, run := range r.planRanges(frames) {
+		g.Go(func() error {
+			return r.readRun(ctx, run, ranges, out)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// planRanges groups the frames that are adjacent in the compressed stream into runs read
+// from the environment at once.  Frames that are cached and frames read incrementally,
+// see WithStreamingDecode, are runs of their own.
+func (r *readerImpl) planRanges(frames map[int64]*rangeFrame) [][]*rangeFrame {
+	sorted := make([]*rangeFrame, 0, len(frames))
+	for _, f := range frames {
+		sorted = append(sorted, f)
+	}
+	slices.SortFunc(sorted, func(a, b *rangeFrame) int { return cmp.Compare(a.index.ID, b.index.ID) })
+
+	alone := func(index *env.FrameOffsetEntry) bool {
+		return r.streamed(index) ||
+			(r.cache != nil && r.cache.contains(index.DecompOffset)) ||
+			(r.compCache != nil && r.compCache.contains(index.CompOffset))
+	}
+
+	var runs [][]*rangeFrame
+	var size uint64
+	for _, f := range sorted {
+		if n := len(runs); n > 0 && !alone(f.index) {
+			run := runs[n-1]
+			last := run[len(run)-1].index
+			if !alone(last) && last.CompOffset+uint64(last.CompSize) == f.index.CompOffset &&
+				size+uint64(f.index.CompSize) <= maxRangeReadSize {
+				runs[n-1] = append(run, f)
+				size += uint64(f.index.CompSize)
+				continue
+			}
+		}
+		runs = append(runs, []*rangeFrame{f})
+		size = uint64(f.index.CompSize)
+	}
+	return runs
+}
+
+// readRun reads the frames of the run, decompresses each of them once and copies them
+// into the ranges they overlap.
+func (r *readerImpl) readRun(ctx context.Context, run []*rangeFrame, ranges []Range, out [][]byte) error {
+	first := run[0].index
+	if len(run) == 1 && r.streamed(first) {
+		for _, i := range run[0].ranges {
+			from, to := overlap(first, ranges[i])
+			off := uint64(ranges[i].Offset)
+			if err := r.readStream(ctx, first, from-first.DecompOffset, out[i][from-off:to-off]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(run) == 1 && r.cache != nil {
+		if data := r.cache.get(first.DecompOffset); data != nil {
+			r.stats.cacheHits.Add(1)
+			copyRanges(run[0], data, ranges, out)
+			return nil
+		}
+	}
+
+	last := run[len(run)-1].index
+	span := &env.FrameOffsetEntry{
+		ID:         first.ID,
+		CompOffset: first.CompOffset,
+		CompSize:   uint32(last.CompOffset + uint64(last.CompSize) - first.CompOffset),
+	}
+	// The frames of the run are decompressed one by one into the same buffer.
+	var decompSize uint32
+	for _, f := range run {
+		decompSize = max(decompSize, f.index.DecompSize)
+	}
+	reserved := int64(span.CompSize) + int64(decompSize)
+	if err := r.memory.acquire(reserved); err != nil {
+		return err
+	}
+	defer r.memory.release(reserved)
+
+	var src []byte
+	var put func()
+	var err error
+	if len(run) == 1 {
+		src, put, err = r.readScratch(ctx, first)
+	} else {
+		src, put, err = r.fetchScratch(ctx, span)
+	}
+	if err != nil {
+		return err
+	}
+	defer put()
+
+	var buf []byte
+	for _, f := range run {
+		start := f.index.CompOffset - first.CompOffset
+		r.stats.cacheMisses.Add(1)
+		data, err := r.decodeData(ctx, f.index, src[start:start+uint64(f.index.CompSize)], buf[:0])
+		if err != nil {
+			return err
+		}
+		copyRanges(f, data, ranges, out)
+		buf = data
+	}
+	return nil
+}
+
+// overlap returns the part [from, to) of the range that is within the frame.
+func overlap(index *env.FrameOffsetEntry, rng Range) (from, to uint64) {
+	from = max(uint64(rng.Offset), index.DecompOffset)
+	to = min(uint64(rng.Offset+rng.Length), index.DecompOffset+uint64(index.DecompSize))
+	return from, to
+}
+
+// copyRanges copies the decompressed frame into the parts of the ranges it overlaps.
+func copyRanges(f *rangeFrame, data []byte, ranges []Range, out [][]byte) {
+	for _, i := range f.ranges {
+		from, to := overlap(f.index, ranges[i])
+		copy(out[i][from-uint64(ranges[i].Offset):to-uint64(ranges[i].Offset)], data[from-f.index.DecompOffset:])
+	}
+}
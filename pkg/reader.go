@@ -1,19 +1,28 @@
 package seekable
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/cespare/xxhash/v2"
 	"github.com/google/btree"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/format"
 )
 
 type cachedFrame struct {
@@ -23,12 +32,20 @@ type cachedFrame struct {
 	data   []byte
 }
 
-func (f *cachedFrame) replace(offset uint64, data []byte) {
+// replace sets the frame and returns the previous one.
+func (f *cachedFrame) replace(offset uint64, data []byte) []byte {
 	f.m.Lock()
 	defer f.m.Unlock()
 
+	old := f.data
 	f.offset = offset
 	f.data = data
+	return old
+}
+
+// reclaim drops the frame and returns its size.
+func (f *cachedFrame) reclaim() int64 {
+	return int64(len(f.replace(math.MaxUint64, nil)))
 }
 
 func (f *cachedFrame) get() (uint64, []byte) {
@@ -39,12 +56,24 @@ func (f *cachedFrame) get() (uint64, []byte) {
 }
 
 // readSeekerEnvImpl is the environment implementation for the io.ReadSeeker.
+// Reads that seek are serialized, so that it is goroutine-safe even if rs
+// does not implement io.ReaderAt.
 type readSeekerEnvImpl struct {
+	m  sync.Mutex
 	rs io.ReadSeeker
 }
 
-func (rs *readSeekerEnvImpl) GetFrameByIndex(index env.FrameOffsetEntry) (p []byte, err error) {
-	p = make([]byte, index.CompSize)
+func (rs *readSeekerEnvImpl) GetFrameByIndex(index env.FrameOffsetEntry) ([]byte, error) {
+	return rs.readInto(index, nil)
+}
+
+// readInto is GetFrameByIndex that reads the frame into buf if it is big enough.
+func (rs *readSeekerEnvImpl) readInto(index env.FrameOffsetEntry, buf []byte) (p []byte, err error) {
+	if cap(buf) >= int(index.CompSize) {
+		p = buf[:index.CompSize]
+	} else {
+		p = make([]byte, index.CompSize)
+	}
 	off := int64(index.CompOffset)
 
 	switch v := rs.rs.(type) {
@@ -54,6 +83,9 @@ func (rs *readSeekerEnvImpl) GetFrameByIndex(index env.FrameOffsetEntry) (p []by
 			err = nil
 		}
 	default:
+		rs.m.Lock()
+		defer rs.m.Unlock()
+
 		_, err = v.Seek(off, io.SeekStart)
 		if err != nil {
 			return nil, err
@@ -65,6 +97,9 @@ func (rs *readSeekerEnvImpl) GetFrameByIndex(index env.FrameOffsetEntry) (p []by
 }
 
 func (rs *readSeekerEnvImpl) ReadFooter() ([]byte, error) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
 	n, err := rs.rs.Seek(-seekTableFooterOffset, io.SeekEnd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to seek to: %d: %w", -seekTableFooterOffset, err)
@@ -79,7 +114,17 @@ func (rs *readSeekerEnvImpl) ReadFooter() ([]byte, error) {
 	return buf, nil
 }
 
+func (rs *readSeekerEnvImpl) Size() (int64, error) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	return rs.rs.Seek(0, io.SeekEnd)
+}
+
 func (rs *readSeekerEnvImpl) ReadSkipFrame(skippableFrameOffset int64) ([]byte, error) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
 	n, err := rs.rs.Seek(-skippableFrameOffset, io.SeekEnd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to seek to: %d: %w", -skippableFrameOffset, err)
@@ -93,31 +138,155 @@ func (rs *readSeekerEnvImpl) ReadSkipFrame(skippableFrameOffset int64) ([]byte,
 	return buf, nil
 }
 
-type readerImpl struct {
-	dec   ZSTDDecoder
-	index *btree.BTreeG[*env.FrameOffsetEntry]
+// readerConcurrency is the prefetching and concurrency set by WithPrefetch, WithReadConcurrency
+// and WithWriteToConcurrency.
+type readerConcurrency struct {
+	prefetch, read, writeTo int
+}
+
+// seekTableIndex is the index of the stream built from its seek table.  It is not modified
+// once published by open or Refresh, so that concurrent reads see a consistent index.
+type seekTableIndex struct {
+	index     frameIndex
+	numFrames int64
+	endOffset int64
+	// compEnd is the end of the last frame in the compressed stream.
+	compEnd uint64
 
+	// checksums is set if the seek table has frame checksums.
 	checksums bool
+	// footerFrames and seekTableFrameSize are the number of frames declared by the footer
+	// and the size of the seek table frame the index was built from, see Validate.
+	footerFrames       int64
+	seekTableFrameSize int64
+	// indexMemory is the part of WithReaderMemoryLimit taken by the index.
+	indexMemory int64
+}
 
-	offset int64
+type readerImpl struct {
+	dec ZSTDDecoder
+	// decoders, if set by WithDecoderFactory, is used instead of dec.
+	decoders *decoderPool
+	// table is the current index of the stream, see idx.
+	table atomic.Pointer[seekTableIndex]
+
+	// lazySeekTable and maxSeekTableSize are set by WithLazySeekTable and WithMaxSeekTableSize.
+	// bucketIndex is disabled by WithBucketIndex.
+	lazySeekTable    bool
+	bucketIndex      bool
+	maxSeekTableSize int64
+	// maxFrameSize, if set by WithMaxDecompressedFrameSize, limits the decompressed size of frames.
+	maxFrameSize int64
+
+	// memoryLimit is set by WithReaderMemoryLimit, memory is the budget for decompressed
+	// frames that is left after the index.
+	memoryLimit int64
+	memory      *readerMemory
+
+	// verifyChecksums, unless disabled by WithChecksumVerification, makes reads verify
+	// the frame checksums of the seek table.
+	verifyChecksums bool
+	// requireChecksums, if set by WithRequireChecksums, refuses seek tables without checksums.
+	requireChecksums bool
+	// strictFormat, if set by WithStrictFormat, refuses seek tables with unknown descriptor bits.
+	strictFormat bool
+	// followInterval, if set by WithFollow, is how often Read and WriteTo check the stream for
+	// new frames at the end of the data.
+	followInterval time.Duration
+
+	// seekTable, if set, is the seek table skippable frame loaded with WithSeekTableFrom
+	// or WithSeekTableBytes.
+	seekTable []byte
 
-	numFrames int64
-	endOffset int64
+	offset int64
 
 	logger *zap.Logger
 	env    env.REnvironment
 
 	closed atomic.Bool
 
-	// TODO: Add simple LRU cache.
+	// cachedFrame is the last decompressed frame, unless cache is set by WithFrameCache.
 	cachedFrame cachedFrame
+	cache       *frameCache
+	// compCache, if set by WithCompressedFrameCache, keeps the compressed frames read from env.
+	compCache *frameCache
+	// newCachePolicy, if set by WithCachePolicy, replaces the LRU policy of the caches.
+	newCachePolicy func(maxBytes int64) CachePolicy
+	// refs counts the reader and its clones, see Clone.  The frame caches they share
+	// are cleared when all of them are closed.
+	refs *atomic.Int64
+
+	stats readerStats
+	// tracer, if set by WithTracerProvider, traces reads, see startSpan.
+	tracer trace.Tracer
+	// buffers, if set by WithRBufferPool, supplies the buffers of compressed frames, see readScratch.
+	buffers BufferPool
+
+	// coalesceWindow and coalesceBytes are set by WithReadCoalescing, coalescer merges
+	// the concurrent frame reads unless they are disabled for the stream.
+	coalesceWindow time.Duration
+	coalesceBytes  int64
+	coalescer      *readCoalescer
+
+	// concurrency is set by the options below, which are disabled for the streams
+	// that do not implement io.ReaderAt.
+	concurrency readerConcurrency
+	// prefetch is the number of frames set by WithPrefetch, which are decompressed by prefetcher.
+	prefetch   int
+	prefetcher *prefetcher
+
+	// readConcurrency, if set by WithReadConcurrency, is the number of frames
+	// decompressed concurrently by a single read.
+	readConcurrency int
+	// ctx, set by WithRContext, is the context of all reads but ReadAtContext.
+	ctx context.Context
+	// writeToConcurrency, if set by WithWriteToConcurrency, is the number of frames
+	// decompressed concurrently by WriteTo.
+	writeToConcurrency int
+
+	// streamThreshold and newStreamReader are set by WithStreamingDecode, streams keeps
+	// the stream of the last frame read incrementally.
+	streamThreshold int64
+	newStreamReader func(src io.Reader) (io.ReadCloser, error)
+	streams         streamSlot
+
+	// hints are set by Advise, advised tracks the frames it decompresses in the background.
+	hints   accessHints
+	advised sync.WaitGroup
+
+	// verifyDigest is set by WithVerifyContentDigest.
+	verifyDigest bool
+
+	// onCorruptFrame, if set by WithSkipCorruptFrames, is called for the frames that are
+	// replaced with zeroes because they can not be decompressed.
+	onCorruptFrame func(FrameInfo, error)
+
+	// dicts are the dictionaries set by WithDictionaries keyed by their IDs.
+	dicts map[uint32][]byte
+	// dictionary ID is lazily loaded by DictionaryID
+	dictOnce sync.Once
+	dictID   uint32
+	dictErr  error
+
+	// frame tags are lazily loaded by FrameTag
+	tagsOnce sync.Once
+	tags     map[int64][]byte
+	tagsErr  error
+
+	// sections are lazily loaded by OpenSection
+	sectionsOnce sync.Once
+	sections     map[string]section
+	sectionsErr  error
 }
 
 var (
+	_ env.Sizer = (*readSeekerEnvImpl)(nil)
+
 	_ io.Seeker   = (*readerImpl)(nil)
 	_ io.Reader   = (*readerImpl)(nil)
 	_ io.ReaderAt = (*readerImpl)(nil)
 	_ io.Closer   = (*readerImpl)(nil)
+	_ io.WriterTo = (*readerImpl)(nil)
 )
 
 type Reader interface {
@@ -131,25 +300,236 @@ type Reader interface {
 	// concurrently since it modifies the underlying offset.
 	Read(p []byte) (n int, err error)
 
+	// WriteTo implements io.WriterTo interface to sequentially write all data from the current
+	// offset to w, so that io.Copy decompresses frames directly into w without an intermediate
+	// buffer.  This method is NOT goroutine-safe since it modifies the underlying offset.
+	WriteTo(w io.Writer) (n int64, err error)
+
 	// ReadAt implements io.ReaderAt interface to randomly access data.
-	// This method is goroutine-safe and can be called concurrently ONLY if
-	// the underlying reader supports io.ReaderAt interface.
+	// This method is goroutine-safe and can be called by any number of goroutines
+	// concurrently with each other and with Read and Seek.  Frames are read concurrently
+	// if the underlying reader supports io.ReaderAt interface, otherwise the reads of
+	// the underlying reader are serialized.  A custom environment set by WithREnvironment
+	// must be goroutine-safe.  The decoder must be goroutine-safe too, as the ones of
+	// github.com/klauspost/compress/zstd are, unless WithDecoderFactory is used.
 	ReadAt(p []byte, off int64) (n int, err error)
 
+	// ReadAtContext is ReadAt that stops reading once ctx is done, e.g. cancelled
+	// or past its deadline.  ctx is passed to the environment if it implements
+	// env.ContextREnvironment, other reads are only checked between frames.
+	ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error)
+
+	// Section returns the reader of n bytes of the decompressed stream starting at off.
+	// It has its own offset and reads with ReadAt, so sections do not affect each other,
+	// nor the offset of the Reader, and can be used concurrently if the underlying reader
+	// supports io.ReaderAt interface.
+	Section(off, n int64) *io.SectionReader
+
+	// FrameTag returns the tag attached to the frame with the given index by Writer's WriteTagged,
+	// or nil if the frame has no tag.  Tags are loaded on the first call.
+	// This method is goroutine-safe.
+	FrameTag(id int64) ([]byte, error)
+
+	// SkippableFrames calls fn for each skippable frame written by Writer's WriteSkippableFrame,
+	// or by other tools, in the stream order until fn returns an error, which is then returned.
+	// Frames used by the seekable format itself, e.g. seek table checkpoints and metadata, are skipped.
+	// The payload may reference the memory of the underlying environment and must not be modified.
+	// This method is goroutine-safe ONLY if the underlying reader supports io.ReaderAt interface.
+	SkippableFrames(fn func(frame SkippableFrame) error) error
+
+	// VerifyContentDigest decompresses the whole stream and compares its SHA-256 digest with
+	// the one stored by Writer's WithContentDigest.  It fails with ErrDigestMismatch, which is also
+	// an ErrChecksumMismatch, if they differ, and with an error if the stream has no digest.  Cancelling ctx aborts the verification.
+	// This method is goroutine-safe ONLY if the underlying reader supports io.ReaderAt interface.
+	VerifyContentDigest(ctx context.Context) error
+
+	// OpenSection returns the reader of the section written by Writer's WriteSection.
+	// It fails with ErrSectionNotFound if there is no such section.  The directory of
+	// sections is loaded on the first call.  The returned reader uses ReadAt,
+	// so sections can be read concurrently if the underlying reader supports io.ReaderAt.
+	// This method is goroutine-safe.
+	OpenSection(name string) (io.ReadSeeker, error)
+
+	// NumFrames returns the number of frames in the seek table, including frames without data.
+	NumFrames() int64
+
+	// Frame returns the description of the frame with the given index from 0 to NumFrames()-1,
+	// e.g. to plan range reads of the compressed stream.  It fails with ErrOffsetOutOfRange
+	// for other indexes.  This method is goroutine-safe.
+	Frame(id int64) (FrameInfo, error)
+
+	// CompressedOffset maps the offset in the decompressed stream to the frame containing it
+	// and the offset of that frame in the compressed stream, e.g. to find the bytes of the
+	// file a range of the data depends on.  It fails with ErrOffsetOutOfRange for offsets
+	// outside of [0, Size()).  This method is goroutine-safe.
+	CompressedOffset(off int64) (frame int64, compOff int64, err error)
+
+	// DecompressedOffset is the inverse of CompressedOffset: it maps the offset in the compressed
+	// stream to the frame containing it and the offset of that frame's data in the decompressed
+	// stream, e.g. to find the data affected by a corrupted range of the file.  It fails with
+	// ErrOffsetOutOfRange for offsets that are not within a frame, e.g. within the seek table.
+	// This method is goroutine-safe.
+	DecompressedOffset(compOff int64) (frame int64, off int64, err error)
+
+	// DecodeFrame decompresses the frame with the given index appending its data to dst,
+	// e.g. for records whose frame indexes are stored elsewhere.  The frame is verified against
+	// its checksum, but bypasses the frame cache.  Frames without data append nothing.
+	// This method is goroutine-safe ONLY if the underlying reader supports io.ReaderAt interface.
+	DecodeFrame(id int64, dst []byte) ([]byte, error)
+
+	// FrameBytes returns the decompressed data of the frame with the given index without copying it,
+	// e.g. straight from the frame cache, for consumers that parse frames in place.  The data must
+	// not be modified and must not be used after release is called, which must be done once
+	// the data is no longer needed.  Under WithReaderMemoryLimit the frame counts against
+	// the limit until then.  Frames without data return nil.
+	// This method is goroutine-safe ONLY if the underlying reader supports io.ReaderAt interface.
+	FrameBytes(id int64) (data []byte, release func(), err error)
+
+	// CacheStats returns statistics of the decompressed frame cache set by WithFrameCache,
+	// or zero statistics if there is no cache.  This method is goroutine-safe.
+	CacheStats() FrameCacheStats
+
+	// CompressedCacheStats returns statistics of the compressed frame cache set by
+	// WithCompressedFrameCache, or zero statistics if there is no cache.  This method is goroutine-safe.
+	CompressedCacheStats() FrameCacheStats
+
+	// DictionaryID returns the ID of the zstd dictionary that the stream is compressed with
+	// as recorded by Writer's WithDictionary, or zero if none is recorded.  See WithDictionaries.
+	// This method is goroutine-safe.
+	DictionaryID() (uint32, error)
+
+	// Validate checks the seek table for internal consistency and returns the report of all
+	// problems found instead of failing on the first read of a bad frame: offsets must follow
+	// each other, the number of frames must match the footer and, if the underlying environment
+	// knows the stream size, the frames and the seek table must add up to it.
+	// The error is only returned if the check could not be done.  This method is goroutine-safe.
+	Validate() (SeekTableReport, error)
+
+	// VerifyAll scrubs the whole stream: it checks the seek table as Validate does, reads every
+	// frame from the underlying environment, bypassing the caches, and checks its header against
+	// the seek table and, with opts.Decode, decompresses it and recomputes its checksum.
+	// It returns the report of all problems found, the error is only returned if the
+	// verification could not be done, e.g. ctx is done.  This method is goroutine-safe ONLY
+	// if the underlying reader supports io.ReaderAt interface.
+	VerifyAll(ctx context.Context, opts VerifyOptions) (VerifyReport, error)
+
+	// Stats returns the cumulative statistics of the frames read and decompressed by the reader.
+	Stats() ReaderStats
+
+	// Advise hints the expected access pattern of length bytes of the decompressed stream
+	// starting at offset, similar to posix_fadvise, to tune prefetching and the retention
+	// of decompressed frames, see AccessHint.  Zero length means the rest of the stream.
+	// The prefetching hints apply to the frames starting in the range, later hints replace earlier ones.
+	// AccessWillNeed decompresses the frames in the background and Close waits for it.
+	// This method is goroutine-safe.
+	Advise(offset, length int64, hint AccessHint) error
+
+	// Reset makes the reader read rs as if it was created by NewReader with the same decoder and
+	// options, reusing the reader and its frame cache, so that services opening many streams can
+	// pool readers.  The seek table is read from rs even if WithSeekTableFrom or WithSeekTableBytes
+	// was used.  The statistics are reset, but the ones of the frame cache are kept.
+	// Readers with a custom environment set by WithREnvironment, and readers with clones, can not
+	// be reset.  If Reset fails, the reader is closed.  This method is NOT goroutine-safe.
+	Reset(rs io.ReadSeeker) error
+
+	// Refresh reads the seek table of a stream that is still being written again, so that
+	// the frames appended since the reader was opened or last refreshed become accessible.
+	// The seek table is read from the end of the stream or, while a frame is being written,
+	// from the latest checkpoint written by Writer's Flush.  It returns whether the data grew.
+	// See WithFollow for reading such streams like tail -f.  This method is goroutine-safe:
+	// concurrent reads see either the old or the new seek table.
+	Refresh() (grown bool, err error)
+
+	// ReadRanges returns the data of each of the ranges, e.g. the columns picked by a query.
+	// Each frame overlapping the ranges is read and decompressed once, adjacent frames are read
+	// from the environment at once, and as many of them as set by WithReadConcurrency are
+	// decompressed concurrently.  Ranges must be within the stream, and may overlap.
+	// This method is goroutine-safe ONLY if the underlying reader supports io.ReaderAt interface.
+	ReadRanges(ranges []Range) ([][]byte, error)
+
+	// Clone returns a new reader over the same stream that shares the parsed seek table,
+	// the frame cache and the memory budget with this one, but has its own offset, last read
+	// frame, prefetched frames and statistics, so that e.g. a server can give each request
+	// its own sequential reader without parsing the seek table again.  The clone uses decoder,
+	// or the decoders of this reader if it is nil.
+	//
+	// Clones are independent of each other and of the reader: closing one does not affect
+	// the others.  Clones may be used concurrently if the underlying reader implements
+	// io.ReaderAt or the environment set by WithREnvironment is goroutine-safe.
+	// Clone must not be called concurrently with Close.
+	Clone(decoder ZSTDDecoder) (Reader, error)
+
 	// Close implements io.Closer interface free up any resources.
 	Close() error
 }
 
+// SkippableFrame is a skippable frame of the stream, see Reader's SkippableFrames.
+type SkippableFrame struct {
+	// ID is the index of the frame in the seek table.
+	ID int64
+	// Tag is the lower 4 bits of the `Skippable_Magic_Number` 0x184D2A5?.
+	Tag uint32
+	// Offset is the offset of the frame in the compressed stream.
+	Offset int64
+	// Payload is the user data of the frame.
+	Payload []byte
+}
+
+// FrameInfo describes a frame of the stream as recorded in the seek table.
+type FrameInfo struct {
+	// ID is the index of the frame in the seek table.
+	ID int64
+	// CompressedOffset and CompressedSize locate the frame in the compressed stream.
+	CompressedOffset int64
+	CompressedSize   int64
+	// DecompressedOffset and DecompressedSize locate the data of the frame in the decompressed
+	// stream.  DecompressedSize is zero for frames without data, e.g. skippable frames.
+	DecompressedOffset int64
+	DecompressedSize   int64
+	// Checksum is the lower 32 bits of the XXH64 hash of the data.  It is only set if HasChecksum is.
+	Checksum    uint32
+	HasChecksum bool
+}
+
 // ZSTDDecoder is the decompressor.  Tested with github.com/klauspost/compress/zstd.
 type ZSTDDecoder interface {
 	DecodeAll(input, dst []byte) ([]byte, error)
 }
 
+// ZSTDDictDecoder can be optionally implemented by the ZSTDDecoder that takes the dictionary
+// on each call, e.g. cgo bindings.  It is used for frames compressed with one of
+// the dictionaries set by WithDictionaries.
+type ZSTDDictDecoder interface {
+	DecodeAllDict(input, dst, dict []byte) ([]byte, error)
+}
+
+// ZSTDIntoDecoder can be optionally implemented by the ZSTDDecoder that decompresses into
+// a buffer of a fixed size instead of appending to it, e.g. cgo bindings.  dst is exactly
+// the decompressed size recorded in the seek table and is either the caller's buffer, e.g.
+// the one passed to DecodeFrame, or the reused one, so decompression does not allocate.
+// DecodeAllInto returns the number of bytes written and must fail if the frame does not fit.
+type ZSTDIntoDecoder interface {
+	DecodeAllInto(dst, src []byte) (int, error)
+}
+
 // NewReader returns ZSTD stream reader that can be randomly accessed using uncompressed data offset.
 // Ideally, passed io.ReadSeeker should implement io.ReaderAt interface.
+//
+// Decoder can be nil if WithDecoderFactory is used.
+//
+// If the stream does not end with a valid seek table (e.g. the writer crashed), the reader
+// falls back to the latest checkpoint written by Writer's Flush.  The data after that
+// checkpoint is not accessible.
+//
+// The seek table can also be loaded from a separate source, see WithSeekTableFrom and WithSeekTableBytes.
 func NewReader(rs io.ReadSeeker, decoder ZSTDDecoder, opts ...rOption) (Reader, error) {
 	sr := readerImpl{
-		dec: decoder,
+		dec:  decoder,
+		ctx:  context.Background(),
+		refs: atomic.NewInt64(1),
+
+		verifyChecksums: true,
+		bucketIndex:     true,
 	}
 
 	sr.logger = zap.NewNop()
@@ -160,41 +540,169 @@ func NewReader(rs io.ReadSeeker, decoder ZSTDDecoder, opts ...rOption) (Reader,
 		}
 	}
 
-	if sr.env == nil {
-		sr.env = &readSeekerEnvImpl{
-			rs: rs,
+	if sr.dec == nil && sr.decoders == nil {
+		return nil, fmt.Errorf("decoder is nil")
+	}
+	if sr.requireChecksums && !sr.verifyChecksums {
+		return nil, fmt.Errorf("checksums can not be required with checksum verification disabled")
+	}
+
+	for _, c := range []*frameCache{sr.cache, sr.compCache} {
+		if c != nil && sr.newCachePolicy != nil {
+			c.policy = sr.newCachePolicy(c.maxBytes)
 		}
 	}
 
-	tree, last, err := sr.indexFooter()
-	if err != nil {
+	sr.concurrency = readerConcurrency{prefetch: sr.prefetch, read: sr.readConcurrency, writeTo: sr.writeToConcurrency}
+	if sr.env == nil {
+		sr.env = &readSeekerEnvImpl{}
+	}
+	if err := sr.open(rs); err != nil {
+		_ = sr.Close()
 		return nil, err
 	}
+	return &sr, nil
+}
 
-	sr.index = tree
-	if last != nil {
-		sr.endOffset = int64(last.DecompOffset) + int64(last.DecompSize)
-		sr.numFrames = last.ID + 1
-	} else {
-		sr.endOffset = 0
-		sr.numFrames = 0
+// open indexes the stream read by rs, or the environment set by WithREnvironment if rs is nil.
+func (r *readerImpl) open(rs io.ReadSeeker) error {
+	r.prefetch = r.concurrency.prefetch
+	r.readConcurrency = r.concurrency.read
+	r.writeToConcurrency = r.concurrency.writeTo
+	r.coalescer = nil
+	if r.coalesceBytes > 0 {
+		r.coalescer = &readCoalescer{window: r.coalesceWindow, maxBytes: r.coalesceBytes, read: r.readEnv}
+	}
+	if e, ok := r.env.(*readSeekerEnvImpl); ok {
+		e.rs = rs
+		if _, ok := rs.(io.ReaderAt); !ok {
+			// Frames can not be read concurrently with Seek and Read.
+			r.prefetch = 0
+			r.readConcurrency = 0
+			r.writeToConcurrency = 0
+			r.coalescer = nil
+		}
 	}
 
-	return &sr, nil
+	var idx *seekTableIndex
+	var err error
+	if r.seekTable != nil {
+		idx, err = r.indexSeekTable(r.seekTable)
+		if err != nil {
+			return fmt.Errorf("failed to parse seek table: %w", err)
+		}
+		r.seekTable = nil
+	} else if idx, err = r.indexFooter(); errors.Is(err, errMissingFooter) {
+		// Corrupt seek tables fail, while streams without the footer, e.g. the ones that were
+		// not closed, have the seek table at the head or in checkpoints.
+		var headErr, cpErr error
+		if idx, headErr = r.indexHead(); headErr != nil {
+			idx, cpErr = r.indexCheckpoint()
+			if cpErr != nil {
+				return err
+			}
+			r.logger.Warn("seek table is missing, using the latest checkpoint", zap.Error(err))
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if r.requireChecksums && !idx.checksums {
+		return ErrNoChecksums
+	}
+
+	r.table.Store(idx)
+	r.prefetcher = nil
+	if r.prefetch > 0 {
+		r.prefetcher = newPrefetcher(r.prefetch)
+	}
+	if r.memoryLimit > 0 {
+		r.memory = newReaderMemory(r.memoryLimit - idx.indexMemory)
+		if r.cache != nil {
+			// The cache outlives the reader if it is shared with clones.
+			r.memory.addReclaimers(r.cache, nil, r.cache.evictVictim)
+		}
+		if r.compCache != nil {
+			r.memory.addReclaimers(r.compCache, nil, r.compCache.evictVictim)
+		}
+		r.addReclaimers()
+	}
+
+	if r.verifyDigest {
+		if err := r.verifyContentDigestOnOpen(); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := r.env.(*decoderEnv); r.dicts != nil && !ok {
+		// Fail early instead of on the first read.
+		id, err := r.DictionaryID()
+		if err != nil {
+			return err
+		}
+		if _, ok := r.dicts[id]; id != 0 && !ok {
+			return fmt.Errorf("%w: stream is compressed with dictionary %d", ErrDictionaryNotFound, id)
+		}
+	}
+
+	return nil
+}
+
+// NewReaderAt is similar to NewReader but reads the stream of the given size from ra,
+// e.g. a memory-mapped file, an object storage client or a part of a bigger file,
+// without the need for io.Seeker.  Reading frames with ReadAt makes reads goroutine-safe.
+func NewReaderAt(ra io.ReaderAt, size int64, decoder ZSTDDecoder, opts ...rOption) (Reader, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("stream size must not be negative: %d", size)
+	}
+	return NewReader(io.NewSectionReader(ra, 0, size), decoder, opts...)
 }
 
 func (r *readerImpl) ReadAt(p []byte, off int64) (n int, err error) {
+	return r.ReadAtContext(r.ctx, p, off)
+}
+
+func (r *readerImpl) ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if r.readConcurrency > 1 {
+		return r.readSpan(ctx, p, off)
+	}
+
 	for m := 0; n < len(p) && err == nil; n += m {
-		_, m, err = r.read(p[n:], off+int64(n))
+		_, m, err = r.read(ctx, p[n:], off+int64(n))
 	}
 	return
 }
 
 func (r *readerImpl) Read(p []byte) (n int, err error) {
-	offset, n, err := r.read(p, r.offset)
+	for {
+		n, err = r.readOffset(p)
+		if r.followInterval <= 0 || n > 0 || len(p) == 0 || !errors.Is(err, io.EOF) {
+			return
+		}
+		if err := r.follow(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readOffset reads the data at the current offset.
+func (r *readerImpl) readOffset(p []byte) (n int, err error) {
+	if r.readConcurrency > 1 {
+		n, err = r.readSpan(r.ctx, p, r.offset)
+		r.offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			if n > 0 {
+				return n, nil
+			}
+			r.offset = r.idx().endOffset
+		}
+		return
+	}
+
+	offset, n, err := r.read(r.ctx, p, r.offset)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
-			r.offset = r.endOffset
+			r.offset = r.idx().endOffset
 		}
 		return
 	}
@@ -204,210 +712,1493 @@ func (r *readerImpl) Read(p []byte) (n int, err error) {
 
 func (r *readerImpl) Close() error {
 	if r.closed.CompareAndSwap(false, true) {
-		r.cachedFrame.replace(math.MaxUint64, nil)
-		r.index = nil
+		r.release()
+		if r.refs.Dec() == 0 {
+			r.releaseCache()
+		}
+		// Drop the index, keeping the sizes.
+		if idx := r.idx(); idx != nil {
+			closed := *idx
+			closed.index = nil
+			r.table.Store(&closed)
+		}
 	}
 	return nil
 }
 
-func (r *readerImpl) read(dst []byte, off int64) (int64, int, error) {
+// release drops the frames retained by the reader itself and waits for the background decompression.
+func (r *readerImpl) release() {
+	r.memory.removeReclaimers(r)
+	r.advised.Wait()
+	if r.prefetcher != nil {
+		r.memory.release(r.prefetcher.wait())
+	}
+	r.memory.release(r.cachedFrame.reclaim())
+	r.streams.put(nil)
+}
+
+// releaseCache drops the frames of the frame caches once no clone uses them.
+func (r *readerImpl) releaseCache() {
+	for _, c := range []*frameCache{r.cache, r.compCache} {
+		if c != nil {
+			r.memory.removeReclaimers(c)
+			r.memory.release(c.clear())
+		}
+	}
+}
+
+func (r *readerImpl) Reset(rs io.ReadSeeker) error {
 	if r.closed.Load() {
-		return 0, 0, fmt.Errorf("reader is closed")
+		return fmt.Errorf("reader is closed")
+	}
+	if _, ok := r.env.(*readSeekerEnvImpl); !ok {
+		return fmt.Errorf("reader with a custom environment can not be reset")
+	}
+	if r.refs.Load() > 1 {
+		return fmt.Errorf("reader with clones can not be reset")
 	}
 
-	if off >= r.endOffset {
-		return 0, 0, io.EOF
+	r.release()
+	r.releaseCache()
+	r.offset = 0
+	r.stats = readerStats{}
+	r.hints = accessHints{}
+	r.dictOnce, r.dictID, r.dictErr = sync.Once{}, 0, nil
+	r.tagsOnce, r.tags, r.tagsErr = sync.Once{}, nil, nil
+	r.sectionsOnce, r.sections, r.sectionsErr = sync.Once{}, nil, nil
+
+	if err := r.open(rs); err != nil {
+		_ = r.Close()
+		return err
 	}
-	if off < 0 {
-		return 0, 0, fmt.Errorf("offset before the start of the file: %d", off)
+	return nil
+}
+
+func (r *readerImpl) Refresh() (bool, error) {
+	if r.closed.Load() {
+		return false, fmt.Errorf("reader is closed")
 	}
 
-	index := r.GetIndexByDecompOffset(uint64(off))
-	if index == nil {
-		return 0, 0, fmt.Errorf("failed to get index by offset: %d", off)
+	idx, err := r.indexFooter()
+	if errors.Is(err, errMissingFooter) {
+		var cpErr error
+		if idx, cpErr = r.indexCheckpoint(); cpErr != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
 	}
-	if off < int64(index.DecompOffset) || off > int64(index.DecompOffset)+int64(index.DecompSize) {
-		return 0, 0, fmt.Errorf("offset outside of index bounds: %d: min: %d, max: %d",
-			off, int64(index.DecompOffset), int64(index.DecompOffset)+int64(index.DecompSize))
+	// Concurrent refreshes only replace the index with a longer one.
+	for old := r.idx(); idx.endOffset > old.endOffset; old = r.idx() {
+		if !r.table.CompareAndSwap(old, idx) {
+			continue
+		}
+		if r.memoryLimit > 0 {
+			r.memory.setLimit(r.memoryLimit - idx.indexMemory)
+		}
+		r.logger.Debug("refreshed", zap.Int64("frames", idx.numFrames), zap.Int64("endOffset", idx.endOffset))
+		return true, nil
 	}
+	return false, nil
+}
 
-	var decompressed []byte
+// idx returns the current index of the stream.
+func (r *readerImpl) idx() *seekTableIndex {
+	return r.table.Load()
+}
 
-	cachedOffset, cachedData := r.cachedFrame.get()
-	if cachedOffset == index.DecompOffset && cachedData != nil {
-		// fastpath
-		decompressed = cachedData
-	} else {
-		// slowpath
-		if index.CompSize > maxDecoderFrameSize {
-			return 0, 0, fmt.Errorf("index.CompSize is too big: %d > %d",
-				index.CompSize, maxDecoderFrameSize)
+// follow waits until the stream grows past the end of the data, see WithFollow.
+// Failures to read the seek table are retried, as they are expected while a frame is being written.
+func (r *readerImpl) follow() error {
+	t := time.NewTicker(r.followInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-t.C:
 		}
-
-		src, err := r.env.GetFrameByIndex(*index)
+		if r.closed.Load() {
+			return fmt.Errorf("reader is closed")
+		}
+		grown, err := r.Refresh()
 		if err != nil {
-			return 0, 0, fmt.Errorf("failed to read compressed data at: %d, %w", index.CompOffset, err)
+			r.logger.Debug("failed to refresh", zap.Error(err))
+			continue
 		}
-
-		if len(src) != int(index.CompSize) {
-			return 0, 0, fmt.Errorf("compressed size does not match index at: %d: expected: %d, index: %+v",
-				off, len(src), index)
+		if grown {
+			return nil
 		}
+	}
+}
 
-		decompressed, err = r.dec.DecodeAll(src, nil)
-		if err != nil {
-			return 0, 0, fmt.Errorf("failed to decompress data data at: %d, %w", index.CompOffset, err)
-		}
+// addReclaimers registers the frames retained by the reader itself in the memory budget.
+func (r *readerImpl) addReclaimers() {
+	var prefetched, retained func() int64
+	if r.prefetcher != nil {
+		prefetched = r.prefetcher.reclaim
+	}
+	if r.cache == nil {
+		retained = r.cachedFrame.reclaim
+	}
+	r.memory.addReclaimers(r, prefetched, retained)
+}
 
-		if r.checksums {
-			checksum := uint32((xxhash.Sum64(decompressed) << 32) >> 32)
-			if index.Checksum != checksum {
-				return 0, 0, fmt.Errorf("checksum verification failed at: %d: expected: %d, actual: %d",
-					index.CompOffset, index.Checksum, checksum)
-			}
-		}
-		r.cachedFrame.replace(index.DecompOffset, decompressed)
+func (r *readerImpl) Clone(decoder ZSTDDecoder) (Reader, error) {
+	if r.closed.Load() {
+		return nil, fmt.Errorf("reader is closed")
 	}
+	c := &readerImpl{
+		dec: decoder,
 
-	if len(decompressed) != int(index.DecompSize) {
-		return 0, 0, fmt.Errorf("index corruption: len: %d, expected: %d", len(decompressed), int(index.DecompSize))
+		lazySeekTable:    r.lazySeekTable,
+		bucketIndex:      r.bucketIndex,
+		maxSeekTableSize: r.maxSeekTableSize,
+		maxFrameSize:     r.maxFrameSize,
+
+		memoryLimit: r.memoryLimit,
+		memory:      r.memory,
+
+		verifyChecksums:  r.verifyChecksums,
+		requireChecksums: r.requireChecksums,
+		strictFormat:     r.strictFormat,
+		followInterval:   r.followInterval,
+
+		logger:  r.logger,
+		env:     r.env,
+		tracer:  r.tracer,
+		buffers: r.buffers,
+
+		coalesceWindow: r.coalesceWindow,
+		coalesceBytes:  r.coalesceBytes,
+		coalescer:      r.coalescer,
+
+		cache:     r.cache,
+		compCache: r.compCache,
+		refs:      r.refs,
+
+		concurrency:        r.concurrency,
+		prefetch:           r.prefetch,
+		readConcurrency:    r.readConcurrency,
+		ctx:                r.ctx,
+		writeToConcurrency: r.writeToConcurrency,
+
+		streamThreshold: r.streamThreshold,
+		newStreamReader: r.newStreamReader,
+
+		verifyDigest:   r.verifyDigest,
+		onCorruptFrame: r.onCorruptFrame,
+		dicts:          r.dicts,
+	}
+	c.table.Store(r.idx())
+	if decoder == nil {
+		c.dec, c.decoders = r.dec, r.decoders
 	}
+	if r.prefetcher != nil {
+		c.prefetcher = newPrefetcher(r.prefetch)
+	}
+	c.addReclaimers()
+	c.refs.Inc()
+	return c, nil
+}
 
-	offsetWithinFrame := uint64(off) - index.DecompOffset
+func (r *readerImpl) Frame(id int64) (FrameInfo, error) {
+	if r.closed.Load() {
+		return FrameInfo{}, fmt.Errorf("reader is closed")
+	}
 
-	size := uint64(len(decompressed)) - offsetWithinFrame
-	if size > uint64(len(dst)) {
-		size = uint64(len(dst))
+	index := r.GetIndexByID(id)
+	if index == nil {
+		return FrameInfo{}, fmt.Errorf("%w: frame %d, number of frames: %d", ErrOffsetOutOfRange, id, r.idx().numFrames)
 	}
+	return r.frameInfo(index), nil
+}
 
-	r.logger.Debug("decompressed", zap.Uint64("offsetWithinFrame", offsetWithinFrame), zap.Uint64("end", offsetWithinFrame+size),
-		zap.Uint64("size", size), zap.Int("lenDecompressed", len(decompressed)), zap.Int("lenDst", len(dst)), zap.Object("index", index))
-	copy(dst, decompressed[offsetWithinFrame:offsetWithinFrame+size])
+func (r *readerImpl) CompressedOffset(off int64) (int64, int64, error) {
+	if r.closed.Load() {
+		return 0, 0, fmt.Errorf("reader is closed")
+	}
 
-	return off + int64(size), int(size), nil
+	idx := r.idx()
+	if off < 0 || off >= idx.endOffset {
+		return 0, 0, fmt.Errorf("%w: offset: %d, size: %d", ErrOffsetOutOfRange, off, idx.endOffset)
+	}
+	index := idx.index.byOffset(uint64(off))
+	return index.ID, int64(index.CompOffset), nil
 }
 
-func (r *readerImpl) Seek(offset int64, whence int) (int64, error) {
-	newOffset := r.offset
-	switch whence {
-	case io.SeekCurrent:
-		newOffset += offset
-	case io.SeekStart:
-		newOffset = offset
-	case io.SeekEnd:
-		newOffset = r.endOffset + offset
-	default:
-		return 0, fmt.Errorf("unknown whence: %d", whence)
+func (r *readerImpl) DecompressedOffset(compOff int64) (int64, int64, error) {
+	if r.closed.Load() {
+		return 0, 0, fmt.Errorf("reader is closed")
 	}
 
-	if newOffset < 0 {
-		return 0, fmt.Errorf("offset before the start of the file: %d (%d + %d)",
-			newOffset, r.offset, offset)
+	// Frames are laid out in the order of their IDs, the first one ending after compOff
+	// contains it unless compOff is in a gap between frames, e.g. in a skippable frame.
+	if compOff >= 0 {
+		idx := r.idx()
+		n := idx.index.Len()
+		id := sort.Search(n, func(i int) bool {
+			index := idx.index.byID(int64(i))
+			return index.CompOffset+uint64(index.CompSize) > uint64(compOff)
+		})
+		if id < n {
+			if index := idx.index.byID(int64(id)); index.CompOffset <= uint64(compOff) {
+				return index.ID, int64(index.DecompOffset), nil
+			}
+		}
 	}
+	return 0, 0, fmt.Errorf("%w: compressed offset %d is not within a frame", ErrOffsetOutOfRange, compOff)
+}
 
-	r.offset = newOffset
-	return r.offset, nil
+func (r *readerImpl) frameInfo(index *env.FrameOffsetEntry) FrameInfo {
+	return FrameInfo{
+		ID:                 index.ID,
+		CompressedOffset:   int64(index.CompOffset),
+		CompressedSize:     int64(index.CompSize),
+		DecompressedOffset: int64(index.DecompOffset),
+		DecompressedSize:   int64(index.DecompSize),
+		Checksum:           index.Checksum,
+		HasChecksum:        r.idx().checksums,
+	}
 }
 
-func (r *readerImpl) indexFooter() (*btree.BTreeG[*env.FrameOffsetEntry], *env.FrameOffsetEntry, error) {
-	// read seekTableFooter
-	buf, err := r.env.ReadFooter()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read footer: %w", err)
+func (r *readerImpl) DecodeFrame(id int64, dst []byte) ([]byte, error) {
+	if r.closed.Load() {
+		return nil, fmt.Errorf("reader is closed")
 	}
-	if len(buf) < seekTableFooterOffset {
-		return nil, nil, fmt.Errorf("footer is too small: %d", len(buf))
+
+	index := r.GetIndexByID(id)
+	if index == nil {
+		return nil, fmt.Errorf("%w: frame %d, number of frames: %d", ErrOffsetOutOfRange, id, r.idx().numFrames)
+	}
+	if index.DecompSize == 0 {
+		return dst, nil
 	}
 
-	// parse seekTableFooter
-	footer := seekTableFooter{}
-	err = footer.UnmarshalBinary(buf[len(buf)-seekTableFooterOffset:])
+	out, err := r.decodeReserved(r.ctx, index, dst)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse footer %+v: %w", buf, err)
+		return nil, err
 	}
-	r.logger.Debug("loaded", zap.Object("footer", &footer))
-
-	r.checksums = footer.SeekTableDescriptor.ChecksumFlag
+	// The frame is owned by the caller from now on.
+	r.memory.release(int64(index.DecompSize))
+	return out, nil
+}
 
-	// read SeekTableEntries
-	seekTableEntrySize := int64(8)
-	if footer.SeekTableDescriptor.ChecksumFlag {
-		seekTableEntrySize += 4
+func (r *readerImpl) FrameBytes(id int64) ([]byte, func(), error) {
+	if r.closed.Load() {
+		return nil, nil, fmt.Errorf("reader is closed")
 	}
 
-	skippableFrameOffset := seekTableFooterOffset + seekTableEntrySize*int64(footer.NumberOfFrames)
-	skippableFrameOffset += frameSizeFieldSize
-	skippableFrameOffset += skippableMagicNumberFieldSize
+	index := r.GetIndexByID(id)
+	if index == nil {
+		return nil, nil, fmt.Errorf("%w: frame %d, number of frames: %d", ErrOffsetOutOfRange, id, r.idx().numFrames)
+	}
+	if index.DecompSize == 0 {
+		return nil, func() {}, nil
+	}
 
-	if skippableFrameOffset > maxDecoderFrameSize {
-		return nil, nil, fmt.Errorf("frame offset is too big: %d > %d",
-			skippableFrameOffset, maxDecoderFrameSize)
+	if r.cache != nil {
+		// The frame is shared with the cache, which accounts for its memory.
+		data, err := r.frame(r.ctx, index)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, func() {}, nil
 	}
 
-	buf, err = r.env.ReadSkipFrame(skippableFrameOffset)
+	data, err := r.frameData(r.ctx, index, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read footer: %w", err)
+		return nil, nil, err
 	}
+	var once sync.Once
+	return data, func() { once.Do(func() { r.memory.release(int64(index.DecompSize)) }) }, nil
+}
 
-	if len(buf) < frameSizeFieldSize+skippableMagicNumberFieldSize+seekTableFooterOffset {
-		return nil, nil, fmt.Errorf("skip frame is too small: %d", len(buf))
+func (r *readerImpl) CacheStats() FrameCacheStats {
+	if r.cache == nil {
+		return FrameCacheStats{}
 	}
+	return r.cache.getStats()
+}
 
-	// parse SeekTableEntries
-	magic := binary.LittleEndian.Uint32(buf[0:4])
-	if magic != skippableFrameMagic+seekableTag {
-		return nil, nil, fmt.Errorf("skippable frame magic mismatch %d vs %d",
-			magic, skippableFrameMagic+seekableTag)
+func (r *readerImpl) CompressedCacheStats() FrameCacheStats {
+	if r.compCache == nil {
+		return FrameCacheStats{}
 	}
+	return r.compCache.getStats()
+}
 
-	expectedFrameSize := int64(len(buf)) - frameSizeFieldSize - skippableMagicNumberFieldSize
-	frameSize := int64(binary.LittleEndian.Uint32(buf[4:8]))
-	if frameSize != expectedFrameSize {
-		return nil, nil, fmt.Errorf("skippable frame size mismatch: expected: %d, actual: %d",
-			expectedFrameSize, frameSize)
+func (r *readerImpl) Stats() ReaderStats {
+	return r.stats.get()
+}
+
+func (r *readerImpl) Section(off, n int64) *io.SectionReader {
+	return io.NewSectionReader(r, off, n)
+}
+
+func (r *readerImpl) DictionaryID() (uint32, error) {
+	if r.closed.Load() {
+		return 0, fmt.Errorf("reader is closed")
 	}
 
-	if frameSize > maxDecoderFrameSize {
-		return nil, nil, fmt.Errorf("frame is too big: %d > %d", frameSize, maxDecoderFrameSize)
+	r.dictOnce.Do(func() {
+		r.dictID, r.dictErr = r.loadDictionaryID()
+	})
+	return r.dictID, r.dictErr
+}
+
+// loadDictionaryID reads the dictionary extension frame, which Writer puts
+// before the first data frame.
+func (r *readerImpl) loadDictionaryID() (uint32, error) {
+	if _, ok := r.env.(*decoderEnv); ok || r.env == nil {
+		// Decoder has the seek table only.
+		return 0, nil
 	}
 
-	return r.indexSeekTableEntries(buf[8:len(buf)-seekTableFooterOffset], uint64(seekTableEntrySize))
+	idx := r.idx()
+	for id := int64(0); id < idx.numFrames; id++ {
+		index := r.GetIndexByID(id)
+		if index == nil || index.DecompSize != 0 {
+			break
+		}
+		payload, err := r.extension(index, extensionDictionary)
+		if err != nil {
+			return 0, err
+		}
+		if payload != nil {
+			if len(payload) != 4 {
+				return 0, fmt.Errorf("%w: dictionary extension size: %d", ErrCorruptSeekTable, len(payload))
+			}
+			return binary.LittleEndian.Uint32(payload), nil
+		}
+	}
+	return 0, nil
 }
 
-func (r *readerImpl) indexSeekTableEntries(p []byte, entrySize uint64) (
-	*btree.BTreeG[*env.FrameOffsetEntry], *env.FrameOffsetEntry, error,
-) {
-	if uint64(len(p))%entrySize != 0 {
-		return nil, nil, fmt.Errorf("seek table size is not multiple of %d", entrySize)
+func (r *readerImpl) SkippableFrames(fn func(frame SkippableFrame) error) error {
+	if r.closed.Load() {
+		return fmt.Errorf("reader is closed")
+	}
+	if _, ok := r.env.(*decoderEnv); ok || r.env == nil {
+		return fmt.Errorf("skippable frames can not be read without the stream")
 	}
 
-	// TODO: make fan-out tunable?
-	t := btree.NewG(8, env.Less)
-	entry := seekTableEntry{}
-	var compOffset, decompOffset uint64
+	first := r.GetIndexByID(0)
+	if first == nil {
+		return nil
+	}
 
-	var last *env.FrameOffsetEntry
-	var i int64
-	for indexOffset := uint64(0); indexOffset < uint64(len(p)); indexOffset += entrySize {
-		err := entry.UnmarshalBinary(p[indexOffset : indexOffset+entrySize])
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse entry %+v at: %d: %w",
-				p[indexOffset:indexOffset+entrySize], indexOffset, err)
+	var err error
+	r.idx().index.ascend(first, func(index *env.FrameOffsetEntry) bool {
+		if index.DecompSize != 0 || index.CompSize < frameSizeFieldSize+skippableMagicNumberFieldSize {
+			return true
 		}
 
-		last = &env.FrameOffsetEntry{
-			ID:           i,
-			CompOffset:   compOffset,
-			DecompOffset: decompOffset,
-			CompSize:     entry.CompressedSize,
-			DecompSize:   entry.DecompressedSize,
-			Checksum:     entry.Checksum,
+		var src []byte
+		if src, err = r.readFrame(r.ctx, index); err != nil {
+			return false
 		}
-		t.ReplaceOrInsert(last)
-		compOffset += uint64(entry.CompressedSize)
-		decompOffset += uint64(entry.DecompressedSize)
-		i++
+		magic := binary.LittleEndian.Uint32(src)
+		tag := magic - skippableFrameMagic
+		if magic&0xFFFFFFF0 != skippableFrameMagic || tag == seekableTag || tag == extensionTag {
+			// Empty zstd frame or a frame of the seekable format.
+			return true
+		}
+		if size := binary.LittleEndian.Uint32(src[4:]); int64(size) != int64(len(src))-frameSizeFieldSize-skippableMagicNumberFieldSize {
+			err = fmt.Errorf("%w: skippable frame size mismatch at: %d: expected: %d, actual: %d",
+				ErrCorruptSeekTable, index.CompOffset, len(src)-frameSizeFieldSize-skippableMagicNumberFieldSize, size)
+			return false
+		}
+
+		err = fn(SkippableFrame{
+			ID:      index.ID,
+			Tag:     tag,
+			Offset:  int64(index.CompOffset),
+			Payload: src[frameSizeFieldSize+skippableMagicNumberFieldSize:],
+		})
+		return err == nil
+	})
+	return err
+}
+
+func (r *readerImpl) FrameTag(id int64) ([]byte, error) {
+	if r.closed.Load() {
+		return nil, fmt.Errorf("reader is closed")
+	}
+
+	r.tagsOnce.Do(func() {
+		r.tags, r.tagsErr = r.loadFrameTags()
+	})
+	if r.tagsErr != nil {
+		return nil, r.tagsErr
 	}
+	return r.tags[id], nil
+}
+
+// loadFrameTags reads the frame tags extension frame, which Writer puts
+// right before the seek table.
+func (r *readerImpl) loadFrameTags() (map[int64][]byte, error) {
+	payload, err := r.trailingExtension(extensionFrameTags)
+	if err != nil || payload == nil {
+		return nil, err
+	}
+
+	tags, err := parseFrameTags(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse frame tags: %w", err)
+	}
+	return tags, nil
+}
 
-	return t, last, nil
+// trailingExtension returns the payload of the extension frame of type t among the frames
+// without data at the end of the stream, where Writer puts them on Close, or nil if there is none.
+func (r *readerImpl) trailingExtension(t extensionType) ([]byte, error) {
+	idx := r.idx()
+	for id := idx.numFrames - 1; id >= 0; id-- {
+		index := r.GetIndexByID(id)
+		if index == nil || index.DecompSize != 0 {
+			return nil, nil
+		}
+		payload, err := r.extension(index, t)
+		if err != nil || payload != nil {
+			return payload, err
+		}
+	}
+	return nil, nil
+}
+
+// extension returns the payload of the frame without data described by index
+// if it is the extension frame of type t, or nil otherwise.
+func (r *readerImpl) extension(index *env.FrameOffsetEntry, t extensionType) ([]byte, error) {
+	if index.CompSize > maxDecoderFrameSize {
+		return nil, nil
+	}
+
+	start := time.Now()
+	src, err := r.env.GetFrameByIndex(*index)
+	r.stats.read(len(src), time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extension frame at: %d, %w", index.CompOffset, err)
+	}
+
+	typ, payload, err := parseExtensionFrame(src)
+	if err != nil || typ != t {
+		// Not the extension we are looking for, e.g. user's skippable frame.
+		return nil, nil
+	}
+	return payload, nil
+}
+
+func (r *readerImpl) OpenSection(name string) (io.ReadSeeker, error) {
+	if r.closed.Load() {
+		return nil, fmt.Errorf("reader is closed")
+	}
+
+	r.sectionsOnce.Do(func() {
+		r.sections, r.sectionsErr = r.loadSections()
+	})
+	if r.sectionsErr != nil {
+		return nil, r.sectionsErr
+	}
+
+	sec, ok := r.sections[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrSectionNotFound, name)
+	}
+	idx := r.idx()
+	if sec.Offset+sec.Size > uint64(idx.endOffset) {
+		return nil, fmt.Errorf("%w: section %q ends at: %d, stream size: %d",
+			ErrOffsetOutOfRange, name, sec.Offset+sec.Size, idx.endOffset)
+	}
+	return r.Section(int64(sec.Offset), int64(sec.Size)), nil
+}
+
+// loadSections reads the sections extension frame, which Writer puts
+// right before the seek table.
+func (r *readerImpl) loadSections() (map[string]section, error) {
+	payload, err := r.trailingExtension(extensionSections)
+	if err != nil || payload == nil {
+		return nil, err
+	}
+
+	sections, err := parseSections(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sections: %w", err)
+	}
+	return sections, nil
+}
+
+// verifyContentDigestOnOpen verifies the content digest if the stream has one, see WithVerifyContentDigest.
+func (r *readerImpl) verifyContentDigestOnOpen() error {
+	if _, ok := r.env.(*decoderEnv); ok {
+		return fmt.Errorf("content digest can not be verified without the stream")
+	}
+
+	expected, err := r.trailingExtension(extensionContentDigest)
+	if err != nil || expected == nil {
+		return err
+	}
+	return r.verifyContentDigest(r.ctx, expected)
+}
+
+func (r *readerImpl) VerifyContentDigest(ctx context.Context) error {
+	if r.closed.Load() {
+		return fmt.Errorf("reader is closed")
+	}
+
+	expected, err := r.trailingExtension(extensionContentDigest)
+	if err != nil {
+		return err
+	}
+	if expected == nil {
+		return fmt.Errorf("stream has no content digest")
+	}
+	return r.verifyContentDigest(ctx, expected)
+}
+
+// verifyContentDigest decompresses the whole stream and compares its digest with expected.
+func (r *readerImpl) verifyContentDigest(ctx context.Context, expected []byte) error {
+	h := sha256.New()
+	var buf []byte
+	idx := r.idx()
+	for id := int64(0); id < idx.numFrames; id++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		index := r.GetIndexByID(id)
+		if index == nil {
+			return fmt.Errorf("%w: failed to get index by ID: %d", ErrCorruptSeekTable, id)
+		}
+		if index.DecompSize == 0 {
+			continue
+		}
+
+		buf = slices.Grow(buf[:0], int(index.DecompSize))[:index.DecompSize]
+		n, err := r.ReadAtContext(ctx, buf, int64(index.DecompOffset))
+		if err != nil && (n != len(buf) || !errors.Is(err, io.EOF)) {
+			return fmt.Errorf("failed to read frame %d: %w", id, err)
+		}
+		h.Write(buf)
+	}
+
+	if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("%w: %w: expected: %x, actual: %x", ErrChecksumMismatch, ErrDigestMismatch, expected, actual)
+	}
+	return nil
+}
+
+func (r *readerImpl) read(ctx context.Context, dst []byte, off int64) (int64, int, error) {
+	if r.closed.Load() {
+		return 0, 0, fmt.Errorf("reader is closed")
+	}
+
+	if off >= r.idx().endOffset {
+		return 0, 0, io.EOF
+	}
+	if off < 0 {
+		return 0, 0, fmt.Errorf("%w: offset before the start of the file: %d", ErrOffsetOutOfRange, off)
+	}
+
+	index := r.GetIndexByDecompOffset(uint64(off))
+	if index == nil {
+		return 0, 0, fmt.Errorf("%w: failed to get index by offset: %d", ErrOffsetOutOfRange, off)
+	}
+	if off < int64(index.DecompOffset) || off > int64(index.DecompOffset)+int64(index.DecompSize) {
+		return 0, 0, fmt.Errorf("%w: offset outside of index bounds: %d: min: %d, max: %d",
+			ErrOffsetOutOfRange, off, int64(index.DecompOffset), int64(index.DecompOffset)+int64(index.DecompSize))
+	}
+
+	offsetWithinFrame := uint64(off) - index.DecompOffset
+	if r.streamed(index) {
+		size := min(uint64(index.DecompSize)-offsetWithinFrame, uint64(len(dst)))
+		if err := r.readStream(ctx, index, offsetWithinFrame, dst[:size]); err != nil {
+			return 0, 0, err
+		}
+		return off + int64(size), int(size), nil
+	}
+
+	decompressed, err := r.frame(ctx, index)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := uint64(len(decompressed)) - offsetWithinFrame
+	if size > uint64(len(dst)) {
+		size = uint64(len(dst))
+	}
+
+	r.logger.Debug("decompressed", zap.Uint64("offsetWithinFrame", offsetWithinFrame), zap.Uint64("end", offsetWithinFrame+size),
+		zap.Uint64("size", size), zap.Int("lenDecompressed", len(decompressed)), zap.Int("lenDst", len(dst)), zap.Object("index", index))
+	copy(dst, decompressed[offsetWithinFrame:offsetWithinFrame+size])
+
+	return off + int64(size), int(size), nil
+}
+
+// frame returns the decompressed frame described by index from the cache, if possible.
+func (r *readerImpl) frame(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error) {
+	var decompressed []byte
+
+	if r.cache != nil {
+		decompressed = r.cache.get(index.DecompOffset)
+	} else if cachedOffset, cachedData := r.cachedFrame.get(); cachedOffset == index.DecompOffset {
+		decompressed = cachedData
+	}
+	if decompressed != nil {
+		r.stats.cacheHits.Add(1)
+	} else {
+		// slowpath
+		r.stats.cacheMisses.Add(1)
+		var err error
+		decompressed, err = r.frameData(ctx, index, nil)
+		if err != nil {
+			return nil, err
+		}
+		// The reserved memory passes to the cache, and is released for the frames it drops.
+		if r.cache != nil {
+			evicted, added := r.cache.add(index.DecompOffset, decompressed)
+			if !added {
+				evicted += int64(index.DecompSize)
+			}
+			r.memory.release(evicted)
+		} else {
+			old := r.cachedFrame.replace(index.DecompOffset, decompressed)
+			r.memory.release(int64(len(old)))
+		}
+	}
+
+	if len(decompressed) != int(index.DecompSize) {
+		return nil, fmt.Errorf("%w: index corruption: len: %d, expected: %d",
+			ErrCorruptSeekTable, len(decompressed), int(index.DecompSize))
+	}
+	return decompressed, nil
+}
+
+// readSpan fills dst with the data at off decompressing the frames it spans concurrently,
+// see WithReadConcurrency.  It returns io.EOF if dst goes beyond the end of the stream.
+func (r *readerImpl) readSpan(ctx context.Context, dst []byte, off int64) (int, error) {
+	if r.closed.Load() {
+		return 0, fmt.Errorf("reader is closed")
+	}
+	idx := r.idx()
+	if off >= idx.endOffset {
+		return 0, io.EOF
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("%w: offset before the start of the file: %d", ErrOffsetOutOfRange, off)
+	}
+
+	start := r.GetIndexByDecompOffset(uint64(off))
+	if start == nil {
+		return 0, fmt.Errorf("%w: failed to get index by offset: %d", ErrOffsetOutOfRange, off)
+	}
+
+	end := min(off+int64(len(dst)), idx.endOffset)
+	var frames []*env.FrameOffsetEntry
+	idx.index.ascend(start, func(index *env.FrameOffsetEntry) bool {
+		if int64(index.DecompOffset) >= end {
+			return false
+		}
+		if index.DecompSize != 0 {
+			frames = append(frames, index)
+		}
+		return true
+	})
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(r.readConcurrency)
+	for _, index := range frames {
+		g.Go(func() error {
+			// Frames are copied into the non-overlapping parts of dst.
+			from := max(off, int64(index.DecompOffset))
+			to := min(end, int64(index.DecompOffset)+int64(index.DecompSize))
+			if r.streamed(index) {
+				return r.readStream(ctx, index, uint64(from)-index.DecompOffset, dst[from-off:to-off])
+			}
+
+			decompressed, err := r.frame(ctx, index)
+			if err != nil {
+				return err
+			}
+			copy(dst[from-off:to-off], decompressed[uint64(from)-index.DecompOffset:])
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+
+	n := int(end - off)
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decodeReserved is decodeFrame that reserves the memory for the frame under WithReaderMemoryLimit.
+// On success the decompressed size of the frame stays reserved and must be released by the caller
+// once the reader no longer retains the frame.
+func (r *readerImpl) decodeReserved(ctx context.Context, index *env.FrameOffsetEntry, dst []byte) ([]byte, error) {
+	if err := r.memory.acquire(int64(index.CompSize) + int64(index.DecompSize)); err != nil {
+		return nil, err
+	}
+	out, err := r.decodeFrame(ctx, index, dst)
+	if err != nil {
+		r.memory.release(int64(index.CompSize) + int64(index.DecompSize))
+		return nil, err
+	}
+	r.memory.release(int64(index.CompSize))
+	return out, nil
+}
+
+// decodeFrame reads the frame described by index and decompresses it appending to dst.
+func (r *readerImpl) decodeFrame(ctx context.Context, index *env.FrameOffsetEntry, dst []byte) ([]byte, error) {
+	if index.CompSize > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: index.CompSize is too big: %d > %d",
+			ErrFrameTooLarge, index.CompSize, maxDecoderFrameSize)
+	}
+
+	src, put, err := r.readScratch(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	defer put()
+	return r.decodeData(ctx, index, src, dst)
+}
+
+// decodeData decompresses the compressed frame src described by index appending to dst.
+func (r *readerImpl) decodeData(
+	ctx context.Context, index *env.FrameOffsetEntry, src, dst []byte,
+) (decompressed []byte, err error) {
+	end := r.traceFrame(ctx, spanDecode, index)
+	defer func() { end(err) }()
+
+	var dict []byte
+	if r.maxFrameSize > 0 || r.dicts != nil {
+		h, err := parseZSTDFrameHeader(src)
+		// Refuse the frame before decoding it if its header declares the size.
+		if err == nil && r.maxFrameSize > 0 && h.HasContentSize && h.ContentSize > uint64(r.maxFrameSize) {
+			return nil, fmt.Errorf("%w: frame content size is too big at: %d: %d > %d",
+				ErrFrameTooLarge, index.CompOffset, h.ContentSize, r.maxFrameSize)
+		}
+		if err == nil {
+			if dict, err = r.frameDict(index, h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	decompressed, err = r.decompress(index, src, dst, dict)
+	if err != nil && r.onCorruptFrame != nil && !errors.Is(err, ErrFrameTooLarge) {
+		// The frame is replaced with zeroes, so that the offsets of the following data are kept.
+		r.onCorruptFrame(r.frameInfo(index), err)
+		return append(dst, make([]byte, index.DecompSize)...), nil
+	}
+	return decompressed, err
+}
+
+// frameDict returns the dictionary set by WithDictionaries that the frame with the header h
+// is compressed with, or nil if it is compressed without one.
+func (r *readerImpl) frameDict(index *env.FrameOffsetEntry, h zstdFrameHeader) ([]byte, error) {
+	if r.dicts == nil || h.DictionaryID == 0 {
+		return nil, nil
+	}
+	dict, ok := r.dicts[h.DictionaryID]
+	if !ok {
+		return nil, fmt.Errorf("%w: frame at: %d is compressed with dictionary %d",
+			ErrDictionaryNotFound, index.CompOffset, h.DictionaryID)
+	}
+	return dict, nil
+}
+
+// readFrame reads the compressed frame described by index from the compressed frame cache, if set,
+// or the environment.
+func (r *readerImpl) readFrame(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.compCache != nil {
+		if src := r.compCache.get(index.CompOffset); src != nil {
+			return src, nil
+		}
+	}
+
+	src, err := r.fetch(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compressed frames are only cached while they fit into the memory budget without
+	// reclaiming the decompressed ones.
+	if r.compCache != nil && len(src) > 0 && r.memory.tryAcquire(int64(len(src))) {
+		evicted, added := r.compCache.add(index.CompOffset, src)
+		if !added {
+			evicted += int64(len(src))
+		}
+		r.memory.release(evicted)
+	}
+	return src, nil
+}
+
+// readScratch is readFrame for the callers that do not retain the compressed frame,
+// which is then read into a buffer of the pool set by WithRBufferPool if possible.
+// put must be called once the frame is no longer used.
+func (r *readerImpl) readScratch(ctx context.Context, index *env.FrameOffsetEntry) (src []byte, put func(), err error) {
+	if r.compCache != nil {
+		src, err = r.readFrame(ctx, index)
+		return src, func() {}, err
+	}
+	return r.fetchScratch(ctx, index)
+}
+
+// fetchScratch is readScratch for fetch.  Frames are pooled only if they are read by
+// the default environment on their own, custom environments and the read coalescing
+// allocate them.
+func (r *readerImpl) fetchScratch(ctx context.Context, index *env.FrameOffsetEntry) (src []byte, put func(), err error) {
+	_, ok := r.env.(*readSeekerEnvImpl)
+	if r.buffers == nil || !ok || r.coalescer != nil {
+		src, err = r.fetch(ctx, index)
+		return src, func() {}, err
+	}
+	if src, err = r.fetchInto(ctx, index, r.buffers.Get()); err != nil {
+		return nil, nil, err
+	}
+	return src, func() { r.buffers.Put(src) }, nil
+}
+
+// fetch reads the compressed data described by index from the environment.
+func (r *readerImpl) fetch(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error) {
+	return r.fetchInto(ctx, index, nil)
+}
+
+// fetchInto is fetch that reads the data into buf, if it is not nil, which is only
+// supported by the default environment.
+func (r *readerImpl) fetchInto(ctx context.Context, index *env.FrameOffsetEntry, buf []byte) (src []byte, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	end := r.traceFrame(ctx, spanFetch, index)
+	defer func() { end(err) }()
+
+	start := time.Now()
+	if buf != nil {
+		src, err = r.env.(*readSeekerEnvImpl).readInto(*index, buf)
+	} else if r.coalescer != nil {
+		src, err = r.coalescer.get(ctx, index)
+	} else {
+		src, err = r.readEnv(ctx, index)
+	}
+	r.stats.read(len(src), time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed data at: %d, %w", index.CompOffset, err)
+	}
+
+	if len(src) != int(index.CompSize) {
+		return nil, fmt.Errorf("%w: compressed size does not match index at: %d: expected: %d, index: %+v",
+			ErrCorruptSeekTable, index.CompOffset, len(src), index)
+	}
+	return src, nil
+}
+
+// readEnv reads the compressed data described by index from the environment.
+func (r *readerImpl) readEnv(ctx context.Context, index *env.FrameOffsetEntry) ([]byte, error) {
+	if e, ok := r.env.(env.ContextREnvironment); ok {
+		return e.GetFrameByIndexContext(ctx, *index)
+	}
+	return r.env.GetFrameByIndex(*index)
+}
+
+// decompress decompresses the frame src described by index appending it to dst and verifies the result.
+func (r *readerImpl) decompress(index *env.FrameOffsetEntry, src, dst, dict []byte) ([]byte, error) {
+	start := time.Now()
+	var decompressed []byte
+	var err error
+	dec, put := r.decoder()
+	if d, ok := dec.(ZSTDDictDecoder); ok && dict != nil {
+		decompressed, err = d.DecodeAllDict(src, dst, dict)
+	} else if d, ok := dec.(ZSTDIntoDecoder); ok {
+		buf := slices.Grow(dst, int(index.DecompSize))
+		var n int
+		if n, err = d.DecodeAllInto(buf[len(dst):len(dst)+int(index.DecompSize)], src); err == nil {
+			decompressed = buf[:len(dst)+n]
+		}
+	} else {
+		decompressed, err = dec.DecodeAll(src, dst)
+	}
+	put()
+	r.stats.decoded(time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data data at: %d, %w", index.CompOffset, err)
+	}
+	if r.maxFrameSize > 0 && int64(len(decompressed)-len(dst)) > r.maxFrameSize {
+		return nil, fmt.Errorf("%w: decompressed frame is too big at: %d: %d > %d",
+			ErrFrameTooLarge, index.CompOffset, len(decompressed)-len(dst), r.maxFrameSize)
+	}
+	if len(decompressed)-len(dst) != int(index.DecompSize) {
+		return nil, fmt.Errorf("%w: index corruption: len: %d, expected: %d",
+			ErrCorruptSeekTable, len(decompressed)-len(dst), int(index.DecompSize))
+	}
+
+	if r.idx().checksums && r.verifyChecksums {
+		checksum := frameChecksum(decompressed[len(dst):])
+		if index.Checksum != checksum {
+			r.stats.checksumFailures.Add(1)
+			return nil, fmt.Errorf("%w: checksum verification failed at: %d: expected: %d, actual: %d",
+				ErrChecksumMismatch, index.CompOffset, index.Checksum, checksum)
+		}
+	}
+	return decompressed, nil
+}
+
+func (r *readerImpl) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		n, err := r.writeTo(w)
+		total += n
+		if r.followInterval <= 0 || err != nil {
+			return total, err
+		}
+		if err := r.follow(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// writeTo writes the data from the current offset up to the end of the stream to w.
+func (r *readerImpl) writeTo(w io.Writer) (int64, error) {
+	if r.closed.Load() {
+		return 0, fmt.Errorf("reader is closed")
+	}
+	if r.offset >= r.idx().endOffset {
+		return 0, nil
+	}
+	if r.offset < 0 {
+		return 0, fmt.Errorf("%w: offset before the start of the file: %d", ErrOffsetOutOfRange, r.offset)
+	}
+
+	start := r.GetIndexByDecompOffset(uint64(r.offset))
+	if start == nil {
+		return 0, fmt.Errorf("%w: failed to get index by offset: %d", ErrOffsetOutOfRange, r.offset)
+	}
+
+	if r.writeToConcurrency > 1 {
+		return r.writeToConcurrent(w, start)
+	}
+
+	// Frames are decompressed into the same buffer, bypassing the frame cache used by Read.
+	var total int64
+	var buf []byte
+	var err error
+	r.idx().index.ascend(start, func(index *env.FrameOffsetEntry) bool {
+		if index.DecompSize == 0 {
+			return true
+		}
+		if r.streamed(index) {
+			var n int64
+			n, err = r.writeStream(r.ctx, index, uint64(r.offset)-index.DecompOffset, w)
+			total += n
+			r.offset += n
+			return err == nil
+		}
+
+		buf, err = r.frameData(r.ctx, index, buf[:0])
+		if err != nil {
+			return false
+		}
+		defer r.memory.release(int64(index.DecompSize))
+
+		p := buf[uint64(r.offset)-index.DecompOffset:]
+		var n int
+		n, err = w.Write(p)
+		total += int64(n)
+		r.offset += int64(n)
+		if err == nil && n != len(p) {
+			err = io.ErrShortWrite
+		}
+		return err == nil
+	})
+	return total, err
+}
+
+// writeToConcurrent is WriteTo that decompresses up to writeToConcurrency frames starting with start
+// concurrently, see WithWriteToConcurrency.  The frames are still written in order.
+func (r *readerImpl) writeToConcurrent(w io.Writer, start *env.FrameOffsetEntry) (int64, error) {
+	type result struct {
+		index *env.FrameOffsetEntry
+		data  []byte
+		err   error
+	}
+	// Streamed frames are decompressed while they are written, they have no data.
+	streamed := func(res result) bool { return res.data == nil && res.err == nil }
+
+	// pending holds the frames being decompressed in the order they are written, one more
+	// frame is being written.  The memory of the frames is reserved in the same order,
+	// so that the frame being waited for never waits for the memory of the frames after it.
+	pending := make(chan chan result, r.writeToConcurrency-1)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	go func() {
+		defer close(pending)
+		r.idx().index.ascend(start, func(index *env.FrameOffsetEntry) bool {
+			if index.DecompSize == 0 {
+				return true
+			}
+
+			c := make(chan result, 1)
+			size := int64(index.CompSize) + int64(index.DecompSize)
+			if r.streamed(index) {
+				c <- result{index: index}
+			} else if err := r.memory.acquire(size); err != nil {
+				c <- result{index: index, err: err}
+			} else {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					data, err := r.decodeFrame(r.ctx, index, nil)
+					if err != nil {
+						r.memory.release(size)
+					} else {
+						r.memory.release(int64(index.CompSize))
+					}
+					c <- result{index: index, data: data, err: err}
+				}()
+			}
+
+			select {
+			case pending <- c:
+				return true
+			case <-stop:
+				if res := <-c; res.err == nil && !streamed(res) {
+					r.memory.release(int64(res.index.DecompSize))
+				}
+				return false
+			}
+		})
+	}()
+
+	var total int64
+	var err error
+	for c := range pending {
+		res := <-c
+		if res.err != nil {
+			err = res.err
+			break
+		}
+		if streamed(res) {
+			var n int64
+			n, err = r.writeStream(r.ctx, res.index, uint64(r.offset)-res.index.DecompOffset, w)
+			total += n
+			r.offset += n
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		p := res.data[uint64(r.offset)-res.index.DecompOffset:]
+		var n int
+		n, err = w.Write(p)
+		r.memory.release(int64(res.index.DecompSize))
+		total += int64(n)
+		r.offset += int64(n)
+		if err == nil && n != len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		close(stop)
+		for c := range pending {
+			if res := <-c; res.err == nil && !streamed(res) {
+				r.memory.release(int64(res.index.DecompSize))
+			}
+		}
+	}
+	wg.Wait()
+	return total, err
+}
+
+func (r *readerImpl) Seek(offset int64, whence int) (int64, error) {
+	newOffset := r.offset
+	switch whence {
+	case io.SeekCurrent:
+		newOffset += offset
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = r.idx().endOffset + offset
+	default:
+		return 0, fmt.Errorf("unknown whence: %d", whence)
+	}
+
+	if newOffset < 0 {
+		return 0, fmt.Errorf("%w: offset before the start of the file: %d (%d + %d)",
+			ErrOffsetOutOfRange, newOffset, r.offset, offset)
+	}
+
+	r.offset = newOffset
+	return r.offset, nil
+}
+
+// errMissingFooter is returned by indexFooter if the stream does not end with the seek table,
+// e.g. it is truncated.
+var errMissingFooter = errors.New("seek table footer is missing")
+
+func (r *readerImpl) indexFooter() (*seekTableIndex, error) {
+	// read seekTableFooter
+	buf, err := r.env.ReadFooter()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read footer: %w", errMissingFooter, err)
+	}
+	if len(buf) < seekTableFooterOffset {
+		return nil, fmt.Errorf("%w: %w: footer is too small: %d", errMissingFooter, ErrCorruptSeekTable, len(buf))
+	}
+	if magic := binary.LittleEndian.Uint32(buf[len(buf)-4:]); magic != seekableMagicNumber {
+		return nil, fmt.Errorf("%w: %w: footer magic mismatch %d vs %d",
+			errMissingFooter, ErrCorruptSeekTable, magic, seekableMagicNumber)
+	}
+
+	// parse seekTableFooter
+	footer := seekTableFooter{}
+	err = footer.unmarshalBinary(buf[len(buf)-seekTableFooterOffset:], r.strictFormat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse footer %+v: %w", buf, err)
+	}
+	r.logger.Debug("loaded", zap.Object("footer", &footer))
+	if _, err := r.checkSeekTableSize(&footer); err != nil {
+		return nil, err
+	}
+
+	var compressedSize uint32
+	if footer.SeekTableDescriptor.CompressedFlag {
+		buf, err = r.env.ReadSkipFrame(seekTableFooterOffset + compressedSizeFieldSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read compressed seek table size: %w", errMissingFooter, err)
+		}
+		if len(buf) != seekTableFooterOffset+compressedSizeFieldSize {
+			return nil, fmt.Errorf("%w: %w: compressed seek table size is truncated: %d",
+				errMissingFooter, ErrCorruptSeekTable, len(buf))
+		}
+		compressedSize = binary.LittleEndian.Uint32(buf)
+	}
+	skippableFrameOffset := seekTableFrameSize(&footer, compressedSize)
+
+	if skippableFrameOffset > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: frame offset is too big: %d > %d",
+			ErrFrameTooLarge, skippableFrameOffset, maxDecoderFrameSize)
+	}
+
+	buf, err = r.env.ReadSkipFrame(skippableFrameOffset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read seek table: %w", errMissingFooter, err)
+	}
+
+	return r.indexSeekTable(buf)
+}
+
+// indexSeekTable parses the full seek table skippable frame
+// including the `Skippable_Magic_Number` and `Frame_Size`.
+func (r *readerImpl) indexSeekTable(buf []byte) (idx *seekTableIndex, err error) {
+	end := r.startSpan(r.ctx, spanParseSeekTable, attribute.Int("seekable.seek_table.size", len(buf)))
+	defer func() { end(err) }()
+
+	if len(buf) < frameSizeFieldSize+skippableMagicNumberFieldSize+seekTableFooterOffset {
+		return nil, fmt.Errorf("%w: skip frame is too small: %d", ErrCorruptSeekTable, len(buf))
+	}
+
+	footer := seekTableFooter{}
+	err = footer.unmarshalBinary(buf[len(buf)-seekTableFooterOffset:], r.strictFormat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse footer %+v: %w", buf, err)
+	}
+	if footer.SeekTableDescriptor.UnknownBits != 0 {
+		r.logger.Warn("ignoring unknown seek table descriptor bits", zap.Object("footer", &footer))
+	}
+	indexMemory, err := r.checkSeekTableSize(&footer)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse SeekTableEntries
+	if err := format.CheckHeader(buf); err != nil {
+		return nil, err
+	}
+	if frameSize := int64(len(buf)) - format.HeaderSize; frameSize > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: frame is too big: %d > %d", ErrFrameTooLarge, frameSize, maxDecoderFrameSize)
+	}
+
+	entries := buf[8 : len(buf)-seekTableFooterOffset]
+	if footer.SeekTableDescriptor.CompressedFlag {
+		entries, err = r.decompressSeekTable(entries, &footer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tree, last, err := r.indexSeekTableEntries(entries, uint64(footer.entrySize()))
+	if err != nil {
+		return nil, err
+	}
+	idx = &seekTableIndex{
+		index:              tree,
+		checksums:          footer.SeekTableDescriptor.ChecksumFlag,
+		footerFrames:       int64(footer.NumberOfFrames),
+		seekTableFrameSize: int64(len(buf)),
+		indexMemory:        indexMemory,
+	}
+	if last != nil {
+		idx.numFrames = last.ID + 1
+		idx.endOffset = int64(last.DecompOffset) + int64(last.DecompSize)
+		idx.compEnd = last.CompOffset + uint64(last.CompSize)
+	}
+	return idx, nil
+}
+
+// checkFrameSizes returns an error if any of the seek table entries exceeds WithMaxDecompressedFrameSize.
+func (r *readerImpl) checkFrameSizes(p []byte, entrySize uint64) error {
+	if r.maxFrameSize == 0 {
+		return nil
+	}
+	for off := uint64(0); off < uint64(len(p)); off += entrySize {
+		if size := binary.LittleEndian.Uint32(p[off+4:]); int64(size) > r.maxFrameSize {
+			return fmt.Errorf("%w: frame %d is too big: %d > %d", ErrFrameTooLarge, off/entrySize, size, r.maxFrameSize)
+		}
+	}
+	return nil
+}
+
+// checkSeekTableSize returns the memory taken by the index of the entries described by footer,
+// or an error if they exceed WithMaxSeekTableSize or their index does not fit into WithReaderMemoryLimit.
+func (r *readerImpl) checkSeekTableSize(footer *seekTableFooter) (int64, error) {
+	size := footer.entrySize() * int64(footer.NumberOfFrames)
+	if r.maxSeekTableSize > 0 && size > r.maxSeekTableSize {
+		return 0, fmt.Errorf("%w: seek table entries are too big: %d > %d", ErrFrameTooLarge, size, r.maxSeekTableSize)
+	}
+	indexMemory := r.estimateIndexMemory(footer)
+	if r.memoryLimit > 0 && indexMemory >= r.memoryLimit {
+		return 0, fmt.Errorf("%w: index of %d frames needs %d bytes, limit: %d",
+			ErrMemoryLimit, footer.NumberOfFrames, indexMemory, r.memoryLimit)
+	}
+	return indexMemory, nil
+}
+
+// seekTableFrameSize returns the size of the seek table skippable frame
+// including the `Skippable_Magic_Number` and `Frame_Size`.
+// compressedSize is only used if the seek table is compressed.
+func seekTableFrameSize(footer *seekTableFooter, compressedSize uint32) int64 {
+	size := int64(seekTableFooterOffset + frameSizeFieldSize + skippableMagicNumberFieldSize)
+	if footer.SeekTableDescriptor.CompressedFlag {
+		return size + compressedSizeFieldSize + int64(compressedSize)
+	}
+	return size + footer.entrySize()*int64(footer.NumberOfFrames)
+}
+
+// decompressSeekTable returns the `Seek_Table_Entries` stored in `Compressed_Seek_Table_Entries`
+// followed by the `Compressed_Size`.
+func (r *readerImpl) decompressSeekTable(p []byte, footer *seekTableFooter) ([]byte, error) {
+	if len(p) < compressedSizeFieldSize {
+		return nil, fmt.Errorf("%w: compressed seek table is too small: %d", ErrCorruptSeekTable, len(p))
+	}
+	compressedSize := int64(binary.LittleEndian.Uint32(p[len(p)-compressedSizeFieldSize:]))
+	p = p[:len(p)-compressedSizeFieldSize]
+	if compressedSize != int64(len(p)) {
+		return nil, fmt.Errorf("%w: compressed seek table size mismatch: expected: %d, actual: %d",
+			ErrCorruptSeekTable, compressedSize, len(p))
+	}
+
+	expectedSize := footer.entrySize() * int64(footer.NumberOfFrames)
+	if expectedSize > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: seek table is too big: %d > %d", ErrFrameTooLarge, expectedSize, maxDecoderFrameSize)
+	}
+	if len(p) == 0 {
+		if expectedSize != 0 {
+			return nil, fmt.Errorf("%w: compressed seek table is empty, expected: %d bytes", ErrCorruptSeekTable, expectedSize)
+		}
+		return nil, nil
+	}
+
+	// Reject mismatching sizes early to avoid decompressing untrusted input.
+	h, err := parseZSTDFrameHeader(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compressed seek table header: %w", err)
+	}
+	if h.HasContentSize && h.ContentSize != uint64(expectedSize) {
+		return nil, fmt.Errorf("%w: compressed seek table content size mismatch: expected: %d, actual: %d",
+			ErrCorruptSeekTable, expectedSize, h.ContentSize)
+	}
+
+	dec, put := r.decoder()
+	entries, err := dec.DecodeAll(p, nil)
+	put()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress seek table: %w", err)
+	}
+	if int64(len(entries)) != expectedSize {
+		return nil, fmt.Errorf("%w: seek table size mismatch: expected: %d, actual: %d",
+			ErrCorruptSeekTable, expectedSize, len(entries))
+	}
+	return entries, nil
+}
+
+// indexHead parses the seek table at the beginning of the stream written with WithSeekTableAtHead.
+func (r *readerImpl) indexHead() (*seekTableIndex, error) {
+	const headerSize = skippableMagicNumberFieldSize + frameSizeFieldSize
+
+	if _, ok := r.env.(*decoderEnv); ok {
+		// Decoder is only given the seek table itself.
+		return nil, fmt.Errorf("decoder does not support seek table at the head")
+	}
+
+	buf, err := r.env.GetFrameByIndex(env.FrameOffsetEntry{CompSize: headerSize})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream head: %w", err)
+	}
+	if len(buf) != headerSize {
+		return nil, fmt.Errorf("%w: stream head is too small: %d", ErrCorruptSeekTable, len(buf))
+	}
+	if magic := binary.LittleEndian.Uint32(buf); magic != skippableFrameMagic+seekableTag {
+		return nil, fmt.Errorf("%w: skippable frame magic mismatch %d vs %d",
+			ErrCorruptSeekTable, magic, skippableFrameMagic+seekableTag)
+	}
+
+	frameSize := int64(binary.LittleEndian.Uint32(buf[4:])) + headerSize
+	if frameSize > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: frame is too big: %d > %d", ErrFrameTooLarge, frameSize, maxDecoderFrameSize)
+	}
+	buf, err = r.env.GetFrameByIndex(env.FrameOffsetEntry{CompSize: uint32(frameSize)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seek table at the head: %w", err)
+	}
+	return r.indexSeekTable(buf)
+}
+
+// indexCheckpoint scans the tail of the stream backwards looking for the latest
+// seek table checkpoint written by Flush.
+//
+// A checkpoint is only accepted if the frames it describes end exactly where
+// the checkpoint starts.  Only the last maxDecoderFrameSize bytes are scanned.
+func (r *readerImpl) indexCheckpoint() (*seekTableIndex, error) {
+	sizer, ok := r.env.(env.Sizer)
+	if !ok {
+		return nil, fmt.Errorf("environment does not support checkpoint recovery")
+	}
+
+	size, err := sizer.Size()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream size: %w", err)
+	}
+
+	window := size
+	if window > maxDecoderFrameSize {
+		window = maxDecoderFrameSize
+	}
+	buf, err := r.env.ReadSkipFrame(window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream tail: %w", err)
+	}
+	base := size - int64(len(buf))
+
+	minLen := frameSizeFieldSize + skippableMagicNumberFieldSize + seekTableFooterOffset
+	for end := len(buf); end >= minLen; end-- {
+		if binary.LittleEndian.Uint32(buf[end-4:end]) != seekableMagicNumber {
+			continue
+		}
+
+		footer := seekTableFooter{}
+		if footer.unmarshalBinary(buf[end-seekTableFooterOffset:end], r.strictFormat) != nil {
+			continue
+		}
+		var compressedSize uint32
+		if footer.SeekTableDescriptor.CompressedFlag {
+			if end < minLen+compressedSizeFieldSize {
+				continue
+			}
+			compressedSize = binary.LittleEndian.Uint32(buf[end-seekTableFooterOffset-compressedSizeFieldSize:])
+		}
+		start := int64(end) - seekTableFrameSize(&footer, compressedSize)
+		if start < 0 {
+			continue
+		}
+
+		idx, err := r.indexSeekTable(buf[start:end])
+		if err != nil || idx.compEnd != uint64(base+start) {
+			continue
+		}
+
+		r.logger.Debug("found checkpoint", zap.Int64("offset", base+start), zap.Object("footer", &footer))
+		return idx, nil
+	}
+
+	return nil, fmt.Errorf("no checkpoint found")
+}
+
+func (r *readerImpl) indexSeekTableEntries(p []byte, entrySize uint64) (
+	frameIndex, *env.FrameOffsetEntry, error,
+) {
+	if uint64(len(p))%entrySize != 0 {
+		return nil, nil, fmt.Errorf("%w: seek table size is not multiple of %d", ErrCorruptSeekTable, entrySize)
+	}
+	if err := r.checkFrameSizes(p, entrySize); err != nil {
+		return nil, nil, err
+	}
+
+	if r.lazySeekTable {
+		index, last := newLazyIndex(p, int(entrySize))
+		return index, last, nil
+	}
+
+	n := uint64(len(p)) / entrySize
+	var comp, decomp []uint64
+	var checksums []uint32
+	var t *btree.BTreeG[*env.FrameOffsetEntry]
+	if r.bucketIndex {
+		comp, decomp = make([]uint64, 1, n+1), make([]uint64, 1, n+1)
+		checksums = make([]uint32, 0, n)
+	} else {
+		// TODO: make fan-out tunable?
+		t = btree.NewG(8, env.Less)
+	}
+	entry := seekTableEntry{}
+	var compOffset, decompOffset uint64
+
+	var last *env.FrameOffsetEntry
+	var i int64
+	for indexOffset := uint64(0); indexOffset < uint64(len(p)); indexOffset += entrySize {
+		err := entry.UnmarshalBinary(p[indexOffset : indexOffset+entrySize])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse entry %+v at: %d: %w",
+				p[indexOffset:indexOffset+entrySize], indexOffset, err)
+		}
+
+		if t != nil {
+			last = &env.FrameOffsetEntry{
+				ID:           i,
+				CompOffset:   compOffset,
+				DecompOffset: decompOffset,
+				CompSize:     entry.CompressedSize,
+				DecompSize:   entry.DecompressedSize,
+				Checksum:     entry.Checksum,
+			}
+			t.ReplaceOrInsert(last)
+		}
+		compOffset += uint64(entry.CompressedSize)
+		decompOffset += uint64(entry.DecompressedSize)
+		if t == nil {
+			comp = append(comp, compOffset)
+			decomp = append(decomp, decompOffset)
+			checksums = append(checksums, entry.Checksum)
+		}
+		i++
+	}
+
+	if t != nil {
+		return &btreeIndex{t: t}, last, nil
+	}
+	index := newBucketIndex(comp, decomp, checksums)
+	return index, index.byID(int64(index.Len()) - 1), nil
 }
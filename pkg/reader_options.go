@@ -1,6 +1,14 @@
 package seekable
 
 import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"time"
+
 	"go.uber.org/zap"
 
 	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
@@ -12,6 +20,390 @@ func WithRLogger(l *zap.Logger) rOption {
 	return func(r *readerImpl) error { r.logger = l; return nil }
 }
 
+// WithRSLogger is similar to WithRLogger but sends logs to the standard library's structured logger.
+func WithRSLogger(l *slog.Logger) rOption {
+	return func(r *readerImpl) error { r.logger = newSLogger(l); return nil }
+}
+
 func WithREnvironment(e env.REnvironment) rOption {
 	return func(r *readerImpl) error { r.env = e; return nil }
 }
+
+// WithSeekTableFrom makes the reader load the seek table from r instead of the end of the stream,
+// e.g. from a sidecar file written with WithSidecarSeekTable.  The seek table must describe
+// the very stream passed to NewReader.
+func WithSeekTableFrom(r io.Reader) rOption {
+	return func(rd *readerImpl) error {
+		buf, err := io.ReadAll(io.LimitReader(r, maxDecoderFrameSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read seek table: %w", err)
+		}
+		if len(buf) > maxDecoderFrameSize {
+			return fmt.Errorf("seek table is too big: > %d", maxDecoderFrameSize)
+		}
+		rd.seekTable = buf
+		return nil
+	}
+}
+
+// WithSeekTableBytes is similar to WithSeekTableFrom but takes the seek table that is already
+// in memory, e.g. fetched from a cache or a database, so that opening a remote stream does not
+// need any reads.  p is used as is and must not be modified afterwards.
+func WithSeekTableBytes(p []byte) rOption {
+	return func(rd *readerImpl) error {
+		if len(p) > maxDecoderFrameSize {
+			return fmt.Errorf("seek table is too big: %d > %d", len(p), maxDecoderFrameSize)
+		}
+		rd.seekTable = p
+		return nil
+	}
+}
+
+// WithRContext sets the context of all reads of the reader but ReadAtContext, including
+// the background ones started by WithPrefetch.  See Reader's ReadAtContext.
+func WithRContext(ctx context.Context) rOption {
+	return func(r *readerImpl) error {
+		if ctx == nil {
+			return fmt.Errorf("context must not be nil")
+		}
+		r.ctx = ctx
+		return nil
+	}
+}
+
+// WithDecoderFactory makes the reader decompress frames with decoders created by factory instead
+// of the one passed to the constructor.  Decoders are pooled and each of them is used by one
+// goroutine at a time, so concurrent ReadAt calls do not contend on a single decoder and decoders
+// that are not goroutine-safe can be used.  All decoders must be created with the same settings.
+func WithDecoderFactory(factory func() ZSTDDecoder) rOption {
+	return func(r *readerImpl) error {
+		if factory == nil {
+			return fmt.Errorf("decoder factory is nil")
+		}
+		r.decoders = newDecoderPool(factory)
+		return nil
+	}
+}
+
+// WithPrefetch makes the reader decompress the next n frames in background goroutines
+// while the current one is being read, which hides the latency of remote storage from
+// sequential readers.  Prefetched frames that are skipped by a seek are dropped.
+//
+// Frames are read concurrently, so prefetching is disabled if the underlying reader does not
+// implement io.ReaderAt.  A custom environment set by WithREnvironment must be goroutine-safe.
+func WithPrefetch(n int) rOption {
+	return func(r *readerImpl) error {
+		if n < 0 {
+			return fmt.Errorf("prefetch must not be negative: %d", n)
+		}
+		r.prefetch = n
+		return nil
+	}
+}
+
+// WithFrameCache makes the reader keep up to maxBytes of decompressed frames in an LRU cache,
+// or the one set by WithCachePolicy, shared by Read, ReadAt and Seek, so that reads with
+// locality do not decompress the same frames again.  By default only the last decompressed
+// frame is kept.  Frames bigger than maxBytes are not cached.  See Reader's CacheStats for
+// the hit statistics.
+func WithFrameCache(maxBytes int64) rOption {
+	return func(r *readerImpl) error {
+		if maxBytes < 1 {
+			return fmt.Errorf("frame cache size must be positive: %d", maxBytes)
+		}
+		r.cache = newFrameCache(maxBytes)
+		return nil
+	}
+}
+
+// WithCompressedFrameCache makes the reader keep up to maxBytes of compressed frames read from
+// the environment in an LRU cache, or the one set by WithCachePolicy, so that frames read
+// again are only decompressed instead of fetched again.  It is meant for slow remote environments and data that compresses well,
+// where it keeps many more frames than WithFrameCache in the same memory, and can be combined
+// with it.  The environment must not modify the frames it returns afterwards.
+// Under WithReaderMemoryLimit frames are only cached while they fit without reclaiming others.
+// See Reader's CompressedCacheStats for the hit statistics.
+func WithCompressedFrameCache(maxBytes int64) rOption {
+	return func(r *readerImpl) error {
+		if maxBytes < 1 {
+			return fmt.Errorf("compressed frame cache size must be positive: %d", maxBytes)
+		}
+		r.compCache = newFrameCache(maxBytes)
+		return nil
+	}
+}
+
+// WithCachePolicy sets the eviction policy of the frame caches set by WithFrameCache and
+// WithCompressedFrameCache, each of them gets its own policy created by newPolicy for its
+// size.  The default NewLRUPolicy is thrashed by scans, e.g. a full read of the stream
+// in between random reads of the same frames, NewSegmentedLRUPolicy and NewTwoQueuePolicy
+// keep the frames read repeatedly instead.
+func WithCachePolicy(newPolicy func(maxBytes int64) CachePolicy) rOption {
+	return func(r *readerImpl) error {
+		if newPolicy == nil {
+			return fmt.Errorf("cache policy is nil")
+		}
+		r.newCachePolicy = newPolicy
+		return nil
+	}
+}
+
+// WithReadConcurrency makes Read and ReadAt decompress up to n of the frames spanned by
+// a single call concurrently, which speeds up big reads of many frames.  Unlike the default,
+// Read fills the whole buffer instead of returning the data of one frame at a time.
+//
+// As with WithPrefetch, it is disabled if the underlying reader does not implement io.ReaderAt,
+// and a custom environment set by WithREnvironment must be goroutine-safe.
+func WithReadConcurrency(n int) rOption {
+	return func(r *readerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("read concurrency must be positive: %d", n)
+		}
+		r.readConcurrency = n
+		return nil
+	}
+}
+
+// WithRBufferPool is similar to Writer's WithBufferPool: it makes the reader take the buffers
+// of compressed frames read from the stream from p and return them once the frames are
+// decompressed, so that many readers, and writers, in one process share the same buffers
+// instead of allocating them for every frame.  Frames kept by WithCompressedFrameCache,
+// frames read by a custom environment set by WithREnvironment and frames merged by
+// WithReadCoalescing are not pooled.  Decompressed frames are owned by the frame caches
+// and the callers of FrameBytes, so they are not pooled either.
+func WithRBufferPool(p BufferPool) rOption {
+	return func(r *readerImpl) error {
+		if p == nil {
+			return fmt.Errorf("buffer pool is nil")
+		}
+		r.buffers = p
+		return nil
+	}
+}
+
+// WithReadCoalescing makes the reader merge the frame reads that arrive within window of
+// each other into single reads of up to maxBytes from the environment, which are then sliced
+// into the frames, e.g. to cut the number of requests to remote object storages.  The frames
+// of a merged read do not have to be adjacent, the data between them is read and dropped.
+// The reads merged are the concurrent ones, of WithPrefetch, WithReadConcurrency, ReadRanges
+// or concurrent ReadAt calls, and each of them waits for up to window, so window should be
+// small compared to the latency of the environment.  Frames of maxBytes or more are read
+// on their own.
+//
+// As with WithPrefetch, it is disabled if the underlying reader does not implement io.ReaderAt,
+// and a custom environment set by WithREnvironment must be goroutine-safe.
+func WithReadCoalescing(window time.Duration, maxBytes int64) rOption {
+	return func(r *readerImpl) error {
+		if window < 0 {
+			return fmt.Errorf("read coalescing window must not be negative: %s", window)
+		}
+		if maxBytes < 1 || maxBytes > math.MaxUint32 {
+			return fmt.Errorf("read coalescing size must be positive and fit into uint32: %d", maxBytes)
+		}
+		r.coalesceWindow = window
+		r.coalesceBytes = maxBytes
+		return nil
+	}
+}
+
+// WithWriteToConcurrency makes WriteTo decompress up to n frames ahead concurrently while
+// writing them strictly in order, so that extracting a whole stream, e.g. with io.Copy,
+// uses all cores instead of one.  Frames are decompressed into separate buffers instead of
+// the single one used by default, and prefetched frames are not used.
+//
+// As with WithPrefetch, it is disabled if the underlying reader does not implement io.ReaderAt,
+// and a custom environment set by WithREnvironment must be goroutine-safe.
+func WithWriteToConcurrency(n int) rOption {
+	return func(r *readerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("write to concurrency must be positive: %d", n)
+		}
+		r.writeToConcurrency = n
+		return nil
+	}
+}
+
+// WithLazySeekTable makes the reader keep the seek table entries in their serialized form and parse
+// them on demand instead of building an index of all frames on open.  It makes opening streams
+// with millions of frames cheap at the cost of slightly slower frame lookups.
+//
+// The Decoder created by NewDecoder with this option references the passed seek table,
+// so it must not be modified while the Decoder is used.
+func WithLazySeekTable() rOption {
+	return func(r *readerImpl) error { r.lazySeekTable = true; return nil }
+}
+
+// WithBucketIndex controls whether the index of the seek table has an array of buckets over
+// the decompressed offsets, which is the default.  It makes the offset lookups of Read, ReadAt
+// and Seek take constant time on average instead of the B-tree search, and the frame offsets
+// are kept in packed arrays that take about half the memory of the B-tree.  Disabling it saves
+// building the buckets on open, e.g. for tiny streams that are read once.
+// It has no effect with WithLazySeekTable.
+func WithBucketIndex(enabled bool) rOption {
+	return func(r *readerImpl) error { r.bucketIndex = enabled; return nil }
+}
+
+// WithMaxSeekTableSize makes the reader refuse streams whose seek table entries take more than
+// n bytes with ErrFrameTooLarge, which bounds the memory used on open.  The size is checked
+// before the entries are read.
+func WithMaxSeekTableSize(n int64) rOption {
+	return func(r *readerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("max seek table size must be positive: %d", n)
+		}
+		r.maxSeekTableSize = n
+		return nil
+	}
+}
+
+// WithMaxDecompressedFrameSize makes the reader refuse streams whose seek table has entries
+// with the decompressed size over n with ErrFrameTooLarge, which protects services opening
+// untrusted streams from decompression bombs.  Frames that decompress to more than n despite
+// the seek table fail to read with ErrFrameTooLarge as well, before decoding if the frame header
+// declares its size.  Frames without the declared size are only bounded by the decoder, see
+// e.g. zstd.WithDecoderMaxMemory.
+func WithMaxDecompressedFrameSize(n int64) rOption {
+	return func(r *readerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("max decompressed frame size must be positive: %d", n)
+		}
+		r.maxFrameSize = n
+		return nil
+	}
+}
+
+// WithReaderMemoryLimit sets a hard limit on the memory held by the reader: the index of
+// the seek table, the decompressed frames it retains (the frame cache, the last read frame and
+// prefetched frames) and the frames being decompressed, including concurrent reads.
+//
+// NewReader fails with ErrMemoryLimit if the index alone does not fit.  Reads of frames that
+// do not fit into the memory left fail with ErrMemoryLimit.  Otherwise retained frames are
+// evicted to make room, prefetched frames first, and reads wait for concurrent decompression
+// to finish.  Frames are only prefetched if there is memory left without evicting anything.
+//
+// Memory held by the decoder and the environment is not accounted for.
+func WithReaderMemoryLimit(n int64) rOption {
+	return func(r *readerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("memory limit must be positive: %d", n)
+		}
+		r.memoryLimit = n
+		return nil
+	}
+}
+
+// WithStreamingDecode makes the reader decompress frames with the decompressed size over threshold
+// incrementally with the streaming decoders created by newReader, e.g. zstd.NewReader's IOReadCloser
+// for github.com/klauspost/compress/zstd, instead of materializing them as a whole.  It lets readers
+// with little memory, e.g. under WithReaderMemoryLimit, read streams written with huge frames.
+// Only the compressed frame is kept in memory while it is read.
+//
+// Streamed frames are not cached nor prefetched.  Sequential reads continue decompressing where
+// the previous one stopped, but reads at earlier offsets decompress the frame from the start.
+// Checksums are only verified for frames read from the start to the end, and the verification
+// error is returned by the read that ends the frame, after the rest of its data.  newReader
+// must support the dictionaries of the stream, WithDictionaries is not used for streamed frames,
+// nor is WithSkipCorruptFrames.
+func WithStreamingDecode(threshold int64, newReader func(src io.Reader) (io.ReadCloser, error)) rOption {
+	return func(r *readerImpl) error {
+		if threshold < 1 {
+			return fmt.Errorf("streaming threshold must be positive: %d", threshold)
+		}
+		if newReader == nil {
+			return fmt.Errorf("stream decoder factory is nil")
+		}
+		r.streamThreshold = threshold
+		r.newStreamReader = newReader
+		return nil
+	}
+}
+
+// WithDictionaries sets the zstd dictionaries that the stream may be compressed with, see
+// Writer's WithDictionary.  NewReader fails with ErrDictionaryNotFound if the dictionary recorded
+// in the stream is not among them, and so do reads of frames compressed with other dictionaries.
+//
+// Frames are decompressed with the matching dictionary if the decoder implements ZSTDDictDecoder.
+// Otherwise the decoder must be configured with the same dictionaries, e.g. via zstd.WithDecoderDicts.
+func WithDictionaries(dicts ...[]byte) rOption {
+	return func(r *readerImpl) error {
+		if r.dicts == nil {
+			r.dicts = make(map[uint32][]byte, len(dicts))
+		}
+		for _, dict := range dicts {
+			if len(dict) < 8 || binary.LittleEndian.Uint32(dict) != zstdDictMagic {
+				return fmt.Errorf("dictionary is not in zstd format")
+			}
+			r.dicts[binary.LittleEndian.Uint32(dict[4:])] = dict
+		}
+		return nil
+	}
+}
+
+// WithSkipCorruptFrames makes the reader recover the data of partially damaged streams: frames that
+// fail to decompress or fail checksum verification are replaced with zeroes of the decompressed size
+// recorded in the seek table, so that the rest of the stream is still readable at the same offsets.
+// report is called with the damaged frame and the error each time one is decompressed, e.g.
+// to log the damaged range of the decompressed stream.  Failures to read the frames from
+// the underlying reader and frames exceeding WithMaxDecompressedFrameSize are still returned.
+//
+// Zero-filled frames are kept in the frame cache, so report may be called less often than
+// the frame is read.  report must be goroutine-safe if reads are concurrent.
+func WithSkipCorruptFrames(report func(frame FrameInfo, err error)) rOption {
+	return func(r *readerImpl) error {
+		if report == nil {
+			return fmt.Errorf("corrupt frame callback must not be nil")
+		}
+		r.onCorruptFrame = report
+		return nil
+	}
+}
+
+// WithVerifyContentDigest makes NewReader decompress the whole stream and compare its SHA-256 digest
+// with the one stored by Writer's WithContentDigest, failing with ErrDigestMismatch if they differ.
+// It verifies the stream end to end, beyond the checksums of individual frames, at the cost
+// of reading all of it on open.  Streams without the digest are opened as usual.
+// See also Reader's VerifyContentDigest to verify the digest later.
+func WithVerifyContentDigest() rOption {
+	return func(r *readerImpl) error {
+		r.verifyDigest = true
+		return nil
+	}
+}
+
+// WithChecksumVerification controls whether the checksums of decompressed frames are verified
+// against the seek table, which is the default.  Disabling it trades the integrity check
+// for throughput in trusted environments.
+func WithChecksumVerification(enabled bool) rOption {
+	return func(r *readerImpl) error { r.verifyChecksums = enabled; return nil }
+}
+
+// WithRequireChecksums makes NewReader refuse streams whose seek table does not have frame
+// checksums with ErrNoChecksums, so that unverifiable data is never accepted.
+// It can not be combined with disabled WithChecksumVerification.
+func WithRequireChecksums() rOption {
+	return func(r *readerImpl) error { r.requireChecksums = true; return nil }
+}
+
+// WithStrictFormat makes the reader refuse streams whose seek table descriptor has any of
+// the reserved or unused bits set with ErrCorruptSeekTable.  By default they are ignored,
+// so that streams written by future revisions of the format can be read, which may misinterpret
+// such streams.  Use it where untrusted streams must conform to the known format exactly.
+func WithStrictFormat() rOption {
+	return func(r *readerImpl) error { r.strictFormat = true; return nil }
+}
+
+// WithFollow makes Read and WriteTo wait for a stream that is still being written to grow
+// instead of stopping at the end of the data, like tail -f.  The stream is checked for new
+// frames every interval, see Reader's Refresh.  Only the data covered by the latest seek table
+// or checkpoint written by Writer's Flush is read, so the writer should flush periodically.
+// Reads only stop when the context set by WithRContext is done, so WithFollow is meant
+// to be used together with it.
+func WithFollow(interval time.Duration) rOption {
+	return func(r *readerImpl) error {
+		if interval <= 0 {
+			return fmt.Errorf("follow interval must be positive: %s", interval)
+		}
+		r.followInterval = interval
+		return nil
+	}
+}
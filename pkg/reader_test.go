@@ -2,14 +2,27 @@ package seekable
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
 )
@@ -147,8 +160,8 @@ func TestReader(t *testing.T) {
 		require.NoError(t, err)
 
 		sr := r.(*readerImpl)
-		assert.Equal(t, int64(9), sr.endOffset)
-		assert.Equal(t, 2, sr.index.Len())
+		assert.Equal(t, int64(9), sr.idx().endOffset)
+		assert.Equal(t, 2, sr.idx().index.Len())
 		assert.Equal(t, int64(0), sr.offset)
 
 		bytes1 := []byte("test")
@@ -308,6 +321,2213 @@ func TestReaderAt(t *testing.T) {
 	}
 }
 
+func TestReaderWriteTo(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	for _, buf := range [][]byte{checksum, noChecksum} {
+		r, err := NewReader(&seekableBufferReaderAt{buf: buf}, dec)
+		require.NoError(t, err)
+
+		var b bytes.Buffer
+		n, err := io.Copy(&b, r)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(sourceString)), n)
+		assert.Equal(t, sourceString, b.String())
+
+		// Continues from the current offset and leaves the offset at the end.
+		_, err = r.Seek(3, io.SeekStart)
+		require.NoError(t, err)
+		b.Reset()
+		n, err = r.WriteTo(&b)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), n)
+		assert.Equal(t, "ttest2", b.String())
+		offset, err := r.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(sourceString)), offset)
+
+		n, err = r.WriteTo(&b)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		// Writer errors stop the copy.
+		pr, pw := io.Pipe()
+		require.NoError(t, pr.CloseWithError(io.ErrClosedPipe))
+		_, err = r.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		_, err = r.WriteTo(pw)
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderWriteToConcurrency(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithWriteToConcurrency(0))
+	require.ErrorContains(t, err, "must be positive")
+
+	// Concurrent decompression needs io.ReaderAt.
+	r, err := NewReader(&seekableBufferReader{seekableBufferReaderAt{buf: b.Bytes()}}, dec, WithWriteToConcurrency(4))
+	require.NoError(t, err)
+	assert.Zero(t, r.(*readerImpl).writeToConcurrency)
+	require.NoError(t, r.Close())
+
+	frameSize := int64(len(makeTestFrame(t, 0)))
+	for _, opts := range [][]rOption{
+		{WithWriteToConcurrency(4)},
+		{WithWriteToConcurrency(8), WithPrefetch(2)},
+		{WithWriteToConcurrency(4), WithReaderMemoryLimit(3 * 2 * frameSize)},
+	} {
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, opts...)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		n, err := io.Copy(&out, r)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)), n)
+		assert.Equal(t, expected, out.Bytes())
+
+		_, err = r.Seek(frameSize*5+3, io.SeekStart)
+		require.NoError(t, err)
+		out.Reset()
+		_, err = r.WriteTo(&out)
+		require.NoError(t, err)
+		assert.Equal(t, expected[frameSize*5+3:], out.Bytes())
+
+		// Writer errors stop the copy and drop the frames being decompressed.
+		pr, pw := io.Pipe()
+		require.NoError(t, pr.CloseWithError(io.ErrClosedPipe))
+		_, err = r.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		_, err = r.WriteTo(pw)
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+		offset, err := r.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Zero(t, offset)
+
+		if m := r.(*readerImpl).memory; m != nil {
+			m.m.Lock()
+			assert.LessOrEqual(t, m.used, m.limit)
+			m.m.Unlock()
+		}
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderPrefetch(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithPrefetch(-1))
+	require.ErrorContains(t, err, "must not be negative")
+
+	// Prefetching needs io.ReaderAt.
+	r, err := NewReader(&seekableBufferReader{seekableBufferReaderAt{buf: b.Bytes()}}, dec, WithPrefetch(2))
+	require.NoError(t, err)
+	assert.Nil(t, r.(*readerImpl).prefetcher)
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithPrefetch(2))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	buf := make([]byte, 1)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	p := r.(*readerImpl).prefetcher
+	p.m.Lock()
+	assert.Len(t, p.frames, 2)
+	assert.Contains(t, p.frames, int64(1))
+	assert.Contains(t, p.frames, int64(2))
+	p.m.Unlock()
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, append(buf, rest...))
+
+	// Frames skipped by a seek are dropped.
+	_, err = r.Seek(int64(len(expected))-1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	p.m.Lock()
+	assert.Empty(t, p.frames)
+	p.m.Unlock()
+
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	_, err = r.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Equal(t, expected, out.Bytes())
+}
+
+func TestReaderFrameCache(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = w.Write(bytes.Repeat([]byte{byte(i)}, 100))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithFrameCache(0))
+	require.ErrorContains(t, err, "must be positive")
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	assert.Equal(t, FrameCacheStats{}, r.CacheStats())
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithFrameCache(250))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1)
+	read := func(off int64) {
+		_, err := r.ReadAt(buf, off)
+		require.NoError(t, err)
+		assert.Equal(t, byte(off/100), buf[0])
+	}
+
+	read(0)
+	read(150)
+	read(50)
+	assert.Equal(t, FrameCacheStats{Hits: 1, Misses: 2, Frames: 2, Bytes: 200}, r.CacheStats())
+
+	// Frame 1 is the least recently used one.
+	read(250)
+	assert.Equal(t, FrameCacheStats{Hits: 1, Misses: 3, Evictions: 1, Frames: 2, Bytes: 200}, r.CacheStats())
+	read(0)
+	read(150)
+	assert.Equal(t, FrameCacheStats{Hits: 2, Misses: 4, Evictions: 2, Frames: 2, Bytes: 200}, r.CacheStats())
+
+	require.NoError(t, r.Close())
+	assert.Equal(t, FrameCacheStats{Hits: 2, Misses: 4, Evictions: 2}, r.CacheStats())
+}
+
+func TestReaderCompressedFrameCache(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = w.Write(bytes.Repeat([]byte{byte(i)}, 100))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithCompressedFrameCache(0))
+	require.ErrorContains(t, err, "must be positive")
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithCompressedFrameCache(1<<20))
+	require.NoError(t, err)
+	assert.Equal(t, FrameCacheStats{}, r.CacheStats())
+
+	buf := make([]byte, 1)
+	for i := 0; i < 2; i++ {
+		for off := int64(0); off < 400; off += 100 {
+			_, err := r.ReadAt(buf, off)
+			require.NoError(t, err)
+			assert.Equal(t, byte(off/100), buf[0])
+		}
+	}
+
+	var compSize int64
+	for id := int64(0); id < 4; id++ {
+		compSize += int64(r.(*readerImpl).GetIndexByID(id).CompSize)
+	}
+	assert.Equal(t, FrameCacheStats{Hits: 4, Misses: 4, Frames: 4, Bytes: compSize}, r.CompressedCacheStats())
+	// Frames served from the cache are decompressed again without reading them.
+	stats := r.Stats()
+	assert.Equal(t, compSize, stats.BytesRead)
+	assert.Equal(t, int64(8), stats.FramesDecoded)
+
+	require.NoError(t, r.Close())
+	assert.Zero(t, r.CompressedCacheStats().Bytes)
+}
+
+func TestReaderReadConcurrency(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+		if i == 4 {
+			require.NoError(t, w.WriteSkippableFrame(0, []byte("skip")))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithReadConcurrency(0))
+	require.ErrorContains(t, err, "must be positive")
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithReadConcurrency(4))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	buf := make([]byte, len(expected)-20)
+	n, err := r.ReadAt(buf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, expected[10:len(expected)-10], buf)
+
+	n, err = r.ReadAt(buf, 30)
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, len(expected)-30, n)
+	assert.Equal(t, expected[30:], buf[:n])
+
+	// Read fills the whole buffer.
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, append(buf, rest...))
+}
+
+func TestReaderFrame(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), r.NumFrames())
+
+	f, err := r.Frame(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), f.ID)
+	assert.Equal(t, int64(4), f.DecompressedOffset)
+	assert.Equal(t, int64(5), f.DecompressedSize)
+	assert.True(t, f.HasChecksum)
+	assert.Equal(t, frameChecksum([]byte("test2")), f.Checksum)
+
+	// The frame can be decompressed on its own.
+	first, err := r.Frame(0)
+	require.NoError(t, err)
+	assert.Equal(t, first.CompressedOffset+first.CompressedSize, f.CompressedOffset)
+	data, err := dec.DecodeAll(checksum[f.CompressedOffset:f.CompressedOffset+f.CompressedSize], nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test2"), data)
+
+	for _, id := range []int64{-1, 2} {
+		_, err = r.Frame(id)
+		assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+		_, err = r.DecodeFrame(id, nil)
+		assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	}
+
+	// DecodeFrame appends to dst.
+	data, err = r.DecodeFrame(1, []byte("frame: "))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("frame: test2"), data)
+	data, err = r.DecodeFrame(0, data[:0])
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test"), data)
+	require.NoError(t, r.Close())
+
+	corrupt := bytes.Clone(checksum)
+	corrupt[len(corrupt)-9-1] ^= 0xff
+	r, err = NewReader(bytes.NewReader(corrupt), dec)
+	require.NoError(t, err)
+	_, err = r.DecodeFrame(1, nil)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(noChecksum), dec)
+	require.NoError(t, err)
+	f, err = r.Frame(0)
+	require.NoError(t, err)
+	assert.False(t, f.HasChecksum)
+	require.NoError(t, r.Close())
+}
+
+func TestReaderOffsetTranslation(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	first, err := r.Frame(0)
+	require.NoError(t, err)
+	second, err := r.Frame(1)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		off   int64
+		frame FrameInfo
+	}{{0, first}, {3, first}, {4, second}, {8, second}} {
+		id, compOff, err := r.CompressedOffset(tc.off)
+		require.NoError(t, err)
+		assert.Equal(t, tc.frame.ID, id, "offset %d", tc.off)
+		assert.Equal(t, tc.frame.CompressedOffset, compOff, "offset %d", tc.off)
+	}
+	for _, off := range []int64{-1, 9} {
+		_, _, err = r.CompressedOffset(off)
+		assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	}
+
+	for _, tc := range []struct {
+		compOff int64
+		frame   FrameInfo
+	}{
+		{first.CompressedOffset, first},
+		{first.CompressedOffset + first.CompressedSize - 1, first},
+		{second.CompressedOffset, second},
+		{second.CompressedOffset + second.CompressedSize - 1, second},
+	} {
+		id, off, err := r.DecompressedOffset(tc.compOff)
+		require.NoError(t, err)
+		assert.Equal(t, tc.frame.ID, id, "compressed offset %d", tc.compOff)
+		assert.Equal(t, tc.frame.DecompressedOffset, off, "compressed offset %d", tc.compOff)
+	}
+	// The seek table is not within a frame.
+	for _, compOff := range []int64{-1, second.CompressedOffset + second.CompressedSize, int64(len(checksum))} {
+		_, _, err = r.DecompressedOffset(compOff)
+		assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	}
+}
+
+func TestReaderSection(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(&seekableBufferReaderAt{buf: checksum}, dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	var g errgroup.Group
+	for i := 0; i < 10; i++ {
+		off := int64(i % len(sourceString))
+		g.Go(func() error {
+			s := r.Section(off, 3)
+			all, err := io.ReadAll(s)
+			if err != nil {
+				return err
+			}
+			expected := sourceString[off:min(off+3, int64(len(sourceString)))]
+			if string(all) != expected {
+				return fmt.Errorf("section at %d: %q != %q", off, all, expected)
+			}
+			return nil
+		})
+	}
+	require.NoError(t, g.Wait())
+
+	// Sections do not move the offset of the reader.
+	offset, err := r.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+}
+
+// readerAtOnly hides all methods of the wrapped reader but ReadAt.
+type readerAtOnly struct {
+	ra io.ReaderAt
+}
+
+func (r readerAtOnly) ReadAt(p []byte, off int64) (int, error) {
+	return r.ra.ReadAt(p, off)
+}
+
+func TestNewReaderAt(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewReaderAt(readerAtOnly{bytes.NewReader(checksum)}, -1, dec)
+	require.ErrorContains(t, err, "must not be negative")
+
+	// The stream is a part of a bigger file.
+	file := append([]byte("prefix"), checksum...)
+	file = append(file, "suffix"...)
+	ra := io.NewSectionReader(readerAtOnly{bytes.NewReader(file)}, 6, int64(len(checksum)))
+	r, err := NewReaderAt(readerAtOnly{ra}, int64(len(checksum)), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+
+	buf := make([]byte, 5)
+	_, err = r.ReadAt(buf, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test2"), buf)
+}
+
+func TestReaderReadAtContext(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var noCtx context.Context
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithRContext(noCtx))
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	buf := make([]byte, len(sourceString))
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	_, err = r.ReadAtContext(ctx, buf, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	n, err := r.ReadAtContext(context.Background(), buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, sourceString, string(buf[:n]))
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(checksum), dec, WithRContext(ctx), WithReadConcurrency(2))
+	require.NoError(t, err)
+	_, err = r.ReadAt(buf, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = r.Read(buf)
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, r.Close())
+
+	// The deadline is propagated into the reads of the environment.
+	e := &stallingReadEnvironment{&readSeekerEnvImpl{rs: bytes.NewReader(checksum)}}
+	r, err = NewReader(nil, dec, WithREnvironment(e))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = r.ReadAtContext(ctx, buf, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReaderMaxDecompressedFrameSize(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithMaxDecompressedFrameSize(0))
+	require.Error(t, err)
+
+	// The seek table entry of the second frame is too big.
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithMaxDecompressedFrameSize(4))
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+
+	r, err := NewReader(bytes.NewReader(checksum), dec, WithMaxDecompressedFrameSize(5))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// patch sets the decompressed sizes in the seek table with checksums at the end of the stream.
+	patch := func(stream []byte, sizes ...uint32) []byte {
+		p := bytes.Clone(stream)
+		entries := len(p) - seekTableFooterOffset - len(sizes)*12
+		for i, size := range sizes {
+			binary.LittleEndian.PutUint32(p[entries+i*12+4:], size)
+		}
+		return p
+	}
+
+	// The seek table lies about the size of the frames.
+	r, err = NewReader(bytes.NewReader(patch(checksum, 2, 2)), dec, WithMaxDecompressedFrameSize(3))
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 2), 0)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+	require.NoError(t, r.Close())
+
+	// Frames with the declared content size are refused before decoding.
+	enc, err := zstd.NewWriter(nil, zstd.WithSingleSegment(true))
+	require.NoError(t, err)
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("a"), 100))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err = NewReader(bytes.NewReader(patch(b.Bytes(), 1)), dec, WithMaxDecompressedFrameSize(10))
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 1), 0)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+	assert.ErrorContains(t, err, "content size")
+	require.NoError(t, r.Close())
+}
+
+func TestReaderMemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	const frames, frameSize = 10, 1 << 10
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < frames; i++ {
+		frame := bytes.Repeat(makeTestFrame(t, i), 2)[:frameSize]
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithReaderMemoryLimit(0))
+	require.Error(t, err)
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithReaderMemoryLimit(frames*bucketEntrySize))
+	require.ErrorIs(t, err, ErrMemoryLimit)
+
+	// The index fits, but frames do not.
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithReaderMemoryLimit(frames*bucketEntrySize+frameSize))
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 1), 0)
+	require.ErrorIs(t, err, ErrMemoryLimit)
+	require.NoError(t, r.Close())
+
+	// Room for three frames.
+	limit := frames*bucketEntrySize + 3*2*frameSize
+	for _, tc := range []struct {
+		name string
+		opts []rOption
+	}{
+		{"default", nil},
+		{"cache", []rOption{WithFrameCache(1 << 20)}},
+		{"prefetch", []rOption{WithPrefetch(4), WithFrameCache(1 << 20)}},
+		{"concurrent", []rOption{WithReadConcurrency(4), WithPrefetch(2)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(b.Bytes()), dec, append(tc.opts, WithReaderMemoryLimit(limit))...)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, r.Close()) }()
+
+			all, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, expected, all)
+
+			var g errgroup.Group
+			for i := 0; i < 16; i++ {
+				off := int64(i * 613 % len(expected))
+				g.Go(func() error {
+					buf := make([]byte, 3*frameSize)
+					n, err := r.ReadAt(buf, off)
+					if err != nil && !errors.Is(err, io.EOF) {
+						return err
+					}
+					if !bytes.Equal(expected[off:off+int64(n)], buf[:n]) {
+						return fmt.Errorf("data mismatch at %d", off)
+					}
+					return nil
+				})
+			}
+			require.NoError(t, g.Wait())
+
+			var sb strings.Builder
+			_, err = r.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			_, err = io.Copy(&sb, r)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), sb.String())
+
+			m := r.(*readerImpl).memory
+			m.m.Lock()
+			defer m.m.Unlock()
+			assert.LessOrEqual(t, m.used, m.limit)
+			assert.LessOrEqual(t, r.CacheStats().Bytes, m.used)
+		})
+	}
+}
+
+func TestReaderMmapEnvironment(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	name := filepath.Join(t.TempDir(), "test.zst")
+	require.NoError(t, os.WriteFile(name, checksum, 0o600))
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	e, err := env.NewMmapREnvironment(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	if runtime.GOOS == "linux" {
+		assert.True(t, e.Mapped())
+	}
+
+	r, err := NewReader(nil, dec, WithREnvironment(e), WithReadConcurrency(2))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+
+	_, err = e.GetFrameByIndex(env.FrameOffsetEntry{CompOffset: uint64(len(checksum)), CompSize: 1})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	require.NoError(t, r.Close())
+	require.NoError(t, e.Close())
+}
+
+func TestReaderFrameBytes(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec, WithReaderMemoryLimit(1<<10))
+	require.NoError(t, err)
+
+	_, _, err = r.FrameBytes(2)
+	require.ErrorIs(t, err, ErrOffsetOutOfRange)
+
+	data, release, err := r.FrameBytes(1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test2"), data)
+	m := r.(*readerImpl).memory
+	assert.Equal(t, int64(5), m.used)
+	release()
+	release()
+	assert.Equal(t, int64(0), m.used)
+	require.NoError(t, r.Close())
+	_, _, err = r.FrameBytes(0)
+	require.Error(t, err)
+
+	// Frames are shared with the cache.
+	r, err = NewReader(bytes.NewReader(checksum), dec, WithFrameCache(1<<10))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	for i := 0; i < 2; i++ {
+		data, release, err = r.FrameBytes(0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test"), data)
+		release()
+	}
+	assert.Equal(t, int64(1), r.CacheStats().Hits)
+}
+
+func TestReaderStats(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	assert.Equal(t, ReaderStats{}, r.Stats())
+
+	buf := make([]byte, 2)
+	for _, off := range []int64{0, 2, 4} {
+		_, err = r.ReadAt(buf, off)
+		require.NoError(t, err)
+	}
+	stats := r.Stats()
+	assert.Equal(t, int64(2), stats.FramesDecoded)
+	assert.Equal(t, int64(1), stats.CacheHits)
+	assert.Equal(t, int64(2), stats.CacheMisses)
+	assert.Zero(t, stats.ChecksumFailures)
+	assert.Positive(t, stats.BytesRead)
+	assert.Positive(t, stats.DecodeTime)
+
+	// Corrupt the checksum of the last frame.
+	corrupted := bytes.Clone(checksum)
+	corrupted[len(corrupted)-seekTableFooterOffset-1] ^= 0xff
+	r2, err := NewReader(bytes.NewReader(corrupted), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r2.Close()) }()
+	_, err = r2.ReadAt(buf, 4)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, int64(1), r2.Stats().ChecksumFailures)
+}
+
+// dictDecoder decompresses frames with the dictionary passed on each call.
+type dictDecoder struct {
+	m    sync.Mutex
+	dict []uint32
+}
+
+func (d *dictDecoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	return nil, errors.New("dictionary is not passed")
+}
+
+func (d *dictDecoder) DecodeAllDict(input, dst, dict []byte) ([]byte, error) {
+	d.m.Lock()
+	d.dict = append(d.dict, binary.LittleEndian.Uint32(dict[4:]))
+	d.m.Unlock()
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(input, dst)
+}
+
+func TestReaderDictionaries(t *testing.T) {
+	t.Parallel()
+
+	samples, dict := makeTestDict(t, 0x1234)
+	_, other := makeTestDict(t, 0x99)
+	expected := bytes.Join(samples[:8], nil)
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderDict(dict))
+	require.NoError(t, err)
+	write := func(opts ...wOption) []byte {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, opts...)
+		require.NoError(t, err)
+		for _, sample := range samples[:8] {
+			_, err = w.Write(sample)
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		return b.Bytes()
+	}
+	stream := write(WithDictionary(dict))
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewReader(bytes.NewReader(stream), dec, WithDictionaries([]byte("not a dictionary")))
+	require.ErrorContains(t, err, "not in zstd format")
+
+	// The dictionary is discovered without WithDictionaries.
+	r, err := NewReader(bytes.NewReader(stream), dec)
+	require.NoError(t, err)
+	id, err := r.DictionaryID()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234), id)
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	id, err = r.DictionaryID()
+	require.NoError(t, err)
+	assert.Zero(t, id)
+	require.NoError(t, r.Close())
+
+	// The recorded dictionary is missing.
+	_, err = NewReader(bytes.NewReader(stream), dec, WithDictionaries(other))
+	require.ErrorIs(t, err, ErrDictionaryNotFound)
+	_, err = NewReader(bytes.NewReader(stream), dec, WithDictionaries())
+	require.ErrorIs(t, err, ErrDictionaryNotFound)
+
+	// The decoder is configured with the dictionary.
+	r, err = NewReader(bytes.NewReader(stream), dec, WithDictionaries(other, dict))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+	require.NoError(t, r.Close())
+
+	// The dictionary is passed to the decoder.
+	dd := &dictDecoder{}
+	r, err = NewReader(bytes.NewReader(stream), dd, WithDictionaries(dict))
+	require.NoError(t, err)
+	all, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+	assert.Equal(t, []uint32{0x1234}, slices.Compact(dd.dict))
+	require.NoError(t, r.Close())
+
+	// Frames are checked even if the dictionary is not recorded.
+	r, err = NewReader(bytes.NewReader(write()), dec, WithDictionaries(other))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrDictionaryNotFound)
+}
+
+func TestReaderSkipCorruptFrames(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var frames [][]byte
+	for i := 0; i < 3; i++ {
+		frames = append(frames, makeTestFrame(t, i))
+		_, err = w.Write(frames[i])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithSkipCorruptFrames(nil))
+	require.Error(t, err)
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	damaged, err := r.Frame(1)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// Damage the data of the middle frame.
+	stream := bytes.Clone(b.Bytes())
+	for i := damaged.CompressedOffset + 8; i < damaged.CompressedOffset+damaged.CompressedSize-4; i++ {
+		stream[i] ^= 0x5a
+	}
+
+	r, err = NewReader(bytes.NewReader(stream), dec)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+	require.NoError(t, r.Close())
+
+	var m sync.Mutex
+	var reported []FrameInfo
+	for _, opts := range [][]rOption{
+		nil,
+		{WithReadConcurrency(3)},
+		{WithWriteToConcurrency(3)},
+	} {
+		reported = nil
+		opts = append(opts, WithSkipCorruptFrames(func(frame FrameInfo, err error) {
+			assert.Error(t, err)
+			m.Lock()
+			reported = append(reported, frame)
+			m.Unlock()
+		}))
+		r, err = NewReader(bytes.NewReader(stream), dec, opts...)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		_, err = io.Copy(&out, r)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Join([][]byte{frames[0], make([]byte, len(frames[1])), frames[2]}, nil), out.Bytes())
+		m.Lock()
+		assert.Equal(t, []FrameInfo{damaged}, reported)
+		m.Unlock()
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderValidate(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	report, err := r.Validate()
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	require.NoError(t, report.Err())
+	assert.Equal(t, int64(2), report.NumFrames)
+	assert.Equal(t, int64(2), report.IndexedFrames)
+	assert.Equal(t, int64(len(sourceString)), report.DecompressedSize)
+	assert.Equal(t, int64(len(checksum)), report.StreamSize)
+	assert.Equal(t, report.StreamSize, report.CompressedSize+report.SeekTableSize)
+	assert.True(t, report.HasChecksums)
+	require.NoError(t, r.Close())
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(1, []byte("skippable")))
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	stream := b.Bytes()
+	tableSize := frameSizeFieldSize + skippableMagicNumberFieldSize + 3*12 + seekTableFooterOffset
+	seekTable := stream[len(stream)-tableSize:]
+
+	// The checksum of the skippable frame is damaged.
+	damaged := bytes.Clone(stream)
+	damaged[len(damaged)-tableSize+8+12+8] ^= 0xff
+	r, err = NewReader(bytes.NewReader(damaged), dec)
+	require.NoError(t, err)
+	report, err = r.Validate()
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, int64(1), report.Problems[0].Frame)
+	assert.ErrorIs(t, report.Err(), ErrCorruptSeekTable)
+	require.NoError(t, r.Close())
+
+	// The footer declares more frames than there are entries.
+	table := bytes.Clone(seekTable)
+	binary.LittleEndian.PutUint32(table[len(table)-seekTableFooterOffset:], 5)
+	r, err = NewReader(bytes.NewReader(stream), dec, WithSeekTableBytes(table))
+	require.NoError(t, err)
+	report, err = r.Validate()
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, int64(-1), report.Problems[0].Frame)
+	assert.Equal(t, int64(5), report.NumFrames)
+	assert.Equal(t, int64(3), report.IndexedFrames)
+	require.NoError(t, r.Close())
+
+	// The seek table does not describe the stream.
+	r, err = NewReader(bytes.NewReader(stream[:len(stream)-tableSize-1]), dec, WithSeekTableBytes(seekTable))
+	require.NoError(t, err)
+	report, err = r.Validate()
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.ErrorContains(t, report.Err(), "stream size")
+	require.NoError(t, r.Close())
+
+	_, err = r.Validate()
+	require.Error(t, err)
+}
+
+func TestReaderVerifyAll(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(1, []byte("skippable")))
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	report, err := r.VerifyAll(context.Background(), VerifyOptions{Decode: true, Concurrency: 4})
+	require.NoError(t, err)
+	require.NoError(t, report.Err())
+	assert.Equal(t, int64(3), report.SeekTable.IndexedFrames)
+	assert.Equal(t, int64(3), report.FramesChecked)
+	assert.Equal(t, int64(3), report.FramesDecoded)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.VerifyAll(ctx, VerifyOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, r.Close())
+	_, err = r.VerifyAll(context.Background(), VerifyOptions{})
+	require.Error(t, err)
+
+	// The checksum of the second frame is damaged, it is only found by decoding, even if
+	// the reads do not verify checksums.
+	corrupt := bytes.Clone(checksum)
+	corrupt[len(corrupt)-9-1] ^= 0xff
+	for _, opts := range [][]rOption{nil, {WithChecksumVerification(false)}} {
+		r, err = NewReader(bytes.NewReader(corrupt), dec, opts...)
+		require.NoError(t, err)
+		report, err = r.VerifyAll(context.Background(), VerifyOptions{})
+		require.NoError(t, err)
+		require.NoError(t, report.Err())
+		assert.Equal(t, int64(2), report.FramesChecked)
+		assert.Zero(t, report.FramesDecoded)
+
+		report, err = r.VerifyAll(context.Background(), VerifyOptions{Decode: true})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		assert.Equal(t, int64(1), report.Problems[0].Frame)
+		assert.ErrorIs(t, report.Err(), ErrChecksumMismatch)
+		assert.Equal(t, int64(2), report.FramesChecked)
+		assert.Equal(t, int64(1), report.FramesDecoded)
+		require.NoError(t, r.Close())
+	}
+
+	// The stream is truncated, the last frame can not be decoded.
+	tableSize := frameSizeFieldSize + skippableMagicNumberFieldSize + 2*12 + seekTableFooterOffset
+	r, err = NewReader(bytes.NewReader(checksum[:len(checksum)-tableSize-1]), dec,
+		WithSeekTableBytes(checksum[len(checksum)-tableSize:]))
+	require.NoError(t, err)
+	report, err = r.VerifyAll(context.Background(), VerifyOptions{Decode: true, Concurrency: 2})
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, int64(1), report.Problems[0].Frame)
+	assert.ErrorContains(t, report.SeekTable.Err(), "stream size")
+	require.NoError(t, r.Close())
+}
+
+func TestReaderVerifyContentDigestOnOpen(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var last env.FrameOffsetEntry
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithContentDigest(), WithOnFrameWritten(func(frame env.FrameOffsetEntry) {
+		last = frame
+	}))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = w.Write(makeTestFrame(t, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithVerifyContentDigest(), WithPrefetch(2))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join([][]byte{makeTestFrame(t, 0), makeTestFrame(t, 1), makeTestFrame(t, 2)}, nil), all)
+	require.NoError(t, r.Close())
+
+	// Tampered digest.
+	corrupt := bytes.Clone(b.Bytes())
+	corrupt[last.CompOffset+uint64(last.CompSize)-1] ^= 0xff
+	_, err = NewReader(bytes.NewReader(corrupt), dec, WithVerifyContentDigest(), WithPrefetch(2))
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	// Streams without a digest are not verified.
+	r, err = NewReader(bytes.NewReader(checksum), dec, WithVerifyContentDigest())
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// Decoder has the seek table only.
+	_, err = NewDecoder(checksum[len(checksum)-seekTableFooterOffset-8-2*12:], dec, WithVerifyContentDigest())
+	assert.ErrorContains(t, err, "without the stream")
+}
+
+func TestReaderSkippableFrames(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithContentDigest(), WithSeekTableCheckpointEvery(1), WithAllowEmptyFrames(true))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(0, []byte("first")))
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write(nil)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(0xF, []byte("second")))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	var frames []SkippableFrame
+	require.NoError(t, r.SkippableFrames(func(frame SkippableFrame) error {
+		frames = append(frames, frame)
+		return nil
+	}))
+	require.Len(t, frames, 2)
+	assert.Equal(t, SkippableFrame{ID: 0, Tag: 0, Offset: 0, Payload: []byte("first")}, frames[0])
+	assert.Equal(t, uint32(0xF), frames[1].Tag)
+	assert.Equal(t, []byte("second"), frames[1].Payload)
+	assert.Equal(t, binary.LittleEndian.Uint32(b.Bytes()[frames[1].Offset:]), skippableFrameMagic+0xF)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = r.SkippableFrames(func(SkippableFrame) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestReaderClone(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	frameSize := int64(len(makeTestFrame(t, 0)))
+	for _, opts := range [][]rOption{
+		nil,
+		{WithFrameCache(4 * frameSize), WithPrefetch(2)},
+		{WithPrefetch(2), WithReaderMemoryLimit(20*bucketEntrySize + 8*frameSize)},
+		{WithFrameCache(4 * frameSize), WithReaderMemoryLimit(20*bucketEntrySize + 8*frameSize)},
+	} {
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, opts...)
+		require.NoError(t, err)
+		_, err = r.Seek(frameSize*3, io.SeekStart)
+		require.NoError(t, err)
+
+		clones := make([]Reader, 4)
+		for i := range clones {
+			clones[i], err = r.Clone(nil)
+			require.NoError(t, err)
+		}
+		clone := clones[0].(*readerImpl)
+		assert.Same(t, r.(*readerImpl).cache, clone.cache)
+		assert.Same(t, r.(*readerImpl).memory, clone.memory)
+		offset, err := clone.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Zero(t, offset)
+		assert.Zero(t, clone.Stats())
+
+		var wg sync.WaitGroup
+		for i, c := range clones {
+			wg.Add(1)
+			go func(off int64, c Reader) {
+				defer wg.Done()
+
+				_, err := c.Seek(off, io.SeekStart)
+				assert.NoError(t, err)
+				data, err := io.ReadAll(c)
+				assert.NoError(t, err)
+				assert.Equal(t, expected[off:], data)
+			}(int64(i)*frameSize+int64(i), c)
+		}
+		wg.Wait()
+
+		// The reader keeps its own offset, and clones keep working once it is closed.
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected[frameSize*3:], data)
+		require.NoError(t, r.Close())
+		_, err = r.Clone(nil)
+		assert.ErrorContains(t, err, "closed")
+
+		p := make([]byte, 10)
+		_, err = clones[1].ReadAt(p, 5)
+		require.NoError(t, err)
+		assert.Equal(t, expected[5:15], p)
+
+		for _, c := range clones {
+			require.NoError(t, c.Close())
+		}
+		if m := clone.memory; m != nil {
+			m.m.Lock()
+			assert.Zero(t, m.used)
+			assert.Empty(t, m.prefetched)
+			assert.Empty(t, m.retained)
+			m.m.Unlock()
+		}
+		if clone.cache != nil {
+			assert.Zero(t, clone.cache.getStats().Bytes)
+		}
+	}
+}
+
+// exclusiveDecoder fails if it is used by more than one goroutine at a time.
+type exclusiveDecoder struct {
+	dec   ZSTDDecoder
+	inUse atomic.Bool
+}
+
+func (d *exclusiveDecoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	if !d.inUse.CompareAndSwap(false, true) {
+		return nil, errors.New("decoder is used concurrently")
+	}
+	defer d.inUse.Store(false)
+	return d.dec.DecodeAll(input, dst)
+}
+
+func TestReaderConcurrentReadAt(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 50; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), nil)
+	require.ErrorContains(t, err, "decoder is nil")
+	_, err = NewReader(bytes.NewReader(b.Bytes()), nil, WithDecoderFactory(nil))
+	require.ErrorContains(t, err, "decoder factory is nil")
+
+	var decoders atomic.Int64
+	factory := WithDecoderFactory(func() ZSTDDecoder {
+		decoders.Add(1)
+		return &exclusiveDecoder{dec: dec}
+	})
+	frameSize := int64(len(makeTestFrame(t, 0)))
+	for _, tc := range []struct {
+		rs   io.ReadSeeker
+		dec  ZSTDDecoder
+		opts []rOption
+	}{
+		{rs: bytes.NewReader(b.Bytes()), dec: dec},
+		{rs: bytes.NewReader(b.Bytes()), opts: []rOption{factory}},
+		{rs: bytes.NewReader(b.Bytes()), opts: []rOption{factory, WithFrameCache(8 * frameSize), WithPrefetch(2)}},
+		{rs: bytes.NewReader(b.Bytes()), opts: []rOption{factory, WithReadConcurrency(4), WithReaderMemoryLimit(100*bucketEntrySize + 16*frameSize)}},
+		// Reads of the underlying reader are serialized without io.ReaderAt.
+		{rs: &seekableBufferReader{seekableBufferReaderAt{buf: b.Bytes()}}, opts: []rOption{factory, WithLazySeekTable()}},
+	} {
+		r, err := NewReader(tc.rs, tc.dec, tc.opts...)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+
+				rng := rand.New(rand.NewSource(seed))
+				for i := 0; i < 50; i++ {
+					off := rng.Int63n(int64(len(expected)))
+					p := make([]byte, rng.Int63n(3*frameSize))
+					n, err := r.ReadAt(p, off)
+					if int(off)+len(p) > len(expected) {
+						assert.ErrorIs(t, err, io.EOF)
+					} else if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, expected[off:off+int64(n)], p[:n])
+				}
+			}(int64(g))
+		}
+		// Sequential reads do not interfere with concurrent ReadAt.
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, expected, data)
+		wg.Wait()
+		require.NoError(t, r.Close())
+	}
+	assert.Positive(t, decoders.Load())
+}
+
+// intoDecoder implements ZSTDIntoDecoder on top of ZSTDDecoder and fails DecodeAll.
+type intoDecoder struct {
+	dec   ZSTDDecoder
+	short bool
+}
+
+func (d *intoDecoder) DecodeAll([]byte, []byte) ([]byte, error) {
+	return nil, errors.New("DecodeAll must not be used")
+}
+
+func (d *intoDecoder) DecodeAllInto(dst, src []byte) (int, error) {
+	out, err := d.dec.DecodeAll(src, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > len(dst) {
+		return 0, errors.New("frame does not fit")
+	}
+	n := copy(dst, out)
+	if d.short {
+		n--
+	}
+	return n, nil
+}
+
+func TestReaderIntoDecoder(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 3; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), &intoDecoder{dec: dec})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, data)
+
+	// Frames are decompressed into the spare capacity of the caller's buffer.
+	buf := make([]byte, 4, 4+len(makeTestFrame(t, 1)))
+	copy(buf, "test")
+	out, err := r.DecodeFrame(1, buf)
+	require.NoError(t, err)
+	assert.Same(t, &buf[0], &out[0])
+	assert.Equal(t, []byte("test"), out[:4])
+	assert.Equal(t, makeTestFrame(t, 1), out[4:])
+
+	r, err = NewReader(bytes.NewReader(b.Bytes()), &intoDecoder{dec: dec, short: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	_, err = r.DecodeFrame(0, nil)
+	assert.ErrorIs(t, err, ErrCorruptSeekTable)
+}
+
+// flipReader flips the bits of the last byte it reads.
+type flipReader struct {
+	io.ReadCloser
+}
+
+func (f flipReader) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if n > 0 {
+		p[n-1] ^= 0xFF
+	}
+	return n, err
+}
+
+func TestReaderStreamingDecode(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 3; i++ {
+		var frame []byte
+		for j := 0; j < 100; j++ {
+			frame = append(frame, makeTestFrame(t, i*100+j)...)
+		}
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+		expected = append(expected, frame...)
+		// Small frames are decompressed as usual.
+		_, err = w.Write(makeTestFrame(t, i))
+		require.NoError(t, err)
+		expected = append(expected, makeTestFrame(t, i)...)
+	}
+	require.NoError(t, w.Close())
+
+	var streams atomic.Int64
+	newReader := func(src io.Reader) (io.ReadCloser, error) {
+		streams.Add(1)
+		d, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithStreamingDecode(0, newReader))
+	require.ErrorContains(t, err, "must be positive")
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithStreamingDecode(1, nil))
+	require.ErrorContains(t, err, "is nil")
+
+	// The big frames do not fit into the memory limit as a whole.
+	limit := WithReaderMemoryLimit(6*bucketEntrySize + 20<<10)
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, limit)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrMemoryLimit)
+	require.NoError(t, r.Close())
+
+	for _, opts := range [][]rOption{
+		{limit, WithPrefetch(2)},
+		{limit, WithFrameCache(8 << 10), WithReadConcurrency(4), WithWriteToConcurrency(4)},
+	} {
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, append(opts, WithStreamingDecode(10<<10, newReader))...)
+		require.NoError(t, err)
+
+		// Sequential reads continue the stream of the frame.
+		streams.Store(0)
+		var out bytes.Buffer
+		_, err = io.CopyBuffer(struct{ io.Writer }{&out}, struct{ io.Reader }{r}, make([]byte, 1000))
+		require.NoError(t, err)
+		assert.Equal(t, expected, out.Bytes())
+		assert.Equal(t, int64(3), streams.Load())
+
+		p := make([]byte, 30<<10)
+		for _, off := range []int64{0, 10, 70 << 10, 50 << 10, int64(len(expected)) - 100} {
+			n, err := r.ReadAt(p, off)
+			if int(off)+len(p) > len(expected) {
+				require.ErrorIs(t, err, io.EOF)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, expected[off:off+int64(n)], p[:n])
+		}
+
+		_, err = r.Seek(100, io.SeekStart)
+		require.NoError(t, err)
+		out.Reset()
+		n, err := r.WriteTo(&out)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(expected)-100), n)
+		assert.Equal(t, expected[100:], out.Bytes())
+
+		m := r.(*readerImpl).memory
+		require.NoError(t, r.Close())
+		m.m.Lock()
+		assert.Zero(t, m.used)
+		m.m.Unlock()
+	}
+
+	r, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithStreamingDecode(10<<10, func(src io.Reader) (io.ReadCloser, error) {
+		rc, err := newReader(src)
+		return flipReader{rc}, err
+	}))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = r.WriteTo(io.Discard)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, int64(2), r.Stats().ChecksumFailures)
+}
+
+func TestReaderAdvise(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	frameSize := int64(len(makeTestFrame(t, 0)))
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithPrefetch(1), WithFrameCache(4*frameSize))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	sr := r.(*readerImpl)
+
+	assert.ErrorIs(t, r.Advise(-1, 0, AccessRandom), ErrOffsetOutOfRange)
+	assert.ErrorContains(t, r.Advise(0, 0, AccessHint(42)), "unknown access hint")
+	assert.Equal(t, "AccessHint(42)", AccessHint(42).String())
+	assert.NoError(t, r.Advise(int64(len(expected)), 10, AccessRandom))
+	assert.Empty(t, sr.hints.ranges)
+
+	at := func(id int64) int64 { return int64(sr.GetIndexByID(id).DecompOffset) }
+	prefetched := func() []int64 {
+		sr.prefetcher.wg.Wait()
+		sr.prefetcher.m.Lock()
+		defer sr.prefetcher.m.Unlock()
+		var ids []int64
+		for id := range sr.prefetcher.frames {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	p := make([]byte, 10)
+
+	require.NoError(t, r.Advise(0, at(3), AccessRandom))
+	require.NoError(t, r.Advise(at(5), 0, AccessSequential))
+	_, err = r.ReadAt(p, at(1))
+	require.NoError(t, err)
+	assert.Empty(t, prefetched())
+	_, err = r.ReadAt(p, at(5))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{6, 7}, prefetched())
+	_, err = r.ReadAt(p, at(3)+5)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4}, prefetched())
+
+	// Later hints replace the earlier ones.
+	require.NoError(t, r.Advise(at(1), at(2)-at(1), AccessNormal))
+	assert.Equal(t, AccessRandom, sr.hints.get(0))
+	assert.Equal(t, AccessNormal, sr.hints.get(uint64(at(1))))
+	assert.Equal(t, AccessRandom, sr.hints.get(uint64(at(2))))
+	assert.Equal(t, AccessSequential, sr.hints.get(uint64(len(expected)-1)))
+
+	require.NoError(t, r.Advise(0, 0, AccessDontNeed))
+	assert.Empty(t, prefetched())
+	assert.Zero(t, r.CacheStats().Frames)
+
+	require.NoError(t, r.Advise(at(1)+1, at(3)-at(1), AccessWillNeed))
+	sr.advised.Wait()
+	assert.Equal(t, 3, r.CacheStats().Frames)
+	hits := r.CacheStats().Hits
+	_, err = r.ReadAt(p, at(3))
+	require.NoError(t, err)
+	assert.Equal(t, expected[at(3):at(3)+10], p)
+	assert.Equal(t, hits+1, r.CacheStats().Hits)
+
+	require.NoError(t, r.Advise(at(2), 1, AccessDontNeed))
+	assert.Equal(t, 2, r.CacheStats().Frames)
+}
+
+func TestReaderReset(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	write := func(frames int, tag []byte) ([]byte, []byte) {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc)
+		require.NoError(t, err)
+		var expected []byte
+		for i := 0; i < frames; i++ {
+			frame := makeTestFrame(t, i*frames)
+			expected = append(expected, frame...)
+			if tag != nil {
+				_, err = w.WriteTagged(frame, tag)
+			} else {
+				_, err = w.Write(frame)
+			}
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		return b.Bytes(), expected
+	}
+	first, firstExpected := write(3, []byte("first"))
+	second, secondExpected := write(5, nil)
+
+	r, err := NewReader(bytes.NewReader(first), dec,
+		WithFrameCache(1<<20), WithPrefetch(2), WithReaderMemoryLimit(1<<20))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	sr := r.(*readerImpl)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, firstExpected, data)
+	tag, err := r.FrameTag(0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), tag)
+
+	require.NoError(t, r.Reset(bytes.NewReader(second)))
+	assert.Zero(t, r.Stats())
+	assert.Zero(t, r.CacheStats().Frames)
+	assert.Equal(t, int64(5), r.NumFrames())
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, secondExpected, data)
+	tag, err = r.FrameTag(0)
+	require.NoError(t, err)
+	assert.Nil(t, tag)
+
+	// Prefetching is disabled for the streams without io.ReaderAt only.
+	require.NoError(t, r.Reset(&seekableBufferReader{seekableBufferReaderAt{buf: first}}))
+	assert.Nil(t, sr.prefetcher)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, firstExpected, data)
+	require.NoError(t, r.Reset(bytes.NewReader(second)))
+	assert.NotNil(t, sr.prefetcher)
+
+	clone, err := r.Clone(nil)
+	require.NoError(t, err)
+	assert.ErrorContains(t, r.Reset(bytes.NewReader(first)), "clones")
+	require.NoError(t, clone.Close())
+
+	// The reader is closed if the new stream can not be opened.
+	err = r.Reset(bytes.NewReader([]byte("test")))
+	require.Error(t, err)
+	_, err = r.Read(make([]byte, 1))
+	assert.ErrorContains(t, err, "reader is closed")
+	assert.ErrorContains(t, r.Reset(bytes.NewReader(first)), "reader is closed")
+	sr.memory.m.Lock()
+	assert.Zero(t, sr.memory.used)
+	sr.memory.m.Unlock()
+
+	d, err := NewDecoder(checksum[17+18:], dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	assert.ErrorContains(t, d.(Reader).Reset(bytes.NewReader(first)), "custom environment")
+}
+
+func TestReaderStrictFormat(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	for _, bits := range []byte{0x04, 0x20, 0x01} {
+		t.Run(fmt.Sprintf("bits=%#x", bits), func(t *testing.T) {
+			stream := slices.Clone(checksum)
+			// The descriptor is the 5th byte from the end of the stream.
+			stream[len(stream)-5] |= bits
+
+			r, err := NewReader(bytes.NewReader(stream), dec)
+			require.NoError(t, err)
+			all, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, sourceString, string(all))
+			require.NoError(t, r.Close())
+
+			_, err = NewReader(bytes.NewReader(stream), dec, WithStrictFormat())
+			require.ErrorIs(t, err, ErrCorruptSeekTable)
+		})
+	}
+
+	r, err := NewReader(bytes.NewReader(checksum), dec, WithStrictFormat())
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+}
+
+// growingBuffer is a stream that is read while it is still being written.
+type growingBuffer struct {
+	m   sync.Mutex
+	buf []byte
+	off int64
+}
+
+func (b *growingBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *growingBuffer) ReadAt(p []byte, off int64) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if off >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *growingBuffer) Read(p []byte) (int, error) {
+	n, err := b.ReadAt(p, b.off)
+	b.off += int64(n)
+	return n, err
+}
+
+func (b *growingBuffer) Seek(offset int64, whence int) (int64, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += int64(len(b.buf))
+	}
+	b.off = offset
+	return offset, nil
+}
+
+func TestReaderFollow(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithFollow(0))
+	require.ErrorContains(t, err, "must be positive")
+
+	var b growingBuffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte{'a'}, 100))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := NewReader(&b, dec, WithFollow(time.Millisecond), WithRContext(ctx))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	grown, err := r.Refresh()
+	require.NoError(t, err)
+	assert.False(t, grown)
+
+	// The frame being written is not accessible until the next checkpoint.
+	_, err = w.Write(bytes.Repeat([]byte{'b'}, 100))
+	require.NoError(t, err)
+	grown, err = r.Refresh()
+	require.NoError(t, err)
+	assert.False(t, grown)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, w.Flush())
+	}()
+
+	buf := make([]byte, 200)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{'a'}, 100), buf[:100])
+	assert.Equal(t, bytes.Repeat([]byte{'b'}, 100), buf[100:])
+	end, err := r.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), end)
+	_, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	// WriteTo waits for new frames as well until the context is done.
+	var out bytes.Buffer
+	n, err := io.Copy(&cancelWriter{w: &out, n: 200, cancel: cancel}, r)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(200), n)
+	assert.Equal(t, buf, out.Bytes())
+}
+
+func TestReaderRefreshConcurrent(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b growingBuffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	first := bytes.Repeat([]byte{'a'}, 100)
+	_, err = w.Write(first)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&b, dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	sr := r.(*readerImpl)
+
+	// Reads see a consistent index while it is refreshed.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, len(first))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				n, err := r.ReadAt(buf, 0)
+				if !assert.NoError(t, err) || !assert.Equal(t, first, buf[:n]) {
+					return
+				}
+				idx := sr.idx()
+				assert.Equal(t, idx.endOffset, int64(len(first))*idx.numFrames)
+			}
+		}()
+	}
+	for i := 1; i < 20; i++ {
+		_, err = w.Write(first)
+		require.NoError(t, err)
+		require.NoError(t, w.Flush())
+		grown, err := r.Refresh()
+		require.NoError(t, err)
+		assert.True(t, grown)
+	}
+	close(done)
+	wg.Wait()
+	assert.Equal(t, int64(20*len(first)), sr.idx().endOffset)
+
+	// A corrupt tail leaves the index as it is.
+	before := sr.idx()
+	_, err = b.Write([]byte("garbage"))
+	require.NoError(t, err)
+	grown, err := r.Refresh()
+	require.NoError(t, err)
+	assert.False(t, grown)
+	assert.Same(t, before, sr.idx())
+}
+
+// stallingReadEnvironment is the environment whose frame reads wait until their context is done.
+type stallingReadEnvironment struct {
+	env.REnvironment
+}
+
+func (e *stallingReadEnvironment) GetFrameByIndexContext(ctx context.Context, _ env.FrameOffsetEntry) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// cancelWriter calls cancel once n bytes are written to w.
+type cancelWriter struct {
+	w      io.Writer
+	n      int
+	cancel func()
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if w.n -= n; w.n <= 0 {
+		w.cancel()
+	}
+	return n, err
+}
+
+// countingReader counts the ReadAt calls of the wrapped reader.
+type countingReader struct {
+	*bytes.Reader
+	reads atomic.Int64
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	r.reads.Inc()
+	return r.Reader.ReadAt(p, off)
+}
+
+func TestReaderReadRanges(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 100)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		expected = append(expected, data...)
+	}
+	require.NoError(t, w.Close())
+
+	for _, concurrency := range []int{1, 4} {
+		rs := &countingReader{Reader: bytes.NewReader(b.Bytes())}
+		r, err := NewReader(rs, dec, WithReadConcurrency(concurrency))
+		require.NoError(t, err)
+
+		ranges := []Range{
+			{Offset: 0, Length: 50},
+			{Offset: 50, Length: 100},
+			{Offset: 120, Length: 10},
+			{Offset: 300, Length: 0},
+			{Offset: 500, Length: 250},
+			{Offset: 990, Length: 10},
+		}
+		reads := rs.reads.Load()
+		out, err := r.ReadRanges(ranges)
+		require.NoError(t, err)
+		require.Len(t, out, len(ranges))
+		for i, rng := range ranges {
+			assert.Equal(t, expected[rng.Offset:rng.Offset+rng.Length], out[i], "range %d", i)
+		}
+		// Frames 0-1, 5-7 and 9 are read at once each, and every frame is decompressed once.
+		assert.Equal(t, int64(3), rs.reads.Load()-reads)
+		assert.Equal(t, int64(6), r.Stats().FramesDecoded)
+
+		_, err = r.ReadRanges([]Range{{Offset: 990, Length: 11}})
+		require.ErrorIs(t, err, ErrOffsetOutOfRange)
+		_, err = r.ReadRanges([]Range{{Offset: -1, Length: 1}})
+		require.ErrorIs(t, err, ErrOffsetOutOfRange)
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderCheckpointFallback(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+	stream := b.Bytes()
+	// Two data frames and the checkpoint.
+	seekTableSize := 8 + 3*12 + 9
+
+	// Truncated stream is read up to the latest checkpoint.
+	r, err := NewReader(bytes.NewReader(stream[:len(stream)-1]), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// Corrupt seek table is not replaced by the checkpoint.
+	corrupt := bytes.Clone(stream)
+	corrupt[len(corrupt)-seekTableSize]++
+	_, err = NewReader(bytes.NewReader(corrupt), dec)
+	require.ErrorIs(t, err, ErrCorruptSeekTable)
+	assert.ErrorContains(t, err, "skippable frame magic mismatch")
+}
+
+func TestReaderReadCoalescing(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+		if i == 4 {
+			require.NoError(t, w.WriteSkippableFrame(0, []byte("skip")))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithReadCoalescing(-1, 1))
+	require.ErrorContains(t, err, "must not be negative")
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithReadCoalescing(0, 0))
+	require.ErrorContains(t, err, "must be positive")
+
+	for _, tc := range []struct {
+		maxBytes           int64
+		minReads, maxReads int64
+	}{
+		// All frames, including the skippable frame between them, are read at once
+		// unless some of the reads miss the window.
+		{int64(b.Len()), 1, 2},
+		// Frames bigger than maxBytes are read on their own.
+		{1, 10, 10},
+	} {
+		rs := &countingReader{Reader: bytes.NewReader(b.Bytes())}
+		r, err := NewReader(rs, dec, WithReadCoalescing(100*time.Millisecond, tc.maxBytes))
+		require.NoError(t, err)
+		rs.reads.Store(0)
+
+		var wg sync.WaitGroup
+		for id := int64(0); id < r.NumFrames(); id++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f, err := r.Frame(id)
+				assert.NoError(t, err)
+				buf := make([]byte, f.DecompressedSize)
+				_, err = r.ReadAt(buf, f.DecompressedOffset)
+				assert.NoError(t, err)
+				assert.Equal(t, expected[f.DecompressedOffset:f.DecompressedOffset+f.DecompressedSize], buf)
+			}()
+		}
+		wg.Wait()
+		assert.GreaterOrEqual(t, rs.reads.Load(), tc.minReads, "max bytes: %d", tc.maxBytes)
+		assert.LessOrEqual(t, rs.reads.Load(), tc.maxReads, "max bytes: %d", tc.maxBytes)
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderBufferPool(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		frame := makeTestFrame(t, i)
+		expected = append(expected, frame...)
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	_, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithRBufferPool(nil))
+	require.ErrorContains(t, err, "buffer pool is nil")
+
+	// The readers share the pool.
+	pool := &countingBufferPool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithRBufferPool(pool), WithReadConcurrency(4))
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { assert.NoError(t, r.Close()) }()
+
+			buf := make([]byte, len(expected))
+			_, err := r.ReadAt(buf, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, buf)
+
+			out, err := r.ReadRanges([]Range{{Offset: 10, Length: 20}, {Offset: int64(len(expected)) - 5, Length: 5}})
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{expected[10:30], expected[len(expected)-5:]}, out)
+
+			report, err := r.VerifyAll(context.Background(), VerifyOptions{Decode: true})
+			assert.NoError(t, err)
+			assert.NoError(t, report.Err())
+		}()
+	}
+	wg.Wait()
+	assert.Positive(t, pool.gets.Load())
+	assert.Equal(t, pool.gets.Load(), pool.puts.Load())
+
+	// Frames kept by the compressed frame cache are not pooled.
+	pool = &countingBufferPool{}
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithRBufferPool(pool), WithCompressedFrameCache(1<<20))
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+	require.NoError(t, r.Close())
+	assert.Zero(t, pool.gets.Load())
+}
+
 func TestReaderEdgesParallel(t *testing.T) {
 	dec, err := zstd.NewReader(nil)
 	require.NoError(t, err)
@@ -544,27 +2764,43 @@ func TestSeekTableParsing(t *testing.T) {
 	require.NoError(t, err)
 
 	// Unused bits.
-	require.NoError(t, err)
-	err = stf.UnmarshalBinary([]byte{
+	unused := []byte{
 		0x00, 0x00, 0x00, 0x00,
 		(1 << 7) + 0x01 + 0x2,
 		0xb1, 0xea, 0x92, 0x8f,
-	})
+	}
+	err = stf.UnmarshalBinary(unused)
 	require.NoError(t, err)
+	assert.Equal(t, uint8(0x3), stf.SeekTableDescriptor.UnknownBits)
+	err = stf.unmarshalBinary(unused, true)
+	require.ErrorIs(t, err, ErrCorruptSeekTable)
+	require.ErrorContains(t, err, "footer unused bits")
 
 	// Reserved bits.
-	err = stf.UnmarshalBinary([]byte{
-		0x00, 0x00, 0x00, 0x00,
-		0x84,
-		0xb1, 0xea, 0x92, 0x8f,
-	})
-	require.ErrorContains(t, err, "footer reserved bits")
+	for _, descriptor := range []byte{0x84, 0x80 + 0x20} {
+		reserved := []byte{
+			0x00, 0x00, 0x00, 0x00,
+			descriptor,
+			0xb1, 0xea, 0x92, 0x8f,
+		}
+		err = stf.UnmarshalBinary(reserved)
+		require.NoError(t, err)
+		assert.True(t, stf.SeekTableDescriptor.ChecksumFlag)
+		assert.Equal(t, descriptor&0x3F, stf.SeekTableDescriptor.UnknownBits)
+		err = stf.unmarshalBinary(reserved, true)
+		require.ErrorIs(t, err, ErrCorruptSeekTable)
+		require.ErrorContains(t, err, "footer reserved bits")
+	}
+
+	// Compressed flag.
 	err = stf.UnmarshalBinary([]byte{
 		0x00, 0x00, 0x00, 0x00,
 		0x80 + 0x40,
 		0xb1, 0xea, 0x92, 0x8f,
 	})
-	require.ErrorContains(t, err, "footer reserved bits")
+	require.NoError(t, err)
+	assert.True(t, stf.SeekTableDescriptor.ChecksumFlag)
+	assert.True(t, stf.SeekTableDescriptor.CompressedFlag)
 
 	// Size.
 	err = stf.UnmarshalBinary([]byte{
@@ -579,4 +2815,54 @@ func TestSeekTableParsing(t *testing.T) {
 		0xea, 0x92, 0x8f, 0xb1,
 	})
 	require.ErrorContains(t, err, "footer magic mismatch")
+	require.ErrorIs(t, err, ErrCorruptSeekTable)
+}
+
+func TestReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// Footer magic.
+	corrupt := bytes.Clone(checksum)
+	corrupt[len(corrupt)-1] ^= 0xff
+	_, err = NewReader(bytes.NewReader(corrupt), dec)
+	assert.ErrorIs(t, err, ErrCorruptSeekTable)
+
+	// Seek table entry checksum.
+	corrupt = bytes.Clone(checksum)
+	corrupt[len(corrupt)-9-1] ^= 0xff
+	r, err := NewReader(bytes.NewReader(corrupt), dec)
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 5), 4)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	require.NoError(t, r.Close())
+
+	// Unless the verification is disabled.
+	r, err = NewReader(bytes.NewReader(corrupt), dec, WithChecksumVerification(false))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = r.ReadAt(buf, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test2"), buf)
+	require.NoError(t, r.Close())
+
+	// Seek tables without checksums.
+	_, err = NewReader(bytes.NewReader(noChecksum), dec, WithRequireChecksums())
+	assert.ErrorIs(t, err, ErrNoChecksums)
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithRequireChecksums(), WithChecksumVerification(false))
+	assert.ErrorContains(t, err, "checksum verification disabled")
+	r, err = NewReader(bytes.NewReader(checksum), dec, WithRequireChecksums())
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	r, err = NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 1), -1)
+	assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	_, err = r.Seek(-1, io.SeekStart)
+	assert.ErrorIs(t, err, ErrOffsetOutOfRange)
+	require.NoError(t, r.Close())
 }
@@ -0,0 +1,209 @@
+package seekable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// RetryPolicy describes how transient failures of the environment are retried.
+// Delays between attempts grow exponentially from InitialBackoff up to MaxBackoff.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts.  Zero means no cap.
+	MaxBackoff time.Duration
+	// AttemptTimeout limits each attempt of the reads of an environment implementing
+	// env.ContextREnvironment, see NewRetryREnvironment.  Zero means no limit.
+	AttemptTimeout time.Duration
+	// Retryable reports whether the error is transient.  If nil, all errors are retried.
+	// Attempts that run out of AttemptTimeout are always retried.
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be positive: %d", p.MaxAttempts)
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("backoff must not be negative: %s, %s", p.InitialBackoff, p.MaxBackoff)
+	}
+	if p.AttemptTimeout < 0 {
+		return fmt.Errorf("attempt timeout must not be negative: %s", p.AttemptTimeout)
+	}
+	return nil
+}
+
+// do calls f until it succeeds, returns a non-retryable error or runs out of attempts.
+func (p *RetryPolicy) do(f func() error) error {
+	return p.doContext(context.Background(), func(context.Context) error { return f() })
+}
+
+// doContext is do that stops once ctx is done.  Each attempt gets ctx limited by AttemptTimeout,
+// attempts that time out are retried.
+func (p *RetryPolicy) doContext(ctx context.Context, f func(ctx context.Context) error) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, f)
+		if err == nil || ctx.Err() != nil || attempt >= p.MaxAttempts {
+			return err
+		}
+		if p.Retryable != nil && !p.Retryable(err) && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+func (p *RetryPolicy) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	if p.AttemptTimeout <= 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+	defer cancel()
+	return f(ctx)
+}
+
+// writeFull writes p with f retrying on failures.
+//
+// Bytes reported as written by a failed attempt are considered durable:
+// the next attempt only gets the remainder of p.
+func (p *RetryPolicy) writeFull(buf []byte, f func([]byte) (int, error)) (int, error) {
+	var written int
+	err := p.do(func() error {
+		n, err := f(buf[written:])
+		written += n
+		if err == nil && written < len(buf) {
+			err = io.ErrShortWrite
+		}
+		return err
+	})
+	return written, err
+}
+
+// retryWEnvironment retries writes of the wrapped environment.
+type retryWEnvironment struct {
+	env    env.WEnvironment
+	policy RetryPolicy
+}
+
+// NewRetryWEnvironment wraps the environment so that failed WriteFrame and WriteSeekTable
+// calls are retried according to the policy.
+//
+// On failure the wrapped environment must report the number of bytes of p that were
+// durably written, just like io.Writer does.  The retry passes only the rest of p, so that
+// a partially written frame is completed rather than duplicated.  An environment that can
+// not tell how much was written must return 0 and discard the partial write itself.
+func NewRetryWEnvironment(e env.WEnvironment, p RetryPolicy) (env.WEnvironment, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &retryWEnvironment{env: e, policy: p}, nil
+}
+
+func (e *retryWEnvironment) WriteFrame(p []byte) (int, error) {
+	return e.policy.writeFull(p, e.env.WriteFrame)
+}
+
+func (e *retryWEnvironment) WriteSeekTable(p []byte) (int, error) {
+	return e.policy.writeFull(p, e.env.WriteSeekTable)
+}
+
+// retryWriterAt retries writes to the wrapped io.WriterAt.
+type retryWriterAt struct {
+	w      io.WriterAt
+	policy RetryPolicy
+}
+
+func (w *retryWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	var written int
+	return w.policy.writeFull(p, func(p []byte) (int, error) {
+		n, err := w.w.WriteAt(p, off+int64(written))
+		written += n
+		return n, err
+	})
+}
+
+// retryREnvironment retries reads of the wrapped environment.
+type retryREnvironment struct {
+	env    env.REnvironment
+	policy RetryPolicy
+}
+
+// retrySizerREnvironment is retryREnvironment of an environment that implements env.Sizer.
+type retrySizerREnvironment struct {
+	*retryREnvironment
+}
+
+// NewRetryREnvironment wraps the environment so that failed reads are retried according
+// to the policy, e.g. for remote storage that fails or stalls now and then.  Reads of
+// environments implementing env.ContextREnvironment stop once the context of the reader's call
+// is done, and each of their attempts is limited by the AttemptTimeout of the policy.
+// The returned environment implements env.Sizer if e does.
+func NewRetryREnvironment(e env.REnvironment, p RetryPolicy) (env.REnvironment, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	re := &retryREnvironment{env: e, policy: p}
+	if _, ok := e.(env.Sizer); ok {
+		return retrySizerREnvironment{re}, nil
+	}
+	return re, nil
+}
+
+func (e *retryREnvironment) GetFrameByIndex(index env.FrameOffsetEntry) ([]byte, error) {
+	return e.GetFrameByIndexContext(context.Background(), index)
+}
+
+func (e *retryREnvironment) GetFrameByIndexContext(ctx context.Context, index env.FrameOffsetEntry) (p []byte, err error) {
+	err = e.policy.doContext(ctx, func(ctx context.Context) error {
+		if ce, ok := e.env.(env.ContextREnvironment); ok {
+			p, err = ce.GetFrameByIndexContext(ctx, index)
+		} else {
+			p, err = e.env.GetFrameByIndex(index)
+		}
+		return err
+	})
+	return p, err
+}
+
+func (e *retryREnvironment) ReadFooter() (p []byte, err error) {
+	err = e.policy.do(func() error {
+		p, err = e.env.ReadFooter()
+		return err
+	})
+	return p, err
+}
+
+func (e *retryREnvironment) ReadSkipFrame(skippableFrameOffset int64) (p []byte, err error) {
+	err = e.policy.do(func() error {
+		p, err = e.env.ReadSkipFrame(skippableFrameOffset)
+		return err
+	})
+	return p, err
+}
+
+func (e retrySizerREnvironment) Size() (size int64, err error) {
+	err = e.policy.do(func() error {
+		size, err = e.env.(env.Sizer).Size()
+		return err
+	})
+	return size, err
+}
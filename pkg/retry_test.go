@@ -0,0 +1,191 @@
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+var errTransient = errors.New("transient")
+
+// flakyWriter fails every other write after writing half of the buffer.
+type flakyWriter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.calls%2 == 1 {
+		n, _ := w.Buffer.Write(p[:len(p)/2])
+		return n, errTransient
+	}
+	return w.Buffer.Write(p)
+}
+
+// flakyWriterAt fails every other write after writing half of the buffer.
+type flakyWriterAt struct {
+	buf   []byte
+	calls int
+}
+
+func (w *flakyWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.calls++
+	var err error
+	if w.calls%2 == 1 {
+		p, err = p[:len(p)/2], errTransient
+	}
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), err
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+
+	var fw flakyWriter
+	w, err := NewWriter(&fw, enc, WithWRetryPolicy(policy))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var fwa flakyWriterAt
+	w, err = NewWriterAt(&fwa, enc, WithWRetryPolicy(policy))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(),
+		makeTestFrameSource([][]byte{[]byte("test"), []byte("test2")})))
+	require.NoError(t, w.Close())
+	assert.Equal(t, fw.Bytes(), fwa.buf)
+
+	r, err := NewReader(bytes.NewReader(fw.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// Non-retryable errors are returned immediately.
+	e, err := NewRetryWEnvironment(failingWriteEnvironment{0, errTransient}, RetryPolicy{
+		MaxAttempts: 10,
+		Retryable:   func(err error) bool { return !errors.Is(err, errTransient) },
+	})
+	require.NoError(t, err)
+	_, err = e.WriteFrame([]byte("test"))
+	assert.ErrorIs(t, err, errTransient)
+
+	// ...and so is the last error once out of attempts.
+	var attempts int
+	p := RetryPolicy{MaxAttempts: 3}
+	err = p.do(func() error { attempts++; return errTransient })
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 3, attempts)
+
+	_, err = NewWriter(&fw, enc, WithWRetryPolicy(RetryPolicy{}))
+	assert.Error(t, err)
+}
+
+// flakyReadEnvironment fails the first footer read and the first frame reads: if stall is set,
+// the first one waits until its context is done, and the next one fails.
+type flakyReadEnvironment struct {
+	env.REnvironment
+	stall                   bool
+	frameCalls, footerCalls int
+}
+
+func (e *flakyReadEnvironment) GetFrameByIndexContext(ctx context.Context, index env.FrameOffsetEntry) ([]byte, error) {
+	e.frameCalls++
+	if e.stall && e.frameCalls == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if e.frameCalls <= 2 {
+		return nil, errTransient
+	}
+	return e.GetFrameByIndex(index)
+}
+
+func (e *flakyReadEnvironment) ReadFooter() ([]byte, error) {
+	e.footerCalls++
+	if e.footerCalls == 1 {
+		return nil, errTransient
+	}
+	return e.REnvironment.ReadFooter()
+}
+
+func TestRetryREnvironment(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewRetryREnvironment(&readSeekerEnvImpl{}, RetryPolicy{MaxAttempts: 1, AttemptTimeout: -1})
+	require.ErrorContains(t, err, "must not be negative")
+
+	// The optional interfaces of the wrapped environment are kept.
+	e, err := NewRetryREnvironment(&readSeekerEnvImpl{rs: bytes.NewReader(checksum)}, RetryPolicy{MaxAttempts: 1})
+	require.NoError(t, err)
+	_, ok := e.(env.Sizer)
+	assert.True(t, ok)
+
+	// Attempts that stall are cut by AttemptTimeout.
+	flaky := &flakyReadEnvironment{REnvironment: &readSeekerEnvImpl{rs: bytes.NewReader(checksum)}, stall: true}
+	e, err = NewRetryREnvironment(flaky, RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		AttemptTimeout: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	_, ok = e.(env.Sizer)
+	assert.False(t, ok)
+
+	r, err := NewReader(nil, dec, WithREnvironment(e))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = r.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, sourceString[:4], string(buf))
+	assert.Equal(t, 3, flaky.frameCalls)
+	require.NoError(t, r.Close())
+
+	// Non-retryable errors are returned immediately.
+	flaky = &flakyReadEnvironment{REnvironment: &readSeekerEnvImpl{rs: bytes.NewReader(checksum)}}
+	e, err = NewRetryREnvironment(flaky, RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return !errors.Is(err, errTransient) },
+	})
+	require.NoError(t, err)
+	_, err = NewReader(nil, dec, WithREnvironment(e))
+	require.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 1, flaky.footerCalls)
+
+	// Retries stop once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}
+	var attempts int
+	err = p.doContext(ctx, func(context.Context) error { attempts++; return errTransient })
+	require.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 1, attempts)
+}
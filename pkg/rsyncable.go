@@ -0,0 +1,65 @@
+package seekable
+
+import (
+	"math/bits"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/gear"
+)
+
+// rollingSplitter finds content-defined frame boundaries for WithRsyncable using the Gear
+// rolling hash.  A boundary is placed after a byte once the top bits of the hash, which depend
+// on the last 64 bytes, are all zero.  Data before minSize is not hashed.
+//
+// The state is kept between calls, so boundaries do not depend on how the data was split
+// into writes.
+type rollingSplitter struct {
+	mask    uint64
+	minSize int
+	maxSize int
+
+	fp      uint64
+	scanned int
+}
+
+func newRollingSplitter(averageSize, minSize, maxSize int) *rollingSplitter {
+	return &rollingSplitter{
+		mask:    ^uint64(0) << (64 - bits.Len(uint(averageSize)-1)),
+		minSize: minSize,
+		maxSize: maxSize,
+	}
+}
+
+// next returns the size of the first frame of p, or zero if p does not contain a boundary yet.
+// p must start at the previous boundary and only grow between calls.
+func (r *rollingSplitter) next(p []byte) int {
+	end := len(p)
+	if end > r.maxSize {
+		end = r.maxSize
+	}
+
+	i := r.scanned
+	if i < r.minSize {
+		i = r.minSize
+	}
+	for ; i < end; i++ {
+		r.fp = (r.fp << 1) + gear.Table[p[i]]
+		if r.fp&r.mask == 0 {
+			r.reset()
+			return i + 1
+		}
+	}
+
+	if len(p) >= r.maxSize {
+		r.reset()
+		return r.maxSize
+	}
+	if end > r.scanned {
+		r.scanned = end
+	}
+	return 0
+}
+
+func (r *rollingSplitter) reset() {
+	r.fp = 0
+	r.scanned = 0
+}
@@ -6,6 +6,8 @@ import (
 	"math"
 
 	"go.uber.org/zap/zapcore"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/format"
 )
 
 const (
@@ -36,19 +38,36 @@ const (
 
 		https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
 	*/
-	skippableFrameMagic uint32 = 0x184D2A50
+	skippableFrameMagic = format.SkippableFrameMagic
 
-	seekableMagicNumber uint32 = 0x8F92EAB1
+	seekableMagicNumber = format.SeekableMagicNumber
 
-	seekTableFooterOffset = 9
+	seekTableFooterOffset = format.FooterSize
 
 	frameSizeFieldSize            = 4
 	skippableMagicNumberFieldSize = 4
 
+	/*
+		If `Compressed_Flag` is set in the `Seek_Table_Descriptor`, the seek table frame is as follows:
+
+			|`Skippable_Magic_Number`|`Frame_Size`|`Compressed_Seek_Table_Entries`|`Compressed_Size`|`Seek_Table_Footer`|
+			|------------------------|------------|-------------------------------|-----------------|-------------------|
+			| 4 bytes                | 4 bytes    | n bytes                       | 4 bytes         | 9 bytes           |
+
+		Compressed_Seek_Table_Entries
+
+		`Seek_Table_Entries` compressed as a single ZSTD frame.  It is empty if there are no entries.
+
+		Compressed_Size
+
+		The size of `Compressed_Seek_Table_Entries`, __little-endian__ format.
+	*/
+	compressedSizeFieldSize = 4
+
 	// maxFrameSize is the maximum framesize supported by decoder.  This is to prevent OOMs due to untrusted input.
 	maxDecoderFrameSize = 128 << 20
 
-	seekableTag = 0xE
+	seekableTag = format.SeekableTag
 
 	// maximum size of a single frame
 	maxChunkSize int64 = math.MaxUint32
@@ -65,26 +84,43 @@ A bitfield describing the format of the seek table.
 	| Bit number | Field name                |
 	| ---------- | ----------                |
 	| 7          | `Checksum_Flag`           |
-	| 6-2        | `Reserved_Bits`           |
+	| 6          | `Compressed_Flag`         |
+	| 5-2        | `Reserved_Bits`           |
 	| 1-0        | `Unused_Bits`             |
 
-While only `Checksum_Flag` currently exists, there are 7 other bits in this field that can be used for future changes to the format,
-for example the addition of inline dictionaries.
+`Checksum_Flag` comes from the upstream format, while `Compressed_Flag` is an extension of this library.
+It takes bit 6, which the upstream format reserves, so the reference decoder and older versions
+of this library refuse compressed seek tables instead of misinterpreting them, see WithCompressedSeekTable.
 
 `Reserved_Bits` are not currently used but may be used in the future for breaking changes,
 so a compliant decoder should ensure they are set to 0.
 
 `Unused_Bits` may be used in the future for non-breaking changes,
 so a compliant decoder should not interpret these bits.
+
+By default both are ignored, so that streams written by future revisions of the format can be read,
+while WithStrictFormat refuses streams that have any of them set.
 */
 type seekTableDescriptor struct {
 	// If the checksum flag is set, each of the seek table entries contains a 4 byte checksum
 	// of the uncompressed data contained in its frame.
 	ChecksumFlag bool
+	// If the compressed flag is set, `Seek_Table_Entries` are stored as a single ZSTD frame
+	// followed by its 4 byte size, see createSeekTable.
+	CompressedFlag bool
+	// UnknownBits are the `Reserved_Bits` and `Unused_Bits` that are set, in place.
+	UnknownBits uint8
 }
 
+// descriptorUnknownBits is the mask of `Reserved_Bits` and `Unused_Bits`.
+const descriptorUnknownBits = format.UnknownBits
+
 func (d *seekTableDescriptor) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddBool("ChecksumFlag", d.ChecksumFlag)
+	enc.AddBool("CompressedFlag", d.CompressedFlag)
+	if d.UnknownBits != 0 {
+		enc.AddUint8("UnknownBits", d.UnknownBits)
+	}
 	return nil
 }
 
@@ -108,12 +144,17 @@ type seekTableFooter struct {
 	SeekableMagicNumber uint32
 }
 
+// entrySize returns the size of a single `Seek_Table_Entries` element.
+func (f *seekTableFooter) entrySize() int64 {
+	return format.EntrySize(f.SeekTableDescriptor.ChecksumFlag)
+}
+
 func (f *seekTableFooter) marshalBinaryInline(dst []byte) {
-	binary.LittleEndian.PutUint32(dst[0:], f.NumberOfFrames)
-	if f.SeekTableDescriptor.ChecksumFlag {
-		dst[4] |= 1 << 7
-	}
-	binary.LittleEndian.PutUint32(dst[5:], seekableMagicNumber)
+	format.PutFooter(dst, format.Footer{
+		NumberOfFrames: f.NumberOfFrames,
+		Checksum:       f.SeekTableDescriptor.ChecksumFlag,
+		Compressed:     f.SeekTableDescriptor.CompressedFlag,
+	})
 }
 
 func (f *seekTableFooter) MarshalBinary() ([]byte, error) {
@@ -131,20 +172,26 @@ func (f *seekTableFooter) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	return nil
 }
 
+// UnmarshalBinary parses the footer ignoring the `Reserved_Bits` and `Unused_Bits` of its descriptor.
 func (f *seekTableFooter) UnmarshalBinary(p []byte) error {
-	if len(p) != seekTableFooterOffset {
-		return fmt.Errorf("footer length mismatch %d vs %d", len(p), seekTableFooterOffset)
-	}
-	// Check that reserved bits are set to 0.
-	var reservedBits uint8 = (p[4] << 1) >> 3
-	if reservedBits != 0 {
-		return fmt.Errorf("footer reserved bits %d != 0", reservedBits)
+	return f.unmarshalBinary(p, false)
+}
+
+// unmarshalBinary parses the footer.  If strict is set, it refuses descriptors with
+// `Reserved_Bits` or `Unused_Bits` set, see WithStrictFormat.
+func (f *seekTableFooter) unmarshalBinary(p []byte, strict bool) error {
+	footer, err := format.ParseFooter(p, strict)
+	if err != nil {
+		return err
 	}
-	f.NumberOfFrames = binary.LittleEndian.Uint32(p[0:])
-	f.SeekTableDescriptor.ChecksumFlag = (p[4] & (1 << 7)) > 0
-	f.SeekableMagicNumber = binary.LittleEndian.Uint32(p[5:])
-	if f.SeekableMagicNumber != seekableMagicNumber {
-		return fmt.Errorf("footer magic mismatch %d vs %d", f.SeekableMagicNumber, seekableMagicNumber)
+	*f = seekTableFooter{
+		NumberOfFrames: footer.NumberOfFrames,
+		SeekTableDescriptor: seekTableDescriptor{
+			ChecksumFlag:   footer.Checksum,
+			CompressedFlag: footer.Compressed,
+			UnknownBits:    footer.UnknownBits,
+		},
+		SeekableMagicNumber: seekableMagicNumber,
 	}
 	return nil
 }
@@ -170,10 +217,10 @@ type seekTableEntry struct {
 	Checksum uint32
 }
 
+// marshalBinaryInline writes the entry into dst.  The checksum is only written
+// if dst has room for it.
 func (e *seekTableEntry) marshalBinaryInline(dst []byte) {
-	binary.LittleEndian.PutUint32(dst[0:], e.CompressedSize)
-	binary.LittleEndian.PutUint32(dst[4:], e.DecompressedSize)
-	binary.LittleEndian.PutUint32(dst[8:], e.Checksum)
+	format.PutEntry(dst, format.Entry(*e))
 }
 
 func (e *seekTableEntry) MarshalBinary() ([]byte, error) {
@@ -190,14 +237,11 @@ func (e *seekTableEntry) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 }
 
 func (e *seekTableEntry) UnmarshalBinary(p []byte) error {
-	if len(p) < 8 {
-		return fmt.Errorf("entry length mismatch %d vs %d", len(p), 8)
-	}
-	e.CompressedSize = binary.LittleEndian.Uint32(p[0:])
-	e.DecompressedSize = binary.LittleEndian.Uint32(p[4:])
-	if len(p) >= 12 {
-		e.Checksum = binary.LittleEndian.Uint32(p[8:])
+	entry, err := format.ParseEntry(p)
+	if err != nil {
+		return err
 	}
+	*e = seekTableEntry(entry)
 	return nil
 }
 
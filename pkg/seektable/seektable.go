@@ -0,0 +1,168 @@
+// Package seektable parses, builds and serializes the seek tables of seekable ZSTD streams
+// on their own, e.g. for indexers, validators and storage migrators that work with the seek
+// tables without the reader or the writer of the streams.
+//
+// The seek table is the skippable frame at the end of the stream, see
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md.
+// Compressed seek tables written with Writer's WithCompressedSeekTable are not supported.
+package seektable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/format"
+)
+
+// FooterSize is the size of `Seek_Table_Footer` at the end of the seek table.
+const FooterSize = format.FooterSize
+
+// Entry is an element of `Seek_Table_Entries` describing a frame of the stream.
+type Entry struct {
+	// CompressedSize is the size of the frame in the stream.
+	CompressedSize uint32
+	// DecompressedSize is the size of its data, zero for skippable frames.
+	DecompressedSize uint32
+	// Checksum is the least significant 32 bits of the XXH64 digest of the data, see Checksum.
+	// It is only stored if the table has checksums.
+	Checksum uint32
+}
+
+// Frame is an entry together with the offsets of its frame.
+type Frame struct {
+	Entry
+	CompressedOffset   uint64
+	DecompressedOffset uint64
+}
+
+// Table is a seek table.
+type Table struct {
+	Entries []Entry
+	// Checksums is the `Checksum_Flag`: whether the entries store the checksums of the frames.
+	Checksums bool
+}
+
+// Checksum returns the checksum of the frame data stored in its entry.
+func Checksum(data []byte) uint32 {
+	return uint32(xxhash.Sum64(data))
+}
+
+// Build returns the seek table with checksums of the entries, which are used as is.
+func Build(entries []Entry) *Table {
+	return &Table{Entries: entries, Checksums: true}
+}
+
+// FrameSize returns the size of the seek table skippable frame ending with the given footer,
+// which are the last FooterSize bytes of the stream, e.g. to find out how much of the tail of
+// the stream to pass to Parse.  It fails with seekable.ErrCorruptSeekTable if the footer is malformed.
+func FrameSize(footer []byte) (int64, error) {
+	frames, checksums, err := parseFooter(footer)
+	if err != nil {
+		return 0, err
+	}
+	return format.HeaderSize + frames*format.EntrySize(checksums) + FooterSize, nil
+}
+
+// Parse parses the seek table skippable frame p, including the `Skippable_Magic_Number`
+// and `Frame_Size`.  It fails with seekable.ErrCorruptSeekTable if p is malformed.
+// The `Reserved_Bits` and `Unused_Bits` of the descriptor are ignored.
+func Parse(p []byte) (*Table, error) {
+	if len(p) < format.HeaderSize+FooterSize {
+		return nil, fmt.Errorf("%w: seek table is too small: %d", seekable.ErrCorruptSeekTable, len(p))
+	}
+	frames, checksums, err := parseFooter(p[len(p)-FooterSize:])
+	if err != nil {
+		return nil, err
+	}
+	if err := format.CheckHeader(p); err != nil {
+		return nil, err
+	}
+	size := format.EntrySize(checksums)
+	entries := p[format.HeaderSize : len(p)-FooterSize]
+	if int64(len(entries)) != frames*size {
+		return nil, fmt.Errorf("%w: footer declares %d frames, seek table has room for: %d",
+			seekable.ErrCorruptSeekTable, frames, int64(len(entries))/size)
+	}
+
+	t := &Table{Entries: make([]Entry, frames), Checksums: checksums}
+	for i := range t.Entries {
+		off := int64(i) * size
+		e, err := format.ParseEntry(entries[off : off+size])
+		if err != nil {
+			return nil, err
+		}
+		t.Entries[i] = Entry(e)
+	}
+	return t, nil
+}
+
+// Serialize returns the seek table skippable frame, which is appended to the frames
+// it describes to make a seekable stream.
+func (t *Table) Serialize() ([]byte, error) {
+	size := format.EntrySize(t.Checksums)
+	frameSize := int64(len(t.Entries))*size + FooterSize
+	if int64(len(t.Entries)) > math.MaxUint32 || frameSize > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: seek table of %d frames does not fit into a skippable frame",
+			seekable.ErrTooManyFrames, len(t.Entries))
+	}
+
+	dst := make([]byte, format.HeaderSize+frameSize)
+	binary.LittleEndian.PutUint32(dst[0:], format.SkippableFrameMagic+format.SeekableTag)
+	binary.LittleEndian.PutUint32(dst[4:], uint32(frameSize))
+	for i, entry := range t.Entries {
+		off := format.HeaderSize + int64(i)*size
+		format.PutEntry(dst[off:off+size], format.Entry(entry))
+	}
+	format.PutFooter(dst[len(dst)-FooterSize:], format.Footer{
+		NumberOfFrames: uint32(len(t.Entries)),
+		Checksum:       t.Checksums,
+	})
+	return dst, nil
+}
+
+// Frames returns the entries together with the offsets of their frames.
+func (t *Table) Frames() []Frame {
+	frames := make([]Frame, len(t.Entries))
+	var comp, decomp uint64
+	for i, e := range t.Entries {
+		frames[i] = Frame{Entry: e, CompressedOffset: comp, DecompressedOffset: decomp}
+		comp += uint64(e.CompressedSize)
+		decomp += uint64(e.DecompressedSize)
+	}
+	return frames
+}
+
+// CompressedSize returns the total size of the frames, which is the size of the stream
+// without the seek table.
+func (t *Table) CompressedSize() uint64 {
+	var size uint64
+	for _, e := range t.Entries {
+		size += uint64(e.CompressedSize)
+	}
+	return size
+}
+
+// DecompressedSize returns the total size of the data of the frames.
+func (t *Table) DecompressedSize() uint64 {
+	var size uint64
+	for _, e := range t.Entries {
+		size += uint64(e.DecompressedSize)
+	}
+	return size
+}
+
+// parseFooter returns the number of frames and the `Checksum_Flag` of the footer.
+func parseFooter(p []byte) (int64, bool, error) {
+	f, err := format.ParseFooter(p, false)
+	if err != nil {
+		return 0, false, err
+	}
+	if f.Compressed {
+		return 0, false, fmt.Errorf("%w: compressed seek tables are not supported", seekable.ErrCorruptSeekTable)
+	}
+	return int64(f.NumberOfFrames), f.Checksum, nil
+}
@@ -0,0 +1,181 @@
+package seektable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/internal/format"
+)
+
+// writeStream writes the frames with the seekable writer and returns the stream
+// and its seek table.
+func writeStream(t *testing.T, frames [][]byte, checksums bool) ([]byte, []byte) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b, sidecar bytes.Buffer
+	w, err := seekable.NewWriter(&b, enc, seekable.WithFrameChecksums(checksums), seekable.WithSidecarSeekTable(&sidecar))
+	require.NoError(t, err)
+	for _, frame := range frames {
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return b.Bytes(), sidecar.Bytes()
+}
+
+func TestParseSerialize(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var frames [][]byte
+	for i := 0; i < 10; i++ {
+		frames = append(frames, bytes.Repeat([]byte(fmt.Sprintf("frame %d ", i)), 10+i))
+	}
+
+	for _, checksums := range []bool{true, false} {
+		stream, seekTable := writeStream(t, frames, checksums)
+		require.True(t, bytes.HasSuffix(stream, seekTable))
+
+		size, err := FrameSize(stream[len(stream)-FooterSize:])
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(seekTable)), size)
+
+		table, err := Parse(seekTable)
+		require.NoError(t, err)
+		assert.Equal(t, checksums, table.Checksums)
+		require.Len(t, table.Entries, len(frames))
+		assert.Equal(t, uint64(len(stream)-len(seekTable)), table.CompressedSize())
+
+		r, err := seekable.NewReader(bytes.NewReader(stream), dec)
+		require.NoError(t, err)
+		var total uint64
+		for i, f := range table.Frames() {
+			info, err := r.Frame(int64(i))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(info.CompressedOffset), f.CompressedOffset)
+			assert.Equal(t, uint64(info.DecompressedOffset), f.DecompressedOffset)
+			assert.Equal(t, uint32(info.CompressedSize), f.CompressedSize)
+			assert.Equal(t, uint32(len(frames[i])), f.DecompressedSize)
+			if checksums {
+				assert.Equal(t, Checksum(frames[i]), f.Checksum)
+			}
+			total += uint64(f.DecompressedSize)
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(end), table.DecompressedSize())
+		assert.Equal(t, total, table.DecompressedSize())
+		require.NoError(t, r.Close())
+
+		serialized, err := table.Serialize()
+		require.NoError(t, err)
+		assert.Equal(t, seekTable, serialized)
+	}
+
+	serialized, err := Build(nil).Serialize()
+	require.NoError(t, err)
+	table, err := Parse(serialized)
+	require.NoError(t, err)
+	assert.Empty(t, table.Entries)
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	frames := [][]byte{[]byte("first frame"), []byte("second frame"), []byte("third frame")}
+	stream, seekTable := writeStream(t, frames, true)
+	parsed, err := Parse(seekTable)
+	require.NoError(t, err)
+
+	// The seek table of the first and the last frames describes the stream without the second one.
+	entries := []Entry{parsed.Entries[0], parsed.Entries[2]}
+	table := Build(entries)
+	serialized, err := table.Serialize()
+	require.NoError(t, err)
+
+	first := parsed.Entries[0].CompressedSize
+	second := parsed.Entries[1].CompressedSize
+	data := bytes.Clone(stream[:first])
+	data = append(data, stream[first+second:len(stream)-len(seekTable)]...)
+	data = append(data, serialized...)
+
+	r, err := seekable.NewReader(bytes.NewReader(data), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "first framethird frame", string(all))
+	require.NoError(t, r.Close())
+
+	// The seek table can also be passed to the reader separately.
+	r, err = seekable.NewReader(bytes.NewReader(data[:len(data)-len(serialized)]), dec,
+		seekable.WithSeekTableBytes(serialized))
+	require.NoError(t, err)
+	all, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "first framethird frame", string(all))
+	require.NoError(t, r.Close())
+
+	// The entries are used as is, so the reader detects wrong checksums.
+	entries[1].Checksum++
+	serialized, err = table.Serialize()
+	require.NoError(t, err)
+	data = append(data[:len(data)-len(serialized)], serialized...)
+	r, err = seekable.NewReader(bytes.NewReader(data), dec)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, seekable.ErrChecksumMismatch)
+	require.NoError(t, r.Close())
+}
+
+func TestParseCorrupt(t *testing.T) {
+	t.Parallel()
+
+	_, seekTable := writeStream(t, [][]byte{[]byte("first frame"), []byte("second frame")}, true)
+
+	for name, corrupt := range map[string]func(p []byte) []byte{
+		"too small":        func(p []byte) []byte { return p[len(p)-FooterSize:] },
+		"skippable magic":  func(p []byte) []byte { p[0]++; return p },
+		"frame size":       func(p []byte) []byte { p[4]++; return p },
+		"footer magic":     func(p []byte) []byte { p[len(p)-1]++; return p },
+		"number of frames": func(p []byte) []byte { p[len(p)-FooterSize]++; return p },
+		"compressed": func(p []byte) []byte {
+			p[len(p)-FooterSize+4] |= format.CompressedFlag
+			return p
+		},
+		"truncated": func(p []byte) []byte {
+			p = append(p[:format.HeaderSize+4], p[len(p)-FooterSize:]...)
+			binary.LittleEndian.PutUint32(p[4:], uint32(len(p)-format.HeaderSize))
+			return p
+		},
+	} {
+		_, err := Parse(corrupt(bytes.Clone(seekTable)))
+		assert.ErrorIs(t, err, seekable.ErrCorruptSeekTable, name)
+	}
+
+	_, err := FrameSize(seekTable[:FooterSize])
+	assert.ErrorIs(t, err, seekable.ErrCorruptSeekTable)
+
+	// The reserved and unused bits of the descriptor are ignored.
+	p := bytes.Clone(seekTable)
+	p[len(p)-FooterSize+4] |= format.UnknownBits
+	table, err := Parse(p)
+	require.NoError(t, err)
+	assert.True(t, table.Checksums)
+	assert.Len(t, table.Entries, 2)
+}
@@ -0,0 +1,49 @@
+package seekable
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLogger(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var wlog, rlog bytes.Buffer
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithWSLogger(slog.New(
+		slog.NewJSONHandler(&wlog, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var record struct {
+		Level string
+		Msg   string
+		Frame map[string]any
+	}
+	require.NoError(t, json.Unmarshal(bytes.SplitN(wlog.Bytes(), []byte("\n"), 2)[0], &record))
+	assert.Equal(t, "DEBUG", record.Level)
+	assert.Equal(t, "appending frame", record.Msg)
+	assert.Equal(t, float64(4), record.Frame["DecompressedSize"])
+
+	// Debug logs are filtered out by the default level.
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithRSLogger(slog.New(slog.NewJSONHandler(&rlog, nil))))
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 4))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Zero(t, rlog.Len())
+}
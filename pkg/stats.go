@@ -0,0 +1,59 @@
+package seekable
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// ReaderStats are the cumulative statistics of a Reader, see Reader's Stats.
+type ReaderStats struct {
+	// FramesDecoded is the number of frames decompressed, including prefetched ones.
+	FramesDecoded int64
+	// BytesRead is the number of compressed bytes of frames read from the environment.
+	BytesRead int64
+	// CacheHits is the number of reads served from the decompressed frames kept by the reader:
+	// the frame cache set by WithFrameCache or, without it, the last decompressed frame.
+	CacheHits int64
+	// CacheMisses is the number of reads that had to decompress the frame.
+	CacheMisses int64
+	// ChecksumFailures is the number of frames that failed checksum verification.
+	ChecksumFailures int64
+	// IOTime is the total time spent reading frames from the environment.
+	IOTime time.Duration
+	// DecodeTime is the total time spent decompressing frames.
+	DecodeTime time.Duration
+}
+
+// readerStats collects ReaderStats, it is updated concurrently by all reads.
+type readerStats struct {
+	framesDecoded    atomic.Int64
+	bytesRead        atomic.Int64
+	cacheHits        atomic.Int64
+	cacheMisses      atomic.Int64
+	checksumFailures atomic.Int64
+	ioTime           atomic.Int64
+	decodeTime       atomic.Int64
+}
+
+func (s *readerStats) read(n int, d time.Duration) {
+	s.bytesRead.Add(int64(n))
+	s.ioTime.Add(int64(d))
+}
+
+func (s *readerStats) decoded(d time.Duration) {
+	s.framesDecoded.Add(1)
+	s.decodeTime.Add(int64(d))
+}
+
+func (s *readerStats) get() ReaderStats {
+	return ReaderStats{
+		FramesDecoded:    s.framesDecoded.Load(),
+		BytesRead:        s.bytesRead.Load(),
+		CacheHits:        s.cacheHits.Load(),
+		CacheMisses:      s.cacheMisses.Load(),
+		ChecksumFailures: s.checksumFailures.Load(),
+		IOTime:           time.Duration(s.ioTime.Load()),
+		DecodeTime:       time.Duration(s.decodeTime.Load()),
+	}
+}
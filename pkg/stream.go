@@ -0,0 +1,214 @@
+package seekable
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// frameStream is a frame over the threshold set by WithStreamingDecode that is decompressed
+// incrementally instead of as a whole.
+type frameStream struct {
+	r     *readerImpl
+	index *env.FrameOffsetEntry
+	rc    io.ReadCloser
+
+	// pos is the offset within the frame that the next Read returns.
+	pos uint64
+	// hash, if set, is the checksum of the frame so far, it is verified once the frame is read.
+	hash *xxhash.Digest
+	// decodeTime is the time spent decompressing the frame so far.
+	decodeTime time.Duration
+	// err is the result of the verification of the frame once it is read.
+	err error
+}
+
+// streamSlot keeps the stream of the last frame read incrementally, so that sequential reads
+// of the frame continue decompressing where the previous one stopped.
+type streamSlot struct {
+	m      sync.Mutex
+	stream *frameStream
+}
+
+// take returns the kept stream of the frame if it has not gone past pos, or nil.
+func (s *streamSlot) take(index *env.FrameOffsetEntry, pos uint64) *frameStream {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	fs := s.stream
+	if fs == nil || fs.index.ID != index.ID || fs.pos > pos {
+		return nil
+	}
+	s.stream = nil
+	return fs
+}
+
+// put keeps the stream closing the one it replaces, or closes all of them if fs is nil.
+func (s *streamSlot) put(fs *frameStream) {
+	s.m.Lock()
+	old := s.stream
+	s.stream = fs
+	s.m.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// streamed returns whether the frame is over the threshold set by WithStreamingDecode.
+func (r *readerImpl) streamed(index *env.FrameOffsetEntry) bool {
+	return r.streamThreshold > 0 && int64(index.DecompSize) > r.streamThreshold
+}
+
+// openStream reads the compressed frame and starts decompressing it from the beginning.
+// The compressed size stays reserved in the reader memory until the stream is closed.
+func (r *readerImpl) openStream(ctx context.Context, index *env.FrameOffsetEntry) (*frameStream, error) {
+	if index.CompSize > maxDecoderFrameSize {
+		return nil, fmt.Errorf("%w: index.CompSize is too big: %d > %d",
+			ErrFrameTooLarge, index.CompSize, maxDecoderFrameSize)
+	}
+	if err := r.memory.acquire(int64(index.CompSize)); err != nil {
+		return nil, err
+	}
+
+	src, err := r.readFrame(ctx, index)
+	if err != nil {
+		r.memory.release(int64(index.CompSize))
+		return nil, err
+	}
+	rc, err := r.newStreamReader(bytes.NewReader(src))
+	if err != nil {
+		r.memory.release(int64(index.CompSize))
+		return nil, fmt.Errorf("failed to decompress data data at: %d, %w", index.CompOffset, err)
+	}
+
+	fs := &frameStream{r: r, index: index, rc: rc}
+	if r.idx().checksums && r.verifyChecksums {
+		fs.hash = xxhash.New()
+	}
+	return fs, nil
+}
+
+// Read decompresses the frame up to its size in the seek table, and verifies it once it is read.
+func (fs *frameStream) Read(p []byte) (int, error) {
+	left := uint64(fs.index.DecompSize) - fs.pos
+	if left == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > left {
+		p = p[:left]
+	}
+
+	start := time.Now()
+	n, err := fs.rc.Read(p)
+	fs.decodeTime += time.Since(start)
+	if fs.hash != nil {
+		_, _ = fs.hash.Write(p[:n])
+	}
+	fs.pos += uint64(n)
+	if err == io.EOF && fs.pos < uint64(fs.index.DecompSize) {
+		return n, fmt.Errorf("%w: index corruption: len: %d, expected: %d",
+			ErrCorruptSeekTable, fs.pos, int(fs.index.DecompSize))
+	}
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("failed to decompress data data at: %d, %w", fs.index.CompOffset, err)
+	}
+	if fs.pos == uint64(fs.index.DecompSize) {
+		fs.err = fs.finish()
+		return n, fs.err
+	}
+	return n, nil
+}
+
+// finish verifies the frame once all of it is read.
+func (fs *frameStream) finish() error {
+	var b [1]byte
+	if n, _ := fs.rc.Read(b[:]); n != 0 {
+		return fmt.Errorf("%w: index corruption: len: > %d, expected: %d",
+			ErrCorruptSeekTable, fs.pos, int(fs.index.DecompSize))
+	}
+	if fs.hash == nil {
+		return nil
+	}
+	checksum := uint32((fs.hash.Sum64() << 32) >> 32)
+	if fs.index.Checksum != checksum {
+		fs.r.stats.checksumFailures.Add(1)
+		return fmt.Errorf("%w: checksum verification failed at: %d: expected: %d, actual: %d",
+			ErrChecksumMismatch, fs.index.CompOffset, fs.index.Checksum, checksum)
+	}
+	return nil
+}
+
+// skip decompresses the frame up to pos discarding the data.  Frames read from the middle
+// can not be verified, so their checksum is not computed.
+func (fs *frameStream) skip(pos uint64) error {
+	if fs.pos == pos {
+		return nil
+	}
+	fs.hash = nil
+	_, err := io.CopyN(io.Discard, fs, int64(pos-fs.pos))
+	return err
+}
+
+func (fs *frameStream) Close() {
+	_ = fs.rc.Close()
+	fs.r.stats.decoded(fs.decodeTime)
+	fs.r.memory.release(int64(fs.index.CompSize))
+}
+
+// stream returns the stream of the frame positioned at pos, continuing the kept one if possible.
+func (r *readerImpl) stream(ctx context.Context, index *env.FrameOffsetEntry, pos uint64) (*frameStream, error) {
+	fs := r.streams.take(index, pos)
+	if fs == nil {
+		var err error
+		if fs, err = r.openStream(ctx, index); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.skip(pos); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// readStream fills dst with the data of the frame starting at pos decompressing it incrementally.
+func (r *readerImpl) readStream(ctx context.Context, index *env.FrameOffsetEntry, pos uint64, dst []byte) error {
+	fs, err := r.stream(ctx, index, pos)
+	if err != nil {
+		return err
+	}
+	// io.ReadFull drops the error of the read that fills dst.
+	if _, err := io.ReadFull(fs, dst); err != nil || fs.err != nil {
+		fs.Close()
+		return cmp.Or(err, fs.err)
+	}
+	if fs.pos == uint64(index.DecompSize) {
+		fs.Close()
+	} else {
+		r.streams.put(fs)
+	}
+	return nil
+}
+
+// writeStream writes the data of the frame starting at pos to w decompressing it incrementally.
+func (r *readerImpl) writeStream(ctx context.Context, index *env.FrameOffsetEntry, pos uint64, w io.Writer) (int64, error) {
+	fs, err := r.stream(ctx, index, pos)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.Close()
+
+	// Reads of fs stop at the frame size, the verification error is returned after the last write.
+	return io.CopyBuffer(w, fs, make([]byte, min(streamBufferSize, int(index.DecompSize))))
+}
+
+// streamBufferSize is the size of the buffer frames are written with by writeStream.
+const streamBufferSize = 1 << 20
@@ -0,0 +1,67 @@
+package seekable
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// tracerName is the instrumentation scope of the spans started by the reader.
+const tracerName = "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+
+// Names of the spans started by the reader, see WithTracerProvider.
+const (
+	spanFetch          = "seekable.fetch"
+	spanDecode         = "seekable.decode"
+	spanParseSeekTable = "seekable.parse_seek_table"
+)
+
+// WithTracerProvider makes the reader trace its reads with OpenTelemetry: it starts a span
+// for each read from the environment, each frame decompressed and each seek table parsed.
+// The spans are children of the span of the context passed to ReadAtContext, or set by
+// WithRContext, and carry the frame index and sizes as attributes.
+func WithTracerProvider(tp trace.TracerProvider) rOption {
+	return func(r *readerImpl) error {
+		if tp == nil {
+			return fmt.Errorf("tracer provider must not be nil")
+		}
+		r.tracer = tp.Tracer(tracerName)
+		return nil
+	}
+}
+
+// startSpan starts the span if tracing is enabled by WithTracerProvider, end must be called
+// with the result of the traced operation once it is done.
+func (r *readerImpl) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (end func(error)) {
+	if r.tracer == nil {
+		return endNoSpan
+	}
+	_, span := r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func endNoSpan(error) {}
+
+// traceFrame is startSpan for the operation on the frame described by index.
+func (r *readerImpl) traceFrame(ctx context.Context, name string, index *env.FrameOffsetEntry) (end func(error)) {
+	if r.tracer == nil {
+		return endNoSpan
+	}
+	return r.startSpan(ctx, name,
+		attribute.Int64("seekable.frame.id", index.ID),
+		attribute.Int64("seekable.frame.compressed_offset", int64(index.CompOffset)),
+		attribute.Int64("seekable.frame.compressed_size", int64(index.CompSize)),
+		attribute.Int64("seekable.frame.decompressed_size", int64(index.DecompSize)),
+	)
+}
@@ -0,0 +1,130 @@
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	noop.Tracer
+
+	m     sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	noop.Span
+
+	name   string
+	parent trace.Span
+	attrs  map[attribute.Key]int64
+	status codes.Code
+	ended  bool
+}
+
+type recordingTracerProvider struct {
+	noop.TracerProvider
+	t *recordingTracer
+}
+
+func (p recordingTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return p.t
+}
+
+func (t *recordingTracer) Start(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	s := &recordedSpan{name: name, parent: trace.SpanFromContext(ctx), attrs: make(map[attribute.Key]int64)}
+	cfg := trace.NewSpanStartConfig(opts...)
+	for _, kv := range cfg.Attributes() {
+		s.attrs[kv.Key] = kv.Value.AsInt64()
+	}
+	t.m.Lock()
+	t.spans = append(t.spans, s)
+	t.m.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *recordedSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+// named returns the recorded spans with the given name.
+func (t *recordingTracer) named(name string) []*recordedSpan {
+	t.m.Lock()
+	defer t.m.Unlock()
+	var spans []*recordedSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestReaderTracing(t *testing.T) {
+	t.Parallel()
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewReader(bytes.NewReader(checksum), dec, WithTracerProvider(nil))
+	require.ErrorContains(t, err, "tracer provider")
+
+	tracer := &recordingTracer{}
+	r, err := NewReader(bytes.NewReader(checksum), dec, WithTracerProvider(recordingTracerProvider{t: tracer}))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	parse := tracer.named(spanParseSeekTable)
+	require.Len(t, parse, 1)
+	assert.True(t, parse[0].ended)
+	assert.Equal(t, codes.Unset, parse[0].status)
+
+	parent := &recordedSpan{}
+	buf := make([]byte, 9)
+	_, err = r.ReadAtContext(trace.ContextWithSpan(context.Background(), parent), buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), buf)
+
+	for _, name := range []string{spanFetch, spanDecode} {
+		spans := tracer.named(name)
+		require.Len(t, spans, 2, name)
+		for id, s := range spans {
+			f, err := r.Frame(int64(id))
+			require.NoError(t, err)
+			assert.True(t, s.ended)
+			assert.Same(t, parent, s.parent)
+			assert.Equal(t, map[attribute.Key]int64{
+				"seekable.frame.id":                f.ID,
+				"seekable.frame.compressed_offset": f.CompressedOffset,
+				"seekable.frame.compressed_size":   f.CompressedSize,
+				"seekable.frame.decompressed_size": f.DecompressedSize,
+			}, s.attrs)
+		}
+	}
+
+	// Failed operations are recorded as errors.
+	corrupt := bytes.Clone(checksum)
+	corrupt[len(corrupt)-9-1] ^= 0xff
+	tracer = &recordingTracer{}
+	cr, err := NewReader(bytes.NewReader(corrupt), dec, WithTracerProvider(recordingTracerProvider{t: tracer}))
+	require.NoError(t, err)
+	_, err = cr.ReadAt(buf, 0)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	require.NoError(t, cr.Close())
+	decode := tracer.named(spanDecode)
+	require.NotEmpty(t, decode)
+	assert.Equal(t, codes.Error, decode[len(decode)-1].status)
+}
@@ -0,0 +1,297 @@
+package seekable
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
+)
+
+// maxSeekTableProblems is the maximum number of problems collected by Validate.
+const maxSeekTableProblems = 100
+
+// SeekTableReport is the result of Reader's Validate.
+type SeekTableReport struct {
+	// NumFrames is the number of frames declared by the seek table footer.
+	NumFrames int64
+	// IndexedFrames is the number of frames parsed from the seek table entries.
+	IndexedFrames int64
+	// CompressedSize and DecompressedSize are the total sizes of all frames.
+	CompressedSize   int64
+	DecompressedSize int64
+	// SeekTableSize is the size of the seek table skippable frame.
+	SeekTableSize int64
+	// StreamSize is the size of the compressed stream, or -1 if the environment does not know it.
+	StreamSize int64
+	// HasChecksums is set if the seek table has frame checksums.
+	HasChecksums bool
+
+	// Problems are the inconsistencies found, at most 100 of them.
+	Problems []SeekTableProblem
+	// Truncated is set if there were more problems than reported.
+	Truncated bool
+}
+
+// SeekTableProblem is an inconsistency of the seek table found by Reader's Validate.
+type SeekTableProblem struct {
+	// Frame is the ID of the frame, or -1 if the problem is with the seek table as a whole.
+	Frame int64
+	// Err describes the problem, it wraps ErrCorruptSeekTable.
+	Err error
+}
+
+// Err returns the problems joined, or nil if there are none.
+func (r *SeekTableReport) Err() error {
+	errs := make([]error, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		errs = append(errs, p.Err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *SeekTableReport) add(frame int64, format string, args ...any) {
+	if len(r.Problems) == maxSeekTableProblems {
+		r.Truncated = true
+		return
+	}
+	r.Problems = append(r.Problems, SeekTableProblem{
+		Frame: frame,
+		Err:   fmt.Errorf("%w: %s", ErrCorruptSeekTable, fmt.Sprintf(format, args...)),
+	})
+}
+
+func (r *readerImpl) Validate() (SeekTableReport, error) {
+	if r.closed.Load() {
+		return SeekTableReport{}, fmt.Errorf("reader is closed")
+	}
+
+	idx := r.idx()
+	report := SeekTableReport{
+		NumFrames:     idx.footerFrames,
+		SeekTableSize: idx.seekTableFrameSize,
+		StreamSize:    -1,
+		HasChecksums:  idx.checksums,
+	}
+	emptyChecksum := frameChecksum(nil)
+
+	var next *env.FrameOffsetEntry
+	if first := r.GetIndexByID(0); first != nil {
+		idx.index.ascend(first, func(index *env.FrameOffsetEntry) bool {
+			report.IndexedFrames++
+			if next != nil {
+				if index.ID != next.ID {
+					report.add(index.ID, "frame %d follows frame %d", index.ID, next.ID-1)
+				}
+				if index.CompOffset != next.CompOffset {
+					report.add(index.ID, "compressed offset: %d, expected: %d", index.CompOffset, next.CompOffset)
+				}
+				if index.DecompOffset != next.DecompOffset {
+					report.add(index.ID, "decompressed offset: %d, expected: %d", index.DecompOffset, next.DecompOffset)
+				}
+			} else if index.CompOffset != 0 || index.DecompOffset != 0 {
+				report.add(index.ID, "first frame is at: %d, decompressed: %d", index.CompOffset, index.DecompOffset)
+			}
+
+			if index.CompSize == 0 && index.DecompSize != 0 {
+				report.add(index.ID, "empty frame has %d bytes of data", index.DecompSize)
+			}
+			if index.CompSize > maxDecoderFrameSize {
+				report.add(index.ID, "frame is too big: %d > %d", index.CompSize, maxDecoderFrameSize)
+			}
+			if idx.checksums && index.DecompSize == 0 && index.Checksum != emptyChecksum {
+				report.add(index.ID, "frame without data has checksum: %d, expected: %d", index.Checksum, emptyChecksum)
+			}
+
+			report.CompressedSize += int64(index.CompSize)
+			report.DecompressedSize += int64(index.DecompSize)
+			next = &env.FrameOffsetEntry{
+				ID:           index.ID + 1,
+				CompOffset:   index.CompOffset + uint64(index.CompSize),
+				DecompOffset: index.DecompOffset + uint64(index.DecompSize),
+			}
+			return true
+		})
+	}
+
+	if report.IndexedFrames != report.NumFrames {
+		report.add(-1, "footer declares %d frames, seek table has: %d", report.NumFrames, report.IndexedFrames)
+	}
+	if report.DecompressedSize != idx.endOffset {
+		report.add(-1, "decompressed size: %d, expected: %d", report.DecompressedSize, idx.endOffset)
+	}
+
+	if sizer, ok := r.env.(env.Sizer); ok {
+		size, err := sizer.Size()
+		if err != nil {
+			return SeekTableReport{}, fmt.Errorf("failed to get stream size: %w", err)
+		}
+		report.StreamSize = size
+		if expected := report.CompressedSize + report.SeekTableSize; size != expected {
+			report.add(-1, "stream size: %d, frames and seek table: %d", size, expected)
+		}
+	}
+	return report, nil
+}
+
+// VerifyOptions configure Reader's VerifyAll.
+type VerifyOptions struct {
+	// Decode makes VerifyAll decompress every frame and recompute its checksum instead of
+	// only reading it and checking its header.
+	Decode bool
+	// Concurrency is the number of frames verified at once, one if it is not positive.
+	// Frames are verified one at a time if the underlying reader does not implement io.ReaderAt.
+	Concurrency int
+}
+
+// VerifyReport is the result of Reader's VerifyAll.
+type VerifyReport struct {
+	// SeekTable is the report of the seek table checks, see Reader's Validate.
+	SeekTable SeekTableReport
+	// FramesChecked is the number of frames read, FramesDecoded is the number of them
+	// that were decompressed.  Frames without data are not read.
+	FramesChecked int64
+	FramesDecoded int64
+
+	// Problems are the frames that failed verification ordered by their IDs, at most 100 of them.
+	Problems []SeekTableProblem
+	// Truncated is set if there were more problems than reported.
+	Truncated bool
+}
+
+// Err returns the problems of the seek table and the frames joined, or nil if there are none.
+func (r *VerifyReport) Err() error {
+	errs := []error{r.SeekTable.Err()}
+	for _, p := range r.Problems {
+		errs = append(errs, p.Err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *readerImpl) VerifyAll(ctx context.Context, opts VerifyOptions) (VerifyReport, error) {
+	table, err := r.Validate()
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	report := VerifyReport{SeekTable: table}
+
+	concurrency := max(opts.Concurrency, 1)
+	if e, ok := r.env.(*readSeekerEnvImpl); ok {
+		if _, ok := e.rs.(io.ReaderAt); !ok {
+			concurrency = 1
+		}
+	}
+
+	var m sync.Mutex
+	g := errgroup.Group{}
+	g.SetLimit(concurrency)
+	idx := r.idx()
+	if first := idx.index.byID(0); first != nil {
+		idx.index.ascend(first, func(index *env.FrameOffsetEntry) bool {
+			if ctx.Err() != nil {
+				return false
+			}
+			if index.CompSize == 0 {
+				return true
+			}
+			g.Go(func() error {
+				err := r.verifyFrame(ctx, index, opts.Decode)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				m.Lock()
+				defer m.Unlock()
+				report.FramesChecked++
+				if opts.Decode && err == nil {
+					report.FramesDecoded++
+				}
+				if err == nil {
+					return nil
+				}
+				if len(report.Problems) == maxSeekTableProblems {
+					report.Truncated = true
+					return nil
+				}
+				report.Problems = append(report.Problems, SeekTableProblem{Frame: index.ID, Err: err})
+				return nil
+			})
+			return true
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return VerifyReport{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return VerifyReport{}, err
+	}
+
+	slices.SortFunc(report.Problems, func(a, b SeekTableProblem) int { return cmp.Compare(a.Frame, b.Frame) })
+	return report, nil
+}
+
+// verifyFrame reads the frame described by index from the environment and checks it,
+// see VerifyAll.
+func (r *readerImpl) verifyFrame(ctx context.Context, index *env.FrameOffsetEntry, decode bool) error {
+	if index.CompSize > maxDecoderFrameSize {
+		return fmt.Errorf("%w: frame is too big: %d > %d", ErrFrameTooLarge, index.CompSize, maxDecoderFrameSize)
+	}
+	reserved := int64(index.CompSize)
+	if decode {
+		reserved += int64(index.DecompSize)
+	}
+	if err := r.memory.acquire(reserved); err != nil {
+		return err
+	}
+	defer r.memory.release(reserved)
+
+	src, put, err := r.fetchScratch(ctx, index)
+	if err != nil {
+		return err
+	}
+	defer put()
+	// Skippable frames written by Writer's WriteSkippableFrame are in the seek table too.
+	if len(src) >= frameSizeFieldSize+skippableMagicNumberFieldSize &&
+		binary.LittleEndian.Uint32(src)&0xFFFFFFF0 == skippableFrameMagic {
+		if size := binary.LittleEndian.Uint32(src[4:]); int64(size) != int64(len(src))-8 || index.DecompSize != 0 {
+			return fmt.Errorf("%w: skippable frame at: %d: size: %d, compressed size: %d, decompressed size: %d",
+				ErrCorruptSeekTable, index.CompOffset, size, len(src), index.DecompSize)
+		}
+		return nil
+	}
+	h, err := parseZSTDFrameHeader(src)
+	if err != nil {
+		return fmt.Errorf("%w: invalid frame header at: %d: %w", ErrCorruptSeekTable, index.CompOffset, err)
+	}
+	if h.HasContentSize && h.ContentSize != uint64(index.DecompSize) {
+		return fmt.Errorf("%w: frame content size at: %d: %d, index: %d",
+			ErrCorruptSeekTable, index.CompOffset, h.ContentSize, index.DecompSize)
+	}
+	if !decode {
+		return nil
+	}
+
+	dict, err := r.frameDict(index, h)
+	if err != nil {
+		return err
+	}
+	data, err := r.decompress(index, src, nil, dict)
+	if err != nil {
+		return err
+	}
+	// decompress only verifies the checksum unless it is disabled by WithChecksumVerification.
+	if r.idx().checksums && !r.verifyChecksums {
+		if checksum := frameChecksum(data); index.Checksum != checksum {
+			return fmt.Errorf("%w: checksum verification failed at: %d: expected: %d, actual: %d",
+				ErrChecksumMismatch, index.CompOffset, index.Checksum, checksum)
+		}
+	}
+	return nil
+}
@@ -2,13 +2,20 @@ package seekable
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"runtime"
 	"sync"
+	"unsafe"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
+	"go.uber.org/atomic"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
@@ -28,45 +35,336 @@ func (w *writerEnvImpl) WriteSeekTable(p []byte) (n int, err error) {
 	return w.w.Write(p)
 }
 
+// writerAtEnvImpl is the environment implementation for the underlying io.WriterAt.
+// Space for each frame is reserved sequentially, while the data itself can be
+// written concurrently.
+type writerAtEnvImpl struct {
+	w      io.WriterAt
+	offset atomic.Int64
+}
+
+// reserve returns the offset of the next n bytes of the stream.
+func (w *writerAtEnvImpl) reserve(n int) int64 {
+	return w.offset.Add(int64(n)) - int64(n)
+}
+
+func (w *writerAtEnvImpl) WriteFrame(p []byte) (n int, err error) {
+	return w.w.WriteAt(p, w.reserve(len(p)))
+}
+
+func (w *writerAtEnvImpl) WriteSeekTable(p []byte) (n int, err error) {
+	return w.w.WriteAt(p, w.reserve(len(p)))
+}
+
 type writerImpl struct {
 	enc          ZSTDEncoder
 	frameEntries []seekTableEntry
 
+	// encoders, if set by WithEncoderFactory, is used instead of enc.
+	encoders *encoderPool
+
+	// levelFactory, if set by WithLevelEncoderFactory, creates encoders for WriteWithLevel.
+	// They are pooled in levelEncoders by level, and levelEnc is used by the write in progress.
+	levelFactory  func(level int) ZSTDEncoder
+	levelEncoders map[int]*encoderPool
+	levelEnc      *encoderPool
+
+	// frameSize is the maximum uncompressed size of a single frame produced by Write.
+	// Zero means that every Write maps to exactly one frame.
+	frameSize int
+
+	// minFrameSize is the minimum uncompressed size of a frame produced by Write.
+	// Smaller writes are buffered in pending until the threshold is reached.
+	minFrameSize int
+	pending      []byte
+
+	// rsync, if set by WithRsyncable, picks content-defined frame boundaries for Write.
+	rsyncAverage int
+	rsync        *rollingSplitter
+
+	// targetSize, if positive, is the compressed size of the frames produced by Write.
+	// targetIn and targetOut are the sizes of the latest frame, used to estimate
+	// how much data fits into the next one.
+	targetSize int64
+	targetIn   int64
+	targetOut  int64
+
+	// checksums controls whether seek table entries contain frame checksums.
+	// The choice is fixed for the whole stream.
+	checksums    bool
+	checksumsSet bool
+
+	// dest is the writer or the environment passed by the user, which is synced on Close
+	// if syncOnClose is set by WithSyncOnClose.
+	dest        any
+	syncOnClose bool
+
+	// sidecar, if set, receives a copy of the seek table written on Close.
+	// inlineSeekTable controls whether the seek table is appended to the stream itself.
+	sidecar         io.Writer
+	inlineSeekTable bool
+
+	// frameLog, if set by WithFrameLog, receives a JSON description of every committed frame.
+	// frameLogErr is the first error of writing to it, reported by Close.
+	frameLog    *json.Encoder
+	frameLogErr error
+
+	// compressSeekTable makes the seek table entries compressed with enc.
+	compressSeekTable bool
+
+	// dictID is the ID of the dictionary set by WithDictionary, zero if none.
+	// The dictionary metadata frame is written before the first frame of the stream.
+	dictID        uint32
+	headerWritten bool
+
+	// alignment is the boundary data frames start at, see WithFrameAlignment.
+	alignment int64
+
+	// frameTags are the tags attached by WriteTagged, written in an extension frame on Close.
+	frameTags []frameTag
+	// sections are the named sections added by WriteSection, written in an extension frame on Close.
+	sections []section
+
+	// digest, if set by WithContentDigest, hashes all uncompressed data in the stream order.
+	digest hash.Hash
+	// memoryLimit, if set by WithWriterMemoryLimit, bounds the memory held by the writer.
+	memoryLimit int64
+
+	// tee, if set by WithTee, receives a copy of all uncompressed data in the stream order.
+	tee io.Writer
+
+	// deterministic is set by WithDeterministicOutput.  encoderVerified is set once
+	// the encoder produced the same output twice, pooled encoders are verified one by one.
+	deterministic   bool
+	encoderVerified atomic.Bool
+
+	// checkpointEvery, if positive, makes the writer write a checkpoint after every
+	// checkpointEvery data frames.  sinceCheckpoint counts data frames since the last one.
+	checkpointEvery int64
+	sinceCheckpoint int64
+
+	// maxFrameSize and maxFrames are the limits of the seekable format.
+	// maxFrameSize can be lowered with WithMaxFrameSize, in which case
+	// splitOversizedWrites makes writes bigger than that split instead of rejected.
+	maxFrameSize         int64
+	maxFrames            int64
+	splitOversizedWrites bool
+
+	// totals over all committed frames, can be read concurrently by Stats
+	frames           atomic.Int64
+	uncompressedSize atomic.Int64
+	compressedSize   atomic.Int64
+
+	progress       func(uncompressed, compressed int64, frames int)
+	onFrameWritten func(env.FrameOffsetEntry)
+
 	logger *zap.Logger
 	env    env.WEnvironment
 
+	// retry, if set, is applied to the environment by the constructors.
+	retry *RetryPolicy
+
+	// emptyFrames is set by WithAllowEmptyFrames.
+	emptyFrames emptyFrameMode
+
+	// buffers supply the destination buffers of the compressed frames, see WithBufferPool.
+	buffers BufferPool
+
+	// stringSrc is set while WriteString passes the string's bytes down the write path.
+	stringSrc bool
+
+	// headSize, if set by WithSeekTableAtHead, is the space reserved for the seek table
+	// at the beginning of the stream, which is written into head on Close.
+	headSize int64
+	head     io.WriterAt
+
+	// partEnv, if WithPartSize is set, is notified once at least partSize bytes were written
+	// since partStart, the offset of the current part.
+	partEnv   env.PartWEnvironment
+	partSize  int64
+	partStart int64
+
+	// customEnv is set if the environment was passed with WithWEnvironment.
+	customEnv bool
+
+	// replicas receive a copy of every frame and the seek table, see WithWFanOut.
+	replicas []io.Writer
+
 	once *sync.Once
 }
 
 var (
-	_ io.Writer = (*writerImpl)(nil)
-	_ io.Closer = (*writerImpl)(nil)
+	_ io.Writer     = (*writerImpl)(nil)
+	_ io.ReaderFrom = (*writerImpl)(nil)
+	_ io.Closer     = (*writerImpl)(nil)
 )
 
+// defaultReadFromChunkSize is the frame size used by ReadFrom when WithFrameSize is not set.
+const defaultReadFromChunkSize = 1 << 20
+
 type Writer interface {
 	// Write writes a chunk of data as a separate frame into the datastream.
 	//
-	// Note that Write does not do any coalescing nor splitting of data,
-	// so each write will map to a separate ZSTD Frame.
+	// By default Write does not do any coalescing of data, so each write will map
+	// to at least one separate ZSTD Frame.  If WithFrameSize is set, writes larger
+	// than the frame size are split into multiple frames.  If WithMinFrameSize is set,
+	// small writes are buffered until the minimum frame size is reached.  If WithRsyncable
+	// is set, frame boundaries are picked based on the content regardless of the writes.
+	// Empty writes produce empty frames, see WithAllowEmptyFrames.
 	Write(src []byte) (int, error)
 
-	// Close implement io.Closer interface.  It writes the seek table footer
-	// and releases occupied memory.
+	// WriteString implements io.StringWriter interface.  It is similar to Write, but if
+	// the encoder implements ZSTDStringEncoder, data is compressed directly from s without
+	// copying it into a []byte first.
+	WriteString(s string) (int, error)
+
+	// WriteTagged is similar to Write but attaches an opaque tag of up to 255 bytes to the
+	// resulting frames, e.g. the time range or the key range of the data.  Data buffered
+	// by Write is flushed first, so src never shares a frame with untagged data.
+	//
+	// Tags are stored in an extension frame right before the seek table on Close and
+	// can be retrieved with Reader's FrameTag without decompressing any frames.
+	WriteTagged(src, tag []byte) (int, error)
+
+	// WriteWithLevel compresses src as its own frame with the encoder for the given
+	// compression level created by WithLevelEncoderFactory, e.g. to compress cold data harder
+	// than the rest of the stream.  Writes larger than the frame size are split into multiple
+	// frames.  Data buffered by Write is flushed first with the default encoder.
+	WriteWithLevel(src []byte, level int) (int, error)
+
+	// ReadFrom implements io.ReaderFrom interface.  It reads r until EOF in chunks
+	// of the frame size (see WithFrameSize, 1MiB by default) and writes each chunk as a frame.
+	// It returns the number of bytes consumed from r.
+	ReadFrom(r io.Reader) (int64, error)
+
+	// Flush writes out buffered data followed by a checkpoint: a snapshot of the current
+	// seek table stored in a skippable frame.  The stream is not finalized, so writing
+	// can continue after Flush.  If the stream is later truncated, e.g. because of a crash,
+	// Reader will still be able to randomly access all data written before the latest checkpoint.
+	//
+	// Flush must not be called concurrently with WriteMany.
+	Flush() error
+
+	// WriteCompressedFrame writes an already compressed ZSTD frame as is, e.g. a frame copied
+	// from another seekable stream, without paying for decompression and recompression.
+	// decompressedSize must be the exact size of the frame's data.  If frame checksums are
+	// enabled, checksum must be the least significant 32 bits of the XXH64 digest of the data,
+	// as stored in other stream's seek table; otherwise it is ignored.
+	//
+	// The frame must be a single ZSTD frame that can be decoded by the reader's decoder.
+	// Only the frame header is validated.
+	WriteCompressedFrame(frame []byte, decompressedSize, checksum uint32) error
+
+	// WriteSkippableFrame writes payload as a skippable frame with the magic number
+	// 0x184D2A50+tag into the datastream, after all the data written so far.  Skippable frames
+	// are ignored by ZSTD decoders and are recorded in the seek table as frames without any
+	// uncompressed data, so they are transparent for the Reader.
+	//
+	// Tags 0xD and 0xE are reserved by this library and are rejected.
+	// WriteSkippableFrame must not be called concurrently with WriteMany.
+	WriteSkippableFrame(tag uint32, payload []byte) error
+
+	// Stats returns statistics about the data written so far.
+	// This method is goroutine-safe and can be called concurrently with writes.
+	Stats() WriterStats
+
+	// Close implement io.Closer interface.  It flushes buffered data, writes the seek
+	// table footer and releases occupied memory.
 	//
 	// Caller is still responsible to Close the underlying writer.
 	Close() (err error)
+
+	// Reset discards the state of the writer and starts a new stream into w with the same
+	// options and encoder, so that services producing many streams can reuse the writer's
+	// buffers and encoders.  Data that was not written out by Close is lost.
+	//
+	// Only writers created by NewWriter or NewWriterAt without WithWEnvironment
+	// and WithWFanOut can be reset.  Writers passed with WithSidecarSeekTable and
+	// WithFrameLog are kept and receive the data of the new stream as well.
+	Reset(w io.Writer) error
+}
+
+// WriterStats contains statistics about the data written by the Writer.
+type WriterStats struct {
+	// Frames is the number of frames in the seek table, including skippable frames.
+	Frames int64
+	// UncompressedBytes is the total size of the data passed to the writer.
+	UncompressedBytes int64
+	// CompressedBytes is the total size of the frames written to the underlying writer,
+	// excluding the seek table.
+	CompressedBytes int64
+	// SeekTableSize is the size of the seek table that would be written on Close.
+	// If the seek table is compressed, this is the size of an uncompressed one.
+	SeekTableSize int64
 }
 
 // FrameSource returns one frame of data at a time.
 // When there are no more frames, returns nil.
 type FrameSource func() ([]byte, error)
 
+// FrameSourceFromChannel returns the FrameSource that receives frames from ch until it is closed.
+// If ctx is done first, the source fails with ctx.Err(), so WriteMany does not block forever
+// on a producer that is gone.  Since nil ends the FrameSource, nil frames sent to ch are
+// treated as empty writes: they are written as empty frames, skipped if WithAllowEmptyFrames
+// is enabled or fail WriteMany with ErrEmptyFrame if it is disabled.
+//
+// Producers should stop sending once WriteMany returns, e.g. by sharing an errgroup context:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	ch := make(chan []byte)
+//	g.Go(func() error {
+//		defer close(ch)
+//		for ... {
+//			select {
+//			case ch <- frame:
+//			case <-ctx.Done():
+//				return ctx.Err()
+//			}
+//		}
+//		return nil
+//	})
+//	g.Go(func() error { return w.WriteMany(ctx, seekable.FrameSourceFromChannel(ctx, ch)) })
+//	err := g.Wait()
+func FrameSourceFromChannel(ctx context.Context, ch <-chan []byte) FrameSource {
+	return func() ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case frame, ok := <-ch:
+			if !ok {
+				return nil, nil
+			}
+			if frame == nil {
+				frame = []byte{}
+			}
+			return frame, nil
+		}
+	}
+}
+
 // ConcurrentWriter allows writing many frames concurrently
 type ConcurrentWriter interface {
 	Writer
 
-	// WriteMany writes many frames concurrently
+	// WriteMany writes many frames concurrently.
+	//
+	// Frames are compressed by a pool of workers (see WithConcurrency) but are
+	// always written to the underlying writer in the order returned by frameSource.
+	//
+	// Memory used by the frames in flight can be bounded with WithMaxInFlightBytes.
+	//
+	// Cancelling ctx aborts the write between frames and makes WriteMany return ctx.Err().
+	// Frames committed before the cancellation stay in the seek table.
 	WriteMany(ctx context.Context, frameSource FrameSource, options ...WriteManyOption) error
+
+	// WriteSection is similar to WriteMany but records the written data as a section with
+	// the given name, which can be opened with Reader's OpenSection.  Data buffered by Write
+	// is flushed first, so the section starts at a frame boundary.  Names must be unique
+	// and up to 65535 bytes long.
+	//
+	// The directory of sections is stored in an extension frame right before the seek table
+	// on Close.  A section is recorded only if WriteSection succeeds.
+	WriteSection(ctx context.Context, name string, frameSource FrameSource, options ...WriteManyOption) error
 }
 
 // ZSTDEncoder is the compressor.  Tested with github.com/klauspost/compress/zstd.
@@ -74,12 +372,132 @@ type ZSTDEncoder interface {
 	EncodeAll(src, dst []byte) []byte
 }
 
+// ZSTDStringEncoder is an optional interface of ZSTDEncoder for encoders that can compress
+// a string, which allows Writer.WriteString to avoid copying the data.
+type ZSTDStringEncoder interface {
+	EncodeAllString(src string, dst []byte) []byte
+}
+
 // NewWriter wraps the passed io.Writer and Encoder into and indexed ZSTD stream.
 // Resulting stream then can be randomly accessed through the Reader and Decoder interfaces.
+//
+// Encoder can be nil if WithEncoderFactory is used.
 func NewWriter(w io.Writer, encoder ZSTDEncoder, opts ...wOption) (ConcurrentWriter, error) {
+	sw, err := newWriter(encoder, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if sw.env != nil {
+		sw.customEnv = true
+		sw.dest = sw.env
+		if sw.buffers == nil {
+			// Custom environments may retain the frames passed to WriteFrame.
+			sw.buffers = noBufferPool{}
+		}
+	} else {
+		sw.env = &writerEnvImpl{
+			w: w,
+		}
+		sw.dest = w
+	}
+	if sw.buffers == nil {
+		sw.buffers = &syncBufferPool{}
+	}
+	if sw.headSize > 0 {
+		return nil, fmt.Errorf("seek table at the head can only be used with io.WriterAt")
+	}
+	if sw.partSize > 0 {
+		pe, ok := sw.env.(env.PartWEnvironment)
+		if !ok {
+			return nil, fmt.Errorf("part size can only be used with env.PartWEnvironment: %T", sw.env)
+		}
+		sw.partEnv = pe
+	}
+	sw.wrapEnvironment()
+
+	return sw, nil
+}
+
+// wrapEnvironment applies retries and fan-out to the environment.
+func (s *writerImpl) wrapEnvironment() {
+	sinks := []env.WEnvironment{s.env}
+	for _, r := range s.replicas {
+		sinks = append(sinks, &writerEnvImpl{w: r})
+	}
+	if s.retry != nil {
+		// Retry sinks independently, so that a retry never duplicates data
+		// in a sink that succeeded.
+		for i := range sinks {
+			sinks[i] = &retryWEnvironment{env: sinks[i], policy: *s.retry}
+		}
+	}
+	s.env = sinks[0]
+	if len(sinks) > 1 {
+		s.env = env.NewFanOutWEnvironment(sinks...)
+	}
+}
+
+// NewWriterAt is similar to NewWriter but writes the stream into the passed io.WriterAt
+// starting at offset 0.
+//
+// WriteMany reserves space for each compressed frame in order and then writes frames
+// concurrently, so that slow storage does not become a bottleneck.  Passed io.WriterAt
+// must support concurrent WriteAt calls to non-overlapping regions, e.g. *os.File.
+func NewWriterAt(w io.WriterAt, encoder ZSTDEncoder, opts ...wOption) (ConcurrentWriter, error) {
+	sw, err := newWriter(encoder, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if sw.env != nil {
+		return nil, fmt.Errorf("custom environment can not be used with io.WriterAt")
+	}
+	if sw.partSize > 0 {
+		return nil, fmt.Errorf("part size can not be used with io.WriterAt")
+	}
+	if len(sw.replicas) > 0 {
+		return nil, fmt.Errorf("fan-out can not be used with io.WriterAt")
+	}
+	sw.dest = w
+	if sw.retry != nil {
+		w = &retryWriterAt{w: w, policy: *sw.retry}
+	}
+	wa := &writerAtEnvImpl{
+		w: w,
+	}
+	sw.env = wa
+	if sw.buffers == nil {
+		sw.buffers = &syncBufferPool{}
+	}
+
+	if sw.headSize > 0 {
+		// The space is accounted for as a frame without data, so that
+		// the offsets of the seek table include it.
+		wa.reserve(int(sw.headSize))
+		sw.head = w
+		sw.commitFrame(seekTableEntry{
+			CompressedSize: uint32(sw.headSize),
+			Checksum:       sw.frameChecksum(nil),
+		})
+	}
+
+	return sw, nil
+}
+
+func newWriter(encoder ZSTDEncoder, opts ...wOption) (*writerImpl, error) {
 	sw := writerImpl{
-		once: &sync.Once{},
-		enc:  encoder,
+		once:      &sync.Once{},
+		enc:       encoder,
+		checksums: true,
+
+		inlineSeekTable: true,
+
+		maxFrameSize: maxChunkSize,
+		maxFrames:    maxNumberOfFrames,
+
+		targetIn:  1,
+		targetOut: 1,
 	}
 
 	sw.logger = zap.NewNop()
@@ -90,149 +508,1206 @@ func NewWriter(w io.Writer, encoder ZSTDEncoder, opts ...wOption) (ConcurrentWri
 		}
 	}
 
-	if sw.env == nil {
-		sw.env = &writerEnvImpl{
-			w: w,
+	if sw.enc == nil && sw.encoders == nil {
+		return nil, fmt.Errorf("encoder is nil")
+	}
+	if sw.splitOversizedWrites && (sw.frameSize <= 0 || int64(sw.frameSize) > sw.maxFrameSize) {
+		sw.frameSize = int(sw.maxFrameSize)
+	}
+	if int64(sw.frameSize) > sw.maxFrameSize || int64(sw.minFrameSize) > sw.maxFrameSize {
+		return nil, fmt.Errorf("frame size is bigger than max frame size: %d > %d",
+			max(sw.frameSize, sw.minFrameSize), sw.maxFrameSize)
+	}
+	if sw.frameSize > 0 && sw.minFrameSize > sw.frameSize {
+		return nil, fmt.Errorf("min frame size is bigger than frame size: %d > %d",
+			sw.minFrameSize, sw.frameSize)
+	}
+	if sw.rsyncAverage > 0 {
+		minSize, maxSize := sw.rsyncAverage/4, sw.rsyncAverage*4
+		if sw.minFrameSize > minSize {
+			minSize = sw.minFrameSize
+		}
+		if sw.frameSize > 0 {
+			maxSize = sw.frameSize
+		}
+		if int64(maxSize) > sw.maxFrameSize {
+			maxSize = int(sw.maxFrameSize)
 		}
+		if minSize > maxSize {
+			return nil, fmt.Errorf("rsyncable min frame size is bigger than frame size: %d > %d", minSize, maxSize)
+		}
+		sw.rsync = newRollingSplitter(sw.rsyncAverage, minSize, maxSize)
+	}
+	if sw.targetSize > 0 && (sw.rsync != nil || sw.minFrameSize > 0) {
+		return nil, fmt.Errorf("target compressed frame size can not be combined with rsyncable or min frame size")
+	}
+	if !sw.inlineSeekTable && sw.sidecar == nil {
+		return nil, fmt.Errorf("inline seek table can only be disabled with a sidecar seek table")
 	}
 
 	return &sw, nil
 }
 
 func (s *writerImpl) Write(src []byte) (int, error) {
-	dst, err := s.Encode(src)
-	if err != nil {
+	if skip, err := s.skipEmpty(src); skip || err != nil {
 		return 0, err
 	}
+	if s.rsync != nil {
+		return s.writeRsyncable(src)
+	}
+	if s.targetSize > 0 {
+		if err := s.checkMemory(int64(len(src))); err != nil {
+			return 0, err
+		}
+		buffered := len(s.pending)
+		s.pending = append(s.pending, src...)
+		if err := s.writeTargeted(false); err != nil {
+			return s.dropUnwritten(buffered, len(src)), err
+		}
+		return len(src), nil
+	}
 
-	n, err := s.env.WriteFrame(dst)
-	if err != nil {
+	if s.minFrameSize <= 0 || (len(s.pending) == 0 && len(src) >= s.minFrameSize) {
+		return s.write(src)
+	}
+
+	if err := s.checkMemory(int64(len(src))); err != nil {
 		return 0, err
 	}
-	if n != len(dst) {
-		return 0, fmt.Errorf("partial write: %d out of %d", n, len(dst))
+	s.pending = append(s.pending, src...)
+	if len(s.pending) < s.minFrameSize {
+		return len(src), nil
+	}
+	if err := s.flushPending(); err != nil {
+		return 0, err
 	}
-
 	return len(src), nil
 }
 
-func (s *writerImpl) Close() (err error) {
-	s.once.Do(func() {
-		err = multierr.Append(err, s.writeSeekTable())
-	})
-	return
+func (s *writerImpl) WriteString(str string) (int, error) {
+	if !s.encodesStrings() {
+		return s.Write([]byte(str))
+	}
+
+	// The rest of the write path only reads src or copies it, so the string
+	// itself can be used as long as it reaches the encoder as a string.
+	s.stringSrc = true
+	defer func() { s.stringSrc = false }()
+	return s.Write(unsafe.Slice(unsafe.StringData(str), len(str)))
 }
 
-type encodeResult struct {
-	buf   []byte
-	entry seekTableEntry
+// encodesStrings reports whether the encoder implements ZSTDStringEncoder.
+func (s *writerImpl) encodesStrings() bool {
+	enc := s.enc
+	if s.encoders != nil {
+		pooled := s.encoders.get()
+		defer s.encoders.put(pooled)
+		enc = pooled.enc
+	}
+	_, ok := enc.(ZSTDStringEncoder)
+	return ok
 }
 
-func (s *writerImpl) writeManyEncoder(ctx context.Context, ch chan<- encodeResult, frame []byte) func() error {
-	return func() error {
-		dst, entry, err := s.encodeOne(frame)
+// splitOversized splits frames bigger than the max frame size if WithSplitOversizedWrites is set.
+func (s *writerImpl) splitOversized(frame []byte) [][]byte {
+	if !s.splitOversizedWrites || int64(len(frame)) <= s.maxFrameSize {
+		return [][]byte{frame}
+	}
+
+	parts := make([][]byte, 0, (int64(len(frame))+s.maxFrameSize-1)/s.maxFrameSize)
+	for int64(len(frame)) > s.maxFrameSize {
+		parts = append(parts, frame[:s.maxFrameSize])
+		frame = frame[s.maxFrameSize:]
+	}
+	return append(parts, frame)
+}
+
+// emptyFrameMode is what happens to empty writes.
+type emptyFrameMode int
+
+const (
+	emptyFramesWrite emptyFrameMode = iota
+	emptyFramesSkip
+	emptyFramesReject
+)
+
+// skipEmpty reports whether the empty write should be skipped according to WithAllowEmptyFrames.
+func (s *writerImpl) skipEmpty(src []byte) (bool, error) {
+	if len(src) > 0 {
+		return false, nil
+	}
+	switch s.emptyFrames {
+	case emptyFramesSkip:
+		return true, nil
+	case emptyFramesReject:
+		return false, ErrEmptyFrame
+	default:
+		return false, nil
+	}
+}
+
+func (s *writerImpl) ReadFrom(r io.Reader) (int64, error) {
+	size := s.frameSize
+	if size <= 0 {
+		size = defaultReadFromChunkSize
+	}
+	if int64(size) > s.maxFrameSize {
+		size = int(s.maxFrameSize)
+	}
+
+	buf := make([]byte, size)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, werr := s.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return total, nil
+		}
 		if err != nil {
-			return fmt.Errorf("failed to encode frame: %w", err)
+			return total, err
 		}
+	}
+}
 
-		select {
-		case <-ctx.Done():
-		// Fulfill our promise
-		case ch <- encodeResult{dst, entry}:
-			close(ch)
+func (s *writerImpl) WriteTagged(src, tag []byte) (int, error) {
+	if len(tag) == 0 {
+		return 0, fmt.Errorf("frame tag is empty")
+	}
+	if len(tag) > maxFrameTagSize {
+		return 0, fmt.Errorf("frame tag is too big: %d > %d", len(tag), maxFrameTagSize)
+	}
+	if skip, err := s.skipEmpty(src); skip || err != nil {
+		return 0, err
+	}
+
+	if err := s.flushPending(); err != nil {
+		return 0, err
+	}
+	if err := s.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	first := len(s.frameEntries)
+	n, err := s.write(src)
+	tag = append([]byte(nil), tag...)
+	for id := first; id < len(s.frameEntries); id++ {
+		s.frameTags = append(s.frameTags, frameTag{ID: uint32(id), Tag: tag})
+	}
+	return n, err
+}
+
+func (s *writerImpl) WriteWithLevel(src []byte, level int) (int, error) {
+	if s.levelFactory == nil {
+		return 0, fmt.Errorf("compression level can only be set with an encoder factory")
+	}
+	if skip, err := s.skipEmpty(src); skip || err != nil {
+		return 0, err
+	}
+
+	if err := s.flushPending(); err != nil {
+		return 0, err
+	}
+
+	pool, ok := s.levelEncoders[level]
+	if !ok {
+		enc := s.levelFactory(level)
+		if enc == nil {
+			return 0, fmt.Errorf("encoder factory returned no encoder for level %d", level)
+		}
+		pool = newEncoderPool(func() ZSTDEncoder { return s.levelFactory(level) })
+		pool.put(&pooledEncoder{enc: enc})
+		if s.levelEncoders == nil {
+			s.levelEncoders = make(map[int]*encoderPool)
 		}
+		s.levelEncoders[level] = pool
+	}
 
-		return nil
+	s.levelEnc = pool
+	defer func() { s.levelEnc = nil }()
+	return s.write(src)
+}
+
+// writeRsyncable buffers src and writes out frames up to the latest content-defined boundary.
+func (s *writerImpl) writeRsyncable(src []byte) (int, error) {
+	if err := s.checkMemory(int64(len(src))); err != nil {
+		return 0, err
+	}
+	buffered := len(s.pending)
+	s.pending = append(s.pending, src...)
+
+	var written int
+	for {
+		n := s.rsync.next(s.pending[written:])
+		if n == 0 {
+			break
+		}
+		if err := s.writeFrame(s.pending[written : written+n]); err != nil {
+			s.pending = append(s.pending[:0], s.pending[written:]...)
+			s.rsync.reset()
+			return s.dropUnwritten(buffered, len(src)), err
+		}
+		written += n
 	}
+
+	s.pending = append(s.pending[:0], s.pending[written:]...)
+	return len(src), nil
 }
 
-func (s *writerImpl) writeManyProducer(ctx context.Context, frameSource FrameSource, g *errgroup.Group, queue chan<- chan encodeResult) func() error {
-	return func() error {
-		for {
-			frame, err := frameSource()
-			if err != nil {
-				return fmt.Errorf("frame source failed: %w", err)
-			}
-			if frame == nil {
-				close(queue)
-				return nil
-			}
+// writeTargeted writes out buffered data in frames of about targetSize compressed bytes.
+// Unless all is set, the tail that is estimated to compress below the target stays buffered.
+func (s *writerImpl) writeTargeted(all bool) error {
+	var written int
+	defer func() { s.pending = append(s.pending[:0], s.pending[written:]...) }()
 
-			// Put a channel on the queue as a sort of promise.
-			// This is a nice trick to keep our results ordered, even when compression
-			// completes out-of-order.
-			ch := make(chan encodeResult, 1)
-			select {
-			case <-ctx.Done():
+	for written < len(s.pending) {
+		rest := s.pending[written:]
+		n := s.targetFrameSize()
+		if len(rest) < n {
+			if !all {
 				return nil
-			case queue <- ch:
 			}
+			n = len(rest)
+		}
 
-			g.Go(s.writeManyEncoder(ctx, ch, frame))
+		n, err := s.writeTargetedFrame(rest[:n])
+		if err != nil {
+			return err
 		}
+		written += n
 	}
+	return nil
 }
 
-func (s *writerImpl) writeManyConsumer(ctx context.Context, callback func(uint32), queue <-chan chan encodeResult) func() error {
-	return func() error {
-		for {
-			var ch <-chan encodeResult
-			select {
-			case <-ctx.Done():
-				return nil
-			case ch = <-queue:
-			}
-			if ch == nil {
-				return nil
-			}
+// dropUnwritten is called after a failed write of src appended to the buffered bytes of pending.
+// It drops the part of src that was not written out, so that it can be retried, and returns the number
+// of bytes of src that were.
+func (s *writerImpl) dropUnwritten(buffered, size int) int {
+	written := buffered + size - len(s.pending)
+	if written < buffered {
+		s.pending = s.pending[:buffered-written]
+		return 0
+	}
+	s.pending = s.pending[:0]
+	return written - buffered
+}
 
-			// Wait for the block to be complete
-			var result encodeResult
-			select {
-			case <-ctx.Done():
-				return nil
-			case result = <-ch:
-			}
+// targetFrameSize estimates the uncompressed size of the next frame from the compression
+// ratio of the previous one.
+func (s *writerImpl) targetFrameSize() int {
+	n := s.targetSize * s.targetIn / s.targetOut
+	if s.frameSize > 0 && n > int64(s.frameSize) {
+		n = int64(s.frameSize)
+	}
+	if n > s.maxFrameSize {
+		n = s.maxFrameSize
+	}
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
+}
 
-			n, err := s.env.WriteFrame(result.buf)
-			if err != nil {
-				return fmt.Errorf("failed to write compressed data: %w", err)
+// writeTargetedFrame writes a prefix of src as a single frame of at most targetSize compressed
+// bytes, retrying with less data if the estimate was too optimistic.  It returns the length of the prefix.
+func (s *writerImpl) writeTargetedFrame(src []byte) (int, error) {
+	const maxAttempts = 4
+
+	for attempt := 1; ; attempt++ {
+		dst, entry, err := s.encodeOne(src)
+		if err != nil {
+			return 0, err
+		}
+
+		if int64(len(dst)) <= s.targetSize || attempt == maxAttempts || len(src) == 1 {
+			s.targetIn, s.targetOut = int64(len(src)), int64(len(dst))
+			defer s.buffers.Put(dst)
+			if err := s.writeEncoded(dst, entry); err != nil {
+				return 0, err
 			}
-			if n != len(result.buf) {
-				return fmt.Errorf("partial write: %d out of %d", n, len(result.buf))
+			if err := s.contentWritten(src); err != nil {
+				return 0, err
 			}
-			s.frameEntries = append(s.frameEntries, result.entry)
+			return len(src), nil
+		}
+		s.buffers.Put(dst)
 
-			if callback != nil {
-				callback(result.entry.DecompressedSize)
-			}
+		// Shrink proportionally leaving some room for the frame overhead.
+		n := int64(len(src)) * s.targetSize / int64(len(dst)) * 15 / 16
+		if n < 1 {
+			n = 1
 		}
+		src = src[:n]
 	}
 }
 
-func (s *writerImpl) WriteMany(ctx context.Context, frameSource FrameSource, options ...WriteManyOption) error {
-	opts := writeManyOptions{concurrency: runtime.GOMAXPROCS(0)}
-	for _, o := range options {
-		if err := o(&opts); err != nil {
-			return err // no wrap, these should be user-comprehensible
+// flushPending writes out data buffered by Write, if any.
+func (s *writerImpl) flushPending() error {
+	if s.rsync != nil {
+		s.rsync.reset()
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if s.targetSize > 0 {
+		return s.writeTargeted(true)
+	}
+
+	_, err := s.write(s.pending)
+	s.pending = s.pending[:0]
+	return err
+}
+
+// write splits src according to the frame size and writes the resulting frames.
+func (s *writerImpl) write(src []byte) (int, error) {
+	if s.frameSize <= 0 || len(src) <= s.frameSize {
+		if err := s.writeFrame(src); err != nil {
+			return 0, err
 		}
+		return len(src), nil
 	}
 
-	g, gCtx := errgroup.WithContext(ctx)
-	g.SetLimit(opts.concurrency + 2) // reader and writer
-	// Add extra room in the queue, so we can keep throughput high even if blocks finish out of order
-	queue := make(chan chan encodeResult, opts.concurrency*2)
-	g.Go(s.writeManyProducer(gCtx, frameSource, g, queue))
-	g.Go(s.writeManyConsumer(gCtx, opts.writeCallback, queue))
-	return g.Wait()
+	var written int
+	for written < len(src) {
+		end := written + s.frameSize
+		if end > len(src) {
+			end = len(src)
+		}
+		if err := s.writeFrame(src[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
 }
 
-func (s *writerImpl) writeSeekTable() error {
-	seekTableBytes, err := s.EndStream()
+// writeFrame compresses src into a single frame and writes it to the environment.
+func (s *writerImpl) writeFrame(src []byte) error {
+	dst, entry, err := s.encodeOne(src)
 	if err != nil {
 		return err
 	}
+	defer s.buffers.Put(dst)
+	if err := s.writeEncoded(dst, entry); err != nil {
+		return err
+	}
+	return s.contentWritten(src)
+}
 
-	_, err = s.env.WriteSeekTable(seekTableBytes)
-	return err
+// contentWritten adds the data of the written frame to the content digest
+// and copies it to the tee, if enabled.
+func (s *writerImpl) contentWritten(src []byte) error {
+	if s.digest != nil {
+		s.digest.Write(src)
+	}
+	if s.tee != nil {
+		n, err := s.tee.Write(src)
+		if err != nil {
+			return fmt.Errorf("failed to write uncompressed copy: %w", err)
+		}
+		if n != len(src) {
+			return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(src))
+		}
+	}
+	return nil
+}
+
+// writeEncoded writes the compressed frame to the environment and records it in the seek table.
+func (s *writerImpl) writeEncoded(dst []byte, entry seekTableEntry) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	if err := s.writePadding(len(dst)); err != nil {
+		return err
+	}
+	if err := s.checkFrameCount(); err != nil {
+		return err
+	}
+
+	n, err := s.env.WriteFrame(dst)
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(dst))
+	}
+
+	s.commitFrame(entry)
+	if err := s.completePart(); err != nil {
+		return err
+	}
+	return s.dataFrameWritten()
+}
+
+// completePart notifies the environment once the current part has reached the part size.
+func (s *writerImpl) completePart() error {
+	if s.partEnv == nil {
+		return nil
+	}
+
+	size := s.compressedSize.Load()
+	if size-s.partStart < s.partSize {
+		return nil
+	}
+	if err := s.partEnv.CompletePart(); err != nil {
+		return err
+	}
+	s.partStart = size
+	return nil
+}
+
+func (s *writerImpl) WriteCompressedFrame(frame []byte, decompressedSize, checksum uint32) error {
+	entry, err := s.compressedFrameEntry(frame, decompressedSize, checksum)
+	if err != nil {
+		return err
+	}
+
+	// Keep frames ordered: data buffered by Write goes before the frame.
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	return s.writeEncoded(frame, entry)
+}
+
+// compressedFrameEntry validates the header of an already compressed frame
+// and returns its seek table entry.
+func (s *writerImpl) compressedFrameEntry(frame []byte, decompressedSize, checksum uint32) (seekTableEntry, error) {
+	if s.digest != nil || s.tee != nil {
+		return seekTableEntry{}, fmt.Errorf("content digest and uncompressed copy are not available for compressed frames")
+	}
+	if int64(len(frame)) > maxChunkSize {
+		return seekTableEntry{}, fmt.Errorf("%w: compressed frame too big for seekable format: %d > %d",
+			ErrFrameTooLarge, len(frame), maxChunkSize)
+	}
+	if int64(decompressedSize) > s.maxFrameSize {
+		return seekTableEntry{}, fmt.Errorf("%w: chunk size too big for seekable format: %d > %d",
+			ErrFrameTooLarge, decompressedSize, s.maxFrameSize)
+	}
+
+	h, err := parseZSTDFrameHeader(frame)
+	if err != nil {
+		return seekTableEntry{}, fmt.Errorf("failed to parse frame header: %w", err)
+	}
+	if h.HasContentSize && h.ContentSize != uint64(decompressedSize) {
+		return seekTableEntry{}, fmt.Errorf("frame content size mismatch: expected: %d, actual: %d",
+			decompressedSize, h.ContentSize)
+	}
+	if s.dictID != 0 && h.DictionaryID != s.dictID {
+		return seekTableEntry{}, fmt.Errorf("%w: frame dictionary ID: %d, expected: %d",
+			ErrDictionaryMismatch, h.DictionaryID, s.dictID)
+	}
+
+	entry := seekTableEntry{
+		CompressedSize:   uint32(len(frame)),
+		DecompressedSize: decompressedSize,
+	}
+	if s.checksums {
+		entry.Checksum = checksum
+	}
+	return entry, nil
+}
+
+func (s *writerImpl) Flush() error {
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	return s.writeCheckpoint()
+}
+
+// writeCheckpoint writes a snapshot of the current seek table.
+func (s *writerImpl) writeCheckpoint() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+
+	checkpoint, err := s.EndStream()
+	if err != nil {
+		return err
+	}
+
+	// Checkpoint itself is a frame without any data.
+	if err := s.writeDataless(checkpoint, "checkpoint"); err != nil {
+		return err
+	}
+	s.sinceCheckpoint = 0
+	return nil
+}
+
+// dataFrameWritten writes a checkpoint if enough data frames were written since the last one.
+func (s *writerImpl) dataFrameWritten() error {
+	if s.checkpointEvery <= 0 {
+		return nil
+	}
+	s.sinceCheckpoint++
+	if s.sinceCheckpoint < s.checkpointEvery {
+		return nil
+	}
+	return s.writeCheckpoint()
+}
+
+// writeDataless writes a frame without any uncompressed data, e.g. a skippable frame,
+// and records it in the seek table.
+func (s *writerImpl) writeDataless(frame []byte, kind string) error {
+	if err := s.checkFrameCount(); err != nil {
+		return err
+	}
+
+	n, err := s.env.WriteFrame(frame)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", kind, err)
+	}
+	if n != len(frame) {
+		return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(frame))
+	}
+
+	s.commitFrame(seekTableEntry{
+		CompressedSize: uint32(len(frame)),
+		Checksum:       s.frameChecksum(nil),
+	})
+	return s.completePart()
+}
+
+func (s *writerImpl) WriteSkippableFrame(tag uint32, payload []byte) error {
+	if tag == seekableTag || tag == extensionTag {
+		return fmt.Errorf("skippable frame tag is reserved: %#x", tag)
+	}
+	if len(payload) == 0 {
+		return fmt.Errorf("skippable frame payload is empty")
+	}
+
+	frame, err := createSkippableFrame(tag, payload)
+	if err != nil {
+		return err
+	}
+
+	// Keep frames ordered: data buffered by Write goes before the skippable frame.
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	return s.writeDataless(frame, "skippable frame")
+}
+
+func (s *writerImpl) Stats() WriterStats {
+	entrySize := int64(8)
+	if s.checksums {
+		entrySize += 4
+	}
+
+	frames := s.frames.Load()
+	return WriterStats{
+		Frames:            frames,
+		UncompressedBytes: s.uncompressedSize.Load(),
+		CompressedBytes:   s.compressedSize.Load(),
+		SeekTableSize: skippableMagicNumberFieldSize + frameSizeFieldSize +
+			frames*entrySize + seekTableFooterOffset,
+	}
+}
+
+func (s *writerImpl) Close() (err error) {
+	s.once.Do(func() {
+		err = multierr.Append(err, s.flushPending())
+		err = multierr.Append(err, s.writeFrameTags())
+		err = multierr.Append(err, s.writeSections())
+		err = multierr.Append(err, s.writeContentDigest())
+		err = multierr.Append(err, s.writeSeekTable())
+		if err == nil && s.syncOnClose {
+			err = s.sync()
+		}
+		err = multierr.Append(err, s.frameLogErr)
+		s.pending = nil
+		s.frameTags = nil
+		s.sections = nil
+	})
+	return
+}
+
+// syncer is implemented by writers that can commit written data to stable storage, e.g. *os.File.
+type syncer interface {
+	Sync() error
+}
+
+// sync commits the stream, its replicas and the sidecar seek table to stable storage,
+// if the underlying writers support it.
+func (s *writerImpl) sync() error {
+	targets := []any{s.dest, s.sidecar}
+	for _, r := range s.replicas {
+		targets = append(targets, r)
+	}
+
+	var err error
+	for _, t := range targets {
+		if f, ok := t.(syncer); ok {
+			if serr := f.Sync(); serr != nil {
+				err = multierr.Append(err, fmt.Errorf("failed to sync: %w", serr))
+			}
+		}
+	}
+	return err
+}
+
+func (s *writerImpl) Reset(w io.Writer) error {
+	if s.customEnv || len(s.replicas) > 0 || s.head != nil {
+		return fmt.Errorf("writer with a custom environment, replicas or seek table at the head can not be reset")
+	}
+
+	s.env = &writerEnvImpl{w: w}
+	s.dest = w
+	s.wrapEnvironment()
+
+	s.frameEntries = s.frameEntries[:0]
+	s.pending = s.pending[:0]
+	s.frameTags = nil
+	s.sections = nil
+	s.headerWritten = false
+	s.sinceCheckpoint = 0
+	s.targetIn, s.targetOut = 1, 1
+	if s.rsync != nil {
+		s.rsync.reset()
+	}
+	s.frameLogErr = nil
+	if s.digest != nil {
+		s.digest.Reset()
+	}
+
+	s.frames.Store(0)
+	s.uncompressedSize.Store(0)
+	s.compressedSize.Store(0)
+	s.once = &sync.Once{}
+	return nil
+}
+
+// encodeJob is a unit of work for the WriteMany worker pool: the frame with the given
+// sequence number, which is compressed and committed to the queue.
+type encodeJob struct {
+	seq   uint64
+	frame []byte
+}
+
+func (s *writerImpl) writeManyWorker(ctx context.Context, jobs <-chan encodeJob, q *CommitQueue) func() error {
+	return func() error {
+		for {
+			var job encodeJob
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case job, ok = <-jobs:
+			}
+			if !ok {
+				return nil
+			}
+
+			dst, entry, err := s.encodeOne(job.frame)
+			if err != nil {
+				return fmt.Errorf("failed to encode frame: %w", err)
+			}
+			if err := q.push(job.seq, queuedFrame{frame: dst, entry: entry, src: job.frame}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inFlightBudget limits the memory occupied by frames in the WriteMany pipeline.
+// Each frame is accounted for from the moment it is returned by the FrameSource
+// until it is written.  A nil budget is unlimited.
+type inFlightBudget struct {
+	sem  *semaphore.Weighted
+	size int64
+	// limit, if set, is the memory limit that rejects frames instead of letting them through
+	// when they are bigger than the whole budget.
+	limit int64
+}
+
+func newInFlightBudget(size, limit int64) *inFlightBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &inFlightBudget{sem: semaphore.NewWeighted(size), size: size, limit: limit}
+}
+
+// frameWeight returns the memory taken by the frame: its uncompressed size plus the worst case
+// compressed size.
+func frameWeight(frameSize int) int64 {
+	n := int64(frameSize)
+	bound := n + n>>8
+	if n < 128<<10 {
+		bound += (128<<10 - n) >> 11
+	}
+	return n + bound
+}
+
+// weight returns the budget taken by the frame.  Frames bigger than the whole budget
+// are allowed to go through one at a time.
+func (b *inFlightBudget) weight(frameSize int) int64 {
+	return min(frameWeight(frameSize), b.size)
+}
+
+func (b *inFlightBudget) acquire(ctx context.Context, frameSize int) error {
+	if b == nil {
+		return nil
+	}
+	if w := frameWeight(frameSize); b.limit > 0 && w > b.limit {
+		return fmt.Errorf("%w: frame of %d bytes needs %d bytes in flight, available: %d",
+			ErrMemoryLimit, frameSize, w, b.limit)
+	}
+	return b.sem.Acquire(ctx, b.weight(frameSize))
+}
+
+func (b *inFlightBudget) release(frameSize int) {
+	if b == nil {
+		return
+	}
+	b.sem.Release(b.weight(frameSize))
+}
+
+// newInFlightBudget returns the budget for the frames in flight limited by both
+// WithMaxInFlightBytes and the memory left under WithWriterMemoryLimit when WriteMany starts.
+func (s *writerImpl) newInFlightBudget(maxInFlightBytes int64) (*inFlightBudget, error) {
+	if s.memoryLimit <= 0 {
+		return newInFlightBudget(maxInFlightBytes, 0), nil
+	}
+
+	available := s.memoryLimit - s.memoryUsage()
+	if available <= 0 {
+		return nil, fmt.Errorf("%w: no memory left for frames in flight: %d >= %d",
+			ErrMemoryLimit, s.memoryUsage(), s.memoryLimit)
+	}
+	if maxInFlightBytes > 0 && maxInFlightBytes < available {
+		return newInFlightBudget(maxInFlightBytes, available), nil
+	}
+	return newInFlightBudget(available, available), nil
+}
+
+func (s *writerImpl) writeManyProducer(ctx context.Context, frameSource FrameSource, budget *inFlightBudget,
+	slots chan struct{}, jobs chan<- encodeJob,
+) func() error {
+	var seq uint64
+	submit := func(frame []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Wait until earlier frames are written, so that the frame source
+		// is not called again before there is room for another frame.
+		if err := budget.acquire(ctx, len(frame)); err != nil {
+			return err
+		}
+
+		// Bound the number of frames waiting for their predecessors in the queue.
+		select {
+		case <-ctx.Done():
+			budget.release(len(frame))
+			return ctx.Err()
+		case slots <- struct{}{}:
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobs <- encodeJob{seq, frame}:
+		}
+		seq++
+		return nil
+	}
+
+	return func() error {
+		defer close(jobs)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			frame, err := frameSource()
+			if err != nil {
+				return fmt.Errorf("frame source failed: %w", err)
+			}
+			if frame == nil {
+				return nil
+			}
+			skip, err := s.skipEmpty(frame)
+			if err != nil {
+				return err
+			}
+			if skip {
+				continue
+			}
+
+			for _, part := range s.splitOversized(frame) {
+				if err := submit(part); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// manyWriter writes the frames compressed by WriteMany in sequence, as they come out of
+// the CommitQueue.  Frames written to an io.WriterAt are written concurrently, but each
+// of them is committed to the seek table only once it and all the frames before it are
+// written, so that checkpoints never describe frames that are not written yet.
+type manyWriter struct {
+	s        *writerImpl
+	budget   *inFlightBudget
+	slots    chan struct{}
+	callback func(uint32)
+
+	// wa is set if frames are written concurrently, at most concurrency of them at once.
+	// inFlight are the frames being written in sequence.
+	wa          *writerAtEnvImpl
+	concurrency int
+	inFlight    []*frameWrite
+}
+
+// frameWrite is a frame written concurrently, err is set once done is closed.
+type frameWrite struct {
+	f    queuedFrame
+	done chan struct{}
+	err  error
+}
+
+func (m *manyWriter) write(f queuedFrame) error {
+	s := m.s
+	if m.wa == nil {
+		if err := s.writePadding(len(f.frame)); err != nil {
+			return err
+		}
+		if err := s.checkFrameCount(); err != nil {
+			return err
+		}
+		n, err := s.env.WriteFrame(f.frame)
+		m.release(f)
+		if err != nil {
+			return fmt.Errorf("failed to write compressed data: %w", err)
+		}
+		if n != len(f.frame) {
+			return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(f.frame))
+		}
+		return m.commit(f)
+	}
+
+	// Padding is based on the size of the committed frames.
+	limit := m.concurrency - 1
+	if s.alignment > 1 {
+		limit = 0
+	}
+	if err := m.wait(limit); err != nil {
+		return err
+	}
+	if err := s.writePadding(len(f.frame)); err != nil {
+		return err
+	}
+	if err := s.checkFrameCountAfter(len(m.inFlight)); err != nil {
+		return err
+	}
+
+	w := &frameWrite{f: f, done: make(chan struct{})}
+	off := m.wa.reserve(len(f.frame))
+	go func() {
+		defer close(w.done)
+		w.err = writeFullAt(m.wa.w, f.frame, off)
+	}()
+	m.inFlight = append(m.inFlight, w)
+
+	// The checkpoint goes right after the frames it describes.
+	if s.checkpointEvery > 0 && s.sinceCheckpoint+int64(len(m.inFlight)) >= s.checkpointEvery {
+		return m.wait(0)
+	}
+	return nil
+}
+
+// wait commits the frames being written in sequence until at most n of them are left.
+func (m *manyWriter) wait(n int) error {
+	for len(m.inFlight) > n {
+		w := m.inFlight[0]
+		<-w.done
+		m.inFlight = m.inFlight[1:]
+		m.release(w.f)
+		if w.err != nil {
+			return w.err
+		}
+		if err := m.commit(w.f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort waits for the frames being written without committing them.
+func (m *manyWriter) abort() {
+	for _, w := range m.inFlight {
+		<-w.done
+		m.release(w.f)
+	}
+	m.inFlight = nil
+}
+
+// release returns the memory taken by the written frame.
+func (m *manyWriter) release(f queuedFrame) {
+	m.budget.release(len(f.src))
+	m.s.buffers.Put(f.frame)
+	<-m.slots
+}
+
+// commit appends the written frame to the seek table.
+func (m *manyWriter) commit(f queuedFrame) error {
+	s := m.s
+	s.commitFrame(f.entry)
+	if err := s.contentWritten(f.src); err != nil {
+		return err
+	}
+	if err := s.completePart(); err != nil {
+		return err
+	}
+	if err := s.dataFrameWritten(); err != nil {
+		return err
+	}
+
+	if m.callback != nil {
+		m.callback(f.entry.DecompressedSize)
+	}
+	return nil
+}
+
+func writeFullAt(w io.WriterAt, p []byte, off int64) error {
+	n, err := w.WriteAt(p, off)
+	if err != nil {
+		return fmt.Errorf("failed to write compressed data at: %d: %w", off, err)
+	}
+	if n != len(p) {
+		return fmt.Errorf("%w at: %d: %d out of %d", ErrPartialWrite, off, n, len(p))
+	}
+	return nil
+}
+
+func (s *writerImpl) WriteMany(ctx context.Context, frameSource FrameSource, options ...WriteManyOption) error {
+	opts := writeManyOptions{concurrency: runtime.GOMAXPROCS(0)}
+	for _, o := range options {
+		if err := o(&opts); err != nil {
+			return err // no wrap, these should be user-comprehensible
+		}
+	}
+
+	// Keep frames ordered: data buffered by Write goes before the new frames.
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+
+	budget, err := s.newInFlightBudget(opts.maxInFlightBytes)
+	if err != nil {
+		return err
+	}
+	m := &manyWriter{
+		s:        s,
+		budget:   budget,
+		callback: opts.writeCallback,
+		// Add extra room in the queue, so we can keep throughput high even if blocks finish out of order
+		slots:       make(chan struct{}, opts.concurrency*2),
+		concurrency: opts.concurrency,
+	}
+	m.wa, _ = s.env.(*writerAtEnvImpl)
+	q := newCommitQueue(s, m.write)
+
+	g, gCtx := errgroup.WithContext(ctx)
+	jobs := make(chan encodeJob, opts.concurrency)
+	g.Go(s.writeManyProducer(gCtx, frameSource, budget, m.slots, jobs))
+	for i := 0; i < opts.concurrency; i++ {
+		g.Go(s.writeManyWorker(gCtx, jobs, q))
+	}
+	if err := g.Wait(); err != nil {
+		m.abort()
+		return err
+	}
+	if err := m.wait(0); err != nil {
+		m.abort()
+		return err
+	}
+	return q.Close()
+}
+
+func (s *writerImpl) WriteSection(ctx context.Context, name string, frameSource FrameSource,
+	options ...WriteManyOption,
+) error {
+	if len(name) == 0 {
+		return fmt.Errorf("section name is empty")
+	}
+	if len(name) > maxSectionNameSize {
+		return fmt.Errorf("section name is too big: %d > %d", len(name), maxSectionNameSize)
+	}
+	for _, sec := range s.sections {
+		if sec.Name == name {
+			return fmt.Errorf("duplicate section: %q", name)
+		}
+	}
+
+	if err := s.flushPending(); err != nil {
+		return err
+	}
+	start := s.uncompressedSize.Load()
+	if err := s.WriteMany(ctx, frameSource, options...); err != nil {
+		return err
+	}
+
+	s.sections = append(s.sections, section{
+		Name:   name,
+		Offset: uint64(start),
+		Size:   uint64(s.uncompressedSize.Load() - start),
+	})
+	return nil
+}
+
+// header returns the metadata frame that goes before the first frame of the stream,
+// or nil if there is nothing to write.
+func (s *writerImpl) header() ([]byte, error) {
+	if s.headerWritten || s.dictID == 0 {
+		return nil, nil
+	}
+
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, s.dictID)
+	return createExtensionFrame(extensionDictionary, payload)
+}
+
+// writeHeader writes the metadata frame to the environment, if it was not written yet.
+func (s *writerImpl) writeHeader() error {
+	frame, err := s.header()
+	if err != nil || frame == nil {
+		return err
+	}
+	if err := s.writeDataless(frame, "metadata frame"); err != nil {
+		return err
+	}
+
+	s.headerWritten = true
+	return nil
+}
+
+// padding returns a padding frame that makes a data frame of the given size start at
+// the alignment boundary, or nil if it is already aligned.
+func (s *writerImpl) padding(frameSize int) []byte {
+	if s.alignment <= 1 || frameSize == 0 {
+		return nil
+	}
+
+	gap := (s.alignment - s.compressedSize.Load()%s.alignment) % s.alignment
+	if gap == 0 {
+		return nil
+	}
+	// Skippable frame can not be smaller than its header.
+	for gap < skippableMagicNumberFieldSize+frameSizeFieldSize {
+		gap += s.alignment
+	}
+	return createPaddingFrame(int(gap))
+}
+
+// writePadding aligns the start of the next data frame, see WithFrameAlignment.
+func (s *writerImpl) writePadding(frameSize int) error {
+	pad := s.padding(frameSize)
+	if pad == nil {
+		return nil
+	}
+	return s.writeDataless(pad, "padding")
+}
+
+// writeFrameTags writes the tags attached by WriteTagged, if any.
+func (s *writerImpl) writeFrameTags() error {
+	if len(s.frameTags) == 0 {
+		return nil
+	}
+
+	frame, err := createExtensionFrame(extensionFrameTags, marshalFrameTags(s.frameTags))
+	if err != nil {
+		return err
+	}
+	return s.writeDataless(frame, "frame tags")
+}
+
+// writeSections writes the directory of sections added by WriteSection, if any.
+func (s *writerImpl) writeSections() error {
+	if len(s.sections) == 0 {
+		return nil
+	}
+
+	frame, err := createExtensionFrame(extensionSections, marshalSections(s.sections))
+	if err != nil {
+		return err
+	}
+	return s.writeDataless(frame, "sections")
+}
+
+// writeContentDigest writes the digest of the whole uncompressed stream, if enabled.
+func (s *writerImpl) writeContentDigest() error {
+	if s.digest == nil {
+		return nil
+	}
+
+	frame, err := createExtensionFrame(extensionContentDigest, s.digest.Sum(nil))
+	if err != nil {
+		return err
+	}
+	return s.writeDataless(frame, "content digest")
+}
+
+// writeHeadSeekTable writes the seek table into the space reserved at the beginning of the stream
+// followed by a padding frame that fills the rest of it.
+func (s *writerImpl) writeHeadSeekTable(seekTable []byte) error {
+	gap := s.headSize - int64(len(seekTable))
+	if gap < 0 || (gap > 0 && gap < skippableMagicNumberFieldSize+frameSizeFieldSize) {
+		return fmt.Errorf("%w: seek table does not fit into the reserved space: %d, reserved: %d",
+			ErrFrameTooLarge, len(seekTable), s.headSize)
+	}
+
+	if err := writeFullAt(s.head, seekTable, 0); err != nil {
+		return err
+	}
+	if gap > 0 {
+		return writeFullAt(s.head, createPaddingFrame(int(gap)), int64(len(seekTable)))
+	}
+	return nil
+}
+
+func (s *writerImpl) writeSeekTable() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+
+	seekTableBytes, err := s.EndStream()
+	if err != nil {
+		return err
+	}
+
+	if s.head != nil {
+		if err := s.writeHeadSeekTable(seekTableBytes); err != nil {
+			return err
+		}
+	} else if s.inlineSeekTable {
+		n, err := s.env.WriteSeekTable(seekTableBytes)
+		if err != nil {
+			return err
+		}
+		if n != len(seekTableBytes) {
+			return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(seekTableBytes))
+		}
+	}
+
+	if s.sidecar != nil {
+		n, err := s.sidecar.Write(seekTableBytes)
+		if err != nil {
+			return fmt.Errorf("failed to write sidecar seek table: %w", err)
+		}
+		if n != len(seekTableBytes) {
+			return fmt.Errorf("%w: %d out of %d", ErrPartialWrite, n, len(seekTableBytes))
+		}
+	}
+	return nil
 }
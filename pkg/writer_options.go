@@ -1,7 +1,12 @@
 package seekable
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 
 	"go.uber.org/zap"
 
@@ -14,13 +19,424 @@ func WithWLogger(l *zap.Logger) wOption {
 	return func(w *writerImpl) error { w.logger = l; return nil }
 }
 
+// WithWSLogger is similar to WithWLogger but sends logs to the standard library's structured logger.
+func WithWSLogger(l *slog.Logger) wOption {
+	return func(w *writerImpl) error { w.logger = newSLogger(l); return nil }
+}
+
 func WithWEnvironment(e env.WEnvironment) wOption {
 	return func(w *writerImpl) error { w.env = e; return nil }
 }
 
+// WithWFanOut makes the writer replicate every frame and the seek table to the passed writers
+// in addition to the main one, see env.NewFanOutWEnvironment.  Writes fail if any of the writers fails.
+func WithWFanOut(ws ...io.Writer) wOption {
+	return func(w *writerImpl) error { w.replicas = append(w.replicas, ws...); return nil }
+}
+
+// WithPartSize makes the writer split the stream into parts of at least n bytes, e.g. for S3
+// multipart uploads.  Parts always end at frame boundaries: after the frame that brings the current
+// part to n bytes or more, the writer calls CompletePart of the environment, which must implement
+// env.PartWEnvironment, see env.NewMultipartWEnvironment.  CompletePart is not retried by WithWRetryPolicy.
+func WithPartSize(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("part size must be positive: %d", n)
+		}
+		w.partSize = int64(n)
+		return nil
+	}
+}
+
+// WithWRetryPolicy makes the writer retry failed writes to the underlying writer or environment
+// according to the policy, see NewRetryWEnvironment for the contract on partial writes.
+// This allows long running jobs to survive transient failures of remote storage.
+func WithWRetryPolicy(p RetryPolicy) wOption {
+	return func(w *writerImpl) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		w.retry = &p
+		return nil
+	}
+}
+
+// WithEncoderFactory makes the writer compress frames with encoders created by factory instead
+// of the one passed to the constructor.  Encoders are pooled and each of them is used by one
+// goroutine at a time, so WriteMany workers do not contend on a single encoder and encoders
+// that are not goroutine-safe can be used.  All encoders must be created with the same settings.
+func WithEncoderFactory(factory func() ZSTDEncoder) wOption {
+	return func(w *writerImpl) error {
+		if factory == nil {
+			return fmt.Errorf("encoder factory is nil")
+		}
+		w.encoders = newEncoderPool(factory)
+		return nil
+	}
+}
+
+// WithLevelEncoderFactory enables WriteWithLevel: factory is called to create encoders
+// for the requested compression level, e.g. for github.com/klauspost/compress/zstd:
+//
+//	seekable.WithLevelEncoderFactory(func(level int) seekable.ZSTDEncoder {
+//		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+//		if err != nil {
+//			return nil
+//		}
+//		return enc
+//	})
+//
+// Encoders are created lazily and pooled per level for the lifetime of the writer.
+// Other writes keep using the encoder passed to the constructor.
+func WithLevelEncoderFactory(factory func(level int) ZSTDEncoder) wOption {
+	return func(w *writerImpl) error {
+		if factory == nil {
+			return fmt.Errorf("encoder factory is nil")
+		}
+		w.levelFactory = factory
+		return nil
+	}
+}
+
+// WithAllowEmptyFrames controls what happens to empty writes in Write, WriteTagged, WriteMany
+// and Encoder's Encode, which by default produce empty frames.  If enabled, they are silently
+// skipped and do not produce any frames, Encode returns nil for them.  Otherwise they fail
+// with ErrEmptyFrame.
+func WithAllowEmptyFrames(allow bool) wOption {
+	return func(w *writerImpl) error {
+		w.emptyFrames = emptyFramesReject
+		if allow {
+			w.emptyFrames = emptyFramesSkip
+		}
+		return nil
+	}
+}
+
+// WithBufferPool makes the writer take the destination buffers of compressed frames from p
+// and return them once the frames are written.  By default writers to an io.Writer or
+// io.WriterAt, which must not retain the written data anyway, use a sync.Pool-backed pool,
+// and writers to an environment set by WithWEnvironment allocate a buffer for each frame.
+// With a pool, the environment must not retain the frames passed to WriteFrame.
+// Frames returned by Encoder's Encode are owned by the caller and are never put back.
+func WithBufferPool(p BufferPool) wOption {
+	return func(w *writerImpl) error {
+		if p == nil {
+			return fmt.Errorf("buffer pool is nil")
+		}
+		w.buffers = p
+		return nil
+	}
+}
+
+// WithFrameSize splits Writes larger than n bytes into multiple frames of at most n
+// uncompressed bytes each.  Zero disables splitting.
+func WithFrameSize(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 0 {
+			return fmt.Errorf("frame size must not be negative: %d", n)
+		}
+		if int64(n) > maxChunkSize {
+			return fmt.Errorf("frame size too big for seekable format: %d > %d", n, maxChunkSize)
+		}
+		w.frameSize = n
+		return nil
+	}
+}
+
+// WithMaxFrameSize caps the uncompressed size of every frame at n bytes, so that readers
+// with little memory are always able to decode the stream.  Writes, WriteMany frames and
+// compressed frames that are bigger than n fail with ErrFrameTooLarge, unless
+// WithSplitOversizedWrites is set.  The default is the limit of the seekable format, 4GiB.
+func WithMaxFrameSize(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("max frame size must be positive: %d", n)
+		}
+		if int64(n) > maxChunkSize {
+			return fmt.Errorf("max frame size too big for seekable format: %d > %d", n, maxChunkSize)
+		}
+		w.maxFrameSize = int64(n)
+		return nil
+	}
+}
+
+// WithSplitOversizedWrites makes writes and WriteMany frames bigger than the max frame size
+// (see WithMaxFrameSize) split into multiple frames instead of rejected.  WithFrameSize is
+// lowered to the max frame size if needed.  Compressed frames are never split.
+func WithSplitOversizedWrites(enabled bool) wOption {
+	return func(w *writerImpl) error { w.splitOversizedWrites = enabled; return nil }
+}
+
+// WithMinFrameSize makes Write buffer small writes until at least n uncompressed bytes
+// are accumulated and only then emit them as a frame.  The remainder is flushed on Close.
+// Zero disables buffering.
+func WithMinFrameSize(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 0 {
+			return fmt.Errorf("min frame size must not be negative: %d", n)
+		}
+		if int64(n) > maxChunkSize {
+			return fmt.Errorf("min frame size too big for seekable format: %d > %d", n, maxChunkSize)
+		}
+		w.minFrameSize = n
+		return nil
+	}
+}
+
+// WithRsyncable makes Write pick frame boundaries with a rolling hash over the data, like
+// `zstd --rsyncable`, so that a small edit of the input only changes the frames around it
+// and the rest of the stream stays byte-identical.  This helps delta-sync and deduplicating storage.
+//
+// averageSize is the expected frame size, rounded up to a power of two.  Frames are between
+// averageSize/4 and averageSize*4 bytes, unless WithMinFrameSize or WithFrameSize are set.
+// WriteMany is not affected, use the chunker package for it.
+func WithRsyncable(averageSize int) wOption {
+	return func(w *writerImpl) error {
+		if averageSize < 64 {
+			return fmt.Errorf("rsyncable average frame size is too small: %d < 64", averageSize)
+		}
+		if int64(averageSize)*4 > maxChunkSize {
+			return fmt.Errorf("rsyncable average frame size is too big: %d > %d", averageSize, maxChunkSize/4)
+		}
+		w.rsyncAverage = averageSize
+		return nil
+	}
+}
+
+// WithTargetCompressedFrameSize makes Write cut frames when their compressed size reaches
+// about n bytes, instead of at fixed uncompressed boundaries.  This suits storage with fixed
+// block or object sizes.
+//
+// Data is buffered until it is expected to fill a frame, based on the compression ratio
+// of the previous frame.  Frames that turn out bigger than n are compressed again with less
+// data, so they rarely exceed the target.  Use WithFrameSize to bound the amount of buffered data
+// for highly compressible input.  WriteMany is not affected.
+func WithTargetCompressedFrameSize(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("target compressed frame size must be positive: %d", n)
+		}
+		if int64(n) > maxChunkSize {
+			return fmt.Errorf("target compressed frame size too big for seekable format: %d > %d", n, maxChunkSize)
+		}
+		w.targetSize = int64(n)
+		return nil
+	}
+}
+
+// WithFrameChecksums controls whether seek table entries contain XXH64-based checksums
+// of the uncompressed frames.  Checksums are enabled by default.  Disabling them saves
+// hashing time and 4 bytes of seek table per frame, but readers will not be able to detect
+// data corruption.  The setting applies to the whole stream, so conflicting values are rejected.
+func WithFrameChecksums(enabled bool) wOption {
+	return func(w *writerImpl) error {
+		if w.checksumsSet && w.checksums != enabled {
+			return fmt.Errorf("conflicting frame checksum settings")
+		}
+		w.checksums = enabled
+		w.checksumsSet = true
+		return nil
+	}
+}
+
+// WithSidecarSeekTable makes Close write a copy of the seek table into w, e.g. an `archive.zst.idx`
+// file next to the archive.  Such a seek table can be loaded by the reader with WithSeekTableFrom.
+func WithSidecarSeekTable(w io.Writer) wOption {
+	return func(s *writerImpl) error { s.sidecar = w; return nil }
+}
+
+// WithFrameLog makes the writer describe every frame as it is committed to the seek table
+// by writing a JSON object per line into w, e.g.:
+//
+//	{"id":0,"compressed_offset":0,"decompressed_offset":0,"compressed_size":25,"decompressed_size":4,"checksum":1234}
+//
+// This is meant for debugging and for external indexing systems that do not parse
+// the seek table.  Errors of writing to w are returned by Close.
+func WithFrameLog(w io.Writer) wOption {
+	return func(s *writerImpl) error { s.frameLog = json.NewEncoder(w); return nil }
+}
+
+// WithSeekTableAtHead makes NewWriterAt reserve size bytes at the beginning of the stream and
+// write the seek table there on Close instead of appending it to the end, so that readers
+// can load it with a sequential read from offset 0.  The rest of the reserved space is filled
+// with a padding frame.  Close fails with ErrFrameTooLarge if the seek table does not fit:
+// it takes 17 bytes plus 12 bytes per frame, or 8 bytes per frame without checksums.
+//
+// Such streams are still valid ZSTD streams, but only readers of this library find the seek table:
+// it is looked up at the head when there is no footer at the end of the stream.
+func WithSeekTableAtHead(size int) wOption {
+	return func(w *writerImpl) error {
+		if size < seekTableFooterOffset+skippableMagicNumberFieldSize+frameSizeFieldSize {
+			return fmt.Errorf("seek table space is too small: %d", size)
+		}
+		if int64(size) > maxChunkSize {
+			return fmt.Errorf("seek table space too big for seekable format: %d > %d", size, maxChunkSize)
+		}
+		w.headSize = int64(size)
+		return nil
+	}
+}
+
+// WithSyncOnClose makes Close call Sync, if the underlying writer implements Sync() error
+// like *os.File does, after the seek table is written.  Then Close returning nil means
+// the stream including its seek table is durable.  Replicas, the sidecar seek table writer
+// and custom environments are synced as well if they implement Sync.
+func WithSyncOnClose() wOption {
+	return func(w *writerImpl) error { w.syncOnClose = true; return nil }
+}
+
+// WithInlineSeekTable controls whether the seek table is appended to the end of the stream.
+// It is enabled by default and can only be disabled together with WithSidecarSeekTable.
+// Streams without an inline seek table are still valid ZSTD streams, but can only be
+// randomly accessed with the sidecar seek table.
+func WithInlineSeekTable(enabled bool) wOption {
+	return func(w *writerImpl) error { w.inlineSeekTable = enabled; return nil }
+}
+
+// WithCompressedSeekTable makes the writer compress seek table entries with the same encoder
+// as the data frames.  For streams with millions of frames this shrinks the seek table
+// considerably, but the stream is no longer compatible with the seekable format.
+//
+// The compression is flagged by bit 6 of `Seek_Table_Descriptor`, which the format reserves
+// for breaking changes, so the reference implementation and the versions of this library
+// released before the option was added refuse such streams as corrupt.  The data frames can
+// still be decompressed by any ZSTD decoder, since the seek table is a skippable frame.
+// Only enable it if all the readers of the stream use this library.
+func WithCompressedSeekTable(enabled bool) wOption {
+	return func(w *writerImpl) error { w.compressSeekTable = enabled; return nil }
+}
+
+// WithFrameAlignment makes every data frame start at a multiple of n bytes from the beginning
+// of the stream, e.g. 4KiB for O_DIRECT I/O or the part size of an object store.  Gaps are
+// filled with padding skippable frames, which are ignored by ZSTD decoders and recorded in
+// the seek table as frames without any data.  One disables the alignment.
+func WithFrameAlignment(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("frame alignment must be positive: %d", n)
+		}
+		if int64(n) > maxChunkSize {
+			return fmt.Errorf("frame alignment too big for seekable format: %d > %d", n, maxChunkSize)
+		}
+		w.alignment = int64(n)
+		return nil
+	}
+}
+
+// WithSeekTableCheckpointEvery makes the writer write a checkpoint, just like Flush does,
+// after every n data frames.  If the stream is not closed properly, e.g. the process crashes,
+// Reader can still access all data up to the latest checkpoint.  Zero disables checkpoints.
+//
+// Each checkpoint is a copy of the whole seek table, so small n makes the stream grow
+// quadratically with the number of frames.
+func WithSeekTableCheckpointEvery(n int) wOption {
+	return func(w *writerImpl) error {
+		if n < 0 {
+			return fmt.Errorf("checkpoint interval must not be negative: %d", n)
+		}
+		w.checkpointEvery = int64(n)
+		return nil
+	}
+}
+
+// WithDeterministicOutput guarantees that identical input and options produce byte-identical
+// streams.  Frame boundaries depend only on the data and the options, frames are always written
+// in order regardless of the concurrency of WriteMany, and no timestamps or other environment
+// specific data is stored in the metadata.
+//
+// The remaining source of nondeterminism is the encoder itself: it must be created with fixed
+// parameters and its output must not depend on timing or the number of CPUs.  As a sanity check,
+// the first frame compressed by each encoder, including the ones created by WithEncoderFactory
+// and WithLevelEncoderFactory, is compressed twice and ErrNondeterministicEncoder is returned
+// on a mismatch.
+func WithDeterministicOutput() wOption {
+	return func(w *writerImpl) error { w.deterministic = true; return nil }
+}
+
+// WithProgress sets a callback that is invoked after each frame is committed to the seek table,
+// both by Write and WriteMany.  It receives the total number of uncompressed and compressed
+// bytes written so far, as well as the number of frames.  The callback is never called concurrently.
+func WithProgress(cb func(uncompressed, compressed int64, frames int)) wOption {
+	return func(w *writerImpl) error { w.progress = cb; return nil }
+}
+
+// WithOnFrameWritten sets a hook that is invoked after each frame is committed to the seek table
+// with the frame's index, offsets within the compressed and decompressed streams, sizes and checksum,
+// e.g. to map keys to frames in an external index.  Frames without data, like checkpoints and
+// skippable frames, are reported as well.  The hook is never called concurrently.
+func WithOnFrameWritten(hook func(frame env.FrameOffsetEntry)) wOption {
+	return func(w *writerImpl) error { w.onFrameWritten = hook; return nil }
+}
+
+// WithContentDigest makes the writer compute the SHA-256 digest of the whole uncompressed stream
+// and store it in a metadata frame right before the seek table on Close.  Unlike frame checksums,
+// the digest detects reordered frames and a tampered seek table, see Reader's VerifyContentDigest.
+//
+// WriteCompressedFrame and CommitQueue can not be used with the digest, since the data of
+// already compressed frames is not available to the writer.
+func WithContentDigest() wOption {
+	return func(w *writerImpl) error { w.digest = sha256.New(); return nil }
+}
+
+// WithWriterMemoryLimit sets a hard limit on the memory held by the writer: data buffered
+// by Write (see WithMinFrameSize, WithRsyncable and WithTargetCompressedFrameSize), frames
+// in flight in WriteMany and the in-memory seek table.  Writes fail with ErrMemoryLimit
+// instead of exceeding it.  Within the limit WriteMany applies backpressure as with
+// WithMaxInFlightBytes, using the memory that is left when it starts.
+//
+// Memory held by the encoders themselves is not accounted for.
+func WithWriterMemoryLimit(n int64) wOption {
+	return func(w *writerImpl) error {
+		if n < 1 {
+			return fmt.Errorf("memory limit must be positive: %d", n)
+		}
+		w.memoryLimit = n
+		return nil
+	}
+}
+
+// WithTee makes the writer copy all uncompressed data to w in the stream order as frames
+// are written, so that a plain copy and a seekable stream can be produced in one pass.
+// Data buffered by Write is copied when its frame is written.  A failed write to w fails
+// the write to the stream as well, although the frame itself stays in the stream.
+//
+// WriteCompressedFrame and CommitQueue can not be used with the tee, since the data of
+// already compressed frames is not available to the writer.
+func WithTee(w io.Writer) wOption {
+	return func(wi *writerImpl) error {
+		if w == nil {
+			return fmt.Errorf("tee writer is nil")
+		}
+		wi.tee = w
+		return nil
+	}
+}
+
+// WithDictionary records the ID of the zstd dictionary that all frames of the stream are
+// compressed with in a metadata frame at the beginning of the stream.  Readers use it to
+// pick the matching dictionary.
+//
+// The dictionary must be in the zstd format, i.e. produced by `zstd --train` or zstd.BuildDict,
+// and the encoder must be configured with the same dictionary, e.g. via zstd.WithEncoderDict.
+// Every frame is checked against it and a mismatch is reported as ErrDictionaryMismatch.
+func WithDictionary(dict []byte) wOption {
+	return func(w *writerImpl) error {
+		if len(dict) < 8 || binary.LittleEndian.Uint32(dict) != zstdDictMagic {
+			return fmt.Errorf("dictionary is not in zstd format")
+		}
+		id := binary.LittleEndian.Uint32(dict[4:])
+		if id == 0 {
+			return fmt.Errorf("dictionary ID must not be zero")
+		}
+		w.dictID = id
+		return nil
+	}
+}
+
 type writeManyOptions struct {
-	concurrency   int
-	writeCallback func(uint32)
+	concurrency      int
+	writeCallback    func(uint32)
+	maxInFlightBytes int64
 }
 
 type WriteManyOption func(options *writeManyOptions) error
@@ -41,3 +457,18 @@ func WithWriteCallback(cb func(size uint32)) WriteManyOption {
 		return nil
 	}
 }
+
+// WithMaxInFlightBytes bounds the memory held by frames that were returned by the FrameSource
+// but are not written yet, both uncompressed and compressed.  Once the limit is reached,
+// the FrameSource is not called until earlier frames are written, so that a slow underlying
+// writer slows down the source instead of piling up frames in memory.  A single frame bigger
+// than the limit is still processed, but alone.
+func WithMaxInFlightBytes(n int64) WriteManyOption {
+	return func(options *writeManyOptions) error {
+		if n < 1 {
+			return fmt.Errorf("max in-flight bytes must be positive: %d", n)
+		}
+		options.maxInFlightBytes = n
+		return nil
+	}
+}
@@ -0,0 +1,39 @@
+//go:build go1.23
+
+package seekable
+
+import (
+	"context"
+	"iter"
+)
+
+// SeqWriter is a ConcurrentWriter that accepts frames from range-over-func iterators.
+// Writers returned by NewWriter and NewWriterAt implement it:
+//
+//	err := w.(seekable.SeqWriter).WriteSeq(ctx, frames)
+type SeqWriter interface {
+	ConcurrentWriter
+
+	// WriteSeq is similar to WriteMany but takes frames from seq.  An error yielded
+	// by seq stops the write and is returned just like a FrameSource error.
+	WriteSeq(ctx context.Context, seq iter.Seq2[[]byte, error], options ...WriteManyOption) error
+}
+
+var _ SeqWriter = (*writerImpl)(nil)
+
+func (s *writerImpl) WriteSeq(ctx context.Context, seq iter.Seq2[[]byte, error], options ...WriteManyOption) error {
+	next, stop := iter.Pull2(seq)
+	defer stop()
+
+	return s.WriteMany(ctx, func() ([]byte, error) {
+		frame, err, ok := next()
+		if !ok {
+			return nil, nil
+		}
+		if frame == nil && err == nil {
+			// nil ends FrameSource, but an iterator can yield it as an empty frame.
+			frame = []byte{}
+		}
+		return frame, err
+	}, options...)
+}
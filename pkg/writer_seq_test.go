@@ -0,0 +1,59 @@
+//go:build go1.23
+
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterWriteSeq(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	frames := [][]byte{[]byte("test"), nil, []byte("test2")}
+	seq := func(yield func([]byte, error) bool) {
+		for _, f := range frames {
+			if !yield(f, nil) {
+				return
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.(SeqWriter).WriteSeq(context.Background(), seq, WithConcurrency(2)))
+	require.NoError(t, w.Close())
+	assert.Len(t, w.(*writerImpl).frameEntries, 3)
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// Errors yielded by the iterator stop the write.
+	errSeq := errors.New("seq failed")
+	failing := func(yield func([]byte, error) bool) {
+		if yield([]byte("test"), nil) {
+			yield(nil, errSeq)
+		}
+	}
+	w, err = NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.(SeqWriter).WriteSeq(context.Background(), failing), errSeq)
+}
@@ -5,71 +5,1926 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	mrand "math/rand"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/pkg/env"
 )
 
-func TestWriter(t *testing.T) {
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	bw := io.Writer(&b)
+	w, err := NewWriter(bw, enc)
+	require.NoError(t, err)
+
+	bytes1 := []byte("test")
+	bytesWritten1, err := w.Write(bytes1)
+	require.NoError(t, err)
+	bytes2 := []byte("test2")
+	bytesWritten2, err := w.Write(bytes2)
+	require.NoError(t, err)
+
+	// test internals
+	sw := w.(*writerImpl)
+	assert.Len(t, sw.frameEntries, 2)
+	assert.Len(t, bytes1, int(sw.frameEntries[0].DecompressedSize))
+	assert.Len(t, bytes1, bytesWritten1)
+	assert.Equal(t, uint32(len(bytes2)), sw.frameEntries[1].DecompressedSize)
+	assert.Equal(t, uint32(bytesWritten2), sw.frameEntries[1].DecompressedSize)
+
+	index1CompressedSize := sw.frameEntries[0].CompressedSize
+	err = w.Close()
+	require.NoError(t, err)
+
+	// verify buffer content
+	buf := b.Bytes()
+	// magic footer
+	assert.Equal(t, []byte{0xb1, 0xea, 0x92, 0x8f}, buf[len(buf)-4:])
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(buf[len(buf)-9:len(buf)-5]))
+	// index.1
+	indexOffset := len(buf) - 4 - 1 - 4 - 2*12
+	assert.Equal(t, index1CompressedSize, binary.LittleEndian.Uint32(buf[indexOffset:indexOffset+4]))
+	assert.Equal(t, uint32(len(bytes1)), binary.LittleEndian.Uint32(buf[indexOffset+4:indexOffset+8]))
+	// skipframe header
+	frameOffset := indexOffset - 4 - 4
+	assert.Equal(t, []byte{0x5e, 0x2a, 0x4d, 0x18}, buf[frameOffset:frameOffset+4])
+	assert.Equal(t, uint32(0x21), binary.LittleEndian.Uint32(buf[frameOffset+4:frameOffset+8]))
+
+	// test decompression
+	br := io.Reader(&b)
+	dec, err := zstd.NewReader(br)
+	require.NoError(t, err)
+	readBuf := make([]byte, 1024)
+	n, err := dec.Read(readBuf)
+	require.ErrorIs(t, err, io.EOF)
+
+	concat := append(bytes1, bytes2...)
+	assert.Equal(t, len(concat), n)
+	assert.Equal(t, concat, readBuf[:n])
+}
+
+func TestWriterFrameSize(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	_, err = NewWriter(nil, enc, WithFrameSize(-1))
+	require.ErrorContains(t, err, "frame size must not be negative")
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithFrameSize(4))
+	require.NoError(t, err)
+
+	n, err := w.Write([]byte("testtest2"))
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+	n, err = w.Write([]byte("te"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.NoError(t, w.Close())
+
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 4)
+	for i, size := range []uint32{4, 4, 1, 2} {
+		assert.Equal(t, size, sw.frameEntries[i].DecompressedSize)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2te"), all)
+}
+
+func TestWriterMinFrameSize(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	_, err = NewWriter(nil, enc, WithMinFrameSize(8), WithFrameSize(4))
+	require.ErrorContains(t, err, "min frame size is bigger than frame size")
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithMinFrameSize(4))
+	require.NoError(t, err)
+
+	for _, s := range []string{"t", "es", "t", "test2", "a", "b"} {
+		n, err := w.Write([]byte(s))
+		require.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 2)
+	assert.Equal(t, []byte("ab"), sw.pending)
+
+	require.NoError(t, w.Close())
+	require.Len(t, sw.frameEntries, 3)
+	for i, size := range []uint32{4, 5, 2} {
+		assert.Equal(t, size, sw.frameEntries[i].DecompressedSize)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	decoded, err := dec.DecodeAll(b.Bytes(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2ab"), decoded)
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithFrameSize(4))
+	require.NoError(t, err)
+
+	n, err := io.Copy(w, bytes.NewReader([]byte("testtest2")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+	require.NoError(t, w.Close())
+
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 3)
+	for i, size := range []uint32{4, 4, 1} {
+		assert.Equal(t, size, sw.frameEntries[i].DecompressedSize)
+	}
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	decoded, err := dec.DecodeAll(b.Bytes(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2"), decoded)
+
+	// Errors from the source are propagated.
+	w, err = NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.ReadFrom(iotest.ErrReader(errors.New("test error")))
+	require.ErrorContains(t, err, "test error")
+}
+
+func TestWriterFlush(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithMinFrameSize(16))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	checkpointEnd := b.Len()
+
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 3)
+	assert.Equal(t, uint32(4), sw.frameEntries[0].DecompressedSize)
+	assert.Equal(t, uint32(0), sw.frameEntries[1].DecompressedSize)
+	assert.Equal(t, uint32(8+12+9), sw.frameEntries[1].CompressedSize)
+	assert.Equal(t, uint32(5), sw.frameEntries[2].DecompressedSize)
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// Checkpoint is transparent for regular ZSTD decoders.
+	decoded, err := dec.DecodeAll(b.Bytes(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2"), decoded)
+
+	// ...and for the seekable reader.
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2"), all)
+
+	d := r.(Decoder)
+	assert.Equal(t, int64(3), d.NumFrames())
+	assert.Equal(t, uint32(0), d.GetIndexByID(1).DecompSize)
+	assert.Equal(t, int64(2), d.GetIndexByDecompOffset(4).ID)
+	require.NoError(t, r.Close())
+
+	// Simulate a crash in the middle of the second frame.
+	truncated := b.Bytes()[:checkpointEnd+3]
+	r, err = NewReader(bytes.NewReader(truncated), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test"), all)
+}
+
+func TestWriterFrameChecksums(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	_, err = NewWriter(nil, enc, WithFrameChecksums(true), WithFrameChecksums(false))
+	require.ErrorContains(t, err, "conflicting frame checksum settings")
+
+	for _, tab := range []struct {
+		opts     []wOption
+		expected []byte
+	}{
+		{nil, checksum},
+		{[]wOption{WithFrameChecksums(true)}, checksum},
+		{[]wOption{WithFrameChecksums(false)}, noChecksum},
+	} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, tab.opts...)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte("test"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("test2"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, tab.expected, b.Bytes())
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	type progress struct {
+		uncompressed, compressed int64
+		frames                   int
+	}
+	var calls []progress
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithProgress(func(uncompressed, compressed int64, frames int) {
+		calls = append(calls, progress{uncompressed, compressed, frames})
+	}))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	err = w.WriteMany(context.Background(), makeTestFrameSource([][]byte{[]byte("test2"), []byte("test3")}))
+	require.NoError(t, err)
+
+	require.Len(t, calls, 3)
+	var compressed int64
+	for i, e := range w.(*writerImpl).frameEntries {
+		compressed += int64(e.CompressedSize)
+		assert.Equal(t, compressed, calls[i].compressed)
+		assert.Equal(t, i+1, calls[i].frames)
+	}
+	assert.Equal(t, int64(len("testtest2test3")), calls[2].uncompressed)
+	assert.Equal(t, int64(b.Len()), calls[2].compressed)
+
+	require.NoError(t, w.Close())
+}
+
+func TestWriterStats(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	for _, checksums := range []bool{true, false} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, WithFrameChecksums(checksums))
+		require.NoError(t, err)
+		assert.Equal(t, WriterStats{SeekTableSize: 17}, w.Stats())
+
+		_, err = w.Write([]byte("test"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("test2"))
+		require.NoError(t, err)
+
+		stats := w.Stats()
+		assert.Equal(t, int64(2), stats.Frames)
+		assert.Equal(t, int64(9), stats.UncompressedBytes)
+		assert.Equal(t, int64(b.Len()), stats.CompressedBytes)
+
+		require.NoError(t, w.Close())
+		assert.Equal(t, int64(b.Len()), stats.CompressedBytes+stats.SeekTableSize)
+	}
+}
+
+func TestWriterLimits(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+
+	sw := w.(*writerImpl)
+	sw.maxFrameSize = 4
+	sw.maxFrames = 2
+
+	_, err = w.Write([]byte("test2"))
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	written := b.Len()
+
+	_, err = w.Write([]byte("test"))
+	require.ErrorIs(t, err, ErrTooManyFrames)
+	require.ErrorIs(t, w.Flush(), ErrTooManyFrames)
+	err = w.WriteMany(context.Background(), makeTestFrameSource([][]byte{[]byte("test")}))
+	require.ErrorIs(t, err, ErrTooManyFrames)
+
+	// Nothing was written after the limit has been reached.
+	assert.Equal(t, written, b.Len())
+	require.NoError(t, w.Close())
+}
+
+// makeTestDict returns JSON samples and the zstd dictionary with the given ID built from them.
+func makeTestDict(t *testing.T, id uint32) ([][]byte, []byte) {
+	t.Helper()
+
+	samples := make([][]byte, 0, 64)
+	for i := 0; i < cap(samples); i++ {
+		samples = append(samples, []byte(fmt.Sprintf(
+			`{"id":%d,"kind":"event","source":"sensor-%d","status":"ok","value":%d}`, i, i%4, i*7)))
+	}
+	var history bytes.Buffer
+	for i := 0; i < 16; i++ {
+		fmt.Fprintf(&history, `{"id":,"kind":"event","source":"sensor-%d","status":"ok","value":}`, i)
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  history.Bytes(),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	require.NoError(t, err)
+	return samples, dict
+}
+
+func TestWriterDictionary(t *testing.T) {
+	t.Parallel()
+
+	samples, dict := makeTestDict(t, 0x1234)
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderDict(dict))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithDictionary(dict))
+	require.NoError(t, err)
+	for _, sample := range samples[:8] {
+		_, err = w.Write(sample)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// dictionary ID is recorded in the metadata frame at the very beginning of the stream
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 9)
+	assert.Equal(t, uint32(0), sw.frameEntries[0].DecompressedSize)
+	typ, payload, err := parseExtensionFrame(b.Bytes()[:sw.frameEntries[0].CompressedSize])
+	require.NoError(t, err)
+	assert.Equal(t, extensionDictionary, typ)
+	assert.Equal(t, uint32(0x1234), binary.LittleEndian.Uint32(payload))
+
+	h, err := parseZSTDFrameHeader(b.Bytes()[sw.frameEntries[0].CompressedSize:])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234), h.DictionaryID)
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	require.NoError(t, err)
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(samples[:8], nil), data)
+	require.NoError(t, r.Close())
+
+	// Encoder API returns the metadata frame along with the first frame.
+	e, err := NewEncoder(enc, WithDictionary(dict))
+	require.NoError(t, err)
+	first, err := e.Encode(samples[0])
+	require.NoError(t, err)
+	_, _, err = parseExtensionFrame(first[:e.(*writerImpl).frameEntries[0].CompressedSize])
+	require.NoError(t, err)
+	second, err := e.Encode(samples[1])
+	require.NoError(t, err)
+	h, err = parseZSTDFrameHeader(second)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234), h.DictionaryID)
+
+	// frames compressed without the dictionary are rejected
+	plain, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	w, err = NewWriter(&nullWriter{}, plain, WithDictionary(dict))
+	require.NoError(t, err)
+	_, err = w.Write(samples[0])
+	assert.ErrorIs(t, err, ErrDictionaryMismatch)
+	err = w.WriteMany(context.Background(), makeTestFrameSource(samples[:2]))
+	assert.ErrorIs(t, err, ErrDictionaryMismatch)
+
+	_, err = NewWriter(&nullWriter{}, enc, WithDictionary(samples[0]))
+	assert.Error(t, err)
+}
+
+func TestWriterSkippableFrame(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithMinFrameSize(16))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(0x1, []byte("schema=v2")))
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+
+	assert.Error(t, w.WriteSkippableFrame(seekableTag, []byte("test")))
+	assert.Error(t, w.WriteSkippableFrame(extensionTag, []byte("test")))
+	assert.Error(t, w.WriteSkippableFrame(0x10, []byte("test")))
+	assert.Error(t, w.WriteSkippableFrame(0x1, nil))
+	require.NoError(t, w.Close())
+
+	// buffered data is flushed before the skippable frame
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 3)
+	assert.Equal(t, uint32(4), sw.frameEntries[0].DecompressedSize)
+	assert.Equal(t, uint32(0), sw.frameEntries[1].DecompressedSize)
+	assert.Equal(t, uint32(17), sw.frameEntries[1].CompressedSize)
+	off := sw.frameEntries[0].CompressedSize
+	assert.Equal(t, uint32(skippableFrameMagic+0x1), binary.LittleEndian.Uint32(b.Bytes()[off:]))
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest2"), data)
+	require.NoError(t, r.Close())
+}
+
+func TestWriterCompressedSeekTable(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	frame := []byte("test")
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithCompressedSeekTable(true))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeRepeatingFrameSource(frame, 999)))
+	require.NoError(t, w.Flush())
+	checkpointEnd := b.Len()
+	_, err = w.Write(frame)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	stats := w.Stats()
+	assert.Less(t, int64(b.Len())-stats.CompressedBytes, stats.SeekTableSize/10)
+	assert.Equal(t, byte(1<<7|1<<6), b.Bytes()[b.Len()-5])
+
+	// Both the final seek table and the checkpoint are readable.
+	for _, tc := range []struct {
+		buf    []byte
+		frames int
+	}{
+		{b.Bytes(), 1000},
+		{b.Bytes()[:checkpointEnd+2], 999},
+	} {
+		r, err := NewReader(bytes.NewReader(tc.buf), dec)
+		require.NoError(t, err)
+		all, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat(frame, tc.frames), all)
+		require.NoError(t, r.Close())
+	}
+
+	// Compressed seek table without any frames.
+	b.Reset()
+	w, err = NewWriter(&b, enc, WithCompressedSeekTable(true))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), r.(Decoder).NumFrames())
+	require.NoError(t, r.Close())
+}
+
+func TestWriterSidecarSeekTable(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewWriter(&nullWriter{}, enc, WithInlineSeekTable(false))
+	assert.Error(t, err)
+
+	for _, inline := range []bool{true, false} {
+		var b, idx bytes.Buffer
+		w, err := NewWriter(&b, enc, WithSidecarSeekTable(&idx), WithInlineSeekTable(inline))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("test"))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("test2"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		stats := w.Stats()
+		assert.Equal(t, stats.SeekTableSize, int64(idx.Len()))
+		if inline {
+			assert.Equal(t, idx.Bytes(), b.Bytes()[stats.CompressedBytes:])
+		} else {
+			assert.Equal(t, stats.CompressedBytes, int64(b.Len()))
+
+			_, err = NewReader(bytes.NewReader(b.Bytes()), dec)
+			assert.Error(t, err)
+		}
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()), dec, WithSeekTableFrom(bytes.NewReader(idx.Bytes())))
+		require.NoError(t, err)
+		all, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(sourceString), all)
+		require.NoError(t, r.Close())
+
+		r, err = NewReader(bytes.NewReader(b.Bytes()), dec, WithSeekTableBytes(idx.Bytes()))
+		require.NoError(t, err)
+		all, err = io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(sourceString), all)
+		require.NoError(t, r.Close())
+	}
+
+	_, err = NewReader(bytes.NewReader(nil), dec, WithSeekTableFrom(bytes.NewReader([]byte("test"))))
+	assert.Error(t, err)
+	_, err = NewReader(bytes.NewReader(nil), dec, WithSeekTableBytes([]byte("test")))
+	assert.Error(t, err)
+}
+
+func TestWriterTagged(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithFrameSize(4), WithMinFrameSize(4))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("te"))
+	require.NoError(t, err)
+	_, err = w.WriteTagged([]byte("st123"), []byte("tag1"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.WriteTagged([]byte("4567"), []byte("tag2"))
+	require.NoError(t, err)
+
+	_, err = w.WriteTagged([]byte("test"), nil)
+	assert.Error(t, err)
+	_, err = w.WriteTagged([]byte("test"), make([]byte, 256))
+	assert.Error(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	// "te", "st12", "3", "test", "4567" and the tags frame
+	assert.Equal(t, int64(6), r.(Decoder).NumFrames())
+	for id, expected := range []string{"", "tag1", "tag1", "", "tag2", ""} {
+		tag, err := r.FrameTag(int64(id))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(tag), "frame %d", id)
+	}
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("test123test4567"), all)
+
+	// Streams without tags.
+	b.Reset()
+	w, err = NewWriter(&b, enc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(0x1, []byte("test")))
+	require.NoError(t, w.Close())
+
+	r2, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	tag, err := r2.FrameTag(0)
+	require.NoError(t, err)
+	assert.Nil(t, tag)
+	require.NoError(t, r2.Close())
+}
+
+type counterEncoder struct {
+	n atomic.Int64
+}
+
+func (e *counterEncoder) EncodeAll(src, dst []byte) []byte {
+	return append(dst, byte(e.n.Inc()))
+}
+
+func TestWriterDeterministicOutput(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(4))
+	require.NoError(t, err)
+
+	frames := make([][]byte, 0, 64)
+	for i := 0; i < cap(frames); i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+
+	var expected []byte
+	for _, concurrency := range []int{1, 3, 16} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc, WithDeterministicOutput())
+		require.NoError(t, err)
+		require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames),
+			WithConcurrency(concurrency)))
+		require.NoError(t, w.Close())
+
+		wa := memWriterAt{n: 1, ready: make(chan struct{})}
+		w, err = NewWriterAt(&wa, enc, WithDeterministicOutput())
+		require.NoError(t, err)
+		require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames),
+			WithConcurrency(concurrency)))
+		require.NoError(t, w.Close())
+
+		if expected == nil {
+			expected = b.Bytes()
+		}
+		assert.Equal(t, expected, b.Bytes(), "concurrency %d", concurrency)
+		assert.Equal(t, expected, wa.buf, "concurrency %d", concurrency)
+	}
+
+	w, err := NewWriter(&nullWriter{}, &counterEncoder{}, WithDeterministicOutput())
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrNondeterministicEncoder)
+
+	// Each of the pooled encoders is verified, not just the first one used.
+	var created int
+	w, err = NewWriter(&nullWriter{}, nil, WithDeterministicOutput(), WithEncoderFactory(func() ZSTDEncoder {
+		if created++; created == 1 {
+			return enc
+		}
+		return &counterEncoder{}
+	}))
+	require.NoError(t, err)
+	sw := w.(*writerImpl)
+	first := sw.encoders.get()
+	defer sw.encoders.put(first)
+	assert.Same(t, enc, first.enc)
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrNondeterministicEncoder)
+}
+
+func TestWriterFrameAlignment(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	const alignment = 64
+	var frames [][]byte
+	for i := 0; i < 20; i++ {
+		frames = append(frames, makeTestFrame(t, i)[:i*7+1])
+	}
+
+	checkAligned := func(t *testing.T, stream []byte) {
+		r, err := NewReader(bytes.NewReader(stream), dec)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+
+		d := r.(Decoder)
+		var data int
+		for i := int64(0); i < d.NumFrames(); i++ {
+			index := d.GetIndexByID(i)
+			if index.DecompSize > 0 {
+				assert.Zero(t, index.CompOffset%alignment, "frame %d", i)
+				data++
+			}
+		}
+		assert.Equal(t, len(frames), data)
+
+		all, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Join(frames, nil), all)
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithFrameAlignment(alignment))
+	require.NoError(t, err)
+	for _, frame := range frames {
+		_, err = w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	checkAligned(t, b.Bytes())
+
+	// Padding is a valid ZSTD skippable frame.
+	decoded, err := dec.DecodeAll(b.Bytes(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(frames, nil), decoded)
+
+	wa := memWriterAt{n: 1, ready: make(chan struct{})}
+	w, err = NewWriterAt(&wa, enc, WithFrameAlignment(alignment))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+	assert.Equal(t, b.Bytes(), wa.buf)
+
+	e, err := NewEncoder(enc, WithFrameAlignment(alignment))
+	require.NoError(t, err)
+	var stream []byte
+	for _, frame := range frames {
+		dst, err := e.Encode(frame)
+		require.NoError(t, err)
+		stream = append(stream, dst...)
+	}
+	seekTable, err := e.EndStream()
+	require.NoError(t, err)
+	assert.Equal(t, b.Bytes(), append(stream, seekTable...))
+
+	_, err = NewWriter(&b, enc, WithFrameAlignment(0))
+	assert.Error(t, err)
+}
+
+func TestWriterFanOut(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var main, replica1, replica2 bytes.Buffer
+	w, err := NewWriter(&main, enc, WithWFanOut(&replica1, &replica2))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeRepeatingFrameSource([]byte("test"), 10)))
+	require.NoError(t, w.Close())
+
+	assert.NotZero(t, main.Len())
+	assert.Equal(t, main.Bytes(), replica1.Bytes())
+	assert.Equal(t, main.Bytes(), replica2.Bytes())
+
+	// Failure of any sink fails the write.
+	testErr := errors.New("test error")
+	e := env.NewFanOutWEnvironment(&fakeWriteEnvironment{&main}, failingWriteEnvironment{1, testErr})
+	w, err = NewWriter(nil, enc, WithWEnvironment(e))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, testErr)
+
+	_, err = NewWriterAt(&memWriterAt{}, enc, WithWFanOut(&replica1))
+	assert.Error(t, err)
+}
+
+// exclusiveEncoder fails the test if it is used concurrently.
+type exclusiveEncoder struct {
+	t    *testing.T
+	enc  ZSTDEncoder
+	busy atomic.Bool
+}
+
+func (e *exclusiveEncoder) EncodeAll(src, dst []byte) []byte {
+	if !e.busy.CompareAndSwap(false, true) {
+		e.t.Error("encoder is used concurrently")
+	}
+	defer e.busy.Store(false)
+	time.Sleep(time.Millisecond)
+	return e.enc.EncodeAll(src, dst)
+}
+
+func TestWriterEncoderFactory(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var frames [][]byte
+	for i := 0; i < 32; i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+
+	var expected bytes.Buffer
+	w, err := NewWriter(&expected, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+
+	var created atomic.Int32
+	factory := func() ZSTDEncoder {
+		created.Inc()
+		return &exclusiveEncoder{t: t, enc: enc}
+	}
+
+	var b bytes.Buffer
+	w, err = NewWriter(&b, nil, WithEncoderFactory(factory))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames), WithConcurrency(4)))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, expected.Bytes(), b.Bytes())
+	assert.LessOrEqual(t, created.Load(), int32(len(frames)))
+	assert.Positive(t, created.Load())
+
+	_, err = NewWriter(&b, nil)
+	assert.Error(t, err)
+	_, err = NewWriter(&b, nil, WithEncoderFactory(nil))
+	assert.Error(t, err)
+}
+
+func TestWriterCompressedFrame(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var src bytes.Buffer
+	w, err := NewWriter(&src, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(
+		[][]byte{makeTestFrame(t, 0), makeTestFrame(t, 1), makeTestFrame(t, 2)})))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(src.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	d := r.(Decoder)
+
+	// Copy frames from one stream to another.
+	var b bytes.Buffer
+	w, err = NewWriter(&b, enc)
+	require.NoError(t, err)
+	for i := int64(0); i < d.NumFrames(); i++ {
+		index := d.GetIndexByID(i)
+		frame := src.Bytes()[index.CompOffset : index.CompOffset+uint64(index.CompSize)]
+		require.NoError(t, w.WriteCompressedFrame(frame, index.DecompSize, index.Checksum))
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, src.Bytes(), b.Bytes())
+
+	w, err = NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	data := makeTestFrame(t, 0)
+	frame := enc.EncodeAll(data, nil)
+	assert.Error(t, w.WriteCompressedFrame(frame, uint32(len(data))+1, 0))
+	assert.Error(t, w.WriteCompressedFrame(data, uint32(len(data)), 0))
+	w.(*writerImpl).maxFrameSize = 4
+	assert.ErrorIs(t, w.WriteCompressedFrame(frame, uint32(len(data)), 0), ErrFrameTooLarge)
+}
+
+func TestWriterRsyncable(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	const averageSize = 4 << 10
+	// The data is fixed, so that the number of frames changed by the insertion is stable.
+	data := make([]byte, 1<<20)
+	_, err = mrand.New(mrand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+
+	write := func(t *testing.T, data []byte, writeSize int) []seekTableEntry {
+		w, err := NewWriter(&nullWriter{}, enc, WithRsyncable(averageSize))
+		require.NoError(t, err)
+		for len(data) > 0 {
+			n := writeSize
+			if n > len(data) {
+				n = len(data)
+			}
+			_, err = w.Write(data[:n])
+			require.NoError(t, err)
+			data = data[n:]
+		}
+		require.NoError(t, w.Close())
+		return w.(*writerImpl).frameEntries
+	}
+
+	// Boundaries do not depend on the writes.
+	frames := write(t, data, len(data))
+	assert.Equal(t, frames, write(t, data, 1000))
+	for i, f := range frames[:len(frames)-1] {
+		assert.GreaterOrEqual(t, f.DecompressedSize, uint32(averageSize/4), "frame %d", i)
+		assert.LessOrEqual(t, f.DecompressedSize, uint32(averageSize*4), "frame %d", i)
+	}
+	assert.InDelta(t, len(data)/(averageSize+averageSize/4), len(frames), float64(len(frames))/4)
+
+	// An insertion only changes a couple of frames.
+	edited := append(append(append([]byte(nil), data[:len(data)/2]...), "test"...), data[len(data)/2:]...)
+	checksums := make(map[uint32]bool)
+	for _, f := range frames {
+		checksums[f.Checksum] = true
+	}
+	var changed int
+	for _, f := range write(t, edited, 777) {
+		if !checksums[f.Checksum] {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 2)
+
+	_, err = NewWriter(&nullWriter{}, enc, WithRsyncable(1))
+	assert.Error(t, err)
+	_, err = NewWriter(&nullWriter{}, enc, WithRsyncable(1024), WithFrameSize(100))
+	assert.Error(t, err)
+}
+
+func TestWriterCheckpointEvery(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithSeekTableCheckpointEvery(2))
+	require.NoError(t, err)
+	var crashed []byte
+	for i := 0; i < 5; i++ {
+		_, err = w.Write([]byte("test"))
+		require.NoError(t, err)
+		if i == 3 {
+			crashed = bytes.Clone(b.Bytes())
+		}
+	}
+	require.NoError(t, w.WriteMany(context.Background(),
+		makeRepeatingFrameSource([]byte("test"), 3)))
+	require.NoError(t, w.Close())
+
+	// 8 data frames and 4 checkpoints.
+	sw := w.(*writerImpl)
+	require.Len(t, sw.frameEntries, 12)
+	for i, e := range sw.frameEntries {
+		assert.Equal(t, i%3 == 2, e.DecompressedSize == 0, i)
+	}
+
+	// Stream cut after the 4th frame still has the data of the latest checkpoint.
+	r, err := NewReader(bytes.NewReader(crashed[:len(crashed)-1]), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("testtest"), all)
+	require.NoError(t, r.Close())
+
+	_, err = NewWriter(&b, enc, WithSeekTableCheckpointEvery(-1))
+	assert.Error(t, err)
+}
+
+func TestWriterFrameLog(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b, log bytes.Buffer
+	w, err := NewWriter(&b, enc, WithFrameLog(&log), WithFrameChecksums(true))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	sw := w.(*writerImpl)
+	d := json.NewDecoder(&log)
+	var offset, decompressedOffset int64
+	for i, e := range sw.frameEntries {
+		var rec frameLogRecord
+		require.NoError(t, d.Decode(&rec))
+		assert.Equal(t, frameLogRecord{
+			ID:                 int64(i),
+			CompressedOffset:   offset,
+			DecompressedOffset: decompressedOffset,
+			CompressedSize:     e.CompressedSize,
+			DecompressedSize:   e.DecompressedSize,
+			Checksum:           e.Checksum,
+		}, rec)
+		offset += int64(e.CompressedSize)
+		decompressedOffset += int64(e.DecompressedSize)
+	}
+	assert.False(t, d.More())
+
+	pr, pw := io.Pipe()
+	require.NoError(t, pr.CloseWithError(errTransient))
+	w, err = NewWriter(&b, enc, WithFrameLog(pw))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Close(), errTransient)
+}
+
+func TestWriterTargetCompressedFrameSize(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var data bytes.Buffer
+	for i := 0; data.Len() < 1<<20; i++ {
+		fmt.Fprintf(&data, "line %d: value %d, status %d\n", i, i*i%9973, i%7)
+	}
+
+	const target = 4 << 10
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithTargetCompressedFrameSize(target))
+	require.NoError(t, err)
+	for p := data.Bytes(); len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		_, err = w.Write(p[:n])
+		require.NoError(t, err)
+		p = p[n:]
+	}
+	require.NoError(t, w.Close())
+
+	frames := w.(*writerImpl).frameEntries
+	require.Greater(t, len(frames), 2)
+	for i, f := range frames[1 : len(frames)-1] {
+		assert.LessOrEqual(t, f.CompressedSize, uint32(target), "frame %d", i+1)
+		assert.Greater(t, f.CompressedSize, uint32(target/2), "frame %d", i+1)
+	}
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data.Bytes(), all)
+	require.NoError(t, r.Close())
+
+	_, err = NewWriter(&b, enc, WithTargetCompressedFrameSize(target), WithRsyncable(target))
+	assert.Error(t, err)
+	_, err = NewWriter(&b, enc, WithTargetCompressedFrameSize(0))
+	assert.Error(t, err)
+}
+
+func TestWriterWriteErrorCount(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var data bytes.Buffer
+	for i := 0; data.Len() < 1<<16; i++ {
+		fmt.Fprintf(&data, "line %d: value %d\n", i, i*i%9973)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opt  wOption
+	}{
+		{"target", WithTargetCompressedFrameSize(1 << 10)},
+		{"rsyncable", WithRsyncable(1 << 10)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Frames written before the failure are counted.
+			w, err := NewWriter(nil, enc, tc.opt, WithWEnvironment(&failAfterWriteEnvironment{frames: 2}))
+			require.NoError(t, err)
+			n, err := w.Write(data.Bytes())
+			require.ErrorIs(t, err, errTest)
+			var written int
+			for _, e := range w.(*writerImpl).frameEntries {
+				written += int(e.DecompressedSize)
+			}
+			assert.NotZero(t, n)
+			assert.Equal(t, written, n)
+			assert.Empty(t, w.(*writerImpl).pending)
+
+			// Nothing of src is kept if no frame was written.
+			w, err = NewWriter(nil, enc, tc.opt, WithWEnvironment(&failAfterWriteEnvironment{}))
+			require.NoError(t, err)
+			n, err = w.Write([]byte("test"))
+			require.NoError(t, err)
+			require.Equal(t, 4, n)
+			n, err = w.Write(data.Bytes())
+			require.ErrorIs(t, err, errTest)
+			assert.Zero(t, n)
+			assert.Equal(t, []byte("test"), w.(*writerImpl).pending)
+		})
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b1, b2 bytes.Buffer
+	w, err := NewWriter(&b1, enc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// Abandon a stream in the middle and write the same data again.
+	require.NoError(t, w.Reset(&nullWriter{}))
+	_, err = w.Write([]byte("garbage"))
+	require.NoError(t, err)
+	require.NoError(t, w.Reset(&b2))
+	assert.Equal(t, WriterStats{SeekTableSize: 9 + 8}, w.Stats())
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, b1.Bytes(), b2.Bytes())
+
+	w, err = NewWriter(&b1, enc, WithWFanOut(&nullWriter{}))
+	require.NoError(t, err)
+	assert.Error(t, w.Reset(&b2))
+}
+
+// stringEncoder counts strings compressed without a copy.
+type stringEncoder struct {
+	*zstd.Encoder
+	strings int
+}
+
+func (e *stringEncoder) EncodeAllString(src string, dst []byte) []byte {
+	e.strings++
+	return e.EncodeAll([]byte(src), dst)
+}
+
+func TestWriterWriteString(t *testing.T) {
+	t.Parallel()
+
+	zenc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var expected bytes.Buffer
+	w, err := NewWriter(&expected, zenc)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test2"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	for _, enc := range []ZSTDEncoder{zenc, &stringEncoder{Encoder: zenc}} {
+		var b bytes.Buffer
+		w, err := NewWriter(&b, enc)
+		require.NoError(t, err)
+		n, err := w.WriteString("test")
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		_, err = io.WriteString(w, "test2")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Equal(t, expected.Bytes(), b.Bytes())
+
+		if se, ok := enc.(*stringEncoder); ok {
+			assert.Equal(t, 2, se.strings)
+		}
+	}
+}
+
+// countingBufferPool tracks buffers that were taken and not returned.
+type countingBufferPool struct {
+	syncBufferPool
+	gets, puts atomic.Int64
+}
+
+func (p *countingBufferPool) Get() []byte {
+	p.gets.Inc()
+	return p.syncBufferPool.Get()
+}
+
+func (p *countingBufferPool) Put(buf []byte) {
+	p.puts.Inc()
+	p.syncBufferPool.Put(buf)
+}
+
+func TestWriterBufferPool(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	frames := make([][]byte, 0, 32)
+	for i := 0; i < cap(frames); i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+
+	var pool countingBufferPool
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithBufferPool(&pool))
+	require.NoError(t, err)
+	for _, f := range frames {
+		_, err = w.Write(f)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames),
+		WithConcurrency(4)))
+	require.NoError(t, w.Close())
+	assert.Equal(t, int64(2*len(frames)), pool.gets.Load())
+	assert.Equal(t, pool.gets.Load(), pool.puts.Load())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	expected := bytes.Join(frames, nil)
+	assert.Equal(t, append(expected, expected...), all)
+	require.NoError(t, r.Close())
+
+	// Frames returned by Encode belong to the caller.
+	e, err := NewEncoder(enc, WithBufferPool(&pool))
+	require.NoError(t, err)
+	_, err = e.Encode([]byte("test"))
+	require.NoError(t, err)
+	assert.Equal(t, pool.gets.Load(), pool.puts.Load()+1)
+
+	_, err = NewWriter(&b, enc, WithBufferPool(nil))
+	assert.Error(t, err)
+
+	// Custom environments may retain the frames, so they are not pooled by default.
+	w, err = NewWriter(nil, enc, WithWEnvironment(&fakeWriteEnvironment{}))
+	require.NoError(t, err)
+	assert.Equal(t, noBufferPool{}, w.(*writerImpl).buffers)
+	w, err = NewWriter(nil, enc, WithWEnvironment(&fakeWriteEnvironment{}), WithBufferPool(&pool))
+	require.NoError(t, err)
+	assert.Equal(t, &pool, w.(*writerImpl).buffers)
+}
+
+func TestWriterEmptyFrames(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	frames := [][]byte{[]byte("test"), {}, []byte("test2")}
+
+	// Empty writes produce empty frames by default.
+	w, err := NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	n, err := w.Write(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteTagged([]byte{}, []byte("tag"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+	assert.Len(t, w.(*writerImpl).frameEntries, 6)
+
+	e, err := NewEncoder(enc)
+	require.NoError(t, err)
+	dst, err := e.Encode(nil)
+	require.NoError(t, err)
+	assert.Empty(t, dst)
+	assert.Len(t, e.(*writerImpl).frameEntries, 1)
+
+	var b bytes.Buffer
+	w, err = NewWriter(&b, enc, WithAllowEmptyFrames(true))
+	require.NoError(t, err)
+	n, err = w.Write(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteTagged([]byte{}, []byte("tag"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+	assert.Len(t, w.(*writerImpl).frameEntries, 2)
+
+	e, err = NewEncoder(enc, WithAllowEmptyFrames(true))
+	require.NoError(t, err)
+	dst, err = e.Encode(nil)
+	require.NoError(t, err)
+	assert.Empty(t, dst)
+	assert.Empty(t, e.(*writerImpl).frameEntries)
+
+	w, err = NewWriter(&nullWriter{}, enc, WithAllowEmptyFrames(false))
+	require.NoError(t, err)
+	_, err = w.Write(nil)
+	assert.ErrorIs(t, err, ErrEmptyFrame)
+	_, err = w.WriteTagged(nil, []byte("tag"))
+	assert.ErrorIs(t, err, ErrEmptyFrame)
+	assert.ErrorIs(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)), ErrEmptyFrame)
+
+	e, err = NewEncoder(enc, WithAllowEmptyFrames(false))
+	require.NoError(t, err)
+	_, err = e.Encode(nil)
+	assert.ErrorIs(t, err, ErrEmptyFrame)
+}
+
+func TestWriterMaxFrameSize(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	data := []byte("testtest2")
+
+	w, err := NewWriter(&nullWriter{}, enc, WithMaxFrameSize(4))
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+	assert.ErrorIs(t, w.WriteMany(context.Background(), makeTestFrameSource([][]byte{data})), ErrFrameTooLarge)
+
+	var b bytes.Buffer
+	w, err = NewWriter(&b, enc, WithMaxFrameSize(4), WithSplitOversizedWrites(true))
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource([][]byte{data})))
+	require.NoError(t, w.Close())
+
+	frames := w.(*writerImpl).frameEntries
+	require.Len(t, frames, 6)
+	for _, f := range frames {
+		assert.LessOrEqual(t, f.DecompressedSize, uint32(4))
+	}
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, append(data, data...), all)
+	require.NoError(t, r.Close())
+
+	_, err = NewWriter(&b, enc, WithMaxFrameSize(4), WithFrameSize(8))
+	assert.Error(t, err)
+	_, err = NewWriter(&b, enc, WithMaxFrameSize(0))
+	assert.Error(t, err)
+}
+
+func TestWriterOnFrameWritten(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var written []env.FrameOffsetEntry
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithOnFrameWritten(func(frame env.FrameOffsetEntry) {
+		written = append(written, frame)
+	}))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSkippableFrame(0, []byte("meta")))
+	require.NoError(t, w.WriteMany(context.Background(),
+		makeTestFrameSource([][]byte{[]byte("test2"), []byte("test3")})))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+	d := r.(Decoder)
+	require.Equal(t, d.NumFrames(), int64(len(written)))
+	for _, frame := range written {
+		assert.Equal(t, *d.GetIndexByID(frame.ID), frame)
+	}
+}
+
+func TestWriterPartialWrite(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	w, err := NewWriter(nil, enc, WithWEnvironment(failingWriteEnvironment{1, nil}))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrPartialWrite)
+	assert.ErrorIs(t, w.WriteMany(context.Background(), makeTestFrameSource([][]byte{[]byte("test")})),
+		ErrPartialWrite)
+	assert.ErrorIs(t, w.Close(), ErrPartialWrite)
+}
+
+func TestWriterContentDigest(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	var last env.FrameOffsetEntry
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc, WithContentDigest(), WithOnFrameWritten(func(frame env.FrameOffsetEntry) {
+		last = frame
+	}))
+	require.NoError(t, err)
+	_, err = w.WriteTagged([]byte("test"), []byte("tag"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(),
+		makeTestFrameSource([][]byte{[]byte("test2"), []byte("test3")})))
+	assert.Error(t, w.WriteCompressedFrame(enc.EncodeAll([]byte("test"), nil), 4, 0))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	require.NoError(t, r.VerifyContentDigest(context.Background()))
+	tag, err := r.FrameTag(0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("tag"), tag)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, r.VerifyContentDigest(ctx), context.Canceled)
+	require.NoError(t, r.Close())
+
+	// Tampered digest.
+	corrupt := bytes.Clone(b.Bytes())
+	corrupt[last.CompOffset+uint64(last.CompSize)-1] ^= 0xff
+	r, err = NewReader(bytes.NewReader(corrupt), dec)
+	require.NoError(t, err)
+	err = r.VerifyContentDigest(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+	require.NoError(t, r.Close())
+
+	// Stream without a digest.
+	r, err = NewReader(bytes.NewReader(checksum), dec)
+	require.NoError(t, err)
+	assert.Error(t, r.VerifyContentDigest(context.Background()))
+	require.NoError(t, r.Close())
+}
+
+// memUploader keeps uploaded parts in memory.
+type memUploader struct {
+	parts     [][]byte
+	completed bool
+}
+
+func (u *memUploader) UploadPart(number int, p []byte) error {
+	if number != len(u.parts)+1 {
+		return fmt.Errorf("unexpected part number: %d", number)
+	}
+	u.parts = append(u.parts, bytes.Clone(p))
+	return nil
+}
+
+func (u *memUploader) Complete() error {
+	u.completed = true
+	return nil
+}
+
+func TestWriterPartSize(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	frames := make([][]byte, 0, 64)
+	for i := 0; i < cap(frames); i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+
+	var expected bytes.Buffer
+	w, err := NewWriter(&expected, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+
+	const partSize = 256
+	var u memUploader
+	ends := make(map[uint64]bool)
+	w, err = NewWriter(nil, enc, WithWEnvironment(env.NewMultipartWEnvironment(&u)), WithPartSize(partSize),
+		WithOnFrameWritten(func(frame env.FrameOffsetEntry) {
+			ends[frame.CompOffset+uint64(frame.CompSize)] = true
+		}))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	require.NoError(t, w.Close())
+
+	require.Greater(t, len(u.parts), 2)
+	assert.True(t, u.completed)
+	assert.Equal(t, expected.Bytes(), bytes.Join(u.parts, nil))
+	var offset uint64
+	for i, p := range u.parts[:len(u.parts)-1] {
+		assert.GreaterOrEqual(t, len(p), partSize, "part %d", i)
+		offset += uint64(len(p))
+		assert.True(t, ends[offset], "part %d does not end at a frame boundary", i)
+	}
+
+	_, err = NewWriter(&expected, enc, WithPartSize(partSize))
+	assert.Error(t, err)
+}
+
+func TestWriterSeekTableAtHead(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	_, err = NewWriter(&nullWriter{}, enc, WithSeekTableAtHead(1024))
+	require.ErrorContains(t, err, "can only be used with io.WriterAt")
+	_, err = NewWriterAt(&memWriterAt{}, enc, WithSeekTableAtHead(16))
+	require.ErrorContains(t, err, "seek table space is too small")
+
+	wa := &memWriterAt{n: 1, ready: make(chan struct{})}
+	w, err := NewWriterAt(wa, enc, WithSeekTableAtHead(1024))
+	require.NoError(t, err)
+	for _, s := range []string{"test", "test2"} {
+		_, err = w.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// The stream starts with the seek table and has no footer.
+	assert.Equal(t, skippableFrameMagic+seekableTag, binary.LittleEndian.Uint32(wa.buf))
+	assert.NotEqual(t, seekableMagicNumber, binary.LittleEndian.Uint32(wa.buf[len(wa.buf)-4:]))
+
+	decoded, err := dec.DecodeAll(wa.buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), decoded)
+
+	r, err := NewReader(bytes.NewReader(wa.buf), dec)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.(*readerImpl).idx().index.Len())
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// Seek table that does not fit into the reserved space.
+	wa = &memWriterAt{n: 1, ready: make(chan struct{})}
+	w, err = NewWriterAt(wa, enc, WithSeekTableAtHead(32))
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = w.Write(makeTestFrame(t, i))
+		require.NoError(t, err)
+	}
+	assert.ErrorIs(t, w.Close(), ErrFrameTooLarge)
+}
+
+func TestWriterWriteWithLevel(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	w, err := NewWriter(&nullWriter{}, enc)
+	require.NoError(t, err)
+	_, err = w.WriteWithLevel([]byte("test"), int(zstd.SpeedBestCompression))
+	require.ErrorContains(t, err, "encoder factory")
+
+	var created []int
+	factory := func(level int) ZSTDEncoder {
+		created = append(created, level)
+		if level == 0 {
+			return nil
+		}
+		e, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+		require.NoError(t, err)
+		return e
+	}
+
+	var b bytes.Buffer
+	w, err = NewWriter(&b, enc, WithLevelEncoderFactory(factory), WithMinFrameSize(1<<10))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	frame := bytes.Repeat([]byte("test2"), 1<<10)
+	for i := 0; i < 2; i++ {
+		_, err = w.WriteWithLevel(frame, int(zstd.SpeedBestCompression))
+		require.NoError(t, err)
+	}
+	_, err = w.WriteWithLevel(frame, 0)
+	require.ErrorContains(t, err, "no encoder for level 0")
+	require.NoError(t, w.Close())
+
+	// Buffered data is written out with the default encoder first.
+	entries := w.(*writerImpl).frameEntries
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint32(4), entries[0].DecompressedSize)
+	// Pooled encoders may be dropped by sync.Pool, so the factory can be called more than once.
+	require.GreaterOrEqual(t, len(created), 2)
+	for _, level := range created[:len(created)-1] {
+		assert.Equal(t, int(zstd.SpeedBestCompression), level)
+	}
+	assert.Equal(t, 0, created[len(created)-1])
+
+	best, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(len(best.EncodeAll(frame, nil))), entries[1].CompressedSize)
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("test"), append(frame, frame...)...), all)
+	require.NoError(t, r.Close())
+}
+
+func TestFrameSourceFromChannel(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for _, frame := range [][]byte{[]byte("test"), nil, []byte("test2")} {
+			ch <- frame
+		}
+	}()
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, w.WriteMany(ctx, FrameSourceFromChannel(ctx, ch), WithConcurrency(2)))
+	require.NoError(t, w.Close())
+	assert.Len(t, w.(*writerImpl).frameEntries, 3)
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(sourceString), all)
+	require.NoError(t, r.Close())
+
+	// nil frames on the channel are empty writes.
+	send := func(frames ...[]byte) <-chan []byte {
+		ch := make(chan []byte, len(frames))
+		for _, frame := range frames {
+			ch <- frame
+		}
+		close(ch)
+		return ch
+	}
+	w, err = NewWriter(&nullWriter{}, enc, WithAllowEmptyFrames(true))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(ctx, FrameSourceFromChannel(ctx, send([]byte("test"), nil, nil))))
+	assert.Len(t, w.(*writerImpl).frameEntries, 1)
+	w, err = NewWriter(&nullWriter{}, enc, WithAllowEmptyFrames(false))
+	require.NoError(t, err)
+	err = w.WriteMany(ctx, FrameSourceFromChannel(ctx, send([]byte("test"), nil)))
+	assert.ErrorIs(t, err, ErrEmptyFrame)
+
+	// Cancellation unblocks the source even if nothing is sent.
+	cctx, cancel := context.WithCancel(ctx)
+	source := FrameSourceFromChannel(cctx, make(chan []byte))
+	cancel()
+	_, err = source()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// syncBuffer is a bytes.Buffer that records Sync calls.
+type syncBuffer struct {
+	bytes.Buffer
+	synced []int
+	err    error
+}
+
+func (b *syncBuffer) Sync() error {
+	b.synced = append(b.synced, b.Len())
+	return b.err
+}
+
+func TestWriterSyncOnClose(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var b, sidecar syncBuffer
+	w, err := NewWriter(&b, enc, WithSyncOnClose(), WithSidecarSeekTable(&sidecar))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	assert.Empty(t, b.synced)
+	require.NoError(t, w.Close())
+
+	// Synced once, after the seek table was written.
+	assert.Equal(t, []int{b.Len()}, b.synced)
+	assert.Equal(t, []int{sidecar.Len()}, sidecar.synced)
+
+	b = syncBuffer{err: errTransient}
+	w, err = NewWriter(&b, enc, WithSyncOnClose())
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Close(), errTransient)
+
+	// Not synced without the option.
+	b = syncBuffer{}
+	w, err = NewWriter(&b, enc)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Empty(t, b.synced)
+}
+
+func TestWriterSections(t *testing.T) {
 	t.Parallel()
 
 	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
 	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
 
+	ctx := context.Background()
 	var b bytes.Buffer
-	bw := io.Writer(&b)
-	w, err := NewWriter(bw, enc)
+	w, err := NewWriter(&b, enc, WithMinFrameSize(1<<10))
 	require.NoError(t, err)
 
-	bytes1 := []byte("test")
-	bytesWritten1, err := w.Write(bytes1)
+	require.ErrorContains(t, w.WriteSection(ctx, "", makeTestFrameSource(nil)), "section name is empty")
+
+	_, err = w.Write([]byte("header"))
 	require.NoError(t, err)
-	bytes2 := []byte("test2")
-	bytesWritten2, err := w.Write(bytes2)
+	a := [][]byte{[]byte("test"), []byte("test2")}
+	require.NoError(t, w.WriteSection(ctx, "a", makeTestFrameSource(a), WithConcurrency(2)))
+	require.NoError(t, w.WriteSection(ctx, "empty", makeTestFrameSource(nil)))
+	require.NoError(t, w.WriteSection(ctx, "b", makeTestFrameSource([][]byte{[]byte("test3")})))
+	require.ErrorContains(t, w.WriteSection(ctx, "a", makeTestFrameSource(a)), "duplicate section")
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(bytes.NewReader(b.Bytes()), dec)
 	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
 
-	// test internals
-	sw := w.(*writerImpl)
-	assert.Len(t, sw.frameEntries, 2)
-	assert.Len(t, bytes1, int(sw.frameEntries[0].DecompressedSize))
-	assert.Len(t, bytes1, bytesWritten1)
-	assert.Equal(t, uint32(len(bytes2)), sw.frameEntries[1].DecompressedSize)
-	assert.Equal(t, uint32(bytesWritten2), sw.frameEntries[1].DecompressedSize)
+	for name, expected := range map[string]string{"a": sourceString, "empty": "", "b": "test3"} {
+		sr, err := r.OpenSection(name)
+		require.NoError(t, err)
+		all, err := io.ReadAll(sr)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(all), name)
+	}
 
-	index1CompressedSize := sw.frameEntries[0].CompressedSize
-	err = w.Close()
+	sr, err := r.OpenSection("a")
+	require.NoError(t, err)
+	_, err = sr.Seek(4, io.SeekStart)
 	require.NoError(t, err)
+	all, err := io.ReadAll(sr)
+	require.NoError(t, err)
+	assert.Equal(t, "test2", string(all))
 
-	// verify buffer content
-	buf := b.Bytes()
-	// magic footer
-	assert.Equal(t, []byte{0xb1, 0xea, 0x92, 0x8f}, buf[len(buf)-4:])
-	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(buf[len(buf)-9:len(buf)-5]))
-	// index.1
-	indexOffset := len(buf) - 4 - 1 - 4 - 2*12
-	assert.Equal(t, index1CompressedSize, binary.LittleEndian.Uint32(buf[indexOffset:indexOffset+4]))
-	assert.Equal(t, uint32(len(bytes1)), binary.LittleEndian.Uint32(buf[indexOffset+4:indexOffset+8]))
-	// skipframe header
-	frameOffset := indexOffset - 4 - 4
-	assert.Equal(t, []byte{0x5e, 0x2a, 0x4d, 0x18}, buf[frameOffset:frameOffset+4])
-	assert.Equal(t, uint32(0x21), binary.LittleEndian.Uint32(buf[frameOffset+4:frameOffset+8]))
+	_, err = r.OpenSection("missing")
+	assert.ErrorIs(t, err, ErrSectionNotFound)
+}
 
-	// test decompression
-	br := io.Reader(&b)
-	dec, err := zstd.NewReader(br)
+func TestWriterTee(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
 	require.NoError(t, err)
-	readBuf := make([]byte, 1024)
-	n, err := dec.Read(readBuf)
-	require.ErrorIs(t, err, io.EOF)
 
-	concat := append(bytes1, bytes2...)
-	assert.Equal(t, len(concat), n)
-	assert.Equal(t, concat, readBuf[:n])
+	var b, tee bytes.Buffer
+	w, err := NewWriter(&b, enc, WithTee(&tee), WithMinFrameSize(8))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	// Buffered data is copied once its frame is written.
+	assert.Zero(t, tee.Len())
+	err = w.WriteMany(context.Background(), makeTestFrameSource([][]byte{[]byte("test2"), []byte("test3")}))
+	require.NoError(t, err)
+	assert.Equal(t, "testtest2test3", tee.String())
+	require.ErrorContains(t, w.WriteCompressedFrame(enc.EncodeAll([]byte("test"), nil), 4, 0), "not available")
+	require.NoError(t, w.Close())
+
+	pr, pw := io.Pipe()
+	require.NoError(t, pr.CloseWithError(errTransient))
+	w, err = NewWriter(&nullWriter{}, enc, WithTee(pw))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, errTransient)
+}
+
+func TestWriterMemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	_, err = NewWriter(&nullWriter{}, enc, WithWriterMemoryLimit(0))
+	require.ErrorContains(t, err, "memory limit must be positive")
+
+	// Buffered data.
+	w, err := NewWriter(&nullWriter{}, enc, WithWriterMemoryLimit(100), WithMinFrameSize(1<<10))
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 90))
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 20))
+	assert.ErrorIs(t, err, ErrMemoryLimit)
+
+	// Seek table entries.
+	w, err = NewWriter(&nullWriter{}, enc, WithWriterMemoryLimit(3*seekTableEntrySize))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = w.Write([]byte("test"))
+		require.NoError(t, err)
+	}
+	_, err = w.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrMemoryLimit)
+	assert.Len(t, w.(*writerImpl).frameEntries, 3)
+
+	// Frames in flight.
+	frame := make([]byte, 1000)
+	limit := 2*frameWeight(len(frame)) + 100*seekTableEntrySize
+	var e countingWriteEnvironment
+	w, err = NewWriter(nil, enc, WithWEnvironment(&e), WithWriterMemoryLimit(limit))
+	require.NoError(t, err)
+	frames := make([][]byte, 10)
+	for i := range frames {
+		frames[i] = frame
+	}
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames), WithConcurrency(4)))
+	assert.Equal(t, int64(10), e.frames.Load())
+
+	big := make([]byte, 3*len(frame))
+	err = w.WriteMany(context.Background(), makeTestFrameSource([][]byte{big}))
+	assert.ErrorIs(t, err, ErrMemoryLimit)
 }
 
 func makeTestFrame(t *testing.T, idx int) []byte {
@@ -153,6 +2008,201 @@ func TestConcurrentWriter(t *testing.T) {
 	assert.Equal(t, concat, decoded)
 }
 
+// barrierEncoder blocks every EncodeAll call until n calls are in flight at once.
+type barrierEncoder struct {
+	ZSTDEncoder
+
+	n        int32
+	inFlight atomic.Int32
+	ready    chan struct{}
+	timedOut atomic.Bool
+}
+
+func (e *barrierEncoder) EncodeAll(src, dst []byte) []byte {
+	if e.inFlight.Inc() == e.n {
+		close(e.ready)
+	}
+	select {
+	case <-e.ready:
+	case <-time.After(5 * time.Second):
+		e.timedOut.Store(true)
+	}
+	return e.ZSTDEncoder.EncodeAll(src, dst)
+}
+
+func TestConcurrentWriterParallelism(t *testing.T) {
+	t.Parallel()
+
+	const concurrency = 4
+
+	zenc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	enc := &barrierEncoder{ZSTDEncoder: zenc, n: concurrency, ready: make(chan struct{})}
+
+	var frames [][]byte
+	var concat []byte
+	for i := 0; i < concurrency; i++ {
+		frame := makeTestFrame(t, i)
+		frames = append(frames, frame)
+		concat = append(concat, frame...)
+	}
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	err = w.WriteMany(context.Background(), makeTestFrameSource(frames), WithConcurrency(concurrency))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.False(t, enc.timedOut.Load(), "frames were not compressed concurrently")
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	decoded, err := dec.DecodeAll(b.Bytes(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, concat, decoded)
+}
+
+// memWriterAt is an in-memory io.WriterAt.  The first n writes block until all of them are in flight.
+type memWriterAt struct {
+	m   sync.Mutex
+	buf []byte
+
+	n        int32
+	inFlight atomic.Int32
+	ready    chan struct{}
+	timedOut atomic.Bool
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if w.inFlight.Inc() == w.n {
+		close(w.ready)
+	}
+	select {
+	case <-w.ready:
+	case <-time.After(5 * time.Second):
+		w.timedOut.Store(true)
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func TestConcurrentWriterAt(t *testing.T) {
+	t.Parallel()
+
+	const concurrency = 4
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var frames [][]byte
+	var concat []byte
+	for i := 0; i < concurrency; i++ {
+		frame := makeTestFrame(t, i)
+		frames = append(frames, frame)
+		concat = append(concat, frame...)
+	}
+
+	_, err = NewWriterAt(&memWriterAt{}, enc, WithWEnvironment(&fakeWriteEnvironment{}))
+	require.ErrorContains(t, err, "custom environment can not be used")
+
+	wa := &memWriterAt{n: concurrency, ready: make(chan struct{})}
+	w, err := NewWriterAt(wa, enc)
+	require.NoError(t, err)
+
+	err = w.WriteMany(context.Background(), makeTestFrameSource(frames), WithConcurrency(concurrency))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.False(t, wa.timedOut.Load(), "frames were not written concurrently")
+
+	// Output should be the same as the sequential one.
+	var b bytes.Buffer
+	sw, err := NewWriter(&b, enc)
+	require.NoError(t, err)
+	err = sw.WriteMany(context.Background(), makeTestFrameSource(frames))
+	require.NoError(t, err)
+	_, err = sw.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, sw.Close())
+	assert.Equal(t, b.Bytes(), wa.buf)
+
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	r, err := NewReader(bytes.NewReader(wa.buf), dec)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, append(concat, []byte("test")...), all)
+}
+
+// checkpointWriterAt fails checkpoints written before all the frames they describe.
+type checkpointWriterAt struct {
+	memWriterAt
+	written atomic.Int64
+	early   atomic.Int32
+}
+
+func (w *checkpointWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) >= 4 && binary.LittleEndian.Uint32(p[len(p)-4:]) == seekableMagicNumber {
+		if w.written.Load() != off {
+			w.early.Inc()
+		}
+	} else {
+		// Delay earlier frames, so that writes complete out of order.
+		time.Sleep(time.Duration(4-off%4) * time.Millisecond)
+	}
+	n, err := w.memWriterAt.WriteAt(p, off)
+	w.written.Add(int64(n))
+	return n, err
+}
+
+func TestConcurrentWriterAtCheckpoints(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	frames := make([][]byte, 0, 20)
+	for i := 0; i < cap(frames); i++ {
+		frames = append(frames, makeTestFrame(t, i))
+	}
+
+	wa := &checkpointWriterAt{memWriterAt: memWriterAt{ready: make(chan struct{})}}
+	close(wa.ready)
+	w, err := NewWriterAt(wa, enc, WithSeekTableCheckpointEvery(3))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMany(context.Background(), makeTestFrameSource(frames), WithConcurrency(4)))
+	assert.Equal(t, int32(0), wa.early.Load(), "checkpoints were written before their frames")
+
+	var b bytes.Buffer
+	sw, err := NewWriter(&b, enc, WithSeekTableCheckpointEvery(3))
+	require.NoError(t, err)
+	require.NoError(t, sw.WriteMany(context.Background(), makeTestFrameSource(frames)))
+	assert.Equal(t, b.Bytes(), wa.buf)
+
+	// The stream is readable up to the latest checkpoint before it is closed.
+	r, err := NewReader(bytes.NewReader(wa.buf), dec)
+	require.NoError(t, err)
+	all, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(frames[:18], nil), all)
+	require.NoError(t, r.Close())
+}
+
 type failingWriteEnvironment struct {
 	n   int
 	err error
@@ -166,6 +2216,25 @@ func (e failingWriteEnvironment) WriteSeekTable(p []byte) (n int, err error) {
 	return e.n, e.err
 }
 
+var errTest = errors.New("test error")
+
+// failAfterWriteEnvironment fails all frame writes after the given number of frames.
+type failAfterWriteEnvironment struct {
+	frames int
+}
+
+func (e *failAfterWriteEnvironment) WriteFrame(p []byte) (n int, err error) {
+	if e.frames == 0 {
+		return 0, errTest
+	}
+	e.frames--
+	return len(p), nil
+}
+
+func (e *failAfterWriteEnvironment) WriteSeekTable(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
 func TestConcurrentWriterErrors(t *testing.T) {
 	t.Parallel()
 
@@ -205,6 +2274,89 @@ func TestConcurrentWriterErrors(t *testing.T) {
 	assert.ErrorContains(t, err, "partial write")
 }
 
+func TestConcurrentWriterCancel(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	w, err := NewWriter(nullWriter{}, enc)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = w.WriteMany(ctx, makeRepeatingFrameSource([]byte("test"), 10))
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Cancel in the middle of an endless stream.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var frames int
+	frameSource := func() ([]byte, error) {
+		frames++
+		if frames == 10 {
+			cancel()
+		}
+		return []byte("test"), nil
+	}
+	err = w.WriteMany(ctx, frameSource, WithConcurrency(2))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, len(w.(*writerImpl).frameEntries), 10)
+}
+
+// countingWriteEnvironment counts written frames and slows down the writes.
+type countingWriteEnvironment struct {
+	frames atomic.Int64
+}
+
+func (e *countingWriteEnvironment) WriteFrame(p []byte) (n int, err error) {
+	time.Sleep(time.Millisecond)
+	e.frames.Inc()
+	return len(p), nil
+}
+
+func (e *countingWriteEnvironment) WriteSeekTable(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func TestConcurrentWriterMaxInFlightBytes(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+
+	var e countingWriteEnvironment
+	w, err := NewWriter(nil, enc, WithWEnvironment(&e))
+	require.NoError(t, err)
+
+	frame := make([]byte, 1000)
+	budget := newInFlightBudget(1<<20, 0).weight(len(frame))
+	var calls, maxInFlight int64
+	frameSource := func() ([]byte, error) {
+		if calls == 100 {
+			return nil, nil
+		}
+		calls++
+		if inFlight := calls - e.frames.Load(); inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		return frame, nil
+	}
+	require.NoError(t, w.WriteMany(context.Background(), frameSource,
+		WithConcurrency(8), WithMaxInFlightBytes(3*budget)))
+	assert.Equal(t, int64(100), e.frames.Load())
+	// three admitted frames and the one waiting for the budget
+	assert.LessOrEqual(t, maxInFlight, int64(4))
+
+	// Frames bigger than the budget go one by one.
+	require.NoError(t, w.WriteMany(context.Background(), makeRepeatingFrameSource(frame, 10),
+		WithMaxInFlightBytes(1)))
+	assert.Equal(t, int64(110), e.frames.Load())
+
+	err = w.WriteMany(context.Background(), makeRepeatingFrameSource(frame, 10), WithMaxInFlightBytes(0))
+	assert.Error(t, err)
+}
+
 type fakeWriteEnvironment struct {
 	bw io.Writer
 }
@@ -0,0 +1,94 @@
+// Package zstdadapter adapts ZSTD bindings with different calling conventions,
+// e.g. cgo based github.com/DataDog/zstd and github.com/valyala/gozstd,
+// to the seekable.ZSTDEncoder and seekable.ZSTDDecoder interfaces.
+//
+// Adapters take the compression functions as arguments, so this package does not
+// depend on any of the bindings and does not require cgo by itself:
+//
+//	enc := zstdadapter.OverwritingEncoder(func(dst, src []byte) ([]byte, error) {
+//		return zstd.CompressLevel(dst, src, 19) // github.com/DataDog/zstd
+//	})
+//	dec := zstdadapter.OverwritingDecoder(zstd.Decompress)
+//
+//	enc := zstdadapter.AppendingEncoder(gozstd.Compress) // github.com/valyala/gozstd
+//	dec := zstdadapter.AppendingDecoder(gozstd.Decompress)
+package zstdadapter
+
+import (
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+)
+
+// OverwritingFunc compresses or decompresses src into the beginning of dst,
+// reallocating it if it is too small, like github.com/DataDog/zstd does.
+type OverwritingFunc func(dst, src []byte) ([]byte, error)
+
+// AppendingEncodeFunc appends the compressed src to dst, like github.com/valyala/gozstd does.
+type AppendingEncodeFunc func(dst, src []byte) []byte
+
+// AppendingDecodeFunc appends the decompressed src to dst, like github.com/valyala/gozstd does.
+type AppendingDecodeFunc func(dst, src []byte) ([]byte, error)
+
+type overwritingEncoder struct {
+	compress OverwritingFunc
+}
+
+// OverwritingEncoder returns the encoder that uses compress.
+//
+// ZSTDEncoder can not report errors, so on failure the encoder produces no output,
+// which makes the writer fail for any non-empty frame.
+func OverwritingEncoder(compress OverwritingFunc) seekable.ZSTDEncoder {
+	return &overwritingEncoder{compress: compress}
+}
+
+func (e *overwritingEncoder) EncodeAll(src, dst []byte) []byte {
+	// Pass the spare capacity of dst, so that its contents are preserved.
+	out, err := e.compress(dst[len(dst):], src)
+	if err != nil {
+		return dst
+	}
+	// If out is in the spare capacity of dst, this is a no-op copy.
+	return append(dst, out...)
+}
+
+type appendingEncoder struct {
+	compress AppendingEncodeFunc
+}
+
+// AppendingEncoder returns the encoder that uses compress.
+func AppendingEncoder(compress AppendingEncodeFunc) seekable.ZSTDEncoder {
+	return &appendingEncoder{compress: compress}
+}
+
+func (e *appendingEncoder) EncodeAll(src, dst []byte) []byte {
+	return e.compress(dst, src)
+}
+
+type overwritingDecoder struct {
+	decompress OverwritingFunc
+}
+
+// OverwritingDecoder returns the decoder that uses decompress.
+func OverwritingDecoder(decompress OverwritingFunc) seekable.ZSTDDecoder {
+	return &overwritingDecoder{decompress: decompress}
+}
+
+func (d *overwritingDecoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	out, err := d.decompress(dst[len(dst):], input)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, out...), nil
+}
+
+type appendingDecoder struct {
+	decompress AppendingDecodeFunc
+}
+
+// AppendingDecoder returns the decoder that uses decompress.
+func AppendingDecoder(decompress AppendingDecodeFunc) seekable.ZSTDDecoder {
+	return &appendingDecoder{decompress: decompress}
+}
+
+func (d *appendingDecoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	return d.decompress(dst, input)
+}
@@ -0,0 +1,85 @@
+package zstdadapter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go/pkg"
+)
+
+func TestAdapters(t *testing.T) {
+	t.Parallel()
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	require.NoError(t, err)
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	// Emulate the calling convention of github.com/DataDog/zstd.
+	overwrite := func(dst, out []byte) []byte {
+		if cap(dst) < len(out) {
+			dst = make([]byte, len(out))
+		}
+		return append(dst[:0], out...)
+	}
+	compress := func(dst, src []byte) ([]byte, error) {
+		return overwrite(dst, enc.EncodeAll(src, nil)), nil
+	}
+	decompress := func(dst, src []byte) ([]byte, error) {
+		out, err := dec.DecodeAll(src, nil)
+		return overwrite(dst, out), err
+	}
+
+	for _, tc := range []struct {
+		name string
+		enc  seekable.ZSTDEncoder
+		dec  seekable.ZSTDDecoder
+	}{
+		{"overwriting", OverwritingEncoder(compress), OverwritingDecoder(decompress)},
+		{"appending", AppendingEncoder(func(dst, src []byte) []byte { return enc.EncodeAll(src, dst) }),
+			AppendingDecoder(func(dst, src []byte) ([]byte, error) { return dec.DecodeAll(src, dst) })},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// dst contents must be preserved, even if there is spare capacity.
+			dst := make([]byte, 4, 1<<10)
+			copy(dst, "test")
+			out := tc.enc.EncodeAll([]byte("test2"), dst)
+			assert.Equal(t, []byte("test"), out[:4])
+			decoded, err := tc.dec.DecodeAll(out[4:], dst)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("testtest2"), decoded)
+
+			var b bytes.Buffer
+			w, err := seekable.NewWriter(&b, tc.enc)
+			require.NoError(t, err)
+			_, err = w.Write([]byte("test"))
+			require.NoError(t, err)
+			_, err = w.Write([]byte("test2"))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := seekable.NewReader(bytes.NewReader(b.Bytes()), tc.dec)
+			require.NoError(t, err)
+			all, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("testtest2"), all)
+			require.NoError(t, r.Close())
+		})
+	}
+
+	// Encoding errors make the writer fail.
+	failing := OverwritingEncoder(func(dst, src []byte) ([]byte, error) {
+		return nil, errors.New("test error")
+	})
+	w, err := seekable.NewWriter(io.Discard, failing)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	assert.Error(t, err)
+}